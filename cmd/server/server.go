@@ -2,11 +2,25 @@ package main
 
 import (
 	"GoNews/pkg/api"
+	"GoNews/pkg/jobs"
+	"GoNews/pkg/outbox"
 	"GoNews/pkg/storage"
+	"GoNews/pkg/storage/cache"
+	"GoNews/pkg/storage/kv"
+	"GoNews/pkg/storage/mongo"
 	"GoNews/pkg/storage/postgres"
+	"GoNews/pkg/storage/sqlite"
+	"GoNews/pkg/stream"
+	"GoNews/pkg/webhooks"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -16,33 +30,595 @@ func main() {
 	if socket == "" {
 		log.Fatal("environment variable SERVER_LISTEN_SOCKET must be set")
 	}
-	connStringPostgres := os.Getenv("POSTGRES_CONN_STRING")
-	if connStringPostgres == "" {
-		log.Fatal("environment variable POSTGRES_CONN_STRING must be set")
-	}
 
 	// создаем образ БД
-	var bd storage.Model
-	bd, err := postgres.New(connStringPostgres)
+	bd, err := openStorage()
 	if err != nil {
 		log.Fatalf("error connecting to database [%v]\n", err)
 	}
 	defer bd.Close()
 
+	// запускаем доставку событий изменения публикаций
+	relay, closeSinks, err := outboxRelay(bd)
+	if err != nil {
+		log.Fatalf("error configuring outbox relay [%v]\n", err)
+	}
+	defer closeSinks()
+
+	// кешируем чтение публикаций: CACHE_TTL - время жизни записей
+	// кеша, CACHE_SIZE - число записей (по умолчанию 1000)
+	bd, err = cachedStorage(bd)
+	if err != nil {
+		log.Fatalf("error configuring cache [%v]\n", err)
+	}
+
 	// создаем API сервера
 	l := log.New(os.Stderr, "[GoNews server]\t->\t", log.LstdFlags|log.Lmsgprefix)
-	api := api.New(bd, l)
+
+	opts, err := apiOptions()
+	if err != nil {
+		log.Fatalf("error configuring api [%v]\n", err)
+	}
+
+	hooks, err := webhookDispatcher()
+	if err != nil {
+		log.Fatalf("error configuring webhooks [%v]\n", err)
+	}
+	if hooks != nil {
+		defer hooks.Close()
+		opts = append(opts, api.WithWebhooks(hooks))
+	}
+
+	hub, heartbeat, err := postStream()
+	if err != nil {
+		log.Fatalf("error configuring post stream [%v]\n", err)
+	}
+	opts = append(opts, api.WithStream(hub, heartbeat))
+
+	wsConfig, err := webSocketConfig()
+	if err != nil {
+		log.Fatalf("error configuring websocket [%v]\n", err)
+	}
+	opts = append(opts, api.WithWebSocket(hub, wsConfig))
+
+	gqlConfig, err := graphQLConfig()
+	if err != nil {
+		log.Fatalf("error configuring graphql [%v]\n", err)
+	}
+	opts = append(opts, api.WithGraphQL(gqlConfig))
+	api := api.New(bd, l, opts...)
+
+	// фоновые задачи и сервер останавливаются по SIGINT и SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// запускаем очистку корзины: TRASH_RETENTION - срок хранения
+	// удаленных публикаций, TRASH_PURGE_INTERVAL - периодичность очистки
+	retention, err := durationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		log.Fatalf("error configuring trash purge [%v]\n", err)
+	}
+	purgeInterval, err := durationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatalf("error configuring trash purge [%v]\n", err)
+	}
+	go jobs.NewPurge(bd, retention, purgeInterval, l).Run(ctx)
+
+	// запускаем публикацию по расписанию: PUBLISH_INTERVAL -
+	// периодичность проверки запланированных публикаций
+	publishInterval, err := durationEnv("PUBLISH_INTERVAL", time.Minute)
+	if err != nil {
+		log.Fatalf("error configuring scheduled publishing [%v]\n", err)
+	}
+	go jobs.NewPublisher(bd, publishInterval, l, jobs.WithNotify(api.NotifyPublished)).Run(ctx)
+
+	if relay != nil {
+		go relay.Run(ctx)
+	}
+
+	// сервис gRPC: GRPC_LISTEN_SOCKET - отдельный адрес для него,
+	// GRPC_MULTIPLEX=true - обслуживание на адресе HTTP-сервера
+	grpcSrv := api.GRPCServer()
+	handler := api.Mux()
+	if v := os.Getenv("GRPC_MULTIPLEX"); v != "" {
+		multiplex, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("error configuring grpc [%v]\n", err)
+		}
+		if multiplex {
+			handler = api.GRPCMux(grpcSrv)
+		}
+	}
 
 	// конфигурируем сервер
 	srv := &http.Server{
 		Addr:              socket,
-		Handler:           api.Mux(),
+		Handler:           handler,
 		IdleTimeout:       3 * time.Minute,
 		ReadHeaderTimeout: time.Minute,
 	}
+	// открытые ленты не завершаются сами, их отключает остановка ленты
+	srv.RegisterOnShutdown(hub.Close)
 
-	err = srv.ListenAndServe()
-	if err != http.ErrServerClosed {
+	// SHUTDOWN_TIMEOUT - время на завершение обработки запросов
+	shutdownTimeout, err := durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		log.Fatalf("error configuring shutdown [%v]\n", err)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- srv.ListenAndServe() }()
+	if grpcSocket := os.Getenv("GRPC_LISTEN_SOCKET"); grpcSocket != "" {
+		lis, err := net.Listen("tcp", grpcSocket)
+		if err != nil {
+			log.Fatalf("error listening grpc socket [%v]\n", err)
+		}
+		go func() { errs <- grpcSrv.Serve(lis) }()
+	}
+
+	select {
+	case err = <-errs:
 		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	l.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		l.Printf("error shutting down server [%v]\n", err)
+	}
+
+	// вызовы gRPC завершаются за то же время, потоки WatchPosts
+	// завершены остановкой ленты
+	stopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		grpcSrv.Stop()
+	}
+}
+
+// openStorage подключается к БД: KV_PATH - путь к файлу встроенного
+// хранилища ключ-значение, KV_SYNC - политика сброса его изменений
+// на диск (always, never или интервал, например 1s), SQLITE_PATH - путь
+// к файлу встроенной БД SQLite, MONGO_CONN_STRING - строка подключения
+// к MongoDB (параметры см. mongoConfig). Если ничего из этого не задано,
+// используется Postgres по строке подключения POSTGRES_CONN_STRING,
+// POSTGRES_REPLICAS - строки подключения к репликам через запятую,
+// POSTGRES_MAX_REPLICA_LAG - допустимое отставание реплик, например 5s,
+// параметры пула см. postgresPool
+func openStorage() (storage.Model, error) {
+	if path := os.Getenv("KV_PATH"); path != "" {
+		var opts []kv.Option
+		if v := os.Getenv("KV_SYNC"); v != "" {
+			opt, err := kv.ParseSync(v)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, opt)
+		}
+		return kv.New(path, opts...)
+	}
+
+	if path := os.Getenv("SQLITE_PATH"); path != "" {
+		return sqlite.New(path)
+	}
+
+	if connString := os.Getenv("MONGO_CONN_STRING"); connString != "" {
+		cfg, err := mongoConfig()
+		if err != nil {
+			return nil, err
+		}
+		dbName, collection := os.Getenv("MONGO_DB"), os.Getenv("MONGO_COLLECTION")
+		if dbName == "" {
+			dbName = "GoNews"
+		}
+		if collection == "" {
+			collection = "posts"
+		}
+		return mongo.New(connString, dbName, collection, mongo.WithConfig(cfg))
+	}
+
+	connStringPostgres := os.Getenv("POSTGRES_CONN_STRING")
+	if connStringPostgres == "" {
+		log.Fatal("environment variable POSTGRES_CONN_STRING, MONGO_CONN_STRING, SQLITE_PATH or KV_PATH must be set")
+	}
+
+	pool, err := postgresPool()
+	if err != nil {
+		return nil, err
+	}
+	opts := []postgres.Option{postgres.WithPool(pool)}
+	if v := os.Getenv("POSTGRES_REPLICAS"); v != "" {
+		opts = append(opts, postgres.WithReplicas(strings.Split(v, ",")...))
+	}
+	if v := os.Getenv("POSTGRES_MAX_REPLICA_LAG"); v != "" {
+		lag, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, postgres.WithMaxReplicaLag(lag))
+	}
+	return postgres.New(connStringPostgres, opts...)
+}
+
+// postgresPool собирает параметры пула Postgres: POSTGRES_MAX_CONNS
+// и POSTGRES_MIN_CONNS - размер пула, POSTGRES_MAX_CONN_LIFETIME,
+// POSTGRES_MAX_CONN_IDLE_TIME и POSTGRES_HEALTH_CHECK_PERIOD - время
+// жизни, простоя и периодичность проверки соединений,
+// POSTGRES_STATEMENT_TIMEOUT - наибольшее время выполнения запроса,
+// POSTGRES_APPLICATION_NAME - имя приложения (по умолчанию GoNews)
+func postgresPool() (postgres.PoolConfig, error) {
+	cfg := postgres.PoolConfig{ApplicationName: os.Getenv("POSTGRES_APPLICATION_NAME")}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "GoNews"
+	}
+
+	var err error
+	if cfg.MaxConns, err = int32Env("POSTGRES_MAX_CONNS"); err != nil {
+		return cfg, err
+	}
+	if cfg.MinConns, err = int32Env("POSTGRES_MIN_CONNS"); err != nil {
+		return cfg, err
+	}
+	for name, d := range map[string]*time.Duration{
+		"POSTGRES_MAX_CONN_LIFETIME":   &cfg.MaxConnLifetime,
+		"POSTGRES_MAX_CONN_IDLE_TIME":  &cfg.MaxConnIdleTime,
+		"POSTGRES_HEALTH_CHECK_PERIOD": &cfg.HealthCheckPeriod,
+		"POSTGRES_STATEMENT_TIMEOUT":   &cfg.StatementTimeout,
+	} {
+		if *d, err = durationEnv(name, 0); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// mongoConfig собирает параметры подключения к MongoDB: MONGO_DB
+// и MONGO_COLLECTION - имена БД и коллекции публикаций (по умолчанию
+// GoNews и posts), MONGO_MAX_POOL_SIZE и MONGO_MIN_POOL_SIZE - размер
+// пула, MONGO_CONNECT_TIMEOUT, MONGO_SERVER_SELECTION_TIMEOUT
+// и MONGO_SOCKET_TIMEOUT - таймауты, MONGO_READ_CONCERN
+// и MONGO_WRITE_CONCERN - гарантии чтения и записи (например majority),
+// MONGO_WRITE_TIMEOUT - время ожидания подтверждения записи,
+// MONGO_APP_NAME - имя приложения (по умолчанию GoNews)
+func mongoConfig() (mongo.Config, error) {
+	cfg := mongo.Config{
+		ReadConcern:  os.Getenv("MONGO_READ_CONCERN"),
+		WriteConcern: os.Getenv("MONGO_WRITE_CONCERN"),
+		AppName:      os.Getenv("MONGO_APP_NAME"),
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "GoNews"
+	}
+
+	var err error
+	for name, n := range map[string]*uint64{
+		"MONGO_MAX_POOL_SIZE": &cfg.MaxPoolSize,
+		"MONGO_MIN_POOL_SIZE": &cfg.MinPoolSize,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *n, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		"MONGO_CONNECT_TIMEOUT":          &cfg.ConnectTimeout,
+		"MONGO_SERVER_SELECTION_TIMEOUT": &cfg.ServerSelectionTimeout,
+		"MONGO_SOCKET_TIMEOUT":           &cfg.SocketTimeout,
+		"MONGO_WRITE_TIMEOUT":            &cfg.WriteTimeout,
+	} {
+		if *d, err = durationEnv(name, 0); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// outboxRelay создает задачу доставки событий изменения публикаций,
+// если БД их сохраняет и задан хотя бы один получатель:
+// OUTBOX_WEBHOOK_URLS - адреса получателей через запятую,
+// OUTBOX_LOG_FILE - файл событий, OUTBOX_INTERVAL - периодичность
+// проверки новых событий (по умолчанию 1s). Возвращаемая функция
+// закрывает файл событий
+func outboxRelay(db storage.Model) (*outbox.Relay, func(), error) {
+	noop := func() {}
+
+	ob, ok := db.(storage.Outbox)
+	if !ok {
+		return nil, noop, nil
+	}
+
+	var sinks []outbox.Sink
+	if v := os.Getenv("OUTBOX_WEBHOOK_URLS"); v != "" {
+		for _, u := range strings.Split(v, ",") {
+			sinks = append(sinks, outbox.NewWebhook(u, nil))
+		}
+	}
+	closeSinks := noop
+	if path := os.Getenv("OUTBOX_LOG_FILE"); path != "" {
+		f, err := outbox.OpenFile(path)
+		if err != nil {
+			return nil, noop, err
+		}
+		sinks = append(sinks, f)
+		closeSinks = func() { f.Close() }
+	}
+	if len(sinks) == 0 {
+		return nil, closeSinks, nil
+	}
+
+	interval, err := durationEnv("OUTBOX_INTERVAL", time.Second)
+	if err != nil {
+		closeSinks()
+		return nil, noop, err
+	}
+
+	l := log.New(os.Stderr, "[GoNews outbox]\t->\t", log.LstdFlags|log.Lmsgprefix)
+	return outbox.NewRelay(ob, interval, l, sinks...), closeSinks, nil
+}
+
+// cachedStorage оборачивает БД кешем, если задано время жизни записей
+func cachedStorage(db storage.Model) (storage.Model, error) {
+	v := os.Getenv("CACHE_TTL")
+	if v == "" {
+		return db, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 1000
+	if v := os.Getenv("CACHE_SIZE"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return cache.New(db, cache.NewLRU(size), ttl), nil
+}
+
+// apiOptions собирает необязательные параметры API из переменных окружения:
+// API_KEYS_FILE - json-файл с таблицей API-ключей пользователей,
+// POLICY_FILE - json-файл с таблицей политики доступа,
+// RATE_LIMIT_READ и RATE_LIMIT_WRITE - бюджеты запросов в формате rate:burst,
+// TRUSTED_PROXIES - список доверенных прокси через запятую,
+// CORS_ALLOWED_ORIGINS - разрешенные для CORS источники через запятую,
+// CORS_ALLOW_CREDENTIALS - разрешить передачу учетных данных (true/false),
+// CORS_MAX_AGE - время кеширования preflight-ответа, например 10m,
+// MAX_BODY_SIZE - максимальный размер тела запроса в байтах,
+// IDEMPOTENCY_TTL - время хранения ответов по ключам идемпотентности.
+// Если задан файл ключей, но не задана политика,
+// используется api.DefaultPolicy
+func apiOptions() ([]api.Option, error) {
+	var opts []api.Option
+
+	rl, err := rateLimiter()
+	if err != nil {
+		return nil, err
+	}
+	if rl != nil {
+		opts = append(opts, api.WithRateLimiter(rl))
+	}
+
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		cfg := api.CORSConfig{AllowedOrigins: strings.Split(origins, ",")}
+		if v := os.Getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+			if cfg.AllowCredentials, err = strconv.ParseBool(v); err != nil {
+				return nil, err
+			}
+		}
+		if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+			if cfg.MaxAge, err = time.ParseDuration(v); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, api.WithCORS(cfg))
+	}
+
+	if v := os.Getenv("MAX_BODY_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithMaxBodySize(n))
+	}
+
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithIdempotencyTTL(ttl))
+	}
+
+	keysFile := os.Getenv("API_KEYS_FILE")
+	if keysFile == "" {
+		return opts, nil
+	}
+
+	f, err := os.Open(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys, err := api.LoadAPIKeys(f)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, api.WithAuthenticator(keys))
+
+	policy := api.DefaultPolicy
+	if policyFile := os.Getenv("POLICY_FILE"); policyFile != "" {
+		pf, err := os.Open(policyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer pf.Close()
+
+		policy, err = api.LoadPolicy(pf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts = append(opts, api.WithPolicy(policy))
+
+	return opts, nil
+}
+
+// webhookDispatcher создает рассылку событий подписчикам, если задано
+// WEBHOOKS=true: WEBHOOK_MAX_ATTEMPTS - число попыток доставки,
+// WEBHOOK_BASE_BACKOFF и WEBHOOK_MAX_BACKOFF - начальная и наибольшая
+// пауза между попытками, WEBHOOK_TIMEOUT - время ожидания ответа,
+// WEBHOOK_ALLOW_PRIVATE=true разрешает подписчиков во внутренней сети
+func webhookDispatcher() (*webhooks.Dispatcher, error) {
+	v := os.Getenv("WEBHOOKS")
+	if v == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	var cfg webhooks.Config
+	if v := os.Getenv("WEBHOOK_ALLOW_PRIVATE"); v != "" {
+		if cfg.AllowPrivate, err = strconv.ParseBool(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		if cfg.MaxAttempts, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		"WEBHOOK_BASE_BACKOFF": &cfg.BaseBackoff,
+		"WEBHOOK_MAX_BACKOFF":  &cfg.MaxBackoff,
+		"WEBHOOK_TIMEOUT":      &cfg.Timeout,
+	} {
+		if *d, err = durationEnv(name, 0); err != nil {
+			return nil, err
+		}
+	}
+	return webhooks.New(cfg), nil
+}
+
+// postStream создает ленту изменений публикаций: STREAM_REPLAY_SIZE -
+// число событий, доступных для возобновления по Last-Event-ID,
+// STREAM_HEARTBEAT - периодичность комментариев в ленте
+func postStream() (*stream.Hub, time.Duration, error) {
+	var size int
+	if v := os.Getenv("STREAM_REPLAY_SIZE"); v != "" {
+		var err error
+		if size, err = strconv.Atoi(v); err != nil {
+			return nil, 0, err
+		}
+	}
+	heartbeat, err := durationEnv("STREAM_HEARTBEAT", api.DefaultHeartbeat)
+	if err != nil {
+		return nil, 0, err
+	}
+	return stream.New(size), heartbeat, nil
+}
+
+// webSocketConfig читает ограничения соединений WebSocket:
+// WS_MAX_SUBSCRIPTIONS - число авторов и тегов на соединение,
+// WS_QUEUE_SIZE - очередь событий соединения, WS_DISCONNECT_SLOW=true
+// отключает не успевающих клиентов вместо пропуска событий,
+// WS_PING_INTERVAL - периодичность проверки соединения
+func webSocketConfig() (api.WebSocketConfig, error) {
+	var cfg api.WebSocketConfig
+	var err error
+	for name, n := range map[string]*int{
+		"WS_MAX_SUBSCRIPTIONS": &cfg.MaxSubscriptions,
+		"WS_QUEUE_SIZE":        &cfg.QueueSize,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	if v := os.Getenv("WS_DISCONNECT_SLOW"); v != "" {
+		if cfg.DisconnectSlow, err = strconv.ParseBool(v); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.PingInterval, err = durationEnv("WS_PING_INTERVAL", 0)
+	return cfg, err
+}
+
+// graphQLConfig читает ограничения запросов GraphQL:
+// GRAPHQL_MAX_DEPTH - вложенность полей запроса,
+// GRAPHQL_MAX_COMPLEXITY - сложность запроса
+func graphQLConfig() (api.GraphQLConfig, error) {
+	var cfg api.GraphQLConfig
+	var err error
+	for name, n := range map[string]*int{
+		"GRAPHQL_MAX_DEPTH":      &cfg.MaxDepth,
+		"GRAPHQL_MAX_COMPLEXITY": &cfg.MaxComplexity,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// rateLimiter создает ограничитель частоты запросов,
+// если задан хотя бы один из бюджетов
+func rateLimiter() (*api.RateLimiter, error) {
+	var cfg api.RateLimitConfig
+	var err error
+
+	read, write := os.Getenv("RATE_LIMIT_READ"), os.Getenv("RATE_LIMIT_WRITE")
+	if read == "" && write == "" {
+		return nil, nil
+	}
+	if read != "" {
+		if cfg.Read, err = api.ParseRateLimit(read); err != nil {
+			return nil, err
+		}
+	}
+	if write != "" {
+		if cfg.Write, err = api.ParseRateLimit(write); err != nil {
+			return nil, err
+		}
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		cfg.TrustedProxies = strings.Split(proxies, ",")
+	}
+
+	return api.NewRateLimiter(cfg)
+}
+
+// durationEnv возвращает длительность из переменной окружения name
+// или значение по умолчанию def, если переменная не задана
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// int32Env возвращает число из переменной окружения name
+// или 0, если переменная не задана
+func int32Env(name string) (int32, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
 	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	return int32(n), err
 }
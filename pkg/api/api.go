@@ -2,7 +2,11 @@ package api
 
 import (
 	"GoNews/pkg/storage"
+	"GoNews/pkg/stream"
+	"GoNews/pkg/webhooks"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"log"
@@ -10,6 +14,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
 )
 
 // methods - ассоциативный массив, где
@@ -25,11 +32,48 @@ type Api struct {
 	db        storage.Model
 	logger    *log.Logger
 	resources map[string]methods
+	auth      Authenticator
+	policy    Policy
+	limiter   *RateLimiter
+	cors      *CORSConfig
+	reporter  PanicReporter
+
+	maxBody    int64            // предел размера тела запроса по умолчанию
+	bodyLimits map[string]int64 // пределы размера тела запроса по ресурсам
+
+	idempotencyTTL time.Duration // время хранения ответов по ключам идемпотентности
+
+	webhooks *webhooks.Dispatcher // рассылка событий подписчикам
+
+	hub       *stream.Hub      // лента изменений публикаций
+	heartbeat time.Duration    // периодичность комментариев ленты
+	ws        *WebSocketConfig // ограничения соединений WebSocket
+
+	gql    *GraphQLConfig // ограничения запросов GraphQL
+	schema graphql.Schema // схема GraphQL
+}
+
+// Option задает необязательный параметр API
+type Option func(*Api)
+
+// WithAuthenticator включает аутентификацию запросов
+func WithAuthenticator(a Authenticator) Option {
+	return func(api *Api) { api.auth = a }
+}
+
+// WithPolicy включает проверку прав доступа к публикациям
+// согласно таблице политики доступа p
+func WithPolicy(p Policy) Option {
+	return func(api *Api) { api.policy = p }
 }
 
 // New возвращает объект API нашего сервиса
-func New(s storage.Model, log *log.Logger) *Api {
-	api := Api{db: s, logger: log}
+func New(s storage.Model, log *log.Logger, opts ...Option) *Api {
+	api := Api{db: s, logger: log, idempotencyTTL: DefaultIdempotencyTTL}
+
+	for _, opt := range opts {
+		opt(&api)
+	}
 
 	// назаначаем обработчики соответствующим ресурсам
 	api.resources = map[string]methods{
@@ -39,6 +83,72 @@ func New(s storage.Model, log *log.Logger) *Api {
 			http.MethodPut:    http.HandlerFunc(api.putPostHandler),
 			http.MethodDelete: http.HandlerFunc(api.deletePostHandler),
 		},
+		"/posts/bulk": {
+			http.MethodPost:   http.HandlerFunc(api.bulkPostHandler),
+			http.MethodPut:    http.HandlerFunc(api.bulkPutHandler),
+			http.MethodDelete: http.HandlerFunc(api.bulkDeleteHandler),
+		},
+		"/posts/{id}/revisions": {
+			http.MethodGet: http.HandlerFunc(api.getRevisionsHandler),
+		},
+		"/posts/{id}/revisions/{number}": {
+			http.MethodGet: http.HandlerFunc(api.getRevisionHandler),
+		},
+		"/posts/{id}/revisions/{number}/revert": {
+			http.MethodPost: http.HandlerFunc(api.revertRevisionHandler),
+		},
+		"/posts/{id}/diff": {
+			http.MethodGet: http.HandlerFunc(api.getDiffHandler),
+		},
+		"/posts/{id}/comments": {
+			http.MethodGet:  http.HandlerFunc(api.getCommentsHandler),
+			http.MethodPost: http.HandlerFunc(api.postCommentHandler),
+		},
+		"/posts/{id}/comments/{comment}": {
+			http.MethodGet: http.HandlerFunc(api.getCommentHandler),
+			http.MethodPut: http.HandlerFunc(api.moderateCommentHandler),
+		},
+		"/tags": {
+			http.MethodGet: http.HandlerFunc(api.getTagsHandler),
+		},
+		"/tags/rename": {
+			http.MethodPost: http.HandlerFunc(api.renameTagHandler),
+		},
+		"/trash": {
+			http.MethodGet: http.HandlerFunc(api.getTrashHandler),
+		},
+		"/trash/restore": {
+			http.MethodPost: http.HandlerFunc(api.restorePostHandler),
+		},
+		"/ready": {
+			http.MethodGet: http.HandlerFunc(api.getReadyHandler),
+		},
+	}
+	if api.hub != nil && api.heartbeat > 0 {
+		api.resources["/posts/stream"] = methods{
+			http.MethodGet: http.HandlerFunc(api.streamHandler),
+		}
+	}
+	if api.hub != nil && api.ws != nil {
+		api.resources["/posts/ws"] = methods{
+			http.MethodGet: http.HandlerFunc(api.websocketHandler),
+		}
+	}
+	if api.gql != nil {
+		api.schema = api.graphqlSchema()
+		api.resources["/graphql"] = methods{
+			http.MethodGet:  http.HandlerFunc(api.graphqlHandler),
+			http.MethodPost: http.HandlerFunc(api.graphqlHandler),
+		}
+	}
+	if api.webhooks != nil {
+		for resource, m := range api.webhookResources() {
+			api.resources[resource] = m
+		}
+	}
+
+	if _, ok := api.bodyLimits["/posts/bulk"]; !ok {
+		WithBodyLimit("/posts/bulk", DefaultMaxBulkBodySize)(&api)
 	}
 
 	return &api
@@ -53,21 +163,81 @@ func (api *Api) Mux() http.Handler {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusNoContent)
 	})
-	mux.Handle("/posts", api)
-	return drainAndClose(mux)
+	registered := make(map[string]bool, len(api.resources))
+	for resource := range api.resources {
+		// ресурсы с параметрами пути обслуживаются
+		// по префиксу до первого параметра
+		if i := strings.Index(resource, "{"); i >= 0 {
+			resource = resource[:i]
+		}
+		if !registered[resource] {
+			registered[resource] = true
+			mux.Handle(resource, api)
+		}
+	}
+
+	var h http.Handler = primaryReads(mux)
+	if api.limiter != nil {
+		h = api.limiter.rateLimit(h)
+	}
+	if api.auth != nil {
+		h = api.authenticate(h)
+	}
+	if api.cors != nil {
+		h = api.corsHandler(h)
+	}
+	return drainAndClose(requestId(api.recoverer(h)))
 }
 
 // drainAndClose вспомогательная функция, опустошает
 // и закрывает request body, это позволяет
-// клиенту переиспользовать tcp-сессию
+// клиенту переиспользовать tcp-сессию. Вычитывается
+// не более maxDrainSize байт, тело большего размера
+// приводит к закрытию соединения
 func drainAndClose(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		next.ServeHTTP(w, r)
-		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.CopyN(io.Discard, r.Body, maxDrainSize)
 		_ = r.Body.Close()
 	})
 }
 
+// primaryReads помечает запрос аутентифицированного пользователя с
+// заголовком Cache-Control: no-cache как чтение собственных записей:
+// публикации читаются с основного сервера БД в обход реплик и кеша.
+// Анонимным клиентам заголовок не позволяет нагружать основной сервер
+func primaryReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFromContext(r.Context()); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, v := range r.Header.Values("Cache-Control") {
+			for _, d := range strings.Split(v, ",") {
+				if strings.EqualFold(strings.TrimSpace(d), "no-cache") {
+					r = r.WithContext(storage.WithPrimary(r.Context()))
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// storedPost возвращает сохраненную публикацию по id с основного
+// сервера БД: изменение публикации проверяется по ее последней версии
+func (api *Api) storedPost(id int) (storage.Post, error) {
+	return storage.PostContext(storage.WithPrimary(context.Background()), api.db, id)
+}
+
+// contextRequest возвращает запрос-обертку над контекстом вызова gRPC
+// или GraphQL: проверки доступа API читают из него пользователя
+func contextRequest(ctx context.Context) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", http.NoBody)
+	return r
+}
+
 // writeResponse вспомогательная функция, которая
 // устанавливает заголовки ответа, пишет тело сообщения в виде json(если есть)
 func (api *Api) writeResponse(w http.ResponseWriter, reply any, code int) {
@@ -97,19 +267,35 @@ func (api *Api) writeResponse(w http.ResponseWriter, reply any, code int) {
 func (api *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// если у нас имеется требуемый ресурс
-	if resourceMethods, ok := api.resources[r.URL.Path]; ok {
+	if resource, resourceMethods, params, ok := api.route(r.URL.Path); ok {
+
+		if params != nil {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey, params))
+		}
 
 		// и имеется требуемый обработчик
-		if handler, ok := resourceMethods[r.Method]; ok {
+		if handler, ok := resourceMethods.handler(r.Method); ok {
+
+			if !api.limitBody(w, r, resource) {
+				return
+			}
+
+			if r.Method == http.MethodHead {
+				// HEAD обслуживается обработчиком GET без тела ответа
+				w = headResponseWriter{w}
+			}
 
 			if handler != nil {
+				if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+					handler = api.idempotent(handler)
+				}
 				handler.ServeHTTP(w, r)
 			} else {
 				// чтобы не вызывать панику на сервере, если вдруг
 				// на место обработчика назначен nil
 				api.logger.Printf("error setting handler: the handler [%s] for [%s] is nil\n",
 					r.Method, r.URL.Path)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				writeError(w, r, http.StatusInternalServerError, "")
 			}
 
 			return
@@ -122,58 +308,238 @@ func (api *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("Allow", resourceMethods.allowedMethods())
 			api.writeResponse(w, nil, http.StatusOK)
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			w.Header().Set("Allow", resourceMethods.allowedMethods())
+			writeError(w, r, http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed")
 		}
 		return
 	}
 
 	// не нашли требуемый ресурс
-	api.writeResponse(w, nil, http.StatusNotFound)
+	writeError(w, r, http.StatusNotFound, "")
+}
+
+// route находит ресурс по пути запроса. Ресурс может содержать
+// параметры пути в виде {name}, например /posts/{id}/revisions,
+// их значения возвращаются в params. Точное совпадение имеет приоритет
+func (api *Api) route(path string) (resource string, m methods, params map[string]string, ok bool) {
+	if m, ok := api.resources[path]; ok {
+		return path, m, nil, true
+	}
+
+	segments := strings.Split(path, "/")
+	for resource, m := range api.resources {
+		if !strings.Contains(resource, "{") {
+			continue
+		}
+		if params, ok := matchPath(strings.Split(resource, "/"), segments); ok {
+			return resource, m, params, true
+		}
+	}
+	return "", nil, nil, false
+}
+
+// matchPath сопоставляет сегменты пути запроса с сегментами шаблона
+func matchPath(pattern, segments []string) (map[string]string, bool) {
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[p[1:len(p)-1]] = segments[i]
+			continue
+		}
+		if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// pathParam возвращает значение параметра пути запроса
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey).(map[string]string)
+	return params[name]
+}
+
+// handler возвращает обработчик метода ресурса.
+// Для HEAD, если он не назначен явно, возвращается обработчик GET
+func (m methods) handler(method string) (http.Handler, bool) {
+	h, ok := m[method]
+	if !ok && method == http.MethodHead {
+		h, ok = m[http.MethodGet]
+	}
+	return h, ok
 }
 
 // allowedMethods возвращает список
-// допустимых методов для ресурса в виде строки
+// допустимых методов для ресурса в виде строки,
+// включая HEAD (при наличии GET) и OPTIONS
 func (m methods) allowedMethods() string {
-	a := make([]string, 0, len(m))
+	a := make([]string, 0, len(m)+2)
 
 	for k := range m {
 		a = append(a, k)
 	}
+	if _, ok := m[http.MethodHead]; !ok {
+		if _, ok := m[http.MethodGet]; ok {
+			a = append(a, http.MethodHead)
+		}
+	}
+	if _, ok := m[http.MethodOptions]; !ok {
+		a = append(a, http.MethodOptions)
+	}
 
 	sort.Strings(a)
 
 	return strings.Join(a, ", ")
 }
 
-// getPostsHandler обработчик для метода GET
+// headResponseWriter отбрасывает тело ответа,
+// сохраняя заголовки, в том числе Content-Length
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// getPostsHandler обработчик для метода GET. По умолчанию возвращает
+// опубликованные публикации, параметр status выбирает публикации
+// в другом состоянии, из которых видны только доступные для изменения.
+// Параметры tags и match отбирают публикации по тегам
 func (api *Api) getPostsHandler(w http.ResponseWriter, r *http.Request) {
-	posts, err := api.db.Posts()
-	if err != nil {
-		api.logger.Printf("error fetching from database: [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !api.authorize(w, r, ActionRead, nil) {
+		return
+	}
+
+	tags, all, p := queryTags(r)
+	if p != nil {
+		writeProblem(w, r, p)
+		return
+	}
+
+	posts, p := api.listPosts(r, storage.Status(r.URL.Query().Get("status")), tags, all)
+	if p != nil {
+		writeProblem(w, r, p)
 		return
 	}
 	api.writeResponse(w, map[string]any{"data": posts}, http.StatusOK)
 }
 
+// listPosts возвращает публикации в состоянии status (пустое -
+// опубликованные) с любым (all - со всеми) из тегов tags, доступные
+// пользователю запроса. Если нельзя, возвращает описание ошибки
+func (api *Api) listPosts(r *http.Request, status storage.Status, tags []string, all bool) ([]storage.Post, *Problem) {
+	if status == "" {
+		status = storage.StatusPublished
+	}
+	if _, ok := transitions[status]; !ok {
+		return nil, NewProblem(http.StatusBadRequest, "unknown post status "+string(status))
+	}
+
+	var posts []storage.Post
+	var err error
+	if len(tags) > 0 {
+		posts, err = api.db.PostsByTags(tags, all)
+	} else {
+		posts, err = storage.PostsContext(r.Context(), api.db)
+	}
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		return nil, NewProblem(http.StatusInternalServerError, "")
+	}
+
+	filtered := make([]storage.Post, 0, len(posts))
+	for _, p := range posts {
+		if p.Status == status || status == storage.StatusPublished && p.Published() {
+			filtered = append(filtered, p)
+		}
+	}
+	if status == storage.StatusPublished {
+		return api.readable(r, filtered), nil
+	}
+	return api.permitted(r, ActionUpdate, filtered), nil
+}
+
+// authors возвращает авторов доступных пользователю опубликованных
+// публикаций по возрастанию id
+func (api *Api) authors(r *http.Request) ([]storage.Author, *Problem) {
+	if p := api.permit(r, ActionRead, nil); p != nil {
+		return nil, p
+	}
+	posts, p := api.listPosts(r, storage.StatusPublished, nil, false)
+	if p != nil {
+		return nil, p
+	}
+
+	seen := make(map[int]bool)
+	var authors []storage.Author
+	for _, post := range posts {
+		if post.Author.Id != 0 && !seen[post.Author.Id] {
+			seen[post.Author.Id] = true
+			authors = append(authors, post.Author)
+		}
+	}
+	sort.Slice(authors, func(i, j int) bool { return authors[i].Id < authors[j].Id })
+	return authors, nil
+}
+
+// pageBounds возвращает границы страницы размера size в списке из n
+// элементов по токену token и токен следующей страницы, false -
+// если токен неверный. Токен хранит смещение начала страницы
+func pageBounds(n, size int, token string) (from, to int, next string, ok bool) {
+	if token != "" {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		if err == nil {
+			from, err = strconv.Atoi(string(b))
+		}
+		if err != nil || from < 0 {
+			return 0, 0, "", false
+		}
+	}
+	if from > n {
+		from = n
+	}
+	to = from + size
+	if to >= n {
+		return from, n, "", true
+	}
+	return from, to, base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(to))), true
+}
+
 // postPostHandler обработчик для метода POST
 func (api *Api) postPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	var post storage.Post
 
-	err := json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
-		api.logger.Printf("error decoding request body [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !api.decode(w, r, &post) {
+		return
+	}
+
+	post.Editor = editor(r)
+
+	if !api.authorize(w, r, ActionCreate, &post) {
 		return
 	}
 
-	err = api.db.AddPost(post)
+	if _, p := api.validate(ActionCreate, &post); p != nil {
+		writeProblem(w, r, p)
+		return
+	}
+
+	err := api.db.AddPost(post)
 	if err != nil {
 		api.logger.Printf("error posting to database: [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeProblem(w, r, storageProblem(err))
 		return
 	}
+	api.notifyStored(storage.EventCreated, post.Id)
 	api.writeResponse(w, nil, http.StatusCreated)
 
 }
@@ -183,41 +549,63 @@ func (api *Api) putPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	var post storage.Post
 
-	err := json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
-		api.logger.Printf("error decoding request body [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !api.decode(w, r, &post) {
+		return
+	}
+
+	post.Editor = editor(r)
+
+	if !api.authorize(w, r, ActionUpdate, &post) {
+		return
+	}
+
+	before, p := api.validate(ActionUpdate, &post)
+	if p != nil {
+		writeProblem(w, r, p)
 		return
 	}
 
-	err = api.db.UpdatePost(post)
+	err := api.db.UpdatePost(post)
 	if err != nil {
 		api.logger.Printf("error updating in database: [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeProblem(w, r, storageProblem(err))
 		return
 	}
+	api.notifyStoredUpdate(*before, post.Id)
 	api.writeResponse(w, nil, http.StatusOK)
 
 }
 
+// validate проверяет и дополняет публикацию, создаваемую или
+// изменяемую действием action. Для изменения возвращает
+// сохраненную публикацию до него
+func (api *Api) validate(action Action, post *storage.Post) (*storage.Post, *Problem) {
+	if p := normalizeTags(post); p != nil {
+		return nil, p
+	}
+	return api.lifecycle(action, post)
+}
+
 // deletePostHandler обработчик для метода DELETE
 func (api *Api) deletePostHandler(w http.ResponseWriter, r *http.Request) {
 
 	var post storage.Post
 
-	err := json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
-		api.logger.Printf("error decoding request body [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !api.decode(w, r, &post) {
+		return
+	}
+
+	if !api.authorize(w, r, ActionDelete, &post) {
 		return
 	}
 
-	err = api.db.DeletePost(post)
+	err := api.db.DeletePost(post)
 	if err != nil {
 		api.logger.Printf("error updating in database: [%v]\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeProblem(w, r, storageProblem(err))
 		return
 	}
+	api.notify(storage.EventDeleted, post)
 	api.writeResponse(w, nil, http.StatusOK)
 
 }
@@ -1,9 +1,11 @@
 package api
 
 import (
+	"GoNews/pkg/storage"
 	memDb "GoNews/pkg/storage/memdb"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -56,10 +58,27 @@ func TestApi_ServeHTTP(t *testing.T) {
 		resp := w.Result()
 
 		assert("http status code", http.StatusMethodNotAllowed, resp.StatusCode, t)
-		assert("Content-Type", "text/plain; charset=utf-8", resp.Header.Get("Content-Type"), t)
+		assert("Content-Type", "application/problem+json", resp.Header.Get("Content-Type"), t)
+		assert("Allow", "DELETE, GET, HEAD, OPTIONS, POST, PUT", resp.Header.Get("Allow"), t)
 
 	})
 
+	t.Run("head_request", func(t *testing.T) {
+		get := httptest.NewRecorder()
+		h.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil))
+
+		req := httptest.NewRequest(http.MethodHead, "http://test.com/posts", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+
+		assert("http status code", http.StatusOK, resp.StatusCode, t)
+		assert("Content-Type", "application/json", resp.Header.Get("Content-Type"), t)
+		assert("Content-Length", get.Result().Header.Get("Content-Length"), resp.Header.Get("Content-Length"), t)
+		assert("body length", 0, w.Body.Len(), t)
+	})
+
 	t.Run("options_request", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodOptions, "http://test.com/posts", nil)
 		w := httptest.NewRecorder()
@@ -159,3 +178,31 @@ func assert[T comparable](name string, want, got T, t *testing.T) {
 		t.Fatalf("%s = %v, want %v", name, got, want)
 	}
 }
+
+func TestApi_primaryReads(t *testing.T) {
+	var primary bool
+	h := primaryReads(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary = storage.Primary(r.Context())
+	}))
+
+	for _, tt := range []struct {
+		header    string
+		anonymous bool
+		want      bool
+	}{
+		{"", false, false},
+		{"max-age=0", false, false},
+		{"max-age=0, No-Cache", false, true},
+		{"no-cache", true, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil)
+		if tt.header != "" {
+			req.Header.Set("Cache-Control", tt.header)
+		}
+		if !tt.anonymous {
+			req = req.WithContext(WithPrincipal(req.Context(), Principal{Name: "author", Role: RoleAuthor}))
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		assert(fmt.Sprintf("primaryReads() Cache-Control: %s, anonymous %v", tt.header, tt.anonymous), tt.want, primary, t)
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Role роль пользователя сервиса
+type Role string
+
+const (
+	RoleAnonymous Role = "anonymous" // неаутентифицированный клиент
+	RoleReader    Role = "reader"
+	RoleAuthor    Role = "author"
+	RoleEditor    Role = "editor"
+	RoleAdmin     Role = "admin"
+)
+
+// Principal аутентифицированный пользователь.
+// AuthorId связывает пользователя с автором публикаций.
+// Id уникально определяет учетные данные пользователя,
+// в отличие от имени, которое может повторяться
+type Principal struct {
+	Id       string `json:"-"`
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	AuthorId int    `json:"author_id"`
+}
+
+// ErrInvalidCredentials возвращается, если клиент
+// предъявил ключ, который не удалось проверить
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator определяет пользователя по запросу.
+// Если запрос не содержит учетных данных, возвращается ok == false
+// и nil ошибка, если данные неверны - ErrInvalidCredentials
+type Authenticator interface {
+	Authenticate(r *http.Request) (p Principal, ok bool, err error)
+}
+
+// APIKeys аутентификатор на основе статических API-ключей,
+// в роли ключа карты выступает сам API-ключ
+type APIKeys map[string]Principal
+
+// LoadAPIKeys читает таблицу API-ключей в формате json
+func LoadAPIKeys(r io.Reader) (APIKeys, error) {
+	var keys APIKeys
+	if err := json.NewDecoder(r).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Authenticate реализует интерфейс Authenticator
+func (k APIKeys) Authenticate(r *http.Request) (Principal, bool, error) {
+	key := apiKey(r)
+	if key == "" {
+		return Principal{}, false, nil
+	}
+	p, ok := k[key]
+	if !ok {
+		return Principal{}, false, ErrInvalidCredentials
+	}
+	p.Id = keyId(key)
+	return p, true, nil
+}
+
+// key возвращает уникальный ключ пользователя: Id, а если
+// аутентификатор его не заполнил - имя пользователя
+func (p Principal) key() string {
+	if p.Id != "" {
+		return p.Id
+	}
+	return "name:" + p.Name
+}
+
+// keyId возвращает идентификатор пользователя по API-ключу:
+// хэш ключа, сам ключ нигде не сохраняется
+func keyId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])
+}
+
+// apiKey извлекает API-ключ из заголовка X-API-Key
+// либо из заголовка Authorization со схемой Bearer
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	const prefix = "bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	return ""
+}
+
+type ctxKey int
+
+const (
+	principalKey ctxKey = iota
+	requestIdKey
+	paramsKey
+)
+
+// WithPrincipal возвращает контекст, содержащий пользователя
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext возвращает пользователя из контекста запроса
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// authenticate промежуточный обработчик, который
+// определяет пользователя и помещает его в контекст запроса.
+// Отказ в доступе по непроверенному ключу расходует бюджет
+// адреса клиента, чтобы ключи нельзя было перебирать
+func (api *Api) authenticate(next http.Handler) http.Handler {
+	var reject http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, "invalid API key")
+	})
+	if api.limiter != nil {
+		reject = api.limiter.rateLimit(reject)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := api.auth.Authenticate(r)
+		if err != nil {
+			api.logger.Printf("error authenticating request [%v]\n", err)
+			reject.ServeHTTP(w, r)
+			return
+		}
+		if ok {
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// DefaultMaxBodySize максимальный размер тела запроса по умолчанию
+	DefaultMaxBodySize int64 = 1 << 20
+
+	// maxDrainSize сколько байт непрочитанного тела запроса вычитывается
+	// после ответа, чтобы сохранить соединение. Если тело больше,
+	// соединение закрывается
+	maxDrainSize int64 = 256 << 10
+)
+
+// WithMaxBodySize задает максимальный размер тела запроса для всех ресурсов
+func WithMaxBodySize(n int64) Option {
+	return func(api *Api) { api.maxBody = n }
+}
+
+// WithBodyLimit задает максимальный размер тела запроса к ресурсу
+func WithBodyLimit(resource string, n int64) Option {
+	return func(api *Api) {
+		if api.bodyLimits == nil {
+			api.bodyLimits = make(map[string]int64)
+		}
+		api.bodyLimits[resource] = n
+	}
+}
+
+// bodyLimit возвращает максимальный размер тела запроса к ресурсу
+func (api *Api) bodyLimit(resource string) int64 {
+	if n, ok := api.bodyLimits[resource]; ok {
+		return n
+	}
+	if api.maxBody > 0 {
+		return api.maxBody
+	}
+	return DefaultMaxBodySize
+}
+
+// limitBody ограничивает размер тела запроса к ресурсу. Если
+// заявленный клиентом размер превышает предел, пишет ответ 413
+// и возвращает false
+func (api *Api) limitBody(w http.ResponseWriter, r *http.Request, resource string) bool {
+	limit := api.bodyLimit(resource)
+	if r.ContentLength > limit {
+		writeError(w, r, http.StatusRequestEntityTooLarge,
+			"request body must not exceed "+strconv.FormatInt(limit, 10)+" bytes")
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// decode читает из тела запроса ровно один json-документ в v. При ошибке
+// пишет ответ 413, если тело превышает допустимый размер, или 400
+// и возвращает false. Данные после документа считаются ошибкой
+func (api *Api) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+
+	err := dec.Decode(v)
+	if err == nil {
+		if dec.Decode(&json.RawMessage{}) != io.EOF {
+			err = errors.New("unexpected data after json document")
+		}
+	}
+	if err == nil {
+		return true
+	}
+
+	api.logger.Printf("error decoding request body [%v]\n", err)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge,
+			"request body must not exceed "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+		return false
+	}
+	writeError(w, r, http.StatusBadRequest, "invalid request body: "+err.Error())
+	return false
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	memDb "GoNews/pkg/storage/memdb"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApi_bodyLimits(t *testing.T) {
+	a := New(memDb.New(), log.New(io.Discard, "", 0),
+		WithMaxBodySize(1024), WithBodyLimit("/posts", 64))
+	h := a.Mux()
+
+	post := `{"Id": 5, "Title": "t", "Content": "c"}`
+
+	tests := []struct {
+		name   string
+		body   io.Reader
+		length int64
+		want   int
+	}{
+		{"within_limit", strings.NewReader(post), int64(len(post)), http.StatusCreated},
+		{"declared_too_large", strings.NewReader(strings.Repeat(" ", 65)), 65, http.StatusRequestEntityTooLarge},
+		{"streamed_too_large", io.MultiReader(strings.NewReader(`{"Title": "`),
+			strings.NewReader(strings.Repeat("a", 100)), strings.NewReader(`"}`)), -1,
+			http.StatusRequestEntityTooLarge},
+		{"trailing_data", strings.NewReader(post + `{"Id": 6}`), -1, http.StatusBadRequest},
+		{"trailing_whitespace", strings.NewReader(`{"Id": 7}` + "\n\n"), -1, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://test.com/posts", tt.body)
+			req.ContentLength = tt.length
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert("http status code", tt.want, w.Result().StatusCode, t)
+		})
+	}
+
+	assert("bodyLimit(/posts)", int64(64), a.bodyLimit("/posts"), t)
+	assert("bodyLimit(/other)", int64(1024), a.bodyLimit("/other"), t)
+}
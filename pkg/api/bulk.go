@@ -0,0 +1,171 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// DefaultMaxBulkBodySize максимальный размер тела
+	// пакетного запроса по умолчанию
+	DefaultMaxBulkBodySize int64 = 32 << 20
+
+	// maxBulkItems максимальное число публикаций в пакетном запросе
+	maxBulkItems = 10000
+)
+
+// BulkItemResult результат пакетной операции над одной публикацией
+type BulkItemResult struct {
+	Id     int    `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkPostHandler обработчик для метода POST пакетного ресурса
+func (api *Api) bulkPostHandler(w http.ResponseWriter, r *http.Request) {
+	api.bulk(w, r, ActionCreate, api.db.AddPosts, http.StatusCreated)
+}
+
+// bulkPutHandler обработчик для метода PUT пакетного ресурса
+func (api *Api) bulkPutHandler(w http.ResponseWriter, r *http.Request) {
+	api.bulk(w, r, ActionUpdate, api.db.UpdatePosts, http.StatusOK)
+}
+
+// bulkDeleteHandler обработчик для метода DELETE пакетного ресурса
+func (api *Api) bulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	api.bulk(w, r, ActionDelete, api.db.DeletePosts, http.StatusOK)
+}
+
+// bulk выполняет пакетную операцию op над массивом публикаций из тела
+// запроса и отвечает 207 Multi-Status со статусом каждой публикации.
+// Параметр запроса atomic=true включает режим "все или ничего": если
+// хотя бы одна публикация не прошла, остальные получают статус
+// 424 Failed Dependency и не применяются
+func (api *Api) bulk(w http.ResponseWriter, r *http.Request, action Action,
+	op func([]storage.Post, bool) ([]error, error), okStatus int) {
+
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid atomic parameter: "+err.Error())
+			return
+		}
+	}
+
+	var posts []storage.Post
+	if !api.decode(w, r, &posts) {
+		return
+	}
+	if len(posts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "request body must be a non-empty array of posts")
+		return
+	}
+	if len(posts) > maxBulkItems {
+		writeError(w, r, http.StatusRequestEntityTooLarge,
+			"request must not contain more than "+strconv.Itoa(maxBulkItems)+" posts")
+		return
+	}
+
+	results := make([]BulkItemResult, len(posts))
+	for i := range posts {
+		results[i].Id = posts[i].Id
+		posts[i].Editor = editor(r)
+	}
+
+	// проверяем права на каждую публикацию и ее состояние,
+	// запрещенные публикации исключаются из пакета
+	allowed := make([]storage.Post, 0, len(posts))
+	before := make([]*storage.Post, 0, len(posts))
+	index := make([]int, 0, len(posts))
+	for i := range posts {
+		if p := api.permit(r, action, &posts[i]); p != nil {
+			if p.Status == http.StatusInternalServerError {
+				writeProblem(w, r, p)
+				return
+			}
+			results[i].Status, results[i].Error = p.Status, p.Detail
+			continue
+		}
+		var stored *storage.Post
+		if action == ActionCreate || action == ActionUpdate {
+			var p *Problem
+			if stored, p = api.validate(action, &posts[i]); p != nil {
+				if p.Status == http.StatusInternalServerError {
+					writeProblem(w, r, p)
+					return
+				}
+				results[i].Status, results[i].Error = p.Status, p.Detail
+				continue
+			}
+		}
+		allowed = append(allowed, posts[i])
+		before = append(before, stored)
+		index = append(index, i)
+	}
+
+	if atomic && len(allowed) < len(posts) {
+		failDependents(results)
+		api.writeResponse(w, map[string]any{"data": results}, http.StatusMultiStatus)
+		return
+	}
+
+	var errs []error
+	var err error
+	if len(allowed) > 0 {
+		errs, err = op(allowed, atomic)
+	}
+	if err != nil && (!errors.Is(err, storage.ErrBulkAborted) || errs == nil) {
+		api.logger.Printf("error executing bulk operation in database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+
+	for j, i := range index {
+		var itemErr error
+		if j < len(errs) {
+			itemErr = errs[j]
+		}
+		if itemErr == nil {
+			results[i].Status = okStatus
+			continue
+		}
+		p := storageProblem(itemErr)
+		if p.Status == http.StatusInternalServerError {
+			api.logger.Printf("error executing bulk operation for post [%d] in database: [%v]\n",
+				results[i].Id, itemErr)
+		}
+		results[i].Status, results[i].Error = p.Status, p.Detail
+	}
+
+	if err != nil {
+		failDependents(results)
+	} else {
+		for j, i := range index {
+			switch {
+			case results[i].Status != okStatus:
+			case action == ActionUpdate:
+				api.notifyStoredUpdate(*before[j], allowed[j].Id)
+			case action == ActionDelete:
+				api.notify(storage.EventDeleted, allowed[j])
+			default:
+				api.notifyStored(actionEvent(action), allowed[j].Id)
+			}
+		}
+	}
+
+	api.writeResponse(w, map[string]any{"data": results}, http.StatusMultiStatus)
+}
+
+// failDependents назначает статус 424 Failed Dependency публикациям,
+// которые не применены из-за ошибки в других публикациях пакета
+func failDependents(results []BulkItemResult) {
+	for i := range results {
+		if results[i].Status < http.StatusBadRequest {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = "not applied: another post in the batch failed"
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApi_bulk(t *testing.T) {
+	db := memDb.New()
+	h := New(db, log.New(io.Discard, "", 0)).Mux()
+
+	do := func(method, url string, posts []storage.Post) (int, []BulkItemResult) {
+		b, err := json.Marshal(posts)
+		if err != nil {
+			t.Fatalf("due encoding test data %v", err)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewReader(b)))
+
+		var reply struct{ Data []BulkItemResult }
+		if w.Code == http.StatusMultiStatus {
+			if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+				t.Fatalf("due decoding response %v", err)
+			}
+		}
+		return w.Code, reply.Data
+	}
+
+	t.Run("create_per_item", func(t *testing.T) {
+		code, res := do(http.MethodPost, "http://test.com/posts/bulk",
+			[]storage.Post{{Id: 10}, {Id: 1}, {Id: 11}})
+		assert("http status code", http.StatusMultiStatus, code, t)
+		assert("post 10", BulkItemResult{Id: 10, Status: http.StatusCreated}, res[0], t)
+		assert("post 1 status", http.StatusConflict, res[1].Status, t)
+		assert("post 11", BulkItemResult{Id: 11, Status: http.StatusCreated}, res[2], t)
+	})
+
+	t.Run("create_atomic", func(t *testing.T) {
+		code, res := do(http.MethodPost, "http://test.com/posts/bulk?atomic=true",
+			[]storage.Post{{Id: 20}, {Id: 10}})
+		assert("http status code", http.StatusMultiStatus, code, t)
+		assert("post 20 status", http.StatusFailedDependency, res[0].Status, t)
+		assert("post 10 status", http.StatusConflict, res[1].Status, t)
+
+		if _, err := db.Post(20); err != storage.ErrNotFound {
+			t.Fatalf("post 20 from aborted batch stored, error = %v", err)
+		}
+	})
+
+	t.Run("update_missing", func(t *testing.T) {
+		code, res := do(http.MethodPut, "http://test.com/posts/bulk",
+			[]storage.Post{{Id: 10, Title: "updated"}, {Id: 30}})
+		assert("http status code", http.StatusMultiStatus, code, t)
+		assert("post 10 status", http.StatusOK, res[0].Status, t)
+		assert("post 30 status", http.StatusNotFound, res[1].Status, t)
+
+		p, _ := db.Post(10)
+		assert("post 10 title", "updated", p.Title, t)
+	})
+
+	t.Run("delete_atomic", func(t *testing.T) {
+		code, res := do(http.MethodDelete, "http://test.com/posts/bulk?atomic=true",
+			[]storage.Post{{Id: 10}, {Id: 11}})
+		assert("http status code", http.StatusMultiStatus, code, t)
+		assert("post 10 status", http.StatusOK, res[0].Status, t)
+		assert("post 11 status", http.StatusOK, res[1].Status, t)
+
+		posts, _ := db.Posts()
+		assert("posts left", len(memDb.FakeData), len(posts), t)
+	})
+
+	t.Run("empty_batch", func(t *testing.T) {
+		code, _ := do(http.MethodPost, "http://test.com/posts/bulk", nil)
+		assert("http status code", http.StatusBadRequest, code, t)
+	})
+}
+
+func TestApi_bulkAuthorize(t *testing.T) {
+	db := memDb.New()
+	_ = db.AddPost(storage.Post{Id: 10, Author: storage.Author{Id: 2}})
+
+	keys := APIKeys{"author1": {Role: RoleAuthor, AuthorId: 1}}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	b, _ := json.Marshal([]storage.Post{{Id: 20}, {Id: 21, Author: storage.Author{Id: 2}}})
+	req := httptest.NewRequest(http.MethodPost, "http://test.com/posts/bulk", bytes.NewReader(b))
+	req.Header.Set("X-API-Key", "author1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var reply struct{ Data []BulkItemResult }
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatalf("due decoding response %v", err)
+	}
+	assert("own post status", http.StatusCreated, reply.Data[0].Status, t)
+	assert("foreign post status", http.StatusForbidden, reply.Data[1].Status, t)
+
+	p, err := db.Post(20)
+	if err != nil {
+		t.Fatalf("memdb.Post() = error %v", err)
+	}
+	assert("author of created post", 1, p.Author.Id, t)
+}
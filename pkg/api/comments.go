@@ -0,0 +1,276 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultCommentsLimit число обсуждений на странице по умолчанию
+	DefaultCommentsLimit = 20
+
+	// maxCommentsLimit максимальное число обсуждений на странице
+	maxCommentsLimit = 100
+
+	// maxCommentLen максимальная длина текста комментария в символах
+	maxCommentLen = 10000
+
+	// maxCommentAuthorLen максимальная длина имени автора комментария
+	maxCommentAuthorLen = 64
+)
+
+// CommentThread комментарий с ответами на него
+type CommentThread struct {
+	storage.Comment
+	Replies []*CommentThread
+}
+
+// getCommentsHandler обработчик для метода GET комментариев публикации,
+// возвращает страницу обсуждений - комментариев к самой публикации
+// со всеми ответами на них. Страница задается параметрами limit и offset
+func (api *Api) getCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, p := page(r)
+	if p != nil {
+		writeProblem(w, r, p)
+		return
+	}
+
+	_, roots, ok := api.threads(w, r, post.Id)
+	if !ok {
+		return
+	}
+
+	api.writeResponse(w, map[string]any{
+		"data":  paginate(roots, limit, offset),
+		"total": len(roots),
+	}, http.StatusOK)
+}
+
+// getCommentHandler обработчик для метода GET комментария, возвращает
+// комментарий со страницей ответов на него, каждый со всеми ответами
+func (api *Api) getCommentHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, p := page(r)
+	if p != nil {
+		writeProblem(w, r, p)
+		return
+	}
+
+	threads, _, ok := api.threads(w, r, post.Id)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(pathParam(r, "comment"))
+	t, ok := threads[id]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	thread := *t
+	thread.Replies = paginate(t.Replies, limit, offset)
+	api.writeResponse(w, map[string]any{"data": thread, "total": len(t.Replies)}, http.StatusOK)
+}
+
+// postCommentHandler обработчик для метода POST комментариев публикации.
+// Автором комментария аутентифицированного пользователя назначается сам
+// пользователь. Комментарии модераторов одобряются сразу, остальные
+// ожидают модерации
+func (api *Api) postCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var c storage.Comment
+
+	if !api.decode(w, r, &c) {
+		return
+	}
+
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	if pr, ok := principal(r); ok {
+		c.Author = pr.Name
+	}
+	c.Author = strings.TrimSpace(c.Author)
+	if c.Author == "" {
+		c.Author = "anonymous"
+	}
+	c.Text = strings.TrimSpace(c.Text)
+
+	switch {
+	case c.Text == "":
+		writeError(w, r, http.StatusBadRequest, "comment text must not be empty")
+		return
+	case utf8.RuneCountInString(c.Text) > maxCommentLen:
+		writeError(w, r, http.StatusBadRequest,
+			"comment text must not exceed "+strconv.Itoa(maxCommentLen)+" characters")
+		return
+	case utf8.RuneCountInString(c.Author) > maxCommentAuthorLen:
+		writeError(w, r, http.StatusBadRequest,
+			"comment author must not exceed "+strconv.Itoa(maxCommentAuthorLen)+" characters")
+		return
+	}
+
+	if c.ParentId != 0 {
+		parent, err := api.db.Comment(c.ParentId)
+		if errors.Is(err, storage.ErrNotFound) || err == nil && parent.PostId != post.Id {
+			writeError(w, r, http.StatusBadRequest, "parent comment not found")
+			return
+		}
+		if err != nil {
+			api.storageError(w, r, err)
+			return
+		}
+	}
+
+	c.Id, c.PostId, c.CreatedAt = 0, post.Id, 0
+	c.Status = storage.CommentPending
+	if api.allowedAll(r, ActionUpdate) {
+		c.Status = storage.CommentApproved
+	}
+
+	id, err := api.db.AddComment(c)
+	if err != nil {
+		api.logger.Printf("error adding comment to database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+
+	c, err = api.db.Comment(id)
+	if err != nil {
+		api.storageError(w, r, err)
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": c}, http.StatusCreated)
+}
+
+// moderateCommentHandler обработчик для метода PUT комментария,
+// изменяет состояние модерации. Модерировать комментарии может
+// только пользователь, которому разрешено изменять любые публикации
+func (api *Api) moderateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct{ Status storage.CommentStatus }
+
+	if !api.decode(w, r, &req) {
+		return
+	}
+
+	if !api.authorizeAll(w, r, ActionUpdate) {
+		return
+	}
+
+	switch req.Status {
+	case storage.CommentPending, storage.CommentApproved, storage.CommentRejected:
+	default:
+		writeError(w, r, http.StatusBadRequest, "unknown comment status "+string(req.Status))
+		return
+	}
+
+	postId, _ := strconv.Atoi(pathParam(r, "id"))
+	id, _ := strconv.Atoi(pathParam(r, "comment"))
+	c, err := api.db.Comment(id)
+	if err == nil && c.PostId != postId {
+		err = storage.ErrNotFound
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "comment not found")
+		return
+	}
+	if err != nil {
+		api.storageError(w, r, err)
+		return
+	}
+
+	err = api.db.SetCommentStatus(id, req.Status)
+	if err != nil {
+		api.logger.Printf("error updating comment in database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+	api.writeResponse(w, nil, http.StatusOK)
+}
+
+// threads возвращает видимые пользователю запроса комментарии
+// к публикации по id, каждый со всеми ответами на него, и отдельно
+// обсуждения по порядку создания. Ответы на скрытые комментарии
+// тоже скрыты. Неодобренные комментарии видны только модераторам
+func (api *Api) threads(w http.ResponseWriter, r *http.Request, postId int) (map[int]*CommentThread, []*CommentThread, bool) {
+	comments, err := api.db.Comments(postId)
+	if err != nil {
+		api.logger.Printf("error fetching comments from database: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return nil, nil, false
+	}
+
+	moderator := api.allowedAll(r, ActionUpdate)
+
+	// ответ всегда создается позже комментария, на который он дан,
+	// поэтому при обходе по возрастанию id родитель уже обработан
+	threads := make(map[int]*CommentThread, len(comments))
+	roots := []*CommentThread{}
+	for _, c := range comments {
+		if c.Status != storage.CommentApproved && !moderator {
+			continue
+		}
+		t := &CommentThread{Comment: c, Replies: []*CommentThread{}}
+		if c.ParentId != 0 {
+			parent, ok := threads[c.ParentId]
+			if !ok {
+				continue
+			}
+			parent.Replies = append(parent.Replies, t)
+		} else {
+			roots = append(roots, t)
+		}
+		threads[c.Id] = t
+	}
+	return threads, roots, true
+}
+
+// page возвращает параметры страницы limit и offset из запроса
+func page(r *http.Request) (limit, offset int, p *Problem) {
+	q := r.URL.Query()
+
+	limit = DefaultCommentsLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCommentsLimit {
+			return 0, 0, NewProblem(http.StatusBadRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxCommentsLimit))
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, NewProblem(http.StatusBadRequest, "offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// paginate возвращает страницу обсуждений
+func paginate(threads []*CommentThread, limit, offset int) []*CommentThread {
+	if offset >= len(threads) {
+		return []*CommentThread{}
+	}
+	end := offset + limit
+	if end > len(threads) {
+		end = len(threads)
+	}
+	return threads[offset:end]
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestApi_comments(t *testing.T) {
+	db := memDb.New()
+
+	keys := APIKeys{
+		"reader": {Name: "reader", Role: RoleReader},
+		"editor": {Name: "editor", Role: RoleEditor},
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(method, url, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	add := func(key, body string) int {
+		w := do(http.MethodPost, "http://test.com/posts/1/comments", key, body)
+		assert("add comment "+body, http.StatusCreated, w.Code, t)
+		var reply struct{ Data storage.Comment }
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatalf("due decoding comment = %v", err)
+		}
+		return reply.Data.Id
+	}
+
+	threads := func(url, key string) ([]*CommentThread, int) {
+		w := do(http.MethodGet, url, key, "")
+		assert(url, http.StatusOK, w.Code, t)
+		var reply struct {
+			Data  []*CommentThread
+			Total int
+		}
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatalf("due decoding %s = %v", url, err)
+		}
+		return reply.Data, reply.Total
+	}
+
+	first := add("reader", `{"Text": "first", "Author": "someone"}`)
+	c, _ := db.Comment(first)
+	assert("author", "reader", c.Author, t)
+	assert("status", storage.CommentPending, c.Status, t)
+
+	second := add("editor", `{"Text": "second"}`)
+	reply := add("", `{"Text": "reply", "ParentId": `+strconv.Itoa(second)+`}`)
+	add("editor", `{"Text": "nested", "ParentId": `+strconv.Itoa(first)+`}`)
+
+	w := do(http.MethodPost, "http://test.com/posts/1/comments", "", `{"Text": "  "}`)
+	assert("empty text", http.StatusBadRequest, w.Code, t)
+	w = do(http.MethodPost, "http://test.com/posts/2/comments", "", `{"Text": "x", "ParentId": `+strconv.Itoa(first)+`}`)
+	assert("foreign parent", http.StatusBadRequest, w.Code, t)
+	w = do(http.MethodPost, "http://test.com/posts/100/comments", "", `{"Text": "x"}`)
+	assert("missing post", http.StatusNotFound, w.Code, t)
+
+	// до модерации читатель видит только одобренное обсуждение без ответа
+	got, total := threads("http://test.com/posts/1/comments", "")
+	if total != 1 || got[0].Id != second || len(got[0].Replies) != 0 {
+		t.Fatalf("public threads = %v, want %d without replies", got, second)
+	}
+	_, total = threads("http://test.com/posts/1/comments", "editor")
+	assert("moderator threads", 2, total, t)
+
+	w = do(http.MethodPut, "http://test.com/posts/1/comments/"+strconv.Itoa(reply), "reader", `{"Status": "approved"}`)
+	assert("reader moderation", http.StatusForbidden, w.Code, t)
+	w = do(http.MethodPut, "http://test.com/posts/1/comments/"+strconv.Itoa(reply), "editor", `{"Status": "spam"}`)
+	assert("unknown status", http.StatusBadRequest, w.Code, t)
+	w = do(http.MethodPut, "http://test.com/posts/2/comments/"+strconv.Itoa(reply), "editor", `{"Status": "approved"}`)
+	assert("comment of other post", http.StatusNotFound, w.Code, t)
+
+	for _, id := range []int{first, reply} {
+		w = do(http.MethodPut, "http://test.com/posts/1/comments/"+strconv.Itoa(id), "editor", `{"Status": "approved"}`)
+		assert("approve", http.StatusOK, w.Code, t)
+	}
+
+	got, total = threads("http://test.com/posts/1/comments?limit=1&offset=1", "")
+	if total != 2 || len(got) != 1 || got[0].Id != second || len(got[0].Replies) != 1 || got[0].Replies[0].Id != reply {
+		t.Fatalf("second page = %v, want %d with reply %d", got, second, reply)
+	}
+
+	w = do(http.MethodGet, "http://test.com/posts/1/comments?limit=0", "", "")
+	assert("invalid limit", http.StatusBadRequest, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/1/comments/"+strconv.Itoa(first), "", "")
+	assert("thread", http.StatusOK, w.Code, t)
+	var thread struct {
+		Data  CommentThread
+		Total int
+	}
+	_ = json.NewDecoder(w.Body).Decode(&thread)
+	if thread.Data.Id != first || thread.Total != 1 || thread.Data.Replies[0].Text != "nested" {
+		t.Fatalf("thread = %v, want %d with nested reply", thread, first)
+	}
+}
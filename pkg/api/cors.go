@@ -0,0 +1,188 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig параметры CORS для браузерных клиентов,
+// работающих с других источников (origin)
+type CORSConfig struct {
+	// AllowedOrigins разрешенные источники, например
+	// "https://news.example.com". "*" разрешает любой источник,
+	// "https://*.example.com" - любой поддомен example.com
+	AllowedOrigins []string
+
+	// AllowedHeaders заголовки запроса, разрешенные для передачи.
+	// Если не заданы, используются DefaultCORSHeaders
+	AllowedHeaders []string
+
+	// ExposedHeaders заголовки ответа, доступные скрипту клиента
+	ExposedHeaders []string
+
+	// AllowCredentials разрешает передачу cookie и заголовка Authorization.
+	// Действует только для источников, перечисленных явно или маской
+	// поддомена: источникам, разрешенным через "*", учетные данные
+	// не передаются
+	AllowCredentials bool
+
+	// MaxAge время, на которое браузер может кешировать ответ на preflight
+	MaxAge time.Duration
+}
+
+// DefaultCORSHeaders заголовки запроса, разрешенные по умолчанию
+var DefaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "X-API-Key"}
+
+// WithCORS включает поддержку CORS
+func WithCORS(cfg CORSConfig) Option {
+	return func(api *Api) {
+		for i := range cfg.AllowedOrigins {
+			cfg.AllowedOrigins[i] = strings.TrimSpace(cfg.AllowedOrigins[i])
+		}
+		if len(cfg.AllowedHeaders) == 0 {
+			cfg.AllowedHeaders = DefaultCORSHeaders
+		}
+		api.cors = &cfg
+	}
+}
+
+// originAllowed сообщает, разрешен ли источник запроса
+func (c *CORSConfig) originAllowed(origin string) bool {
+	return c.match(origin, true)
+}
+
+// originListed сообщает, разрешен ли источник без учета "*"
+func (c *CORSConfig) originListed(origin string) bool {
+	return c.match(origin, false)
+}
+
+// match сообщает, подходит ли источник под разрешенные,
+// wildcard включает разрешение любого источника через "*"
+func (c *CORSConfig) match(origin string, wildcard bool) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	for _, o := range c.AllowedOrigins {
+		switch {
+		case o == "*":
+			if wildcard {
+				return true
+			}
+		case strings.EqualFold(o, origin):
+			return true
+		case strings.Contains(o, "://*."):
+			// источник с маской поддомена: схема и
+			// суффикс хоста должны совпадать
+			scheme, suffix, _ := strings.Cut(o, "://*")
+			if strings.EqualFold(scheme, u.Scheme) &&
+				len(u.Host) > len(suffix) &&
+				strings.EqualFold(u.Host[len(u.Host)-len(suffix):], suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headersAllowed сообщает, разрешены ли все заголовки
+// из Access-Control-Request-Headers
+func (c *CORSConfig) headersAllowed(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		found := false
+		for _, a := range c.AllowedHeaders {
+			if strings.EqualFold(a, h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// corsHandler промежуточный обработчик, который добавляет заголовки CORS
+// к ответам на запросы с разрешенных источников и отвечает на preflight-запросы
+// для всех зарегистрированных ресурсов API
+func (api *Api) corsHandler(next http.Handler) http.Handler {
+	c := api.cors
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		if !c.originAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || reqMethod == "" {
+			// обычный запрос
+			c.setOrigin(w, origin)
+			if len(c.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// preflight-запрос
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		_, resourceMethods, _, ok := api.route(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := resourceMethods.handler(reqMethod); !ok ||
+			!c.headersAllowed(r.Header.Get("Access-Control-Request-Headers")) {
+			writeError(w, r, http.StatusForbidden, "CORS request not allowed")
+			return
+		}
+
+		c.setOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", resourceMethods.allowedMethods())
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// setOrigin устанавливает Access-Control-Allow-Origin. При разрешенной
+// передаче учетных данных "*" недопустим, поэтому возвращается сам
+// источник, если он разрешен явно. Любой источник через "*" никогда
+// не получает учетные данные, иначе их мог бы прочитать любой сайт
+func (c *CORSConfig) setOrigin(w http.ResponseWriter, origin string) {
+	if c.AllowCredentials && c.originListed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	memDb "GoNews/pkg/storage/memdb"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApi_corsHandler(t *testing.T) {
+	a := New(memDb.New(), log.New(io.Discard, "", 0), WithCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://front.example.org", "https://*.example.com"},
+		ExposedHeaders:   []string{"RateLimit-Remaining"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	h := a.Mux()
+
+	t.Run("preflight", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, "http://test.com/posts", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		req.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert("http status code", http.StatusNoContent, resp.StatusCode, t)
+		assert("Access-Control-Allow-Origin", "https://app.example.com",
+			resp.Header.Get("Access-Control-Allow-Origin"), t)
+		assert("Access-Control-Allow-Methods", a.resources["/posts"].allowedMethods(),
+			resp.Header.Get("Access-Control-Allow-Methods"), t)
+		assert("Access-Control-Allow-Credentials", "true",
+			resp.Header.Get("Access-Control-Allow-Credentials"), t)
+		assert("Access-Control-Max-Age", "600", resp.Header.Get("Access-Control-Max-Age"), t)
+	})
+
+	t.Run("preflight_wrong_method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, "http://test.com/posts", nil)
+		req.Header.Set("Origin", "https://front.example.org")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert("http status code", http.StatusForbidden, resp.StatusCode, t)
+		assert("Access-Control-Allow-Origin", "", resp.Header.Get("Access-Control-Allow-Origin"), t)
+	})
+
+	t.Run("simple_request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil)
+		req.Header.Set("Origin", "https://front.example.org")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert("http status code", http.StatusOK, resp.StatusCode, t)
+		assert("Access-Control-Allow-Origin", "https://front.example.org",
+			resp.Header.Get("Access-Control-Allow-Origin"), t)
+		assert("Access-Control-Expose-Headers", "RateLimit-Remaining",
+			resp.Header.Get("Access-Control-Expose-Headers"), t)
+	})
+
+	t.Run("foreign_origin", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.org", "http://app.example.com", "https://example.com"} {
+			req := httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil)
+			req.Header.Set("Origin", origin)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert("Access-Control-Allow-Origin for "+origin, "",
+				w.Result().Header.Get("Access-Control-Allow-Origin"), t)
+		}
+	})
+}
+
+func TestApi_corsWildcardCredentials(t *testing.T) {
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithCORS(CORSConfig{
+		AllowedOrigins:   []string{"*", "https://front.example.org"},
+		AllowCredentials: true,
+	})).Mux()
+
+	do := func(origin string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Result().Header
+	}
+
+	// любой источник получает "*" без учетных данных
+	header := do("https://evil.org")
+	assert("Access-Control-Allow-Origin", "*", header.Get("Access-Control-Allow-Origin"), t)
+	assert("Access-Control-Allow-Credentials", "", header.Get("Access-Control-Allow-Credentials"), t)
+
+	// явно разрешенный источник получает учетные данные
+	header = do("https://front.example.org")
+	assert("listed Access-Control-Allow-Origin", "https://front.example.org",
+		header.Get("Access-Control-Allow-Origin"), t)
+	assert("listed Access-Control-Allow-Credentials", "true",
+		header.Get("Access-Control-Allow-Credentials"), t)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"strings"
+)
+
+const (
+	// maxDiffLines наибольшее число строк текста версии при сравнении
+	maxDiffLines = 10000
+
+	// maxDiffEdits наибольшее число правок между версиями: память
+	// на обратный ход растет как квадрат числа правок
+	maxDiffEdits = 1000
+)
+
+// DiffLine строка построчного сравнения: Op " " - строка
+// не изменилась, "-" - удалена, "+" - добавлена
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff построчное сравнение двух версий публикации
+type Diff struct {
+	PostId  int        `json:"post_id"`
+	From    int        `json:"from"`
+	To      int        `json:"to"`
+	Title   []DiffLine `json:"title"`
+	Content []DiffLine `json:"content"`
+}
+
+// diffRevisions сравнивает заголовки и тексты версий from и to.
+// Возвращает false, если версии слишком велики или слишком
+// различаются для сравнения
+func diffRevisions(from, to storage.Revision) (Diff, bool) {
+	title, ok := diffLines(splitLines(from.Title), splitLines(to.Title))
+	if !ok {
+		return Diff{}, false
+	}
+	content, ok := diffLines(splitLines(from.Content), splitLines(to.Content))
+	if !ok {
+		return Diff{}, false
+	}
+	return Diff{PostId: from.PostId, From: from.Number, To: to.Number, Title: title, Content: content}, true
+}
+
+// splitLines разбивает текст на строки, пустой текст не содержит строк
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// diffLines возвращает кратчайшую последовательность правок,
+// превращающую a в b (алгоритм Майерса). Возвращает false, если
+// в тексте больше maxDiffLines строк или правок больше maxDiffEdits
+func diffLines(a, b []string) ([]DiffLine, bool) {
+	n, m := len(a), len(b)
+	if n > maxDiffLines || m > maxDiffLines {
+		return nil, false
+	}
+	offset := n + m
+
+	// v[offset+k] - самая дальняя x на диагонали k, trace хранит
+	// перед каждым шагом d диагонали -d..d из v для обратного хода
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+
+	for d := 0; d <= offset; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // вставка
+			} else {
+				x = v[offset+k-1] + 1 // удаление
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), true
+			}
+		}
+	}
+	return nil, true
+}
+
+// backtrack восстанавливает правки по сохраненным шагам алгоритма,
+// trace[d][d+k] - самая дальняя x на диагонали k перед шагом d
+func backtrack(trace [][]int, a, b []string) []DiffLine {
+	x, y := len(a), len(b)
+	lines := make([]DiffLine, 0, x+y)
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, DiffLine{Op: " ", Text: a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			lines = append(lines, DiffLine{Op: "+", Text: b[y-1]})
+		} else {
+			lines = append(lines, DiffLine{Op: "-", Text: a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		lines = append(lines, DiffLine{Op: " ", Text: a[x-1]})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
@@ -0,0 +1,665 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	DefaultGraphQLMaxDepth      = 10   // уровней вложенности полей
+	DefaultGraphQLMaxComplexity = 5000 // полей с учетом размера страниц
+	DefaultGraphQLPageSize      = 20   // публикаций или авторов на странице
+	MaxGraphQLPageSize          = 100  // наибольший размер страницы
+)
+
+// GraphQLConfig ограничения запросов GraphQL, нулевые
+// значения заменяются значениями по умолчанию
+type GraphQLConfig struct {
+	// MaxDepth наибольшая вложенность полей запроса
+	MaxDepth int
+
+	// MaxComplexity наибольшая сложность запроса: каждое поле
+	// стоит 1, стоимость полей внутри страницы posts или authors
+	// умножается на ее размер first
+	MaxComplexity int
+}
+
+// graphqlRequest запрос GraphQL: тело POST или параметры GET
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// graphqlPaged поля схемы, возвращающие страницу списка
+var graphqlPaged = map[string]bool{"posts": true, "authors": true}
+
+// WithGraphQL включает /graphql: запросы публикаций и авторов
+// и изменение публикаций с теми же проверками, что и REST API
+func WithGraphQL(cfg GraphQLConfig) Option {
+	return func(api *Api) {
+		if cfg.MaxDepth <= 0 {
+			cfg.MaxDepth = DefaultGraphQLMaxDepth
+		}
+		if cfg.MaxComplexity <= 0 {
+			cfg.MaxComplexity = DefaultGraphQLMaxComplexity
+		}
+		api.gql = &cfg
+	}
+}
+
+// graphqlHandler обработчик для методов GET и POST запросов GraphQL.
+// Ошибки в запросе и превышение ограничений возвращаются со статусом
+// 400, ошибки полей - вместе с данными со статусом 200. Ошибки API
+// содержат код статуса HTTP в extensions.status
+func (api *Api) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodPost {
+		if !api.decode(w, r, &req) {
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid variables: "+err.Error())
+				return
+			}
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, r, http.StatusBadRequest, "query must not be empty")
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		api.writeGraphQLErrors(w, gqlerrors.FormatErrors(err))
+		return
+	}
+	if v := graphql.ValidateDocument(&api.schema, doc, nil); !v.IsValid {
+		api.writeGraphQLErrors(w, v.Errors)
+		return
+	}
+	op, err := graphqlOperation(doc, req.OperationName)
+	if err == nil {
+		err = api.graphqlLimits(doc, op, req.Variables)
+	}
+	if err != nil {
+		api.writeGraphQLErrors(w, gqlerrors.FormatErrors(err))
+		return
+	}
+	if r.Method != http.MethodPost && op.Operation != ast.OperationTypeQuery {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, http.StatusMethodNotAllowed, "mutations require POST")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), authorPostsKey{}, api.authorPostsLoader(r))
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        api.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+	graphqlStatuses(res.Errors)
+	api.writeResponse(w, res, http.StatusOK)
+}
+
+// writeGraphQLErrors пишет ответ 400 с ошибками запроса без данных
+func (api *Api) writeGraphQLErrors(w http.ResponseWriter, errs []gqlerrors.FormattedError) {
+	api.writeResponse(w, map[string]any{"errors": errs}, http.StatusBadRequest)
+}
+
+// graphqlOperation возвращает операцию документа с именем name,
+// пустое имя выбирает единственную операцию
+func graphqlOperation(doc *ast.Document, name string) (*ast.OperationDefinition, error) {
+	var op *ast.OperationDefinition
+	for _, d := range doc.Definitions {
+		d, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "" && op != nil:
+			return nil, fmt.Errorf("operationName is required for a document with several operations")
+		case name == "" || d.Name != nil && d.Name.Value == name:
+			op = d
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("unknown operation %q", name)
+	}
+	return op, nil
+}
+
+// graphqlLimits проверяет вложенность и сложность операции op
+func (api *Api) graphqlLimits(doc *ast.Document, op *ast.OperationDefinition, vars map[string]any) error {
+	c := queryCost{fragments: make(map[string]*ast.FragmentDefinition), vars: vars}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			c.fragments[f.Name.Value] = f
+		}
+	}
+
+	depth, complexity := c.selection(op.SelectionSet)
+	if depth > api.gql.MaxDepth {
+		return fmt.Errorf("query depth %d exceeds limit %d", depth, api.gql.MaxDepth)
+	}
+	if complexity > api.gql.MaxComplexity {
+		return fmt.Errorf("query complexity %d exceeds limit %d", complexity, api.gql.MaxComplexity)
+	}
+	return nil
+}
+
+// maxQueryCost предел, которым ограничивается подсчет сложности
+// запроса, чтобы он не переполнялся
+const maxQueryCost = 1 << 20
+
+// queryCost подсчет вложенности и сложности запроса. Поля интроспекции
+// (__schema, __type) не учитываются: их вложенность ограничена схемой
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	vars      map[string]any
+}
+
+// selection возвращает вложенность и сложность набора полей
+func (c queryCost) selection(set *ast.SelectionSet) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, s := range set.Selections {
+		var d, n int
+		switch s := s.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, n = c.selection(s.SelectionSet)
+			d++
+			if graphqlPaged[s.Name.Value] {
+				n *= c.pageSize(s)
+			}
+			n++
+		case *ast.FragmentSpread:
+			// циклы фрагментов отклоняются при проверке запроса
+			if f := c.fragments[s.Name.Value]; f != nil {
+				d, n = c.selection(f.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			d, n = c.selection(s.SelectionSet)
+		}
+		if d > depth {
+			depth = d
+		}
+		if complexity += n; complexity > maxQueryCost {
+			complexity = maxQueryCost
+		}
+	}
+	return depth, complexity
+}
+
+// pageSize возвращает размер страницы, запрошенный аргументом first поля
+func (c queryCost) pageSize(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		var first any
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			first, _ = strconv.Atoi(v.Value)
+		case *ast.Variable:
+			first = c.vars[v.Name.Value]
+		}
+		switch first := first.(type) {
+		case int:
+			return graphqlPageSize(first)
+		case float64:
+			return graphqlPageSize(int(first))
+		}
+	}
+	return DefaultGraphQLPageSize
+}
+
+// graphqlPageSize приводит размер страницы к допустимым пределам
+func graphqlPageSize(first int) int {
+	switch {
+	case first < 1:
+		return 1
+	case first > MaxGraphQLPageSize:
+		return MaxGraphQLPageSize
+	}
+	return first
+}
+
+// graphqlStatuses дополняет ошибки полей, вызванные описанием ошибки
+// API, кодом статуса HTTP
+func graphqlStatuses(errs []gqlerrors.FormattedError) {
+	for i := range errs {
+		var err error = errs[i]
+		for err != nil {
+			switch e := err.(type) {
+			case *Problem:
+				errs[i].Extensions = map[string]any{"status": e.Status}
+				err = nil
+			case gqlerrors.FormattedError:
+				err = e.OriginalError()
+			case *gqlerrors.Error:
+				err = e.OriginalError
+			default:
+				err = nil
+			}
+		}
+	}
+}
+
+// graphqlPage возвращает страницу items по аргументам first и after
+func graphqlPage[T any](params graphql.ResolveParams, items []T) (map[string]any, error) {
+	size := DefaultGraphQLPageSize
+	if first, ok := params.Args["first"].(int); ok {
+		if first < 1 {
+			return nil, NewProblem(http.StatusBadRequest, "first must be positive")
+		}
+		size = graphqlPageSize(first)
+	}
+	after, _ := params.Args["after"].(string)
+	from, to, next, ok := pageBounds(len(items), size, after)
+	if !ok {
+		return nil, NewProblem(http.StatusBadRequest, "invalid cursor after")
+	}
+
+	page := map[string]any{"items": items[from:to], "nextCursor": nil}
+	if next != "" {
+		page["nextCursor"] = next
+	}
+	return page, nil
+}
+
+// authorPostsKey ключ загрузчика публикаций авторов в контексте запроса
+type authorPostsKey struct{}
+
+// authorPosts загружает опубликованные публикации авторов пакетами:
+// поля posts всех авторов одного уровня запроса регистрируют авторов,
+// а первое вычисленное из них читает публикации из БД один раз для всех
+type authorPosts struct {
+	load func(ids []int) (map[int][]storage.Post, error)
+
+	mu      sync.Mutex
+	pending []int
+	posts   map[int][]storage.Post
+	errs    map[int]error
+}
+
+// authorPostsLoader возвращает загрузчик публикаций авторов,
+// доступных пользователю запроса r
+func (api *Api) authorPostsLoader(r *http.Request) *authorPosts {
+	return &authorPosts{
+		load: func(ids []int) (map[int][]storage.Post, error) {
+			posts, p := api.listPosts(r, storage.StatusPublished, nil, false)
+			if p != nil {
+				return nil, p
+			}
+			byAuthor := make(map[int][]storage.Post, len(ids))
+			for _, id := range ids {
+				byAuthor[id] = []storage.Post{}
+			}
+			for _, post := range posts {
+				if list, ok := byAuthor[post.Author.Id]; ok {
+					byAuthor[post.Author.Id] = append(list, post)
+				}
+			}
+			return byAuthor, nil
+		},
+		posts: make(map[int][]storage.Post),
+		errs:  make(map[int]error),
+	}
+}
+
+// get регистрирует автора id и возвращает функцию, которая
+// загружает его публикации вместе с остальными ожидающими
+func (l *authorPosts) get(id int) func() (any, error) {
+	l.mu.Lock()
+	if _, ok := l.posts[id]; !ok {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.pending) > 0 {
+			ids := l.pending
+			l.pending = nil
+			posts, err := l.load(ids)
+			for _, id := range ids {
+				l.posts[id], l.errs[id] = posts[id], err
+			}
+		}
+		return l.posts[id], l.errs[id]
+	}
+}
+
+// graphqlSchema возвращает схему GraphQL API
+func (api *Api) graphqlSchema() graphql.Schema {
+	author := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: authorField(func(a storage.Author) any { return a.Id })},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: authorField(func(a storage.Author) any { return a.Name })},
+		},
+	})
+	post := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.Fields{
+			"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: postField(func(p storage.Post) any { return p.Id })},
+			"author":    &graphql.Field{Type: graphql.NewNonNull(author), Resolve: postField(func(p storage.Post) any { return p.Author })},
+			"title":     &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: postField(func(p storage.Post) any { return p.Title })},
+			"content":   &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: postField(func(p storage.Post) any { return p.Content })},
+			"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: postField(func(p storage.Post) any { return p.CreatedAt })},
+			"status":    &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: postField(func(p storage.Post) any { return string(p.Status) })},
+			"publishAt": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: postField(func(p storage.Post) any { return p.PublishAt })},
+			"tags": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Resolve: postField(func(p storage.Post) any {
+					if p.Tags == nil {
+						return []string{}
+					}
+					return p.Tags
+				}),
+			},
+		},
+	})
+	postPage := pageType("PostPage", post)
+	authorPage := pageType("AuthorPage", author)
+
+	pageArgs := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{Type: graphql.Int, Description: "Размер страницы"},
+		"after": &graphql.ArgumentConfig{Type: graphql.String, Description: "nextCursor предыдущей страницы"},
+	}
+	author.AddFieldConfig("posts", &graphql.Field{
+		Type:        graphql.NewNonNull(postPage),
+		Description: "Опубликованные публикации автора",
+		Args:        pageArgs,
+		Resolve: func(params graphql.ResolveParams) (any, error) {
+			loader := params.Context.Value(authorPostsKey{}).(*authorPosts)
+			load := loader.get(params.Source.(storage.Author).Id)
+			return func() (any, error) {
+				posts, err := load()
+				if err != nil {
+					return nil, err
+				}
+				return graphqlPage(params, posts.([]storage.Post))
+			}, nil
+		},
+	})
+
+	postsArgs := graphql.FieldConfigArgument{
+		"status":   &graphql.ArgumentConfig{Type: graphql.String, Description: "Состояние публикаций, по умолчанию published"},
+		"tags":     &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		"matchAll": &graphql.ArgumentConfig{Type: graphql.Boolean, Description: "Публикации со всеми тегами tags, а не с любым"},
+	}
+	for name, arg := range pageArgs {
+		postsArgs[name] = arg
+	}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"posts":   &graphql.Field{Type: graphql.NewNonNull(postPage), Args: postsArgs, Resolve: api.resolvePosts},
+			"post":    &graphql.Field{Type: post, Args: idArgs(), Resolve: api.resolvePost},
+			"authors": &graphql.Field{Type: graphql.NewNonNull(authorPage), Args: pageArgs, Resolve: api.resolveAuthors},
+			"author":  &graphql.Field{Type: author, Args: idArgs(), Resolve: api.resolveAuthor},
+		},
+	})
+
+	authorInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "AuthorInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"name": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+	postInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PostInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id":        &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"author":    &graphql.InputObjectFieldConfig{Type: authorInput},
+			"title":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"content":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"createdAt": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"status":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"publishAt": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"tags":      &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		},
+	})
+	inputArgs := graphql.FieldConfigArgument{
+		"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(postInput)},
+	}
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createPost": &graphql.Field{Type: graphql.NewNonNull(post), Args: inputArgs, Resolve: api.resolveCreatePost},
+			"updatePost": &graphql.Field{Type: graphql.NewNonNull(post), Args: inputArgs, Resolve: api.resolveUpdatePost},
+			"deletePost": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Args: idArgs(), Resolve: api.resolveDeletePost},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		// схема задана в коде, ошибка в ней - ошибка программы
+		panic("graphql schema: " + err.Error())
+	}
+	return schema
+}
+
+// pageType возвращает тип страницы списка элементов item
+func pageType(name string, item *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			"items": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(item)))},
+			"nextCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Курсор следующей страницы, null - страница последняя",
+			},
+		},
+	})
+}
+
+func idArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}}
+}
+
+func postField(f func(storage.Post) any) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (any, error) {
+		return f(params.Source.(storage.Post)), nil
+	}
+}
+
+func authorField(f func(storage.Author) any) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (any, error) {
+		return f(params.Source.(storage.Author)), nil
+	}
+}
+
+// resolvePosts возвращает страницу публикаций, как GET /posts
+func (api *Api) resolvePosts(params graphql.ResolveParams) (any, error) {
+	r := contextRequest(params.Context)
+	if p := api.permit(r, ActionRead, nil); p != nil {
+		return nil, p
+	}
+
+	var tags []string
+	list, _ := params.Args["tags"].([]any)
+	for _, t := range list {
+		t, err := normalizeTag(t.(string))
+		if err != nil {
+			return nil, NewProblem(http.StatusBadRequest, err.Error())
+		}
+		tags = append(tags, t)
+	}
+	status, _ := params.Args["status"].(string)
+	all, _ := params.Args["matchAll"].(bool)
+
+	posts, p := api.listPosts(r, storage.Status(status), tags, all)
+	if p != nil {
+		return nil, p
+	}
+	return graphqlPage(params, posts)
+}
+
+// resolvePost возвращает публикацию по id, если пользователь ее видит
+func (api *Api) resolvePost(params graphql.ResolveParams) (any, error) {
+	r := contextRequest(params.Context)
+	post, err := storage.PostContext(params.Context, api.db, params.Args["id"].(int))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+	if p := api.permit(r, ActionRead, &post); p != nil {
+		return nil, p
+	}
+	if !api.visible(r, post) {
+		return nil, nil
+	}
+	return post, nil
+}
+
+// resolveAuthors возвращает страницу авторов опубликованных
+// публикаций по возрастанию id
+func (api *Api) resolveAuthors(params graphql.ResolveParams) (any, error) {
+	authors, p := api.authors(contextRequest(params.Context))
+	if p != nil {
+		return nil, p
+	}
+	return graphqlPage(params, authors)
+}
+
+// resolveAuthor возвращает автора опубликованных публикаций по id
+func (api *Api) resolveAuthor(params graphql.ResolveParams) (any, error) {
+	authors, p := api.authors(contextRequest(params.Context))
+	if p != nil {
+		return nil, p
+	}
+	id := params.Args["id"].(int)
+	i := sort.Search(len(authors), func(i int) bool { return authors[i].Id >= id })
+	if i == len(authors) || authors[i].Id != id {
+		return nil, nil
+	}
+	return authors[i], nil
+}
+
+// resolveCreatePost создает публикацию, как POST /posts
+func (api *Api) resolveCreatePost(params graphql.ResolveParams) (any, error) {
+	r := contextRequest(params.Context)
+	post := postFromInput(params.Args["input"].(map[string]any))
+	post.Editor = editor(r)
+
+	if p := api.permit(r, ActionCreate, &post); p != nil {
+		return nil, p
+	}
+	if _, p := api.validate(ActionCreate, &post); p != nil {
+		return nil, p
+	}
+	if err := api.db.AddPost(post); err != nil {
+		api.logger.Printf("error posting to database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+
+	stored, err := api.storedPost(post.Id)
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+	api.notify(storage.EventCreated, stored)
+	return stored, nil
+}
+
+// resolveUpdatePost изменяет публикацию, как PUT /posts
+func (api *Api) resolveUpdatePost(params graphql.ResolveParams) (any, error) {
+	r := contextRequest(params.Context)
+	post := postFromInput(params.Args["input"].(map[string]any))
+	post.Editor = editor(r)
+
+	if p := api.permit(r, ActionUpdate, &post); p != nil {
+		return nil, p
+	}
+	before, p := api.validate(ActionUpdate, &post)
+	if p != nil {
+		return nil, p
+	}
+	if err := api.db.UpdatePost(post); err != nil {
+		api.logger.Printf("error updating in database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+
+	stored, err := api.storedPost(post.Id)
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+	api.notifyUpdate(*before, stored)
+	return stored, nil
+}
+
+// resolveDeletePost удаляет публикацию в корзину, как DELETE /posts
+func (api *Api) resolveDeletePost(params graphql.ResolveParams) (any, error) {
+	r := contextRequest(params.Context)
+	post := storage.Post{Id: params.Args["id"].(int)}
+
+	if p := api.permit(r, ActionDelete, &post); p != nil {
+		return nil, p
+	}
+	if err := api.db.DeletePost(post); err != nil {
+		api.logger.Printf("error updating in database: [%v]\n", err)
+		return nil, storageProblem(err)
+	}
+	api.notify(storage.EventDeleted, post)
+	return true, nil
+}
+
+// postFromInput переводит аргумент PostInput в публикацию
+func postFromInput(in map[string]any) storage.Post {
+	var post storage.Post
+	post.Id, _ = in["id"].(int)
+	post.Title, _ = in["title"].(string)
+	post.Content, _ = in["content"].(string)
+	if v, ok := in["createdAt"].(int); ok {
+		post.CreatedAt = int64(v)
+	}
+	if v, ok := in["status"].(string); ok {
+		post.Status = storage.Status(v)
+	}
+	if v, ok := in["publishAt"].(int); ok {
+		post.PublishAt = int64(v)
+	}
+	if a, ok := in["author"].(map[string]any); ok {
+		post.Author.Id, _ = a["id"].(int)
+		post.Author.Name, _ = a["name"].(string)
+	}
+	if tags, ok := in["tags"].([]any); ok {
+		post.Tags = make([]string, 0, len(tags))
+		for _, t := range tags {
+			post.Tags = append(post.Tags, t.(string))
+		}
+	}
+	return post
+}
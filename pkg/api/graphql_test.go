@@ -0,0 +1,211 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// graphqlResponse ответ на запрос GraphQL. Поля data
+// упорядочены по имени, а не как в запросе
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// graphqlDo выполняет запрос GraphQL методом POST с API-ключом key
+func graphqlDo(t *testing.T, h http.Handler, key, query string, vars map[string]any) (int, graphqlResponse) {
+	t.Helper()
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: vars})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var resp graphqlResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("graphql response %q: %v", rr.Body.String(), err)
+	}
+	return rr.Code, resp
+}
+
+func TestApi_graphql(t *testing.T) {
+	keys := APIKeys{
+		"author": {Name: "author", Role: RoleAuthor, AuthorId: 7},
+		"editor": {Name: "editor", Role: RoleEditor},
+	}
+	api := New(memDb.New(), log.New(io.Discard, "", 0),
+		WithAuthenticator(keys), WithPolicy(DefaultPolicy), WithGraphQL(GraphQLConfig{}))
+	h := api.Mux()
+
+	create := `mutation($input: PostInput!) { createPost(input: $input) { id status tags author { id } } }`
+	code, resp := graphqlDo(t, h, "", create, map[string]any{"input": map[string]any{"id": 60, "title": "anonymous"}})
+	assert("anonymous createPost status", http.StatusOK, code, t)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["status"] != float64(http.StatusUnauthorized) {
+		t.Fatalf("anonymous createPost errors = %+v, want status 401", resp.Errors)
+	}
+
+	code, resp = graphqlDo(t, h, "author", create, map[string]any{"input": map[string]any{"id": 60, "title": "news", "tags": []string{"Go"}}})
+	assert("author createPost status", http.StatusOK, code, t)
+	assert("author createPost data",
+		`{"createPost":{"author":{"id":7},"id":60,"status":"published","tags":["go"]}}`, string(resp.Data), t)
+
+	_, resp = graphqlDo(t, h, "author", `mutation { updatePost(input: {id: 1, title: "not mine"}) { id } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["status"] != float64(http.StatusForbidden) {
+		t.Fatalf("author updatePost of other post errors = %+v, want status 403", resp.Errors)
+	}
+	_, resp = graphqlDo(t, h, "editor",
+		`mutation { createPost(input: {id: 61, title: "other", author: {id: 8, name: "Bob"}}) { id } }`, nil)
+	if len(resp.Errors) != 0 {
+		t.Fatalf("editor createPost errors = %+v", resp.Errors)
+	}
+
+	_, resp = graphqlDo(t, h, "author", `{
+		posts(tags: ["go"]) { items { title author { id } } nextCursor }
+		post(id: 99) { id }
+		author(id: 8) { name posts { items { id } } }
+	}`, nil)
+	assert("query data",
+		`{"author":{"name":"Bob","posts":{"items":[{"id":61}]}},"post":null,"posts":{"items":[{"author":{"id":7},"title":"news"}],"nextCursor":null}}`,
+		string(resp.Data), t)
+
+	_, resp = graphqlDo(t, h, "author", `{ authors(first: 1) { items { id } nextCursor } }`, nil)
+	var page struct {
+		Authors struct {
+			Items      []storage.Author
+			NextCursor string
+		}
+	}
+	json.Unmarshal(resp.Data, &page)
+	if len(page.Authors.Items) != 1 || page.Authors.Items[0].Id != 7 || page.Authors.NextCursor == "" {
+		t.Fatalf("first authors page = %s", resp.Data)
+	}
+	_, resp = graphqlDo(t, h, "author", `query($after: String) { authors(first: 1, after: $after) { items { id } nextCursor } }`,
+		map[string]any{"after": page.Authors.NextCursor})
+	assert("second authors page", `{"authors":{"items":[{"id":8}],"nextCursor":null}}`, string(resp.Data), t)
+
+	_, resp = graphqlDo(t, h, "editor", `mutation { deletePost(id: 61) }`, nil)
+	assert("deletePost data", `{"deletePost":true}`, string(resp.Data), t)
+
+	// изменения методом GET не выполняются
+	req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`mutation { deletePost(id: 60) }`), nil)
+	req.Header.Set("X-API-Key", "editor")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert("GET mutation status", http.StatusMethodNotAllowed, rr.Code, t)
+
+	req = httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ post(id: 60) { title } }`), nil)
+	req.Header.Set("X-API-Key", "author")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert("GET query body", `{"data":{"post":{"title":"news"}}}`, strings.TrimSpace(rr.Body.String()), t)
+
+	code, resp = graphqlDo(t, h, "author", `{ posts { items { unknown } } }`, nil)
+	assert("invalid query status", http.StatusBadRequest, code, t)
+	if len(resp.Errors) == 0 || resp.Data != nil {
+		t.Fatalf("invalid query response = %+v", resp)
+	}
+}
+
+// countingDB БД, считающая чтения всех публикаций
+type countingDB struct {
+	storage.Model
+	reads int32
+}
+
+func (db *countingDB) Posts() ([]storage.Post, error) {
+	atomic.AddInt32(&db.reads, 1)
+	return db.Model.Posts()
+}
+
+func TestApi_graphqlBatch(t *testing.T) {
+	db := &countingDB{Model: memDb.New()}
+	for i, author := range []storage.Author{{Id: 1, Name: "Ann"}, {Id: 2, Name: "Bob"}, {Id: 3, Name: "Eve"}} {
+		for j := 0; j < 2; j++ {
+			db.AddPost(storage.Post{Id: 50 + 2*i + j, Title: author.Name, Author: author})
+		}
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithGraphQL(GraphQLConfig{})).Mux()
+
+	atomic.StoreInt32(&db.reads, 0)
+	_, resp := graphqlDo(t, h, "", `{ authors { items { name posts { items { title author { name } } } } } }`, nil)
+	if len(resp.Errors) != 0 {
+		t.Fatalf("errors = %+v", resp.Errors)
+	}
+	var data struct {
+		Authors struct {
+			Items []struct {
+				Name  string
+				Posts struct{ Items []storage.Post }
+			}
+		}
+	}
+	json.Unmarshal(resp.Data, &data)
+	assert("authors", 3, len(data.Authors.Items), t)
+	for _, a := range data.Authors.Items {
+		assert(a.Name+" posts", 2, len(a.Posts.Items), t)
+		assert(a.Name+" post title", a.Name, a.Posts.Items[0].Title, t)
+	}
+	// одно чтение для списка авторов и одно для публикаций всех авторов
+	assert("posts reads", int32(2), atomic.LoadInt32(&db.reads), t)
+}
+
+func TestApi_graphqlLimits(t *testing.T) {
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithGraphQL(GraphQLConfig{MaxDepth: 5, MaxComplexity: 200})).Mux()
+
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]any
+		want  string // начало сообщения об ошибке, пустое - без ошибок
+	}{
+		{"flat", `{ posts { items { id title } } }`, nil, ""},
+		{"deep", `{ authors(first: 1) { items { posts(first: 1) { items { author { name } } } } } }`, nil, "query depth 6 exceeds limit 5"},
+		{"fragment depth", `{ authors(first: 1) { items { ...p } } } fragment p on Author { posts(first: 1) { items { author { id } } } }`, nil, "query depth 6"},
+		{"nested pages", `{ authors { items { posts { items { id } } } } }`, nil, "query complexity"},
+		{"small nested pages", `{ authors(first: 3) { items { posts(first: 3) { items { id } } } } }`, nil, ""},
+		{"page size variable", `query($n: Int) { posts(first: $n) { items { id title content } } }`, map[string]any{"n": 100}, "query complexity 401"},
+		{"introspection", `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := graphqlDo(t, h, "", tt.query, tt.vars)
+			if tt.want == "" {
+				if code != http.StatusOK || len(resp.Errors) != 0 {
+					t.Fatalf("status %d, errors %+v", code, resp.Errors)
+				}
+				return
+			}
+			assert("status", http.StatusBadRequest, code, t)
+			if len(resp.Errors) != 1 || !strings.HasPrefix(resp.Errors[0].Message, tt.want) {
+				t.Fatalf("errors = %+v, want %q", resp.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestApi_graphqlCreateStored(t *testing.T) {
+	h := New(stampingDB{memDb.New()}, log.New(io.Discard, "", 0), WithGraphQL(GraphQLConfig{})).Mux()
+
+	// возвращается сохраненная публикация, а не переданная
+	_, resp := graphqlDo(t, h, "", `mutation { createPost(input: {id: 60, title: "news"}) { id createdAt } }`, nil)
+	if len(resp.Errors) != 0 {
+		t.Fatalf("createPost errors = %+v", resp.Errors)
+	}
+	assert("createPost data", `{"createPost":{"createdAt":42,"id":60}}`, string(resp.Data), t)
+}
@@ -0,0 +1,531 @@
+package api
+
+import (
+	"GoNews/pkg/newspb"
+	"GoNews/pkg/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultGRPCPageSize = 50  // публикаций или авторов на странице
+	MaxGRPCPageSize     = 500 // наибольший размер страницы
+)
+
+// grpcService сервис публикаций gRPC. Вызовы проходят те же
+// проверки доступа и состояния публикаций, что и запросы REST API,
+// и рассылают те же события подписчикам и в ленту
+type grpcService struct {
+	newspb.UnimplementedNewsServiceServer
+	api *Api
+}
+
+// GRPCServer возвращает сервер gRPC с сервисом публикаций.
+// Перехватчики определяют пользователя по метаданным x-api-key
+// или authorization (как заголовки REST API), пишут вызовы
+// в журнал, перехватывают паники, ограничивают частоту вызовов
+// и переводят ошибки в коды состояния gRPC. Размер сообщения
+// ограничен, как тело запроса к /posts
+func (api *Api) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.MaxRecvMsgSize(int(api.bodyLimit("/posts"))),
+		grpc.ChainUnaryInterceptor(api.grpcLogUnary, api.grpcRecoverUnary, api.grpcAuthUnary,
+			api.grpcLimitUnary, api.grpcErrorsUnary),
+		grpc.ChainStreamInterceptor(api.grpcLogStream, api.grpcRecoverStream, api.grpcAuthStream,
+			api.grpcLimitStream, api.grpcErrorsStream),
+	}, opts...)
+	s := grpc.NewServer(opts...)
+	newspb.RegisterNewsServiceServer(s, &grpcService{api: api})
+	return s
+}
+
+// GRPCMux возвращает мультиплексер, который обслуживает на одном порту
+// вызовы gRPC сервером s, а остальные запросы - как Mux. HTTP/2
+// без TLS (h2c) поддерживается для клиентов gRPC внутри сети
+func (api *Api) GRPCMux(s *grpc.Server) http.Handler {
+	h := api.Mux()
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+// grpcRequest возвращает запрос с заголовками из метаданных вызова
+// и адресом клиента, чтобы определить клиента так же, как в REST API
+func grpcRequest(ctx context.Context) *http.Request {
+	r := contextRequest(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"X-API-Key", "Authorization", "X-Forwarded-For"} {
+		for _, v := range md.Get(key) {
+			r.Header.Add(key, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// grpcAuth определяет пользователя вызова method по метаданным
+// и помещает его в контекст. Как и в REST API, отказ по
+// непроверенному ключу расходует бюджет адреса клиента
+func (api *Api) grpcAuth(ctx context.Context, method string) (context.Context, error) {
+	if api.auth == nil {
+		return ctx, nil
+	}
+	p, ok, err := api.auth.Authenticate(grpcRequest(ctx))
+	if err != nil {
+		api.logger.Printf("error authenticating request [%v]\n", err)
+		if err := api.grpcLimit(ctx, method); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	if ok {
+		ctx = WithPrincipal(ctx, p)
+	}
+	return ctx, nil
+}
+
+func (api *Api) grpcAuthUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := api.grpcAuth(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (api *Api) grpcAuthStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := api.grpcAuth(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcStream поток вызова с контекстом, дополненным перехватчиком
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcError переводит ошибку обработчика в ошибку с кодом состояния
+// gRPC: описание ошибки REST API - по коду статуса HTTP, ошибки БД -
+// как в REST API, остальные - Internal
+func (api *Api) grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var p *Problem
+	switch {
+	case errors.As(err, &p):
+		return status.Error(grpcCode(p.Status), p.Detail)
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, "post not found")
+	case errors.Is(err, storage.ErrConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		api.logger.Printf("error serving grpc call: [%v]\n", err)
+		return status.Error(codes.Internal, "")
+	}
+}
+
+// grpcCode возвращает код состояния gRPC, соответствующий статусу HTTP
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+func (api *Api) grpcErrorsUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	return resp, api.grpcError(err)
+}
+
+func (api *Api) grpcErrorsStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return api.grpcError(handler(srv, ss))
+}
+
+// grpcLimit проверяет бюджет клиента вызова method ограничителем
+// частоты запросов REST API. Методы Get, List и Watch расходуют
+// бюджет чтения, остальные - бюджет изменений
+func (api *Api) grpcLimit(ctx context.Context, method string) error {
+	rl := api.limiter
+	if rl == nil {
+		return nil
+	}
+	name := method[strings.LastIndex(method, "/")+1:]
+	read := strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List") || strings.HasPrefix(name, "Watch")
+	limit, class := rl.limit(read)
+	if limit.Rate == 0 {
+		return nil
+	}
+
+	ok, _, _, retry := rl.take(class+"|"+rl.clientKey(grpcRequest(ctx)), limit)
+	if ok {
+		return nil
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds(retry))))
+	return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+}
+
+func (api *Api) grpcLimitUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := api.grpcLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (api *Api) grpcLimitStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := api.grpcLimit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcRecover перехватывает панику в обработчике вызова method,
+// как recoverer в REST API, и заменяет ее ошибкой Internal.
+// Вызывается отложенно
+func (api *Api) grpcRecover(ctx context.Context, method string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+
+	PanicsTotal.Add(1)
+	api.logger.Printf("panic serving grpc [%s]: %v\n%s", method, v, stack)
+
+	if api.reporter != nil {
+		r := grpcRequest(ctx)
+		r.URL.Path = method
+		api.reporter.ReportPanic(r, "", v, stack)
+	}
+	*err = status.Error(codes.Internal, "")
+}
+
+func (api *Api) grpcRecoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer api.grpcRecover(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func (api *Api) grpcRecoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer api.grpcRecover(ss.Context(), info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+func (api *Api) grpcLogUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	api.logger.Printf("grpc %s [%s] %v\n", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+func (api *Api) grpcLogStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	api.logger.Printf("grpc %s [%s] %v\n", info.FullMethod, status.Code(err), time.Since(start))
+	return err
+}
+
+// ListPosts возвращает страницу публикаций, как GET /posts
+func (s *grpcService) ListPosts(ctx context.Context, req *newspb.ListPostsRequest) (*newspb.ListPostsResponse, error) {
+	r := contextRequest(ctx)
+	if p := s.api.permit(r, ActionRead, nil); p != nil {
+		return nil, p
+	}
+
+	tags := make([]string, 0, len(req.Tags))
+	for _, t := range req.Tags {
+		t, err := normalizeTag(t)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		tags = append(tags, t)
+	}
+	posts, p := s.api.listPosts(r, storage.Status(req.Status), tags, req.MatchAll)
+	if p != nil {
+		return nil, p
+	}
+
+	from, to, next, err := grpcPage(len(posts), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	resp := &newspb.ListPostsResponse{NextPageToken: next}
+	for _, post := range posts[from:to] {
+		resp.Posts = append(resp.Posts, postToProto(post))
+	}
+	return resp, nil
+}
+
+// GetPost возвращает публикацию по id, если пользователь ее видит
+func (s *grpcService) GetPost(ctx context.Context, req *newspb.GetPostRequest) (*newspb.Post, error) {
+	r := contextRequest(ctx)
+	post, err := storage.PostContext(ctx, s.api.db, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	if p := s.api.permit(r, ActionRead, &post); p != nil {
+		return nil, p
+	}
+	if !s.api.visible(r, post) {
+		return nil, status.Error(codes.NotFound, "post not found")
+	}
+	return postToProto(post), nil
+}
+
+// CreatePost создает публикацию, как POST /posts
+func (s *grpcService) CreatePost(ctx context.Context, req *newspb.CreatePostRequest) (*newspb.Post, error) {
+	r := contextRequest(ctx)
+	post := postFromProto(req.Post)
+	post.Editor = editor(r)
+
+	if p := s.api.permit(r, ActionCreate, &post); p != nil {
+		return nil, p
+	}
+	if _, p := s.api.validate(ActionCreate, &post); p != nil {
+		return nil, p
+	}
+	if err := s.api.db.AddPost(post); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.api.storedPost(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.api.notify(storage.EventCreated, stored)
+	return postToProto(stored), nil
+}
+
+// UpdatePost изменяет публикацию, как PUT /posts
+func (s *grpcService) UpdatePost(ctx context.Context, req *newspb.UpdatePostRequest) (*newspb.Post, error) {
+	r := contextRequest(ctx)
+	post := postFromProto(req.Post)
+	post.Editor = editor(r)
+
+	if p := s.api.permit(r, ActionUpdate, &post); p != nil {
+		return nil, p
+	}
+	before, p := s.api.validate(ActionUpdate, &post)
+	if p != nil {
+		return nil, p
+	}
+	if err := s.api.db.UpdatePost(post); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.api.storedPost(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.api.notifyUpdate(*before, stored)
+	return postToProto(stored), nil
+}
+
+// DeletePost удаляет публикацию в корзину, как DELETE /posts
+func (s *grpcService) DeletePost(ctx context.Context, req *newspb.DeletePostRequest) (*newspb.DeletePostResponse, error) {
+	r := contextRequest(ctx)
+	post := storage.Post{Id: int(req.Id)}
+
+	if p := s.api.permit(r, ActionDelete, &post); p != nil {
+		return nil, p
+	}
+	if err := s.api.db.DeletePost(post); err != nil {
+		return nil, err
+	}
+	s.api.notify(storage.EventDeleted, post)
+	return &newspb.DeletePostResponse{}, nil
+}
+
+// WatchPosts передает изменения публикаций из ленты, как GET
+// /posts/stream. При разрыве клиент возобновляет поток с last_event_id
+func (s *grpcService) WatchPosts(req *newspb.WatchPostsRequest, stream newspb.NewsService_WatchPostsServer) error {
+	ctx := stream.Context()
+	if p := s.api.permit(contextRequest(ctx), ActionRead, nil); p != nil {
+		return p
+	}
+	hub := s.api.hub
+	if hub == nil {
+		return status.Error(codes.Unimplemented, "post stream is disabled")
+	}
+
+	sub, replay, complete := hub.Subscribe(req.LastEventId)
+	if sub == nil {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	defer hub.Unsubscribe(sub)
+
+	// заголовки ответа сообщают клиенту, что подписка на ленту оформлена
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	if !complete {
+		if err := stream.Send(&newspb.PostEvent{Reset_: true}); err != nil {
+			return err
+		}
+	}
+	for _, e := range replay {
+		if err := stream.Send(eventToProto(e)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				select {
+				case <-hub.Done():
+					return status.Error(codes.Unavailable, "server is shutting down")
+				default:
+					return status.Error(codes.ResourceExhausted, "client is too slow, resume with last_event_id")
+				}
+			}
+			if err := stream.Send(eventToProto(e)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ListAuthors возвращает страницу авторов опубликованных публикаций
+// по возрастанию id
+func (s *grpcService) ListAuthors(ctx context.Context, req *newspb.ListAuthorsRequest) (*newspb.ListAuthorsResponse, error) {
+	authors, p := s.api.authors(contextRequest(ctx))
+	if p != nil {
+		return nil, p
+	}
+
+	from, to, next, err := grpcPage(len(authors), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	resp := &newspb.ListAuthorsResponse{NextPageToken: next}
+	for _, a := range authors[from:to] {
+		resp.Authors = append(resp.Authors, &newspb.Author{Id: int64(a.Id), Name: a.Name})
+	}
+	return resp, nil
+}
+
+// GetAuthor возвращает автора опубликованных публикаций по id
+func (s *grpcService) GetAuthor(ctx context.Context, req *newspb.GetAuthorRequest) (*newspb.Author, error) {
+	authors, p := s.api.authors(contextRequest(ctx))
+	if p != nil {
+		return nil, p
+	}
+	i := sort.Search(len(authors), func(i int) bool { return authors[i].Id >= int(req.Id) })
+	if i == len(authors) || authors[i].Id != int(req.Id) {
+		return nil, status.Error(codes.NotFound, "author not found")
+	}
+	return &newspb.Author{Id: int64(authors[i].Id), Name: authors[i].Name}, nil
+}
+
+// grpcPage возвращает границы страницы размера size в списке из n
+// элементов по токену token и токен следующей страницы
+func grpcPage(n int, size int32, token string) (from, to int, next string, err error) {
+	switch {
+	case size < 0:
+		return 0, 0, "", status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case size == 0:
+		size = DefaultGRPCPageSize
+	case size > MaxGRPCPageSize:
+		size = MaxGRPCPageSize
+	}
+	from, to, next, ok := pageBounds(n, int(size), token)
+	if !ok {
+		return 0, 0, "", status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	return from, to, next, nil
+}
+
+// postToProto переводит публикацию в сообщение gRPC
+func postToProto(p storage.Post) *newspb.Post {
+	return &newspb.Post{
+		Id:        int64(p.Id),
+		Author:    &newspb.Author{Id: int64(p.Author.Id), Name: p.Author.Name},
+		Title:     p.Title,
+		Content:   p.Content,
+		CreatedAt: p.CreatedAt,
+		Status:    string(p.Status),
+		PublishAt: p.PublishAt,
+		Tags:      p.Tags,
+	}
+}
+
+// postFromProto переводит сообщение gRPC в публикацию
+func postFromProto(p *newspb.Post) storage.Post {
+	post := storage.Post{
+		Id:        int(p.GetId()),
+		Title:     p.GetTitle(),
+		Content:   p.GetContent(),
+		CreatedAt: p.GetCreatedAt(),
+		Status:    storage.Status(p.GetStatus()),
+		PublishAt: p.GetPublishAt(),
+		Tags:      p.GetTags(),
+	}
+	if a := p.GetAuthor(); a != nil {
+		post.Author = storage.Author{Id: int(a.Id), Name: a.Name}
+	}
+	return post
+}
+
+// eventToProto переводит событие ленты в сообщение gRPC
+func eventToProto(e storage.Event) *newspb.PostEvent {
+	var post storage.Post
+	_ = json.Unmarshal(e.Payload, &post)
+	return &newspb.PostEvent{
+		Id:        e.Id,
+		Type:      string(e.Type),
+		Post:      postToProto(post),
+		CreatedAt: e.CreatedAt,
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"GoNews/pkg/newspb"
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"GoNews/pkg/stream"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient запускает сервер gRPC API на bufconn и возвращает клиента
+func grpcClient(t *testing.T, api *Api) newspb.NewsServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := api.GRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.Dial() = error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newspb.NewNewsServiceClient(conn)
+}
+
+// withKey возвращает контекст вызова с API-ключом key
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+// assertCode проверяет код состояния ошибки вызова gRPC
+func assertCode(name string, want codes.Code, err error, t *testing.T) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("%s = %v (%v), want %v", name, got, err, want)
+	}
+}
+
+func TestApi_grpc(t *testing.T) {
+	keys := APIKeys{
+		"author": {Name: "author", Role: RoleAuthor, AuthorId: 7},
+		"editor": {Name: "editor", Role: RoleEditor},
+	}
+	api := New(memDb.New(), log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy))
+	c := grpcClient(t, api)
+	ctx := context.Background()
+
+	_, err := c.CreatePost(ctx, &newspb.CreatePostRequest{Post: &newspb.Post{Id: 60, Title: "anonymous"}})
+	assertCode("anonymous CreatePost", codes.Unauthenticated, err, t)
+	_, err = c.ListPosts(withKey("unknown"), &newspb.ListPostsRequest{})
+	assertCode("ListPosts with unknown key", codes.Unauthenticated, err, t)
+
+	post, err := c.CreatePost(withKey("author"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 60, Title: "news", Tags: []string{"Go"}}})
+	assertCode("author CreatePost", codes.OK, err, t)
+	assert("post author", int64(7), post.Author.Id, t)
+	assert("post status", "published", post.Status, t)
+	assert("post tag", "go", post.Tags[0], t)
+
+	_, err = c.CreatePost(withKey("author"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 60, Title: "again"}})
+	assertCode("duplicate CreatePost", codes.AlreadyExists, err, t)
+	_, err = c.UpdatePost(withKey("author"), &newspb.UpdatePostRequest{Post: &newspb.Post{Id: 1, Title: "not mine"}})
+	assertCode("author UpdatePost of other post", codes.PermissionDenied, err, t)
+	_, err = c.UpdatePost(withKey("editor"), &newspb.UpdatePostRequest{Post: &newspb.Post{Id: 60, Status: "draft"}})
+	assertCode("UpdatePost of published to draft", codes.FailedPrecondition, err, t)
+
+	post, err = c.UpdatePost(withKey("author"), &newspb.UpdatePostRequest{Post: &newspb.Post{Id: 60, Title: "news 2", Author: &newspb.Author{Id: 7}, Tags: []string{"go"}}})
+	assertCode("author UpdatePost", codes.OK, err, t)
+	assert("updated title", "news 2", post.Title, t)
+
+	// черновик видят только те, кто вправе его изменять
+	_, err = c.CreatePost(withKey("editor"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 61, Title: "draft", Status: "draft"}})
+	assertCode("editor CreatePost draft", codes.OK, err, t)
+	_, err = c.GetPost(ctx, &newspb.GetPostRequest{Id: 61})
+	assertCode("anonymous GetPost of draft", codes.NotFound, err, t)
+	_, err = c.GetPost(withKey("editor"), &newspb.GetPostRequest{Id: 61})
+	assertCode("editor GetPost of draft", codes.OK, err, t)
+	_, err = c.GetPost(ctx, &newspb.GetPostRequest{Id: 1000})
+	assertCode("GetPost of unknown post", codes.NotFound, err, t)
+
+	// постраничный обход опубликованных публикаций 1, 2 и 60
+	var ids []int64
+	req := &newspb.ListPostsRequest{PageSize: 2}
+	for pages := 0; ; pages++ {
+		resp, err := c.ListPosts(ctx, req)
+		assertCode("ListPosts", codes.OK, err, t)
+		for _, p := range resp.Posts {
+			ids = append(ids, p.Id)
+		}
+		if resp.NextPageToken == "" {
+			assert("pages", 1, pages, t)
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[2] != 60 {
+		t.Fatalf("listed posts = %v, want 1, 2, 60", ids)
+	}
+	_, err = c.ListPosts(ctx, &newspb.ListPostsRequest{PageToken: "???"})
+	assertCode("ListPosts with invalid token", codes.InvalidArgument, err, t)
+	_, err = c.ListPosts(ctx, &newspb.ListPostsRequest{Status: "unknown"})
+	assertCode("ListPosts with unknown status", codes.InvalidArgument, err, t)
+	resp, err := c.ListPosts(ctx, &newspb.ListPostsRequest{Tags: []string{"GO"}})
+	if err != nil || len(resp.Posts) != 1 || resp.Posts[0].Id != 60 {
+		t.Fatalf("ListPosts by tag = %v, %v, want post 60", resp, err)
+	}
+
+	authors, err := c.ListAuthors(ctx, &newspb.ListAuthorsRequest{})
+	if err != nil || len(authors.Authors) != 1 || authors.Authors[0].Id != 7 {
+		t.Fatalf("ListAuthors() = %v, %v, want author 7", authors, err)
+	}
+	_, err = c.GetAuthor(ctx, &newspb.GetAuthorRequest{Id: 7})
+	assertCode("GetAuthor", codes.OK, err, t)
+	_, err = c.GetAuthor(ctx, &newspb.GetAuthorRequest{Id: 8})
+	assertCode("GetAuthor of unknown author", codes.NotFound, err, t)
+
+	_, err = c.DeletePost(withKey("author"), &newspb.DeletePostRequest{Id: 60})
+	assertCode("author DeletePost", codes.OK, err, t)
+	_, err = c.GetPost(ctx, &newspb.GetPostRequest{Id: 60})
+	assertCode("GetPost of deleted post", codes.NotFound, err, t)
+}
+
+// stampingDB БД, которая дополняет публикацию при сохранении
+type stampingDB struct{ storage.Model }
+
+func (db stampingDB) AddPost(p storage.Post) error {
+	p.CreatedAt = 42
+	return db.Model.AddPost(p)
+}
+
+func (db stampingDB) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	for i := range posts {
+		posts[i].CreatedAt = 42
+	}
+	return db.Model.AddPosts(posts, atomic)
+}
+
+// panickingDB БД, которая паникует при чтении публикации
+type panickingDB struct{ storage.Model }
+
+func (panickingDB) Post(int) (storage.Post, error) {
+	panic("broken driver")
+}
+
+func TestApi_grpcGuards(t *testing.T) {
+	rl, err := NewRateLimiter(RateLimitConfig{Write: RateLimit{Rate: 0.001, Burst: 1}})
+	if err != nil {
+		t.Fatalf("NewRateLimiter() = error %v", err)
+	}
+	keys := APIKeys{"editor": {Name: "editor", Role: RoleEditor}}
+	c := grpcClient(t, New(stampingDB{memDb.New()}, log.New(io.Discard, "", 0),
+		WithAuthenticator(keys), WithPolicy(DefaultPolicy), WithRateLimiter(rl)))
+
+	// возвращается сохраненная публикация
+	post, err := c.CreatePost(withKey("editor"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 60, Title: "news"}})
+	assertCode("CreatePost", codes.OK, err, t)
+	assert("stored created_at", int64(42), post.CreatedAt, t)
+
+	_, err = c.CreatePost(withKey("editor"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 61, Title: "news"}})
+	assertCode("CreatePost over budget", codes.ResourceExhausted, err, t)
+	// непроверенный ключ расходует бюджет адреса клиента
+	_, err = c.CreatePost(withKey("unknown"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 61, Title: "news"}})
+	assertCode("CreatePost with unknown key", codes.Unauthenticated, err, t)
+	_, err = c.CreatePost(withKey("other"), &newspb.CreatePostRequest{Post: &newspb.Post{Id: 61, Title: "news"}})
+	assertCode("CreatePost with other unknown key", codes.ResourceExhausted, err, t)
+	_, err = c.GetPost(context.Background(), &newspb.GetPostRequest{Id: 60})
+	assertCode("GetPost with separate budget", codes.OK, err, t)
+
+	c = grpcClient(t, New(panickingDB{memDb.New()}, log.New(io.Discard, "", 0), WithMaxBodySize(1<<10)))
+
+	panics := PanicsTotal.Value()
+	_, err = c.GetPost(context.Background(), &newspb.GetPostRequest{Id: 1})
+	assertCode("GetPost with panic", codes.Internal, err, t)
+	assert("panics", panics+1, PanicsTotal.Value(), t)
+
+	_, err = c.CreatePost(context.Background(), &newspb.CreatePostRequest{
+		Post: &newspb.Post{Id: 62, Title: "big", Content: strings.Repeat("x", 2<<10)}})
+	assertCode("CreatePost over message size", codes.ResourceExhausted, err, t)
+}
+
+func TestApi_grpcWatch(t *testing.T) {
+	hub := stream.New(0)
+	api := New(memDb.New(), log.New(io.Discard, "", 0), WithStream(hub, 0))
+	c := grpcClient(t, api)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := c.WatchPosts(ctx, &newspb.WatchPostsRequest{})
+	assertCode("WatchPosts", codes.OK, err, t)
+	if _, err = watch.Header(); err != nil {
+		t.Fatalf("WatchPosts.Header() = error %v", err)
+	}
+
+	var events []*newspb.PostEvent
+	for _, id := range []int64{60, 61} {
+		_, err = c.CreatePost(ctx, &newspb.CreatePostRequest{Post: &newspb.Post{Id: id, Title: "news"}})
+		assertCode("CreatePost", codes.OK, err, t)
+		e, err := watch.Recv()
+		assertCode("WatchPosts.Recv", codes.OK, err, t)
+		if e.Type != "created" || e.Post.Id != id {
+			t.Fatalf("watched event = %v, want created of post %d", e, id)
+		}
+		events = append(events, e)
+	}
+
+	// возобновление с последнего полученного события
+	resumed, err := c.WatchPosts(ctx, &newspb.WatchPostsRequest{LastEventId: events[0].Id})
+	assertCode("resumed WatchPosts", codes.OK, err, t)
+	e, err := resumed.Recv()
+	assertCode("resumed WatchPosts.Recv", codes.OK, err, t)
+	assert("resumed event id", events[1].Id, e.Id, t)
+
+	hub.Close()
+	_, err = watch.Recv()
+	assertCode("WatchPosts.Recv after shutdown", codes.Unavailable, err, t)
+}
+
+func TestGRPCMux(t *testing.T) {
+	api := New(memDb.New(), log.New(io.Discard, "", 0))
+	srv := httptest.NewServer(api.GRPCMux(api.GRPCServer()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tags")
+	if err != nil {
+		t.Fatalf("GET /tags = error %v", err)
+	}
+	resp.Body.Close()
+	assert("REST status", http.StatusOK, resp.StatusCode, t)
+
+	conn, err := grpc.Dial(srv.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.Dial() = error %v", err)
+	}
+	defer conn.Close()
+	post, err := newspb.NewNewsServiceClient(conn).GetPost(context.Background(), &newspb.GetPostRequest{Id: 1})
+	assertCode("multiplexed GetPost", codes.OK, err, t)
+	assert("post id", int64(1), post.Id, t)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultIdempotencyTTL время хранения ответа на запрос
+	// с ключом идемпотентности по умолчанию
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLen максимальная длина ключа идемпотентности
+	maxIdempotencyKeyLen = 255
+)
+
+// WithIdempotencyTTL задает время хранения ответов на запросы
+// с ключом идемпотентности
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(api *Api) { api.idempotencyTTL = ttl }
+}
+
+// idempotent оборачивает обработчик POST или PATCH поддержкой заголовка
+// Idempotency-Key: первый ответ на запрос с ключом сохраняется в БД
+// и возвращается на повторные запросы с тем же ключом без повторного
+// выполнения. Повторное использование ключа с другим телом запроса
+// приводит к ответу 422, а запрос, пока выполняется первый, - к 409.
+// Ответы 5xx не сохраняются, чтобы клиент мог повторить запрос
+func (api *Api) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, r, http.StatusBadRequest,
+				"Idempotency-Key must not exceed "+strconv.Itoa(maxIdempotencyKeyLen)+" characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, r, http.StatusRequestEntityTooLarge,
+					"request body must not exceed "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, "error reading request body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// ключ действует в пределах пользователя: его учетных
+		// данных, а не имени, которое может повторяться
+		scope := ""
+		if pr, ok := principal(r); ok {
+			scope = pr.key()
+		}
+		rec := storage.IdempotencyRecord{
+			Key:         scope + ":" + key,
+			RequestHash: requestHash(r, body),
+			ExpiresAt:   time.Now().Add(api.idempotencyTTL),
+		}
+
+		err = api.db.AddIdempotencyRecord(rec)
+		if errors.Is(err, storage.ErrConflict) {
+			api.replay(w, r, rec)
+			return
+		}
+		if err != nil {
+			api.logger.Printf("error saving idempotency key to database: [%v]\n", err)
+			writeError(w, r, http.StatusInternalServerError, "")
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			// при панике или ошибке сервера освобождаем ключ
+			if rw.status == 0 || rw.status >= http.StatusInternalServerError {
+				if err := api.db.DeleteIdempotencyRecord(rec.Key); err != nil {
+					api.logger.Printf("error releasing idempotency key: [%v]\n", err)
+				}
+				return
+			}
+
+			rec.Status = rw.status
+			rec.Header = map[string][]string(w.Header().Clone())
+			rec.Body = rw.body.Bytes()
+			if err := api.db.UpdateIdempotencyRecord(rec); err != nil {
+				api.logger.Printf("error saving idempotent response to database: [%v]\n", err)
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// replay отвечает на повторный запрос с ключом идемпотентности
+// сохраненным ответом на первый запрос
+func (api *Api) replay(w http.ResponseWriter, r *http.Request, rec storage.IdempotencyRecord) {
+	stored, err := api.db.IdempotencyRecord(rec.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		// первый запрос завершился ошибкой и освободил ключ
+		writeError(w, r, http.StatusConflict, "request with this Idempotency-Key failed, retry")
+		return
+	}
+	if err != nil {
+		api.logger.Printf("error fetching idempotency key from database: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if stored.RequestHash != rec.RequestHash {
+		writeError(w, r, http.StatusUnprocessableEntity,
+			"Idempotency-Key has already been used with a different request")
+		return
+	}
+	if stored.Status == 0 {
+		writeError(w, r, http.StatusConflict, "request with this Idempotency-Key is in progress")
+		return
+	}
+
+	for k, v := range stored.Header {
+		// идентификатор запроса у повтора свой
+		if k == "X-Request-Id" {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	_, _ = w.Write(stored.Body)
+}
+
+// requestHash возвращает отпечаток запроса: метод, путь и тело
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter копирует код статуса и тело ответа
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
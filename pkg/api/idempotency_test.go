@@ -0,0 +1,104 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApi_idempotent(t *testing.T) {
+	db := memDb.New()
+	h := New(db, log.New(io.Discard, "", 0)).Mux()
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/posts", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		first := do("key-1", `{"Id": 50, "Title": "idempotent"}`)
+		assert("first http status code", http.StatusCreated, first.Code, t)
+		assert("first Idempotent-Replayed", "", first.Header().Get("Idempotent-Replayed"), t)
+
+		// без ключа повтор вызвал бы конфликт
+		second := do("key-1", `{"Id": 50, "Title": "idempotent"}`)
+		assert("second http status code", http.StatusCreated, second.Code, t)
+		assert("second Idempotent-Replayed", "true", second.Header().Get("Idempotent-Replayed"), t)
+		assert("second body", first.Body.String(), second.Body.String(), t)
+	})
+
+	t.Run("different_payload", func(t *testing.T) {
+		w := do("key-1", `{"Id": 51, "Title": "another"}`)
+		assert("http status code", http.StatusUnprocessableEntity, w.Code, t)
+
+		if _, err := db.Post(51); err != storage.ErrNotFound {
+			t.Fatalf("post with reused key stored, error = %v", err)
+		}
+	})
+
+	t.Run("in_progress", func(t *testing.T) {
+		rec := storage.IdempotencyRecord{
+			Key:         ":key-2",
+			RequestHash: requestHash(httptest.NewRequest(http.MethodPost, "/posts", nil), []byte(`{}`)),
+			ExpiresAt:   time.Now().Add(time.Minute),
+		}
+		if err := db.AddIdempotencyRecord(rec); err != nil {
+			t.Fatalf("memdb.AddIdempotencyRecord() = error %v", err)
+		}
+		w := do("key-2", `{}`)
+		assert("http status code", http.StatusConflict, w.Code, t)
+	})
+
+	t.Run("client_error_is_stored", func(t *testing.T) {
+		w := do("key-3", `{"Id": 1}`)
+		assert("first http status code", http.StatusConflict, w.Code, t)
+
+		w = do("key-3", `{"Id": 1}`)
+		assert("second http status code", http.StatusConflict, w.Code, t)
+		assert("second Idempotent-Replayed", "true", w.Header().Get("Idempotent-Replayed"), t)
+	})
+
+	t.Run("expired_key", func(t *testing.T) {
+		rec := storage.IdempotencyRecord{Key: ":key-5", Status: 201, ExpiresAt: time.Now().Add(-time.Second)}
+		if err := db.AddIdempotencyRecord(rec); err != nil {
+			t.Fatalf("memdb.AddIdempotencyRecord() = error %v", err)
+		}
+		w := do("key-5", `{"Id": 52}`)
+		assert("http status code", http.StatusCreated, w.Code, t)
+		assert("Idempotent-Replayed", "", w.Header().Get("Idempotent-Replayed"), t)
+	})
+}
+
+func TestApi_idempotentScope(t *testing.T) {
+	// у разных ключей одно имя пользователя
+	keys := APIKeys{
+		"key-a": {Name: "editor", Role: RoleEditor},
+		"key-b": {Name: "editor", Role: RoleEditor},
+	}
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/posts", strings.NewReader(body))
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert("first user", http.StatusCreated, do("key-a", `{"Id": 50, "Title": "first"}`).Code, t)
+	w := do("key-b", `{"Id": 51, "Title": "second"}`)
+	assert("second user", http.StatusCreated, w.Code, t)
+	assert("second user Idempotent-Replayed", "", w.Header().Get("Idempotent-Replayed"), t)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// transitions допустимые переходы между состояниями публикации.
+// Опубликованную публикацию можно только отправить в архив,
+// из архива ее можно вернуть в черновики или опубликовать снова
+var transitions = map[storage.Status][]storage.Status{
+	storage.StatusDraft:     {storage.StatusDraft, storage.StatusScheduled, storage.StatusPublished},
+	storage.StatusScheduled: {storage.StatusDraft, storage.StatusScheduled, storage.StatusPublished},
+	storage.StatusPublished: {storage.StatusPublished, storage.StatusArchived},
+	storage.StatusArchived:  {storage.StatusArchived, storage.StatusDraft, storage.StatusPublished},
+}
+
+// lifecycle проверяет состояние публикации post, создаваемой или
+// изменяемой действием action, и дополняет его: без состояния
+// новая публикация публикуется сразу, а изменяемая сохраняет текущее
+// состояние. Для изменения возвращает сохраненную публикацию до него.
+// Если состояние неверно, возвращает описание ошибки
+func (api *Api) lifecycle(action Action, post *storage.Post) (*storage.Post, *Problem) {
+	var stored *storage.Post
+	if action == ActionUpdate {
+		p, err := api.storedPost(post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, NewProblem(http.StatusNotFound, "post not found")
+		}
+		if err != nil {
+			api.logger.Printf("error fetching from database: [%v]\n", err)
+			return nil, NewProblem(http.StatusInternalServerError, "")
+		}
+		stored = &p
+	}
+	return stored, transition(stored, post, time.Now())
+}
+
+// transition проверяет переход публикации из сохраненного состояния
+// stored (nil для новой публикации) в состояние post в момент now.
+// Неизвестное состояние и запланированная публикация без будущего
+// времени публикации - ошибка 400, недопустимый переход - 409
+func transition(stored, post *storage.Post, now time.Time) *Problem {
+	from := storage.StatusDraft
+	if stored != nil {
+		from = stored.Status
+		if from == "" {
+			from = storage.StatusPublished
+		}
+		if post.Status == "" {
+			post.Status = from
+			if post.PublishAt == 0 {
+				post.PublishAt = stored.PublishAt
+			}
+		}
+	}
+	if post.Status == "" {
+		post.Status = storage.StatusPublished
+	}
+
+	if _, ok := transitions[post.Status]; !ok {
+		return NewProblem(http.StatusBadRequest, "unknown post status "+string(post.Status))
+	}
+	if !canTransition(from, post.Status) {
+		return NewProblem(http.StatusConflict,
+			"post status cannot change from "+string(from)+" to "+string(post.Status))
+	}
+
+	switch post.Status {
+	case storage.StatusScheduled:
+		if post.PublishAt <= now.Unix() {
+			return NewProblem(http.StatusBadRequest, "scheduled post must have PublishAt in the future")
+		}
+	case storage.StatusPublished:
+		if post.PublishAt == 0 || from != storage.StatusPublished {
+			post.PublishAt = now.Unix()
+		}
+	}
+	return nil
+}
+
+// canTransition сообщает, допустим ли переход из состояния from в to
+func canTransition(from, to storage.Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// visible сообщает, может ли пользователь запроса видеть публикацию:
+// неопубликованные видны только тем, кто вправе их изменять
+func (api *Api) visible(r *http.Request, post storage.Post) bool {
+	if post.Published() {
+		return true
+	}
+	return len(api.permitted(r, ActionUpdate, []storage.Post{post})) > 0
+}
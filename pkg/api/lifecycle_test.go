@@ -0,0 +1,135 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_transition(t *testing.T) {
+	now := time.Unix(1000, 0)
+	published := &storage.Post{Status: storage.StatusPublished, PublishAt: 500}
+
+	tests := []struct {
+		name       string
+		stored     *storage.Post
+		post       storage.Post
+		wantStatus int
+		want       storage.Status
+	}{
+		{name: "create_default", want: storage.StatusPublished},
+		{name: "create_draft", post: storage.Post{Status: storage.StatusDraft}, want: storage.StatusDraft},
+		{name: "create_scheduled", post: storage.Post{Status: storage.StatusScheduled, PublishAt: 2000},
+			want: storage.StatusScheduled},
+		{name: "create_scheduled_in_past", post: storage.Post{Status: storage.StatusScheduled, PublishAt: 900},
+			wantStatus: http.StatusBadRequest},
+		{name: "create_archived", post: storage.Post{Status: storage.StatusArchived},
+			wantStatus: http.StatusConflict},
+		{name: "unknown_status", post: storage.Post{Status: "deleted"}, wantStatus: http.StatusBadRequest},
+		{name: "update_keeps_status", stored: &storage.Post{Status: storage.StatusDraft},
+			want: storage.StatusDraft},
+		{name: "update_legacy_status", stored: &storage.Post{}, post: storage.Post{Status: storage.StatusArchived},
+			want: storage.StatusArchived},
+		{name: "publish_draft", stored: &storage.Post{Status: storage.StatusDraft},
+			post: storage.Post{Status: storage.StatusPublished}, want: storage.StatusPublished},
+		{name: "unpublish", stored: published, post: storage.Post{Status: storage.StatusDraft},
+			wantStatus: http.StatusConflict},
+		{name: "archive", stored: published, post: storage.Post{Status: storage.StatusArchived},
+			want: storage.StatusArchived},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := tt.post
+			p := transition(tt.stored, &post, now)
+			if tt.wantStatus != 0 {
+				if p == nil || p.Status != tt.wantStatus {
+					t.Fatalf("transition() = %v, want status %d", p, tt.wantStatus)
+				}
+				return
+			}
+			if p != nil {
+				t.Fatalf("transition() = %v, want nil", p)
+			}
+			assert("status", tt.want, post.Status, t)
+			if post.Status == storage.StatusPublished && post.PublishAt != now.Unix() {
+				t.Errorf("published post PublishAt = %d, want %d", post.PublishAt, now.Unix())
+			}
+		})
+	}
+}
+
+func TestApi_lifecycle(t *testing.T) {
+	db := memDb.New()
+
+	keys := APIKeys{
+		"author1": {Role: RoleAuthor, AuthorId: 1},
+		"author2": {Role: RoleAuthor, AuthorId: 2},
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(method, url, key string, post *storage.Post) *httptest.ResponseRecorder {
+		var body io.Reader
+		if post != nil {
+			b, _ := json.Marshal(post)
+			body = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, url, body)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	ids := func(url, key string) []int {
+		w := do(http.MethodGet, url, key, nil)
+		var reply struct{ Data []storage.Post }
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatalf("due decoding %s = %v", url, err)
+		}
+		var ids []int
+		for _, p := range reply.Data {
+			ids = append(ids, p.Id)
+		}
+		return ids
+	}
+
+	draft := storage.Post{Id: 40, Title: "draft", Status: storage.StatusDraft}
+	w := do(http.MethodPost, "http://test.com/posts", "author1", &draft)
+	assert("create draft", http.StatusCreated, w.Code, t)
+
+	for _, id := range ids("http://test.com/posts", "") {
+		if id == 40 {
+			t.Fatal("draft is listed publicly")
+		}
+	}
+	assert("own drafts", 1, len(ids("http://test.com/posts?status=draft", "author1")), t)
+	assert("foreign drafts", 0, len(ids("http://test.com/posts?status=draft", "author2")), t)
+
+	w = do(http.MethodGet, "http://test.com/posts?status=hidden", "", nil)
+	assert("unknown status", http.StatusBadRequest, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/40/revisions", "author2", nil)
+	assert("foreign draft revisions", http.StatusNotFound, w.Code, t)
+
+	draft.Author.Id = 1
+	draft.Status = storage.StatusPublished
+	w = do(http.MethodPut, "http://test.com/posts", "author1", &draft)
+	assert("publish draft", http.StatusOK, w.Code, t)
+
+	p, _ := db.Post(40)
+	assert("published status", storage.StatusPublished, p.Status, t)
+	if p.PublishAt == 0 {
+		t.Fatal("published post has no PublishAt")
+	}
+
+	draft.Status = storage.StatusDraft
+	w = do(http.MethodPut, "http://test.com/posts", "author1", &draft)
+	assert("unpublish", http.StatusConflict, w.Code, t)
+}
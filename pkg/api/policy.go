@@ -0,0 +1,251 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Action действие над публикацией, которое проверяет политика доступа
+type Action string
+
+const (
+	ActionRead    Action = "read"
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionRestore Action = "restore" // просмотр корзины и восстановление из нее
+
+	// ActionWebhooks управление подписками на события,
+	// имеет смысл только область действия any
+	ActionWebhooks Action = "webhooks"
+)
+
+// Scope область действия разрешения: на собственные
+// публикации пользователя или на любые
+type Scope string
+
+const (
+	ScopeNone Scope = ""
+	ScopeOwn  Scope = "own"
+	ScopeAny  Scope = "any"
+)
+
+// Policy таблица политики доступа: для каждой роли
+// задается область действия разрешения на каждое действие.
+// Отсутствующая запись означает запрет
+type Policy map[Role]map[Action]Scope
+
+// DefaultPolicy политика доступа по умолчанию: авторы
+// управляют только своими публикациями, редакторы
+// и администраторы - всеми
+var DefaultPolicy = Policy{
+	RoleAnonymous: {ActionRead: ScopeAny},
+	RoleReader:    {ActionRead: ScopeAny},
+	RoleAuthor: {
+		ActionRead:    ScopeAny,
+		ActionCreate:  ScopeOwn,
+		ActionUpdate:  ScopeOwn,
+		ActionDelete:  ScopeOwn,
+		ActionRestore: ScopeOwn,
+	},
+	RoleEditor: {
+		ActionRead:    ScopeAny,
+		ActionCreate:  ScopeAny,
+		ActionUpdate:  ScopeAny,
+		ActionDelete:  ScopeAny,
+		ActionRestore: ScopeAny,
+	},
+	RoleAdmin: {
+		ActionRead:     ScopeAny,
+		ActionCreate:   ScopeAny,
+		ActionUpdate:   ScopeAny,
+		ActionDelete:   ScopeAny,
+		ActionRestore:  ScopeAny,
+		ActionWebhooks: ScopeAny,
+	},
+}
+
+// LoadPolicy читает таблицу политики доступа в формате json, например
+// {"author": {"read": "any", "update": "own"}}
+func LoadPolicy(r io.Reader) (Policy, error) {
+	var p Policy
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	for role, actions := range p {
+		for action, scope := range actions {
+			if scope != ScopeNone && scope != ScopeOwn && scope != ScopeAny {
+				return nil, errors.New("policy: unknown scope " +
+					string(scope) + " for " + string(role) + "/" + string(action))
+			}
+		}
+	}
+	return p, nil
+}
+
+// Scope возвращает область действия разрешения роли на действие
+func (p Policy) Scope(role Role, action Action) Scope {
+	return p[role][action]
+}
+
+// Allowed сообщает, может ли пользователь выполнить действие
+// над публикацией, принадлежащей автору ownerId
+func (p Policy) Allowed(pr Principal, action Action, ownerId int) bool {
+	switch p.Scope(pr.Role, action) {
+	case ScopeAny:
+		return true
+	case ScopeOwn:
+		return pr.AuthorId != 0 && pr.AuthorId == ownerId
+	default:
+		return false
+	}
+}
+
+// authorize проверяет, разрешено ли пользователю запроса выполнить
+// действие над публикацией post (nil для списка публикаций). Если нет,
+// пишет ответ с описанием ошибки и возвращает false
+func (api *Api) authorize(w http.ResponseWriter, r *http.Request, action Action, post *storage.Post) bool {
+	if p := api.permit(r, action, post); p != nil {
+		if p.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeProblem(w, r, p)
+		return false
+	}
+	return true
+}
+
+// authorizeAll проверяет, разрешено ли пользователю запроса выполнить
+// действие над любыми публикациями. Если нет, пишет ответ с описанием
+// ошибки и возвращает false
+func (api *Api) authorizeAll(w http.ResponseWriter, r *http.Request, action Action) bool {
+	if api.allowedAll(r, action) {
+		return true
+	}
+
+	switch _, ok := principal(r); {
+	case !ok:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, "authentication required")
+	default:
+		writeError(w, r, http.StatusForbidden, "action "+string(action)+" is not permitted")
+	}
+	return false
+}
+
+// allowedAll проверяет, разрешено ли пользователю запроса выполнять
+// действие над любыми публикациями. Без политики доступа подписки
+// недоступны никому: они раскрывают секреты и адреса партнеров
+func (api *Api) allowedAll(r *http.Request, action Action) bool {
+	if api.policy == nil {
+		return action != ActionWebhooks
+	}
+	pr, _ := principal(r)
+	return api.policy.Scope(pr.Role, action) == ScopeAny
+}
+
+// permit проверяет, разрешено ли пользователю запроса выполнить
+// действие над публикацией post (nil для списка публикаций). Если нет,
+// возвращает описание ошибки 401 (для анонимного клиента) или 403.
+// Для изменения и удаления владелец определяется по сохраненной в БД
+// публикации, а не по телу запроса. Для создания собственной публикации
+// без указанного автора автором назначается сам пользователь
+func (api *Api) permit(r *http.Request, action Action, post *storage.Post) *Problem {
+	if api.policy == nil {
+		return nil
+	}
+
+	pr, ok := principal(r)
+	scope := api.policy.Scope(pr.Role, action)
+
+	allowed := false
+	switch {
+	case scope == ScopeAny:
+		allowed = true
+
+	case scope == ScopeOwn && post == nil:
+		// список публикаций, фильтруется обработчиком
+		allowed = true
+
+	case scope == ScopeOwn && action == ActionRestore:
+		stored, err := api.db.TrashedPost(post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return NewProblem(http.StatusNotFound, "post not found in trash")
+		}
+		if err != nil {
+			api.logger.Printf("error fetching from database: [%v]\n", err)
+			return NewProblem(http.StatusInternalServerError, "")
+		}
+		allowed = api.policy.Allowed(pr, action, stored.Author.Id)
+
+	case scope == ScopeOwn && action == ActionCreate:
+		if post.Author.Id == 0 {
+			post.Author.Id = pr.AuthorId
+		}
+		allowed = api.policy.Allowed(pr, action, post.Author.Id)
+
+	case scope == ScopeOwn:
+		stored, err := api.storedPost(post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return NewProblem(http.StatusNotFound, "post not found")
+		}
+		if err != nil {
+			api.logger.Printf("error fetching from database: [%v]\n", err)
+			return NewProblem(http.StatusInternalServerError, "")
+		}
+		allowed = api.policy.Allowed(pr, action, stored.Author.Id)
+		// автор не может передать свою публикацию другому автору
+		if action == ActionUpdate && post.Author.Id != stored.Author.Id {
+			allowed = false
+		}
+	}
+
+	switch {
+	case allowed:
+		return nil
+	case !ok:
+		return NewProblem(http.StatusUnauthorized, "authentication required")
+	default:
+		return NewProblem(http.StatusForbidden, "action "+string(action)+" is not permitted")
+	}
+}
+
+// readable оставляет в списке только публикации,
+// которые пользователь запроса вправе читать
+func (api *Api) readable(r *http.Request, posts []storage.Post) []storage.Post {
+	return api.permitted(r, ActionRead, posts)
+}
+
+// permitted оставляет в списке только публикации, над
+// которыми пользователь запроса вправе выполнить действие
+func (api *Api) permitted(r *http.Request, action Action, posts []storage.Post) []storage.Post {
+	if api.policy == nil {
+		return posts
+	}
+
+	pr, _ := principal(r)
+	if api.policy.Scope(pr.Role, action) == ScopeAny {
+		return posts
+	}
+
+	filtered := make([]storage.Post, 0, len(posts))
+	for _, p := range posts {
+		if api.policy.Allowed(pr, action, p.Author.Id) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// principal возвращает пользователя запроса, для неаутентифицированного
+// клиента - анонимного пользователя и false
+func principal(r *http.Request) (Principal, bool) {
+	pr, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return Principal{Role: RoleAnonymous}, false
+	}
+	return pr, true
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApi_authorize(t *testing.T) {
+	db := memDb.New()
+	_ = db.AddPost(storage.Post{Id: 10, Author: storage.Author{Id: 1}, Title: "post of author 1"})
+	_ = db.AddPost(storage.Post{Id: 20, Author: storage.Author{Id: 2}, Title: "post of author 2"})
+	_ = db.AddPost(storage.Post{Id: 21, Author: storage.Author{Id: 2}, Title: "post of author 2"})
+
+	keys := APIKeys{
+		"author1": {Name: "Author 1", Role: RoleAuthor, AuthorId: 1},
+		"editor":  {Name: "Editor", Role: RoleEditor},
+		"reader":  {Name: "Reader", Role: RoleReader},
+	}
+	a := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy))
+	h := a.Mux()
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		post   storage.Post
+		want   int
+	}{
+		{"anonymous_read", http.MethodGet, "", storage.Post{}, http.StatusOK},
+		{"anonymous_delete", http.MethodDelete, "", storage.Post{Id: 10}, http.StatusUnauthorized},
+		{"invalid_key", http.MethodGet, "wrong", storage.Post{}, http.StatusUnauthorized},
+		{"reader_create", http.MethodPost, "reader", storage.Post{Id: 30}, http.StatusForbidden},
+		{"author_create_own", http.MethodPost, "author1", storage.Post{Id: 30}, http.StatusCreated},
+		{"author_create_foreign", http.MethodPost, "author1",
+			storage.Post{Id: 31, Author: storage.Author{Id: 2}}, http.StatusForbidden},
+		{"author_create_over_foreign", http.MethodPost, "author1",
+			storage.Post{Id: 21, Author: storage.Author{Id: 1}, Title: "replaced"}, http.StatusConflict},
+		{"author_update_own", http.MethodPut, "author1",
+			storage.Post{Id: 10, Author: storage.Author{Id: 1}}, http.StatusOK},
+		{"author_reassign_own", http.MethodPut, "author1",
+			storage.Post{Id: 10, Author: storage.Author{Id: 2}}, http.StatusForbidden},
+		{"author_update_foreign", http.MethodPut, "author1",
+			storage.Post{Id: 20, Author: storage.Author{Id: 1}}, http.StatusForbidden},
+		{"author_delete_foreign", http.MethodDelete, "author1", storage.Post{Id: 20}, http.StatusForbidden},
+		{"author_delete_missing", http.MethodDelete, "author1", storage.Post{Id: 404}, http.StatusNotFound},
+		{"editor_delete_foreign", http.MethodDelete, "editor", storage.Post{Id: 20}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.post)
+			if err != nil {
+				t.Fatalf("due encoding test data %v", err)
+			}
+			req := httptest.NewRequest(tt.method, "http://test.com/posts", bytes.NewReader(b))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert("http status code", tt.want, w.Result().StatusCode, t)
+		})
+	}
+
+	created, err := db.Post(30)
+	if err != nil {
+		t.Fatalf("memdb.Post() = error %v", err)
+	}
+	assert("author of created post", 1, created.Author.Id, t)
+
+	foreign, err := db.Post(21)
+	if err != nil {
+		t.Fatalf("memdb.Post() = error %v", err)
+	}
+	assert("author of foreign post", 2, foreign.Author.Id, t)
+	assert("title of foreign post", "post of author 2", foreign.Title, t)
+}
+
+func TestLoadPolicy(t *testing.T) {
+	p, err := LoadPolicy(strings.NewReader(`{"author": {"read": "any", "update": "own"}}`))
+	if err != nil {
+		t.Fatalf("LoadPolicy() = error %v", err)
+	}
+	assert("Scope(author, update)", ScopeOwn, p.Scope(RoleAuthor, ActionUpdate), t)
+	assert("Scope(author, delete)", ScopeNone, p.Scope(RoleAuthor, ActionDelete), t)
+
+	_, err = LoadPolicy(strings.NewReader(`{"author": {"read": "everything"}}`))
+	if err == nil {
+		t.Fatal("LoadPolicy() with unknown scope = nil error, want error")
+	}
+}
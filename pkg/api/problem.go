@@ -0,0 +1,110 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Problem описание ошибки в формате RFC 7807 (application/problem+json)
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// NewProblem возвращает описание ошибки с кодом статуса status.
+// Тип ошибки - "about:blank", заголовок - стандартный текст статуса
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// writeProblem пишет ответ с описанием ошибки
+// в формате application/problem+json
+func writeProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestId == "" {
+		p.RequestId = RequestIdFromContext(r.Context())
+	}
+
+	// ошибка кодирования невозможна: все поля - строки и числа
+	b, _ := json.Marshal(p)
+	b = append(b, '\n')
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(b)
+}
+
+// writeError вспомогательная функция, пишет ответ
+// с описанием ошибки с кодом статуса status
+func writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, r, NewProblem(status, detail))
+}
+
+// storageProblem возвращает описание ошибки БД: отсутствие
+// публикации - 404, нарушение ограничений - 409, остальное - 500
+func storageProblem(err error) *Problem {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return NewProblem(http.StatusNotFound, "post not found")
+	case errors.Is(err, storage.ErrConflict):
+		return NewProblem(http.StatusConflict, err.Error())
+	default:
+		return NewProblem(http.StatusInternalServerError, "")
+	}
+}
+
+func withRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+// RequestIdFromContext возвращает идентификатор запроса из контекста
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// requestId промежуточный обработчик, который назначает запросу
+// идентификатор: берет его из заголовка X-Request-Id или генерирует новый.
+// Идентификатор возвращается клиенту в том же заголовке
+func requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > 128 {
+			id = newRequestId()
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(withRequestId(r.Context(), id)))
+	})
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	h := testApi.Mux()
+
+	t.Run("method_not_allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "http://test.com/posts", nil)
+		req.Header.Set("X-Request-Id", "test-request-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert("http status code", http.StatusMethodNotAllowed, resp.StatusCode, t)
+		assert("Content-Type", "application/problem+json", resp.Header.Get("Content-Type"), t)
+		assert("X-Request-Id", "test-request-1", resp.Header.Get("X-Request-Id"), t)
+
+		var p Problem
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("due decoding problem = %v", err)
+		}
+		want := Problem{
+			Type:      "about:blank",
+			Title:     "Method Not Allowed",
+			Status:    http.StatusMethodNotAllowed,
+			Detail:    "method PATCH is not allowed",
+			Instance:  "/posts",
+			RequestId: "test-request-1",
+		}
+		assert("problem", want, p, t)
+	})
+
+	t.Run("bad_request_body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/posts", strings.NewReader("{"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert("http status code", http.StatusBadRequest, resp.StatusCode, t)
+
+		var p Problem
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("due decoding problem = %v", err)
+		}
+		if p.RequestId == "" || p.RequestId != resp.Header.Get("X-Request-Id") {
+			t.Fatalf("problem request_id = %q, want generated id %q", p.RequestId, resp.Header.Get("X-Request-Id"))
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit бюджет запросов по алгоритму token bucket:
+// корзина емкостью Burst пополняется со скоростью Rate
+// токенов в секунду. Нулевой Rate означает отсутствие ограничения
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit разбирает бюджет в формате "rate:burst", например "10:20"
+func ParseRateLimit(s string) (RateLimit, error) {
+	rate, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return RateLimit{}, errors.New("rate limit must be in form rate:burst")
+	}
+
+	var l RateLimit
+	var err error
+	l.Rate, err = strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	l.Burst, err = strconv.Atoi(burst)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	if l.Rate < 0 || l.Burst < 1 {
+		return RateLimit{}, errors.New("rate limit must be non-negative with burst at least 1")
+	}
+	return l, nil
+}
+
+// RateLimitConfig параметры ограничителя частоты запросов
+type RateLimitConfig struct {
+	Read  RateLimit // бюджет для GET, HEAD и OPTIONS
+	Write RateLimit // бюджет для остальных методов
+
+	// TrustedProxies адреса и подсети (в нотации CIDR) прокси-серверов,
+	// которым разрешено передавать адрес клиента в X-Forwarded-For
+	TrustedProxies []string
+
+	// IdleTimeout время, после которого корзина
+	// неактивного клиента удаляется из памяти
+	IdleTimeout time.Duration
+}
+
+// bucket корзина токенов одного клиента
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter ограничивает частоту запросов клиентов,
+// клиент определяется по проверенному API-ключу или IP-адресу.
+// Корзины хранятся в памяти процесса
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	proxies []*net.IPNet
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+// NewRateLimiter возвращает ограничитель частоты запросов
+func NewRateLimiter(cfg RateLimitConfig) (*RateLimiter, error) {
+	rl := RateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	if rl.cfg.IdleTimeout <= 0 {
+		rl.cfg.IdleTimeout = 10 * time.Minute
+	}
+
+	for _, p := range cfg.TrustedProxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		rl.proxies = append(rl.proxies, n)
+	}
+
+	return &rl, nil
+}
+
+// WithRateLimiter включает ограничение частоты запросов
+func WithRateLimiter(rl *RateLimiter) Option {
+	return func(api *Api) { api.limiter = rl }
+}
+
+// rateLimit промежуточный обработчик, который отклоняет
+// запросы сверх бюджета клиента с кодом 429. Пользователь
+// берется из контекста запроса, поэтому обработчик
+// выполняется после authenticate
+func (rl *RateLimiter) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit RateLimit
+		var class string
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			limit, class = rl.limit(true)
+		default:
+			limit, class = rl.limit(false)
+		}
+
+		if limit.Rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, remaining, reset, retry := rl.take(class+"|"+rl.clientKey(r), limit)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retry)))
+			writeError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit возвращает бюджет и класс корзины для
+// запросов на чтение (read) или на изменение
+func (rl *RateLimiter) limit(read bool) (RateLimit, string) {
+	if read {
+		return rl.cfg.Read, "r"
+	}
+	return rl.cfg.Write, "w"
+}
+
+// take забирает токен из корзины клиента. Возвращает, разрешен ли
+// запрос, сколько токенов осталось, через сколько корзина будет
+// заполнена полностью и через сколько появится следующий токен
+func (rl *RateLimiter) take(key string, limit RateLimit) (ok bool, remaining int, reset, retry time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, found := rl.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+
+	// пополняем корзину за прошедшее время
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = duration((1 - b.tokens) / limit.Rate)
+	}
+
+	remaining = int(math.Floor(b.tokens))
+	reset = duration((float64(limit.Burst) - b.tokens) / limit.Rate)
+
+	return ok, remaining, reset, retry
+}
+
+// sweep удаляет корзины клиентов, неактивных дольше IdleTimeout.
+// Проход выполняется не чаще одного раза за IdleTimeout
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.cfg.IdleTimeout {
+		return
+	}
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) >= rl.cfg.IdleTimeout {
+			delete(rl.buckets, k)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// clientKey возвращает ключ клиента: идентификатор пользователя, если
+// ключ прошел проверку, иначе IP-адрес клиента. Непроверенный ключ
+// не учитывается, иначе каждый новый ключ получал бы новую корзину
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if p, ok := principal(r); ok {
+		return "user:" + p.key()
+	}
+	return "ip:" + rl.clientIP(r)
+}
+
+// clientIP возвращает IP-адрес клиента. Если запрос пришел от
+// доверенного прокси, адрес берется из X-Forwarded-For: первый
+// справа адрес, не принадлежащий доверенным прокси
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !rl.trusted(host) {
+		return host
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, h := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(h))
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		host = hops[i]
+		if !rl.trusted(host) {
+			break
+		}
+	}
+	return host
+}
+
+// trusted сообщает, принадлежит ли адрес доверенному прокси
+func (rl *RateLimiter) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range rl.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func duration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
+// seconds округляет длительность вверх до целых секунд
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
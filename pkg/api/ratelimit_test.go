@@ -0,0 +1,126 @@
+package api
+
+import (
+	memDb "GoNews/pkg/storage/memdb"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl, err := NewRateLimiter(RateLimitConfig{
+		Read:           RateLimit{Rate: 1, Burst: 2},
+		Write:          RateLimit{Rate: 0.5, Burst: 1},
+		TrustedProxies: []string{"10.0.0.0/8"},
+		IdleTimeout:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewRateLimiter() = error %v", err)
+	}
+	now := time.Unix(1652355804, 0)
+	rl.now = func() time.Time { return now }
+
+	keys := APIKeys{
+		"k1": {Name: "reader", Role: RoleReader},
+		"k2": {Name: "reader", Role: RoleReader},
+	}
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithAuthenticator(keys), WithRateLimiter(rl)).Mux()
+
+	do := func(method, remote, xff, key string) *http.Response {
+		req := httptest.NewRequest(method, "http://test.com/posts", nil)
+		req.RemoteAddr = remote
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("read_budget", func(t *testing.T) {
+		assert("1st request", http.StatusOK, do(http.MethodGet, "192.0.2.1:1234", "", "").StatusCode, t)
+		resp := do(http.MethodGet, "192.0.2.1:1234", "", "")
+		assert("2nd request", http.StatusOK, resp.StatusCode, t)
+		assert("RateLimit-Remaining", "0", resp.Header.Get("RateLimit-Remaining"), t)
+
+		resp = do(http.MethodGet, "192.0.2.1:1234", "", "")
+		assert("3rd request", http.StatusTooManyRequests, resp.StatusCode, t)
+		assert("Retry-After", "1", resp.Header.Get("Retry-After"), t)
+		assert("RateLimit-Limit", "2", resp.Header.Get("RateLimit-Limit"), t)
+
+		now = now.Add(time.Second)
+		assert("after refill", http.StatusOK, do(http.MethodGet, "192.0.2.1:1234", "", "").StatusCode, t)
+	})
+
+	t.Run("write_budget_is_separate", func(t *testing.T) {
+		resp := do(http.MethodDelete, "192.0.2.1:1234", "", "")
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatal("write request limited by read budget")
+		}
+		resp = do(http.MethodDelete, "192.0.2.1:1234", "", "")
+		assert("2nd write", http.StatusTooManyRequests, resp.StatusCode, t)
+		assert("Retry-After", "2", resp.Header.Get("Retry-After"), t)
+	})
+
+	t.Run("forwarded_for", func(t *testing.T) {
+		// недоверенный клиент не может подменить свой адрес
+		do(http.MethodGet, "192.0.2.2:1234", "198.51.100.1", "")
+		do(http.MethodGet, "192.0.2.2:1234", "198.51.100.2", "")
+		resp := do(http.MethodGet, "192.0.2.2:1234", "198.51.100.3", "")
+		assert("spoofed X-Forwarded-For", http.StatusTooManyRequests, resp.StatusCode, t)
+
+		// за доверенным прокси клиенты различаются
+		do(http.MethodGet, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "")
+		do(http.MethodGet, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "")
+		resp = do(http.MethodGet, "10.0.0.1:1234", "198.51.100.4, 10.0.0.2", "")
+		assert("client behind proxy", http.StatusOK, resp.StatusCode, t)
+		resp = do(http.MethodGet, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "")
+		assert("same client behind proxy", http.StatusTooManyRequests, resp.StatusCode, t)
+	})
+
+	t.Run("api_key", func(t *testing.T) {
+		do(http.MethodGet, "192.0.2.3:1234", "", "k1")
+		do(http.MethodGet, "192.0.2.3:1234", "", "k1")
+		resp := do(http.MethodGet, "192.0.2.3:1234", "", "k2")
+		assert("another key from same address", http.StatusOK, resp.StatusCode, t)
+	})
+
+	t.Run("invalid_key", func(t *testing.T) {
+		// непроверенные ключи не дают новой корзины
+		do(http.MethodGet, "192.0.2.5:1234", "", "random-1")
+		do(http.MethodGet, "192.0.2.5:1234", "", "random-2")
+		resp := do(http.MethodGet, "192.0.2.5:1234", "", "random-3")
+		assert("random key", http.StatusTooManyRequests, resp.StatusCode, t)
+		resp = do(http.MethodGet, "192.0.2.5:1234", "", "k2")
+		assert("valid key from same address", http.StatusOK, resp.StatusCode, t)
+	})
+
+	t.Run("evict_idle", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		do(http.MethodGet, "192.0.2.4:1234", "", "")
+		rl.mu.Lock()
+		n := len(rl.buckets)
+		rl.mu.Unlock()
+		assert("buckets after eviction", 1, n, t)
+	})
+}
+
+func TestParseRateLimit(t *testing.T) {
+	l, err := ParseRateLimit("2.5:10")
+	if err != nil {
+		t.Fatalf("ParseRateLimit() = error %v", err)
+	}
+	assert("ParseRateLimit()", RateLimit{Rate: 2.5, Burst: 10}, l, t)
+
+	for _, s := range []string{"10", "a:1", "1:0", "-1:5"} {
+		if _, err := ParseRateLimit(s); err == nil {
+			t.Fatalf("ParseRateLimit(%q) = nil error, want error", s)
+		}
+	}
+}
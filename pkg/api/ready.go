@@ -0,0 +1,38 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"net/http"
+	"time"
+)
+
+// readyTimeout время на проверку готовности БД
+const readyTimeout = 2 * time.Second
+
+// getReadyHandler обработчик для метода GET ресурса готовности,
+// доступен без авторизации для проверок оркестратора. Если БД
+// реализует storage.Checker, ответ содержит действующие параметры
+// подключения и статистику кеша, а недоступная БД приводит к ответу 503
+func (api *Api) getReadyHandler(w http.ResponseWriter, r *http.Request) {
+	checker, ok := api.db.(storage.Checker)
+	if !ok {
+		api.writeResponse(w, map[string]any{"status": "ready"}, http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	health, err := checker.Check(ctx)
+	if err != nil {
+		api.logger.Printf("error checking database: [%v]\n", err)
+		writeError(w, r, http.StatusServiceUnavailable, "storage is unavailable")
+		return
+	}
+	reply := map[string]any{"status": "ready"}
+	if health.Backend != "" || health.Cache != nil {
+		reply["storage"] = health
+	}
+	api.writeResponse(w, reply, http.StatusOK)
+}
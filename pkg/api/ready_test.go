@@ -0,0 +1,76 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"GoNews/pkg/storage/cache"
+	memDb "GoNews/pkg/storage/memdb"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// checkedDb БД, проверка готовности которой возвращает health и err
+type checkedDb struct {
+	storage.Model
+	health storage.Health
+	err    error
+}
+
+func (db *checkedDb) Check(context.Context) (storage.Health, error) {
+	return db.health, db.err
+}
+
+func TestApi_getReadyHandler(t *testing.T) {
+	db := &checkedDb{
+		Model:  memDb.New(),
+		health: storage.Health{Backend: "test", Settings: map[string]string{"max_conns": "4"}},
+	}
+	h := New(db, log.New(io.Discard, "", 0)).Mux()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test.com/ready", nil))
+	assert("api.getReadyHandler() http status code", http.StatusOK, w.Code, t)
+
+	var got struct {
+		Status  string
+		Storage storage.Health
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("api.getReadyHandler() due decoding response = %v", err)
+	}
+	assert("api.getReadyHandler() status", "ready", got.Status, t)
+	assert("api.getReadyHandler() backend", "test", got.Storage.Backend, t)
+	assert("api.getReadyHandler() max_conns", "4", got.Storage.Settings["max_conns"], t)
+
+	db.err = errors.New("connection refused")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test.com/ready", nil))
+	assert("api.getReadyHandler() unavailable http status code", http.StatusServiceUnavailable, w.Code, t)
+}
+
+func TestApi_getReadyHandlerCache(t *testing.T) {
+	db := cache.New(memDb.New(), cache.NewLRU(10), time.Minute)
+	h := New(db, log.New(io.Discard, "", 0)).Mux()
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test.com/ready", nil))
+	assert("api.getReadyHandler() http status code", http.StatusOK, w.Code, t)
+
+	var got struct{ Storage storage.Health }
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("api.getReadyHandler() due decoding response = %v", err)
+	}
+	if got.Storage.Cache["hits"] < 1 || got.Storage.Cache["misses"] < 1 {
+		t.Fatalf("api.getReadyHandler() cache = %v, want hits and misses", got.Storage.Cache)
+	}
+}
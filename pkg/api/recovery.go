@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicsTotal счетчик паник в обработчиках запросов,
+// публикуется через expvar
+var PanicsTotal = expvar.NewInt("gonews_http_panics_total")
+
+// PanicReporter получает сведения о перехваченной панике,
+// например, для передачи в систему учета ошибок
+type PanicReporter interface {
+	ReportPanic(r *http.Request, requestId string, v any, stack []byte)
+}
+
+// PanicReporterFunc адаптер, позволяющий использовать
+// обычную функцию в качестве PanicReporter
+type PanicReporterFunc func(r *http.Request, requestId string, v any, stack []byte)
+
+func (f PanicReporterFunc) ReportPanic(r *http.Request, requestId string, v any, stack []byte) {
+	f(r, requestId, v, stack)
+}
+
+// WithPanicReporter назначает получателя сведений о паниках
+func WithPanicReporter(rep PanicReporter) Option {
+	return func(api *Api) { api.reporter = rep }
+}
+
+// recoverer промежуточный обработчик, который перехватывает панику
+// в обработчике или драйвере БД, записывает в журнал стек вызовов
+// и отвечает клиенту ошибкой 500. Если ответ уже начал передаваться,
+// соединение с клиентом разрывается
+func (api *Api) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// штатный способ прервать ответ, не является ошибкой
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			id := RequestIdFromContext(r.Context())
+
+			PanicsTotal.Add(1)
+			api.logger.Printf("panic serving [%s %s] request id [%s]: %v\n%s",
+				r.Method, r.URL.Path, id, v, stack)
+
+			if api.reporter != nil {
+				api.reporter.ReportPanic(r, id, v, stack)
+			}
+
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, r, http.StatusInternalServerError, "")
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter запоминает, был ли уже отправлен заголовок ответа
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush реализует http.Flusher, если его реализует исходный ResponseWriter
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack реализует http.Hijacker, если его реализует исходный
+// ResponseWriter: нужен для перехода на протокол WebSocket
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported")
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap позволяет http.ResponseController получить исходный ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
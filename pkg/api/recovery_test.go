@@ -0,0 +1,54 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// panicDb БД, драйвер которой паникует при чтении публикаций
+type panicDb struct {
+	*memDb.MemDb
+}
+
+func (panicDb) Posts() ([]storage.Post, error) {
+	panic("driver failure")
+}
+
+func TestApi_recoverer(t *testing.T) {
+	var reported any
+	var reportedId string
+
+	a := New(panicDb{memDb.New()}, log.New(io.Discard, "", 0),
+		WithPanicReporter(PanicReporterFunc(func(r *http.Request, id string, v any, stack []byte) {
+			reported, reportedId = v, id
+		})))
+
+	before := PanicsTotal.Value()
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/posts", nil)
+	req.Header.Set("X-Request-Id", "panic-request")
+	w := httptest.NewRecorder()
+	a.Mux().ServeHTTP(w, req)
+
+	resp := w.Result()
+	assert("http status code", http.StatusInternalServerError, resp.StatusCode, t)
+	assert("Content-Type", "application/problem+json", resp.Header.Get("Content-Type"), t)
+
+	var p Problem
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatalf("due decoding problem = %v", err)
+	}
+	assert("problem request_id", "panic-request", p.RequestId, t)
+
+	if reported != "driver failure" {
+		t.Fatalf("reported value = %v, want %v", reported, "driver failure")
+	}
+	assert("reported request id", "panic-request", reportedId, t)
+	assert("PanicsTotal", before+1, PanicsTotal.Value(), t)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// getRevisionsHandler обработчик для метода GET истории версий публикации
+func (api *Api) getRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	revs, err := api.db.Revisions(post.Id)
+	if err != nil {
+		api.logger.Printf("error fetching revisions from database: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if revs == nil {
+		revs = []storage.Revision{}
+	}
+	api.writeResponse(w, map[string]any{"data": revs}, http.StatusOK)
+}
+
+// getRevisionHandler обработчик для метода GET версии публикации
+func (api *Api) getRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	rev, ok := api.revision(w, r, post.Id, pathParam(r, "number"))
+	if !ok {
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": rev}, http.StatusOK)
+}
+
+// revertRevisionHandler обработчик для метода POST ресурса отката,
+// возвращает публикацию к версии, что сохраняется как новая версия
+func (api *Api) revertRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid post id")
+		return
+	}
+
+	post, err := api.storedPost(id)
+	if err != nil {
+		api.storageError(w, r, err)
+		return
+	}
+
+	rev, ok := api.revision(w, r, post.Id, pathParam(r, "number"))
+	if !ok {
+		return
+	}
+
+	post.Title, post.Content, post.Author = rev.Title, rev.Content, rev.Author
+	post.Editor = editor(r)
+
+	if !api.authorize(w, r, ActionUpdate, &post) {
+		return
+	}
+
+	err = api.db.UpdatePost(post)
+	if err != nil {
+		api.logger.Printf("error updating in database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+	api.notifyStored(storage.EventUpdated, post.Id)
+	api.writeResponse(w, nil, http.StatusOK)
+}
+
+// getDiffHandler обработчик для метода GET построчного сравнения
+// версий публикации, номера версий задаются параметрами from и to
+func (api *Api) getDiffHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := api.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	from, ok := api.revision(w, r, post.Id, r.URL.Query().Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := api.revision(w, r, post.Id, r.URL.Query().Get("to"))
+	if !ok {
+		return
+	}
+	diff, ok := diffRevisions(from, to)
+	if !ok {
+		writeError(w, r, http.StatusUnprocessableEntity, "revisions are too large or too different to compare")
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": diff}, http.StatusOK)
+}
+
+// readPost возвращает публикацию по параметру пути id, если
+// пользователь вправе ее читать и видит ее. Иначе пишет ответ с ошибкой
+func (api *Api) readPost(w http.ResponseWriter, r *http.Request) (storage.Post, bool) {
+	id, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid post id")
+		return storage.Post{}, false
+	}
+
+	post, err := storage.PostContext(r.Context(), api.db, id)
+	if err != nil {
+		api.storageError(w, r, err)
+		return storage.Post{}, false
+	}
+
+	if !api.authorize(w, r, ActionRead, &post) {
+		return storage.Post{}, false
+	}
+	if !api.visible(r, post) {
+		writeError(w, r, http.StatusNotFound, "post not found")
+		return storage.Post{}, false
+	}
+	return post, true
+}
+
+// revision возвращает версию публикации по номеру number.
+// Если номер неверен или версии нет, пишет ответ с ошибкой
+func (api *Api) revision(w http.ResponseWriter, r *http.Request, postId int, number string) (storage.Revision, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		writeError(w, r, http.StatusBadRequest, "invalid revision number "+strconv.Quote(number))
+		return storage.Revision{}, false
+	}
+
+	rev, err := api.db.Revision(postId, n)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "revision "+number+" not found")
+		return storage.Revision{}, false
+	}
+	if err != nil {
+		api.storageError(w, r, err)
+		return storage.Revision{}, false
+	}
+	return rev, true
+}
+
+// storageError пишет ответ с описанием ошибки БД,
+// непредвиденные ошибки записываются в журнал
+func (api *Api) storageError(w http.ResponseWriter, r *http.Request, err error) {
+	p := storageProblem(err)
+	if p.Status == http.StatusInternalServerError {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+	}
+	writeProblem(w, r, p)
+}
+
+// editor возвращает имя пользователя запроса для истории версий
+func editor(r *http.Request) string {
+	pr, _ := principal(r)
+	return pr.Name
+}
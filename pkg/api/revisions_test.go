@@ -0,0 +1,167 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestApi_revisions(t *testing.T) {
+	db := memDb.New()
+
+	keys := APIKeys{
+		"author1": {Name: "ivan", Role: RoleAuthor, AuthorId: 1},
+		"author2": {Name: "petr", Role: RoleAuthor, AuthorId: 2},
+		"editor":  {Name: "olga", Role: RoleEditor},
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(method, url, key string, post *storage.Post) *httptest.ResponseRecorder {
+		var body io.Reader
+		if post != nil {
+			b, _ := json.Marshal(post)
+			body = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, url, body)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	post := storage.Post{Id: 30, Title: "title", Content: "line 1\nline 2", Author: storage.Author{Id: 1}}
+	w := do(http.MethodPost, "http://test.com/posts", "author1", &post)
+	assert("create post", http.StatusCreated, w.Code, t)
+
+	post.Content = "line 1\nline 2 changed\nline 3"
+	w = do(http.MethodPut, "http://test.com/posts", "editor", &post)
+	assert("update post", http.StatusOK, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/30/revisions", "author2", nil)
+	assert("list revisions", http.StatusOK, w.Code, t)
+	var list struct{ Data []storage.Revision }
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("due decoding revisions = %v", err)
+	}
+	assert("revisions count", 2, len(list.Data), t)
+	assert("first revision editor", "ivan", list.Data[0].Editor, t)
+	assert("second revision editor", "olga", list.Data[1].Editor, t)
+	assert("second revision number", 2, list.Data[1].Number, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/30/revisions/1", "author2", nil)
+	assert("get revision", http.StatusOK, w.Code, t)
+	var one struct{ Data storage.Revision }
+	_ = json.NewDecoder(w.Body).Decode(&one)
+	assert("revision content", "line 1\nline 2", one.Data.Content, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/30/revisions/9", "author2", nil)
+	assert("missing revision", http.StatusNotFound, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/30/revisions/x", "author2", nil)
+	assert("invalid revision number", http.StatusBadRequest, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/31/revisions", "author2", nil)
+	assert("missing post", http.StatusNotFound, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/posts/30/diff?from=1&to=2", "author2", nil)
+	assert("diff", http.StatusOK, w.Code, t)
+	var diff struct{ Data Diff }
+	_ = json.NewDecoder(w.Body).Decode(&diff)
+	wantContent := []DiffLine{
+		{Op: " ", Text: "line 1"},
+		{Op: "-", Text: "line 2"},
+		{Op: "+", Text: "line 2 changed"},
+		{Op: "+", Text: "line 3"},
+	}
+	if !reflect.DeepEqual(diff.Data.Content, wantContent) {
+		t.Errorf("diff content = %v, want %v", diff.Data.Content, wantContent)
+	}
+	assert("diff title", 1, len(diff.Data.Title), t)
+
+	w = do(http.MethodPost, "http://test.com/posts/30/revisions/1/revert", "author2", nil)
+	assert("revert foreign post", http.StatusForbidden, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/posts/30/revisions/1/revert", "author1", nil)
+	assert("revert own post", http.StatusOK, w.Code, t)
+
+	p, _ := db.Post(30)
+	assert("reverted content", "line 1\nline 2", p.Content, t)
+
+	revs, _ := db.Revisions(30)
+	assert("revisions after revert", 3, len(revs), t)
+	assert("revert revision editor", "ivan", revs[2].Editor, t)
+
+	// слишком различающиеся версии не сравниваются
+	var big bytes.Buffer
+	for i := 0; i <= maxDiffEdits; i++ {
+		big.WriteString("new line " + strconv.Itoa(i) + "\n")
+	}
+	post.Content = big.String()
+	w = do(http.MethodPut, "http://test.com/posts", "editor", &post)
+	assert("update post with large content", http.StatusOK, w.Code, t)
+	w = do(http.MethodGet, "http://test.com/posts/30/diff?from=1&to=4", "author2", nil)
+	assert("diff of too different revisions", http.StatusUnprocessableEntity, w.Code, t)
+
+	w = do(http.MethodDelete, "http://test.com/posts/30/revisions", "editor", nil)
+	assert("wrong method", http.StatusMethodNotAllowed, w.Code, t)
+	assert("Allow", "GET, HEAD, OPTIONS", w.Header().Get("Allow"), t)
+}
+
+func Test_diffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []DiffLine
+	}{
+		{name: "empty", want: []DiffLine{}},
+		{name: "equal", a: []string{"a"}, b: []string{"a"},
+			want: []DiffLine{{" ", "a"}}},
+		{name: "insert", b: []string{"a"},
+			want: []DiffLine{{"+", "a"}}},
+		{name: "delete", a: []string{"a", "b"}, b: []string{"b"},
+			want: []DiffLine{{"-", "a"}, {" ", "b"}}},
+		{name: "mixed", a: []string{"a", "b", "c", "a", "b", "b", "a"}, b: []string{"c", "b", "a", "b", "a", "c"},
+			want: []DiffLine{{"-", "a"}, {"-", "b"}, {" ", "c"}, {"+", "b"}, {" ", "a"}, {" ", "b"},
+				{"-", "b"}, {" ", "a"}, {"+", "c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := diffLines(tt.a, tt.b); !ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func Test_diffLinesLimits(t *testing.T) {
+	lines := func(n int, prefix string) []string {
+		list := make([]string, n)
+		for i := range list {
+			list[i] = prefix + strconv.Itoa(i)
+		}
+		return list
+	}
+
+	// большой текст с небольшими правками сравнивается
+	a := lines(maxDiffLines, "line ")
+	b := append(append([]string{"first"}, a[:5000]...), a[5001:]...)
+	got, ok := diffLines(a, b)
+	if !ok || len(got) != maxDiffLines+1 {
+		t.Fatalf("diffLines() of similar texts = %d lines, %v, want %d lines", len(got), ok, maxDiffLines+1)
+	}
+
+	if _, ok = diffLines(lines(maxDiffLines+1, "a"), nil); ok {
+		t.Fatal("diffLines() of too long text = ok")
+	}
+	if _, ok = diffLines(lines(maxDiffLines, "a"), lines(maxDiffLines, "b")); ok {
+		t.Fatal("diffLines() of completely different texts = ok")
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"GoNews/pkg/stream"
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHeartbeat периодичность комментариев, поддерживающих
+// соединение ленты через прокси при отсутствии событий
+const DefaultHeartbeat = 15 * time.Second
+
+// EventReset событие ленты: часть пропущенных событий уже недоступна,
+// клиенту нужно заново загрузить публикации
+const EventReset = "reset"
+
+// WithStream включает ленту изменений публикаций /posts/stream
+// (Server-Sent Events) с комментариями каждые heartbeat
+// (0 - DefaultHeartbeat)
+func WithStream(h *stream.Hub, heartbeat time.Duration) Option {
+	return func(api *Api) {
+		if heartbeat <= 0 {
+			heartbeat = DefaultHeartbeat
+		}
+		api.hub, api.heartbeat = h, heartbeat
+	}
+}
+
+// streamHandler обработчик для метода GET ленты изменений публикаций.
+// Заголовок Last-Event-ID возобновляет ленту после разрыва соединения
+func (api *Api) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorize(w, r, ActionRead, nil) {
+		return
+	}
+
+	var lastId int64
+	if v := strings.TrimSpace(r.Header.Get("Last-Event-ID")); v != "" {
+		var err error
+		if lastId, err = strconv.ParseInt(v, 10, 64); err != nil || lastId < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.logger.Printf("error streaming posts: [%T is not http.Flusher]\n", w)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	sub, replay, complete := api.hub.Subscribe(lastId)
+	if sub == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	defer api.hub.Unsubscribe(sub)
+
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	if !complete {
+		bw.WriteString("event: " + EventReset + "\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		writeEvent(bw, e)
+	}
+	if bw.Flush() != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(api.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// лента остановлена или клиент не успевает читать
+				return
+			}
+			writeEvent(bw, e)
+		case <-heartbeat.C:
+			bw.WriteString(": heartbeat\n\n")
+		case <-api.hub.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if bw.Flush() != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent пишет событие в формате Server-Sent Events
+func writeEvent(w *bufio.Writer, e storage.Event) {
+	w.WriteString("id: " + strconv.FormatInt(e.Id, 10) + "\n")
+	w.WriteString("event: " + string(e.Type) + "\n")
+	w.WriteString("data: ")
+	w.Write(e.Payload)
+	w.WriteString("\n\n")
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	memDb "GoNews/pkg/storage/memdb"
+	"GoNews/pkg/stream"
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent читает из ленты следующее событие, пропуская комментарии
+func sseEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	e := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream = %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(e) > 0:
+			return e
+		case line == "" || strings.HasPrefix(line, ":"):
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		e[field] = value
+	}
+}
+
+func TestApi_stream(t *testing.T) {
+	hub := stream.New(0)
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithStream(hub, 10*time.Millisecond)).Mux()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	connect := func(lastId string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/posts/stream", nil)
+		if lastId != "" {
+			req.Header.Set("Last-Event-ID", lastId)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connecting to stream = %v", err)
+		}
+		assert("stream status", http.StatusOK, resp.StatusCode, t)
+		assert("stream content type", "text/event-stream", resp.Header.Get("Content-Type"), t)
+		return resp
+	}
+	write := func(method, body string) {
+		req, _ := http.NewRequest(method, srv.URL+"/posts", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s /posts = %v", method, err)
+		}
+		resp.Body.Close()
+	}
+
+	resp := connect("")
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	// до первого события приходит комментарий, поддерживающий соединение
+	if line, _ := r.ReadString('\n'); line != ": heartbeat\n" {
+		t.Fatalf("stream line = %q, want heartbeat", line)
+	}
+
+	// черновик в ленту не попадает
+	write(http.MethodPost, `{"Id": 50, "Title": "draft", "Status": "draft"}`)
+	write(http.MethodPost, `{"Id": 51, "Title": "news", "Content": "news"}`)
+	write(http.MethodPut, `{"Id": 51, "Title": "news 2", "Content": "news"}`)
+	write(http.MethodDelete, `{"Id": 51}`)
+
+	for i, want := range []string{"created", "updated", "deleted"} {
+		e := sseEvent(t, r)
+		assert("event id", strconv.Itoa(i+1), e["id"], t)
+		assert("event type", want, e["event"], t)
+		if !strings.Contains(e["data"], `"Id":51`) {
+			t.Fatalf("event data = %s, want post 51", e["data"])
+		}
+	}
+
+	// возобновление после разрыва соединения
+	resumed := connect("1")
+	defer resumed.Body.Close()
+	rr := bufio.NewReader(resumed.Body)
+	assert("resumed event id", "2", sseEvent(t, rr)["id"], t)
+	assert("resumed event id", "3", sseEvent(t, rr)["id"], t)
+
+	// номер события из прошлого запуска сервера
+	reset := connect("100")
+	defer reset.Body.Close()
+	assert("reset event", EventReset, sseEvent(t, bufio.NewReader(reset.Body))["event"], t)
+
+	// остановка ленты закрывает соединения
+	hub.Close()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("reading closed stream = %v", err)
+	}
+	if _, err := io.ReadAll(rr); err != nil {
+		t.Fatalf("reading closed stream = %v", err)
+	}
+}
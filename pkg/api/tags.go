@@ -0,0 +1,145 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTagLen максимальная длина тега в символах
+const maxTagLen = 64
+
+// TagRename запрос на переименование тега From в To
+type TagRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// getTagsHandler обработчик для метода GET ресурса тегов,
+// возвращает теги с числом публикаций
+func (api *Api) getTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorize(w, r, ActionRead, nil) {
+		return
+	}
+
+	tags, err := api.db.Tags()
+	if err != nil {
+		api.logger.Printf("error fetching tags from database: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if tags == nil {
+		tags = []storage.Tag{}
+	}
+	api.writeResponse(w, map[string]any{"data": tags}, http.StatusOK)
+}
+
+// renameTagHandler обработчик для метода POST ресурса переименования
+// тега. Тег меняется во всех публикациях, поэтому требуется право
+// изменять любые публикации
+func (api *Api) renameTagHandler(w http.ResponseWriter, r *http.Request) {
+	var req TagRename
+
+	if !api.decode(w, r, &req) {
+		return
+	}
+
+	if !api.authorizeAll(w, r, ActionUpdate) {
+		return
+	}
+
+	from, err := normalizeTag(req.From)
+	if err == nil {
+		req.To, err = normalizeTag(req.To)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if from == req.To {
+		writeError(w, r, http.StatusBadRequest, "tag names must differ")
+		return
+	}
+
+	n, err := api.db.RenameTag(from, req.To)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "tag "+strconv.Quote(from)+" not found")
+		return
+	}
+	if err != nil {
+		api.logger.Printf("error renaming tag in database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": map[string]int{"posts": n}}, http.StatusOK)
+}
+
+// normalizeTags приводит теги публикации к нижнему регистру,
+// удаляет пустые и повторяющиеся и упорядочивает их
+func normalizeTags(post *storage.Post) *Problem {
+	if len(post.Tags) == 0 {
+		post.Tags = nil
+		return nil
+	}
+
+	seen := make(map[string]bool, len(post.Tags))
+	tags := make([]string, 0, len(post.Tags))
+	for _, t := range post.Tags {
+		t, err := normalizeTag(t)
+		if err != nil {
+			return NewProblem(http.StatusBadRequest, err.Error())
+		}
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+
+	post.Tags = tags
+	return nil
+}
+
+// normalizeTag проверяет тег и приводит его к нижнему регистру.
+// Запятая недопустима, так как разделяет теги в параметре запроса
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	switch {
+	case tag == "":
+		return "", errors.New("tag must not be empty")
+	case utf8.RuneCountInString(tag) > maxTagLen:
+		return "", errors.New("tag must not exceed " + strconv.Itoa(maxTagLen) + " characters")
+	case strings.Contains(tag, ","):
+		return "", errors.New("tag must not contain commas")
+	}
+	return tag, nil
+}
+
+// queryTags возвращает теги из параметра запроса tags, разделенные
+// запятыми, и режим отбора: match=all - публикации со всеми тегами,
+// match=any (по умолчанию) - с любым из них
+func queryTags(r *http.Request) (tags []string, all bool, p *Problem) {
+	q := r.URL.Query()
+	if v := q.Get("tags"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t, err := normalizeTag(t)
+			if err != nil {
+				return nil, false, NewProblem(http.StatusBadRequest, err.Error())
+			}
+			tags = append(tags, t)
+		}
+	}
+
+	switch q.Get("match") {
+	case "", "any":
+	case "all":
+		all = true
+	default:
+		return nil, false, NewProblem(http.StatusBadRequest, "match must be any or all")
+	}
+	return tags, all, nil
+}
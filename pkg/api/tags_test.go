@@ -0,0 +1,94 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApi_tags(t *testing.T) {
+	db := memDb.New()
+
+	keys := APIKeys{
+		"author1": {Role: RoleAuthor, AuthorId: 1},
+		"editor":  {Role: RoleEditor},
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(method, url, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	ids := func(url string) []int {
+		w := do(http.MethodGet, url, "", "")
+		assert(url, http.StatusOK, w.Code, t)
+		var reply struct{ Data []storage.Post }
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatalf("due decoding %s = %v", url, err)
+		}
+		var ids []int
+		for _, p := range reply.Data {
+			ids = append(ids, p.Id)
+		}
+		return ids
+	}
+
+	w := do(http.MethodPost, "http://test.com/posts/bulk", "editor",
+		`[{"Id": 50, "Tags": [" Go ", "news", "go"]}, {"Id": 51, "Tags": ["go"]}, {"Id": 52, "Tags": ["sport"]},
+		 {"Id": 54, "Tags": ["secret"], "Status": "draft"}]`)
+	assert("create tagged posts", http.StatusMultiStatus, w.Code, t)
+
+	p, _ := db.Post(50)
+	if !reflect.DeepEqual(p.Tags, []string{"go", "news"}) {
+		t.Fatalf("normalized tags = %v, want [go news]", p.Tags)
+	}
+
+	w = do(http.MethodPost, "http://test.com/posts", "editor", `{"Id": 53, "Tags": ["a,b"]}`)
+	assert("tag with comma", http.StatusBadRequest, w.Code, t)
+
+	w = do(http.MethodGet, "http://test.com/tags", "", "")
+	var tags struct{ Data []storage.Tag }
+	_ = json.NewDecoder(w.Body).Decode(&tags)
+	want := []storage.Tag{{Name: "go", Posts: 2}, {Name: "news", Posts: 1}, {Name: "sport", Posts: 1}}
+	if !reflect.DeepEqual(tags.Data, want) {
+		t.Fatalf("tags = %v, want %v", tags.Data, want)
+	}
+
+	if got := ids("http://test.com/posts?tags=news,sport,go"); !reflect.DeepEqual(got, []int{50, 51, 52}) {
+		t.Errorf("posts with any tag = %v, want [50 51 52]", got)
+	}
+	if got := ids("http://test.com/posts?tags=news,go&match=all"); !reflect.DeepEqual(got, []int{50}) {
+		t.Errorf("posts with all tags = %v, want [50]", got)
+	}
+
+	w = do(http.MethodGet, "http://test.com/posts?tags=go&match=some", "", "")
+	assert("invalid match", http.StatusBadRequest, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/tags/rename", "author1", `{"from": "go", "to": "golang"}`)
+	assert("author rename", http.StatusForbidden, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/tags/rename", "editor", `{"from": "unknown", "to": "golang"}`)
+	assert("rename unknown tag", http.StatusNotFound, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/tags/rename", "editor", `{"from": "news", "to": "Go"}`)
+	assert("merge tags", http.StatusOK, w.Code, t)
+
+	p, _ = db.Post(50)
+	if !reflect.DeepEqual(p.Tags, []string{"go"}) {
+		t.Fatalf("merged tags = %v, want [go]", p.Tags)
+	}
+	if got := ids("http://test.com/posts?tags=go"); !reflect.DeepEqual(got, []int{50, 51}) {
+		t.Errorf("posts with merged tag = %v, want [50 51]", got)
+	}
+}
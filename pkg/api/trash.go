@@ -0,0 +1,47 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"net/http"
+)
+
+// getTrashHandler обработчик для метода GET корзины,
+// возвращает удаленные публикации
+func (api *Api) getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorize(w, r, ActionRestore, nil) {
+		return
+	}
+
+	posts, err := api.db.Trash()
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": api.permitted(r, ActionRestore, posts)}, http.StatusOK)
+}
+
+// restorePostHandler обработчик для метода POST ресурса
+// восстановления, возвращает публикацию из корзины
+func (api *Api) restorePostHandler(w http.ResponseWriter, r *http.Request) {
+
+	var post storage.Post
+
+	if !api.decode(w, r, &post) {
+		return
+	}
+
+	if !api.authorize(w, r, ActionRestore, &post) {
+		return
+	}
+
+	err := api.db.RestorePost(post)
+	if err != nil {
+		api.logger.Printf("error restoring in database: [%v]\n", err)
+		writeProblem(w, r, storageProblem(err))
+		return
+	}
+	// запрос содержит только id, подписчикам нужна публикация целиком
+	api.notifyStored(storage.EventUpdated, post.Id)
+	api.writeResponse(w, nil, http.StatusOK)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApi_trash(t *testing.T) {
+	db := memDb.New()
+	_ = db.AddPost(storage.Post{Id: 10, Author: storage.Author{Id: 1}})
+	_ = db.AddPost(storage.Post{Id: 20, Author: storage.Author{Id: 2}})
+
+	keys := APIKeys{
+		"author1": {Role: RoleAuthor, AuthorId: 1},
+		"editor":  {Role: RoleEditor},
+	}
+	h := New(db, log.New(io.Discard, "", 0), WithAuthenticator(keys), WithPolicy(DefaultPolicy)).Mux()
+
+	do := func(method, url, key string, post *storage.Post) *httptest.ResponseRecorder {
+		var body io.Reader
+		if post != nil {
+			b, _ := json.Marshal(post)
+			body = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, url, body)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	list := func(url, key string) []storage.Post {
+		w := do(http.MethodGet, url, key, nil)
+		var reply struct{ Data []storage.Post }
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatalf("due decoding %s = %v", url, err)
+		}
+		return reply.Data
+	}
+
+	do(http.MethodDelete, "http://test.com/posts", "editor", &storage.Post{Id: 10})
+	do(http.MethodDelete, "http://test.com/posts", "editor", &storage.Post{Id: 20})
+
+	for _, p := range list("http://test.com/posts", "editor") {
+		if p.Id == 10 || p.Id == 20 {
+			t.Fatalf("deleted post %d is listed", p.Id)
+		}
+	}
+
+	trash := list("http://test.com/trash", "editor")
+	assert("editor trash size", 2, len(trash), t)
+	if trash[0].DeletedAt == 0 {
+		t.Fatal("post in trash has no deletion time")
+	}
+
+	trash = list("http://test.com/trash", "author1")
+	assert("author trash size", 1, len(trash), t)
+	assert("author trash post", 10, trash[0].Id, t)
+
+	w := do(http.MethodPost, "http://test.com/trash/restore", "author1", &storage.Post{Id: 20})
+	assert("restore foreign post", http.StatusForbidden, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/trash/restore", "author1", &storage.Post{Id: 10})
+	assert("restore own post", http.StatusOK, w.Code, t)
+
+	w = do(http.MethodPost, "http://test.com/trash/restore", "editor", &storage.Post{Id: 10})
+	assert("restore live post", http.StatusNotFound, w.Code, t)
+
+	p, err := db.Post(10)
+	if err != nil {
+		t.Fatalf("memdb.Post() of restored post = error %v", err)
+	}
+	assert("restored post DeletedAt", int64(0), p.DeletedAt, t)
+}
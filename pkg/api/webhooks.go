@@ -0,0 +1,284 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"GoNews/pkg/webhooks"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// WebhookRequest запрос на создание подписки: адрес обратного вызова,
+// события (пустой список - все) и ключ подписи (если не задан,
+// создается случайно и возвращается в ответе)
+type WebhookRequest struct {
+	URL    string              `json:"url"`
+	Events []storage.EventType `json:"events"`
+	Secret string              `json:"secret"`
+}
+
+// WithWebhooks включает подписки на события изменения публикаций
+// и ресурсы /webhooks для управления ими
+func WithWebhooks(d *webhooks.Dispatcher) Option {
+	return func(api *Api) { api.webhooks = d }
+}
+
+// webhookResources возвращает ресурсы управления подписками
+func (api *Api) webhookResources() map[string]methods {
+	return map[string]methods{
+		"/webhooks": {
+			http.MethodGet:  http.HandlerFunc(api.getWebhooksHandler),
+			http.MethodPost: http.HandlerFunc(api.postWebhookHandler),
+		},
+		"/webhooks/dead-letters": {
+			http.MethodGet: http.HandlerFunc(api.getDeadLettersHandler),
+		},
+		"/webhooks/{id}": {
+			http.MethodGet:    http.HandlerFunc(api.getWebhookHandler),
+			http.MethodDelete: http.HandlerFunc(api.deleteWebhookHandler),
+		},
+		"/webhooks/{id}/deliveries": {
+			http.MethodGet: http.HandlerFunc(api.getDeliveriesHandler),
+		},
+		"/webhooks/{id}/deliveries/{delivery}/redeliver": {
+			http.MethodPost: http.HandlerFunc(api.redeliverHandler),
+		},
+	}
+}
+
+// notify рассылает подписчикам и в ленту событие typ о публикации
+// post. Рассылаются только опубликованные публикации: черновики
+// партнерам и читателям не видны. Удаленная публикация берется
+// из корзины и рассылается без содержимого
+func (api *Api) notify(typ storage.EventType, post storage.Post) {
+	if api.webhooks == nil && api.hub == nil {
+		return
+	}
+	if typ == storage.EventDeleted {
+		trashed, err := api.db.TrashedPost(post.Id)
+		if err != nil || !trashed.Published() {
+			return
+		}
+		post = removed(trashed)
+	} else if !post.Published() {
+		return
+	}
+	api.publish(typ, post)
+}
+
+// notifyUpdate рассылает изменение публикации, сохраненной до него
+// как before. Снятая с публикации публикация рассылается как удаленная,
+// чтобы подписчики перестали ее показывать
+func (api *Api) notifyUpdate(before, post storage.Post) {
+	if before.Published() && !post.Published() {
+		api.publish(storage.EventDeleted, removed(post))
+		return
+	}
+	api.notify(storage.EventUpdated, post)
+}
+
+// notifyStored рассылает событие typ о публикации id, прочитанной
+// из хранилища после записи: запрос может не содержать полей,
+// которые заполнило хранилище
+func (api *Api) notifyStored(typ storage.EventType, id int) {
+	if post, ok := api.notified(id); ok {
+		api.notify(typ, post)
+	}
+}
+
+// notifyStoredUpdate рассылает изменение публикации id, как
+// notifyUpdate, по публикации, прочитанной из хранилища
+func (api *Api) notifyStoredUpdate(before storage.Post, id int) {
+	if post, ok := api.notified(id); ok {
+		api.notifyUpdate(before, post)
+	}
+}
+
+// notified читает публикацию id для рассылки. Без подписчиков
+// и ленты публикация не читается
+func (api *Api) notified(id int) (storage.Post, bool) {
+	if api.webhooks == nil && api.hub == nil {
+		return storage.Post{}, false
+	}
+	post, err := api.storedPost(id)
+	if err != nil {
+		api.logger.Printf("error fetching from database: [%v]\n", err)
+		return storage.Post{}, false
+	}
+	return post, true
+}
+
+// NotifyPublished рассылает подписчикам и в ленту события создания
+// публикаций ids, опубликованных по расписанию: до публикации
+// подписчики их не видели
+func (api *Api) NotifyPublished(ids []int) {
+	if api.webhooks == nil && api.hub == nil {
+		return
+	}
+	for _, id := range ids {
+		post, err := api.storedPost(id)
+		if err != nil {
+			api.logger.Printf("error fetching from database: [%v]\n", err)
+			continue
+		}
+		api.notify(storage.EventCreated, post)
+	}
+}
+
+// publish отправляет событие подписчикам и в ленту
+func (api *Api) publish(typ storage.EventType, post storage.Post) {
+	if api.webhooks != nil {
+		api.webhooks.Notify(typ, post)
+	}
+	if api.hub != nil {
+		api.hub.Publish(typ, post)
+	}
+}
+
+// removed возвращает публикацию для события удаления: только id,
+// автора и теги, по которым подписчики отбирают события
+func removed(post storage.Post) storage.Post {
+	return storage.Post{Id: post.Id, Author: post.Author, Tags: post.Tags}
+}
+
+// actionEvent возвращает событие, которое порождает действие
+func actionEvent(action Action) storage.EventType {
+	switch action {
+	case ActionCreate:
+		return storage.EventCreated
+	case ActionDelete:
+		return storage.EventDeleted
+	default:
+		return storage.EventUpdated
+	}
+}
+
+// getWebhooksHandler обработчик для метода GET ресурса подписок
+func (api *Api) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorizeAll(w, r, ActionWebhooks) {
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": api.webhooks.Subscriptions()}, http.StatusOK)
+}
+
+// postWebhookHandler обработчик для метода POST ресурса подписок,
+// ключ подписи возвращается только в этом ответе
+func (api *Api) postWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorizeAll(w, r, ActionWebhooks) {
+		return
+	}
+
+	var req WebhookRequest
+	if !api.decode(w, r, &req) {
+		return
+	}
+
+	sub, err := api.webhooks.Subscribe(req.URL, req.Events, req.Secret)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": sub}, http.StatusCreated)
+}
+
+// getWebhookHandler обработчик для метода GET подписки
+func (api *Api) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := api.webhookId(w, r)
+	if !ok {
+		return
+	}
+
+	sub, err := api.webhooks.Subscription(id)
+	if err != nil {
+		api.webhookError(w, r, err)
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": sub}, http.StatusOK)
+}
+
+// deleteWebhookHandler обработчик для метода DELETE подписки
+func (api *Api) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := api.webhookId(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.webhooks.Unsubscribe(id); err != nil {
+		api.webhookError(w, r, err)
+		return
+	}
+	api.writeResponse(w, nil, http.StatusOK)
+}
+
+// getDeliveriesHandler обработчик для метода GET журнала
+// доставок подписки, начиная с последних
+func (api *Api) getDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := api.webhookId(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := api.webhooks.Deliveries(id)
+	if err != nil {
+		api.webhookError(w, r, err)
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": list}, http.StatusOK)
+}
+
+// getDeadLettersHandler обработчик для метода GET списка
+// недоставленных событий всех подписок
+func (api *Api) getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorizeAll(w, r, ActionWebhooks) {
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": api.webhooks.DeadLetters()}, http.StatusOK)
+}
+
+// redeliverHandler обработчик для метода POST повторной доставки,
+// доставка выполняется асинхронно, ответ 202 содержит ее состояние
+func (api *Api) redeliverHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := api.webhookId(w, r)
+	if !ok {
+		return
+	}
+	deliveryId, err := strconv.ParseInt(pathParam(r, "delivery"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid delivery id")
+		return
+	}
+
+	dl, err := api.webhooks.Redeliver(id, deliveryId)
+	if err != nil {
+		api.webhookError(w, r, err)
+		return
+	}
+	api.writeResponse(w, map[string]any{"data": dl}, http.StatusAccepted)
+}
+
+// webhookId проверяет права на управление подписками и возвращает
+// id подписки из пути запроса. Если нельзя, пишет ответ с ошибкой
+func (api *Api) webhookId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if !api.authorizeAll(w, r, ActionWebhooks) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid webhook id")
+		return 0, false
+	}
+	return id, true
+}
+
+// webhookError пишет ответ с описанием ошибки подписок
+func (api *Api) webhookError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "webhook or delivery not found")
+	case errors.Is(err, webhooks.ErrInProgress):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		api.logger.Printf("error managing webhooks: [%v]\n", err)
+		writeError(w, r, http.StatusInternalServerError, "")
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"GoNews/pkg/stream"
+	"GoNews/pkg/webhooks"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApi_webhooks(t *testing.T) {
+	events := make(chan storage.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e storage.Event
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if r.Header.Get("X-Webhook-Signature") != "sha256="+webhooks.Sign("partner-secret", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.Unmarshal(body, &e)
+		events <- e
+	}))
+	defer receiver.Close()
+
+	d := webhooks.New(webhooks.Config{BaseBackoff: time.Millisecond, AllowPrivate: true})
+	defer d.Close()
+
+	keys := APIKeys{
+		"editor": {Name: "editor", Role: RoleEditor},
+		"admin":  {Name: "admin", Role: RoleAdmin},
+	}
+	h := New(memDb.New(), log.New(io.Discard, "", 0),
+		WithAuthenticator(keys), WithPolicy(DefaultPolicy), WithWebhooks(d)).Mux()
+
+	do := func(method, url, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	sub := `{"url": "` + receiver.URL + `", "events": ["created", "deleted"], "secret": "partner-secret"}`
+	assert("editor creates webhook", http.StatusForbidden, do(http.MethodPost, "http://test.com/webhooks", "editor", sub).Code, t)
+	assert("invalid webhook", http.StatusBadRequest,
+		do(http.MethodPost, "http://test.com/webhooks", "admin", `{"url": "not a url"}`).Code, t)
+
+	w := do(http.MethodPost, "http://test.com/webhooks", "admin", sub)
+	assert("admin creates webhook", http.StatusCreated, w.Code, t)
+	var created struct{ Data webhooks.Subscription }
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("due decoding webhook = %v", err)
+	}
+	assert("webhook secret", "partner-secret", created.Data.Secret, t)
+
+	// черновик и изменение подписчику не рассылаются
+	do(http.MethodPost, "http://test.com/posts", "editor", `{"Id": 50, "Title": "draft", "Status": "draft"}`)
+	do(http.MethodPost, "http://test.com/posts", "editor", `{"Id": 51, "Title": "news", "Content": "news"}`)
+	do(http.MethodPut, "http://test.com/posts", "editor", `{"Id": 51, "Title": "news 2", "Content": "news"}`)
+	do(http.MethodDelete, "http://test.com/posts", "editor", `{"Id": 51}`)
+
+	// события доставляются параллельно, порядок не гарантирован
+	got := map[storage.EventType]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if e.PostId != 51 {
+				t.Fatalf("webhook event = %+v, want event of post 51", e)
+			}
+			got[e.Type] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook event %d is not delivered", i+1)
+		}
+	}
+	if !got[storage.EventCreated] || !got[storage.EventDeleted] {
+		t.Fatalf("webhook events = %v, want created and deleted", got)
+	}
+
+	id := strconv.Itoa(created.Data.Id)
+	var deliveries struct{ Data []webhooks.Delivery }
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		w = do(http.MethodGet, "http://test.com/webhooks/"+id+"/deliveries", "admin", "")
+		assert("webhook deliveries", http.StatusOK, w.Code, t)
+		if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil {
+			t.Fatalf("due decoding deliveries = %v", err)
+		}
+		if succeeded(deliveries.Data) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !succeeded(deliveries.Data) {
+		t.Fatalf("webhook deliveries = %+v, want 2 succeeded", deliveries.Data)
+	}
+
+	url := "http://test.com/webhooks/" + id + "/deliveries/" + strconv.FormatInt(deliveries.Data[1].Id, 10) + "/redeliver"
+	assert("redeliver", http.StatusAccepted, do(http.MethodPost, url, "admin", "").Code, t)
+	select {
+	case e := <-events:
+		assert("redelivered event id", deliveries.Data[1].Event.Id, e.Id, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("redelivered event is not delivered")
+	}
+
+	assert("dead letters", http.StatusOK, do(http.MethodGet, "http://test.com/webhooks/dead-letters", "admin", "").Code, t)
+	assert("delete webhook", http.StatusOK, do(http.MethodDelete, "http://test.com/webhooks/"+id, "admin", "").Code, t)
+	assert("deleted webhook", http.StatusNotFound, do(http.MethodGet, "http://test.com/webhooks/"+id, "admin", "").Code, t)
+}
+
+// succeeded проверяет, что обе доставки завершились успешно
+func succeeded(list []webhooks.Delivery) bool {
+	return len(list) == 2 && list[0].Status == webhooks.DeliverySucceeded && list[1].Status == webhooks.DeliverySucceeded
+}
+
+func TestApi_notifyUnpublished(t *testing.T) {
+	hub := stream.New(0)
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithStream(hub, time.Minute)).Mux()
+	sub, _, _ := hub.Subscribe(0)
+	defer hub.Unsubscribe(sub)
+
+	do := func(method, body string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/posts", strings.NewReader(body)))
+		if w.Code >= http.StatusBadRequest {
+			t.Fatalf("%s /posts %s = %d %s", method, body, w.Code, w.Body.String())
+		}
+	}
+	next := func() (storage.EventType, storage.Post) {
+		t.Helper()
+		select {
+		case e := <-sub.C:
+			var post storage.Post
+			json.Unmarshal(e.Payload, &post)
+			return e.Type, post
+		default:
+			return "", storage.Post{}
+		}
+	}
+
+	// удаление черновика и запланированной публикации не рассылается
+	do(http.MethodPost, `{"Id": 50, "Title": "draft", "Content": "secret", "Status": "draft"}`)
+	do(http.MethodPost, fmt.Sprintf(`{"Id": 51, "Title": "soon", "Content": "secret", "Status": "scheduled", "PublishAt": %d}`,
+		time.Now().Add(time.Hour).Unix()))
+	do(http.MethodDelete, `{"Id": 50}`)
+	do(http.MethodDelete, `{"Id": 51}`)
+	if typ, post := next(); typ != "" {
+		t.Fatalf("event %s %+v for unpublished post", typ, post)
+	}
+
+	// удаление опубликованной рассылается без содержимого
+	do(http.MethodPost, `{"Id": 52, "Title": "news", "Content": "text", "Tags": ["go"], "Author": {"Id": 3}}`)
+	if typ, _ := next(); typ != storage.EventCreated {
+		t.Fatalf("event = %q, want created", typ)
+	}
+	do(http.MethodDelete, `{"Id": 52}`)
+	typ, post := next()
+	assert("delete event", storage.EventDeleted, typ, t)
+	assert("deleted post id", 52, post.Id, t)
+	assert("deleted post author", 3, post.Author.Id, t)
+	assert("deleted post tag", "go", post.Tags[0], t)
+	if post.Title != "" || post.Content != "" {
+		t.Fatalf("deleted post payload = %+v, want no content", post)
+	}
+
+	// снятие с публикации рассылается как удаление
+	do(http.MethodPost, `{"Id": 53, "Title": "news", "Content": "text"}`)
+	next()
+	do(http.MethodPut, `{"Id": 53, "Title": "old news", "Content": "text", "Status": "archived"}`)
+	typ, post = next()
+	assert("archive event", storage.EventDeleted, typ, t)
+	assert("archived post id", 53, post.Id, t)
+	assert("archived post content", "", post.Content, t)
+
+	// изменение архивной публикации не рассылается
+	do(http.MethodPut, `{"Id": 53, "Title": "older news", "Content": "text"}`)
+	if typ, post := next(); typ != "" {
+		t.Fatalf("event %s %+v for archived post", typ, post)
+	}
+}
+
+func TestApi_notifyStored(t *testing.T) {
+	hub := stream.New(0)
+	h := New(stampingDB{memDb.New()}, log.New(io.Discard, "", 0), WithStream(hub, time.Minute)).Mux()
+	sub, _, _ := hub.Subscribe(0)
+	defer hub.Unsubscribe(sub)
+
+	do := func(method, url, body string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		if w.Code >= http.StatusBadRequest {
+			t.Fatalf("%s %s %s = %d %s", method, url, body, w.Code, w.Body.String())
+		}
+	}
+
+	// событие содержит публикацию, сохраненную хранилищем
+	do(http.MethodPost, "/posts", `{"Id": 50, "Title": "news", "Content": "text"}`)
+	do(http.MethodPost, "/posts/bulk", `[{"Id": 51, "Title": "news", "Content": "text"}]`)
+	for _, id := range []int{50, 51} {
+		select {
+		case e := <-sub.C:
+			var post storage.Post
+			json.Unmarshal(e.Payload, &post)
+			assert("event post id", id, post.Id, t)
+			assert("event post created_at", int64(42), post.CreatedAt, t)
+		default:
+			t.Fatalf("no event for post %d", id)
+		}
+	}
+}
+
+func TestApi_webhooksWithoutPolicy(t *testing.T) {
+	d := webhooks.New(webhooks.Config{AllowPrivate: true})
+	defer d.Close()
+	h := New(memDb.New(), log.New(io.Discard, "", 0), WithWebhooks(d)).Mux()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/webhooks",
+			strings.NewReader(`{"url": "http://127.0.0.1/hook", "events": ["created"]}`)))
+		assert(method+" /webhooks without policy", http.StatusUnauthorized, w.Code, t)
+	}
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	"GoNews/pkg/stream"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultWSMaxSubscriptions = 100  // авторов и тегов на соединение
+	DefaultWSMaxMessageSize   = 4096 // байт в сообщении клиента
+	DefaultWSQueueSize        = 64   // событий в очереди соединения
+	DefaultWSPingInterval     = 30 * time.Second
+	DefaultWSWriteTimeout     = 10 * time.Second
+)
+
+// Типы сообщений протокола WebSocket
+const (
+	WSSubscribe   = "subscribe"   // клиент: подписка на авторов и теги
+	WSUnsubscribe = "unsubscribe" // клиент: отписка от авторов и тегов
+	WSPing        = "ping"        // клиент: проверка соединения
+	WSPong        = "pong"        // сервер: ответ на ping с тем же id
+	WSSubscribed  = "subscribed"  // сервер: текущие подписки соединения
+	WSEvent       = "event"       // сервер: событие изменения публикации
+	WSDropped     = "dropped"     // сервер: число пропущенных событий
+	WSError       = "error"       // сервер: ошибка в сообщении клиента
+)
+
+// WebSocketConfig ограничения соединений WebSocket, нулевые
+// значения заменяются значениями по умолчанию
+type WebSocketConfig struct {
+	// MaxSubscriptions наибольшее число авторов и тегов,
+	// на которые подписано одно соединение
+	MaxSubscriptions int
+
+	// MaxMessageSize наибольший размер сообщения клиента, при
+	// превышении соединение закрывается
+	MaxMessageSize int64
+
+	// QueueSize число событий, ожидающих отправки клиенту. Если
+	// клиент не успевает их читать, новые события пропускаются,
+	// а клиент получает сообщение dropped с их числом. При
+	// DisconnectSlow такой клиент вместо этого отключается
+	QueueSize      int
+	DisconnectSlow bool
+
+	// PingInterval периодичность проверки соединения: клиент, не
+	// ответивший за два интервала, отключается. WriteTimeout -
+	// время на отправку одного сообщения
+	PingInterval time.Duration
+	WriteTimeout time.Duration
+}
+
+// WSMessage сообщение протокола WebSocket. Клиент подписывается
+// сообщением {"type": "subscribe", "authors": [1], "tags": ["go"]}
+// и получает события публикаций этих авторов или с этими тегами
+type WSMessage struct {
+	Type    string         `json:"type"`
+	Id      string         `json:"id,omitempty"`
+	Authors []int          `json:"authors,omitempty"`
+	Tags    []string       `json:"tags,omitempty"`
+	Event   *storage.Event `json:"event,omitempty"`
+	Dropped int            `json:"dropped,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// WithWebSocket включает подписку на изменения публикаций отдельных
+// авторов и тегов через WebSocket /posts/ws. События берутся из
+// ленты h, той же, что передана WithStream
+func WithWebSocket(h *stream.Hub, cfg WebSocketConfig) Option {
+	return func(api *Api) {
+		if cfg.MaxSubscriptions <= 0 {
+			cfg.MaxSubscriptions = DefaultWSMaxSubscriptions
+		}
+		if cfg.MaxMessageSize <= 0 {
+			cfg.MaxMessageSize = DefaultWSMaxMessageSize
+		}
+		if cfg.QueueSize <= 0 {
+			cfg.QueueSize = DefaultWSQueueSize
+		}
+		if cfg.PingInterval <= 0 {
+			cfg.PingInterval = DefaultWSPingInterval
+		}
+		if cfg.WriteTimeout <= 0 {
+			cfg.WriteTimeout = DefaultWSWriteTimeout
+		}
+		api.hub, api.ws = h, &cfg
+	}
+}
+
+// wsConn соединение WebSocket с подписками клиента. Все сообщения
+// отправляет одна горутина write, остальные передают их через очереди
+type wsConn struct {
+	cfg  *WebSocketConfig
+	conn *websocket.Conn
+
+	events  chan WSMessage // события публикаций
+	replies chan WSMessage // ответы на сообщения клиента
+	done    chan struct{}  // закрывается при завершении соединения
+	once    sync.Once
+
+	mu      sync.Mutex
+	authors map[int]bool
+	tags    map[string]bool
+	dropped int // пропущено событий с последней отправки
+}
+
+// websocketHandler обработчик для метода GET подписки через WebSocket
+func (api *Api) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.authorize(w, r, ActionRead, nil) {
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: api.wsOriginAllowed}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// ответ с ошибкой уже отправлен
+		return
+	}
+	defer conn.Close()
+
+	sub, _, _ := api.hub.Subscribe(0)
+	if sub == nil {
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+			time.Now().Add(api.ws.WriteTimeout))
+		return
+	}
+	defer api.hub.Unsubscribe(sub)
+
+	c := &wsConn{
+		cfg:     api.ws,
+		conn:    conn,
+		events:  make(chan WSMessage, api.ws.QueueSize),
+		replies: make(chan WSMessage, 16),
+		done:    make(chan struct{}),
+		authors: make(map[int]bool),
+		tags:    make(map[string]bool),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.fanOut(sub, api.hub.Done())
+	}()
+	go func() {
+		defer wg.Done()
+		if err := c.write(); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+			c.close(websocket.CloseAbnormalClosure, "")
+		}
+	}()
+
+	c.read()
+	c.close(websocket.CloseNormalClosure, "")
+	wg.Wait()
+}
+
+// wsOriginAllowed разрешает подключение без заголовка Origin, с того же
+// узла или с источников, разрешенных настройками CORS
+func (api *Api) wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return api.cors != nil && api.cors.originAllowed(origin)
+}
+
+// close завершает соединение, отправляя клиенту код и причину закрытия
+func (c *wsConn) close(code int, reason string) {
+	c.once.Do(func() {
+		deadline := time.Now().Add(c.cfg.WriteTimeout)
+		if code != websocket.CloseAbnormalClosure {
+			_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		}
+		// ждем ответного закрытия от клиента не дольше WriteTimeout
+		_ = c.conn.SetReadDeadline(deadline)
+		close(c.done)
+	})
+}
+
+// read читает и обрабатывает сообщения клиента до закрытия соединения
+func (c *wsConn) read() {
+	pongWait := 2 * c.cfg.PingInterval
+	c.conn.SetReadLimit(c.cfg.MaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				c.close(websocket.CloseMessageTooBig, "message is too big")
+			}
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var msg WSMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			c.reply(WSMessage{Type: WSError, Error: "invalid message: " + err.Error()})
+			continue
+		}
+
+		switch msg.Type {
+		case WSPing:
+			c.reply(WSMessage{Type: WSPong, Id: msg.Id})
+		case WSSubscribe, WSUnsubscribe:
+			if err := c.subscribe(msg); err != nil {
+				c.reply(WSMessage{Type: WSError, Id: msg.Id, Error: err.Error()})
+				continue
+			}
+			authors, tags := c.subscriptions()
+			c.reply(WSMessage{Type: WSSubscribed, Id: msg.Id, Authors: authors, Tags: tags})
+		default:
+			c.reply(WSMessage{Type: WSError, Id: msg.Id, Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// reply ставит в очередь ответ клиенту. Клиент, не читающий
+// ответы на свои сообщения, ждет их отправки
+func (c *wsConn) reply(msg WSMessage) {
+	select {
+	case c.replies <- msg:
+	case <-c.done:
+	}
+}
+
+// subscribe изменяет подписки соединения согласно сообщению msg.
+// При ошибке подписки не изменяются
+func (c *wsConn) subscribe(msg WSMessage) error {
+	tags := make([]string, 0, len(msg.Tags))
+	for _, t := range msg.Tags {
+		t, err := normalizeTag(t)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, t)
+	}
+	for _, id := range msg.Authors {
+		if id <= 0 {
+			return fmt.Errorf("invalid author id %d", id)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.Type == WSUnsubscribe {
+		for _, id := range msg.Authors {
+			delete(c.authors, id)
+		}
+		for _, t := range tags {
+			delete(c.tags, t)
+		}
+		return nil
+	}
+
+	added := 0
+	for _, id := range msg.Authors {
+		if !c.authors[id] {
+			added++
+		}
+	}
+	for _, t := range tags {
+		if !c.tags[t] {
+			added++
+		}
+	}
+	if len(c.authors)+len(c.tags)+added > c.cfg.MaxSubscriptions {
+		return fmt.Errorf("too many subscriptions, limit is %d", c.cfg.MaxSubscriptions)
+	}
+	for _, id := range msg.Authors {
+		c.authors[id] = true
+	}
+	for _, t := range tags {
+		c.tags[t] = true
+	}
+	return nil
+}
+
+// subscriptions возвращает авторов и теги, на которые подписано соединение
+func (c *wsConn) subscriptions() ([]int, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	authors := make([]int, 0, len(c.authors))
+	for id := range c.authors {
+		authors = append(authors, id)
+	}
+	tags := make([]string, 0, len(c.tags))
+	for t := range c.tags {
+		tags = append(tags, t)
+	}
+	sort.Ints(authors)
+	sort.Strings(tags)
+	return authors, tags
+}
+
+// wants сообщает, подписано ли соединение на публикацию из события e
+func (c *wsConn) wants(e storage.Event) bool {
+	var post struct {
+		Author storage.Author
+		Tags   []string
+	}
+	if err := json.Unmarshal(e.Payload, &post); err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authors[post.Author.Id] {
+		return true
+	}
+	for _, t := range post.Tags {
+		if c.tags[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// fanOut отбирает события ленты по подпискам соединения и ставит их
+// в очередь отправки. Переполнение очереди не задерживает ленту:
+// событие пропускается или медленный клиент отключается
+func (c *wsConn) fanOut(sub *stream.Subscriber, shutdown <-chan struct{}) {
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				select {
+				case <-shutdown:
+					c.close(websocket.CloseGoingAway, "server is shutting down")
+				default:
+					c.close(websocket.CloseTryAgainLater, "too slow")
+				}
+				return
+			}
+			if !c.wants(e) {
+				continue
+			}
+			select {
+			case c.events <- WSMessage{Type: WSEvent, Event: &e}:
+			default:
+				if c.cfg.DisconnectSlow {
+					c.close(websocket.ClosePolicyViolation, "too slow")
+					return
+				}
+				c.mu.Lock()
+				c.dropped++
+				c.mu.Unlock()
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write отправляет клиенту сообщения из очередей и проверяет
+// соединение сообщениями ping до завершения соединения
+func (c *wsConn) write() error {
+	ping := time.NewTicker(c.cfg.PingInterval)
+	defer ping.Stop()
+
+	send := func(msg WSMessage) error {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+		return c.conn.WriteJSON(msg)
+	}
+
+	for {
+		select {
+		case msg := <-c.replies:
+			if err := send(msg); err != nil {
+				return err
+			}
+		case msg := <-c.events:
+			c.mu.Lock()
+			dropped := c.dropped
+			c.dropped = 0
+			c.mu.Unlock()
+			if dropped > 0 {
+				if err := send(WSMessage{Type: WSDropped, Dropped: dropped}); err != nil {
+					return err
+				}
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(c.cfg.WriteTimeout)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return err
+			}
+		case <-c.done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"GoNews/pkg/stream"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsDial подключается к /posts/ws тестового сервера
+func wsDial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/posts/ws", nil)
+	if err != nil {
+		t.Fatalf("connecting to websocket = %v", err)
+	}
+	return conn
+}
+
+// wsRead читает следующее сообщение сервера
+func wsRead(t *testing.T, conn *websocket.Conn) WSMessage {
+	t.Helper()
+	var msg WSMessage
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("reading websocket = %v", err)
+	}
+	return msg
+}
+
+func TestApi_websocket(t *testing.T) {
+	hub := stream.New(0)
+	h := New(memDb.New(), log.New(io.Discard, "", 0),
+		WithWebSocket(hub, WebSocketConfig{MaxSubscriptions: 3})).Mux()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	write := func(method, body string) {
+		req, _ := http.NewRequest(method, srv.URL+"/posts", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s /posts = %v", method, err)
+		}
+		resp.Body.Close()
+	}
+
+	conn := wsDial(t, srv)
+	defer conn.Close()
+
+	_ = conn.WriteJSON(WSMessage{Type: WSSubscribe, Id: "s1", Authors: []int{7}, Tags: []string{" Go "}})
+	msg := wsRead(t, conn)
+	if msg.Type != WSSubscribed || msg.Id != "s1" || len(msg.Authors) != 1 || len(msg.Tags) != 1 || msg.Tags[0] != "go" {
+		t.Fatalf("subscribe reply = %+v, want author 7 and tag go", msg)
+	}
+
+	_ = conn.WriteJSON(WSMessage{Type: WSSubscribe, Tags: []string{"a", "b"}})
+	assert("subscriptions limit", WSError, wsRead(t, conn).Type, t)
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("{"))
+	assert("invalid message", WSError, wsRead(t, conn).Type, t)
+	_ = conn.WriteJSON(WSMessage{Type: WSPing, Id: "p1"})
+	msg = wsRead(t, conn)
+	assert("pong", WSPong, msg.Type, t)
+	assert("pong id", "p1", msg.Id, t)
+
+	// публикации других авторов и тегов клиенту не приходят
+	write(http.MethodPost, `{"Id": 50, "Title": "other", "Content": "other", "Author": {"Id": 1}, "Tags": ["news"]}`)
+	write(http.MethodPost, `{"Id": 51, "Title": "author", "Content": "author", "Author": {"Id": 7}}`)
+	write(http.MethodPost, `{"Id": 52, "Title": "tag", "Content": "tag", "Author": {"Id": 1}, "Tags": ["go"]}`)
+	write(http.MethodDelete, `{"Id": 51}`)
+
+	for _, want := range []struct {
+		typ    storage.EventType
+		postId int
+	}{{storage.EventCreated, 51}, {storage.EventCreated, 52}, {storage.EventDeleted, 51}} {
+		msg = wsRead(t, conn)
+		if msg.Type != WSEvent || msg.Event.Type != want.typ || msg.Event.PostId != want.postId {
+			t.Fatalf("websocket message = %+v, want %s of post %d", msg, want.typ, want.postId)
+		}
+	}
+
+	_ = conn.WriteJSON(WSMessage{Type: WSUnsubscribe, Authors: []int{7}})
+	msg = wsRead(t, conn)
+	if msg.Type != WSSubscribed || len(msg.Authors) != 0 || len(msg.Tags) != 1 {
+		t.Fatalf("unsubscribe reply = %+v, want only tag go", msg)
+	}
+
+	// слишком большое сообщение закрывает соединение
+	_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ping", "id": "`+strings.Repeat("x", DefaultWSMaxMessageSize)+`"}`))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("reading after big message = %v, want close %d", err, websocket.CloseMessageTooBig)
+	}
+
+	// остановка ленты закрывает соединения
+	conn = wsDial(t, srv)
+	defer conn.Close()
+	hub.Close()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("reading after shutdown = %v, want close %d", err, websocket.CloseGoingAway)
+	}
+}
+
+func TestWsConn_dropEvents(t *testing.T) {
+	hub := stream.New(0)
+	defer hub.Close()
+	sub, _, _ := hub.Subscribe(0)
+
+	c := &wsConn{
+		cfg:     &WebSocketConfig{},
+		events:  make(chan WSMessage, 1),
+		done:    make(chan struct{}),
+		authors: map[int]bool{7: true},
+	}
+	finished := make(chan struct{})
+	go func() {
+		c.fanOut(sub, hub.Done())
+		close(finished)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		hub.Publish(storage.EventCreated, storage.Post{Id: i, Author: storage.Author{Id: 7}})
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		dropped := c.dropped
+		c.mu.Unlock()
+		if dropped == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped events = %d, want 2", dropped)
+		}
+	}
+	close(c.done)
+	<-finished
+
+	// в очереди остается первое событие
+	assert("queued event", 1, (<-c.events).Event.PostId, t)
+}
+
+func TestApi_websocketDisconnectSlow(t *testing.T) {
+	hub := stream.New(0)
+	defer hub.Close()
+	h := New(memDb.New(), log.New(io.Discard, "", 0),
+		WithWebSocket(hub, WebSocketConfig{QueueSize: 1, DisconnectSlow: true})).Mux()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn := wsDial(t, srv)
+	defer conn.Close()
+	_ = conn.WriteJSON(WSMessage{Type: WSSubscribe, Authors: []int{7}})
+	wsRead(t, conn)
+
+	// клиент не читает события, пока не заполнятся буферы соединения
+	content := strings.Repeat("x", 64<<10)
+	for i := 1; i <= 300; i++ {
+		hub.Publish(storage.EventCreated, storage.Post{Id: i, Author: storage.Author{Id: 7}, Content: content})
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("reading slow connection = %v, want close %d", err, websocket.ClosePolicyViolation)
+		}
+		return
+	}
+}
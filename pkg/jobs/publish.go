@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"log"
+	"time"
+)
+
+// Publisher периодически публикует запланированные
+// публикации, время публикации которых наступило
+type Publisher struct {
+	db       storage.Model
+	interval time.Duration
+	logger   *log.Logger
+	notify   func(ids []int)
+
+	now func() time.Time
+}
+
+// PublisherOption задает необязательный параметр задачи публикации
+type PublisherOption func(*Publisher)
+
+// WithNotify вызывает f с id публикаций, опубликованных
+// очередной проверкой, например, для рассылки событий о них
+func WithNotify(f func(ids []int)) PublisherOption {
+	return func(p *Publisher) { p.notify = f }
+}
+
+// NewPublisher возвращает задачу публикации по расписанию,
+// запланированные публикации проверяются каждые interval
+func NewPublisher(db storage.Model, interval time.Duration, logger *log.Logger, opts ...PublisherOption) *Publisher {
+	p := &Publisher{
+		db:       db,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Run выполняет публикацию сразу и затем с заданным интервалом,
+// пока не будет отменен ctx
+func (p *Publisher) Run(ctx context.Context) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for {
+		n, err := p.Once()
+		if err != nil {
+			p.logger.Printf("error publishing scheduled posts: [%v]\n", err)
+		} else if n > 0 {
+			p.logger.Printf("published %d scheduled posts\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Once публикует запланированные публикации один раз
+// и возвращает их число
+func (p *Publisher) Once() (int, error) {
+	ids, err := p.db.PublishScheduled(p.now().Unix())
+	if len(ids) > 0 && p.notify != nil {
+		p.notify(ids)
+	}
+	return len(ids), err
+}
@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"GoNews/pkg/api"
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"GoNews/pkg/stream"
+	"GoNews/pkg/webhooks"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublisher_Once(t *testing.T) {
+	db := memDb.New()
+	publishAt := time.Now().Add(time.Hour)
+	err := db.AddPost(storage.Post{Id: 10, Status: storage.StatusScheduled, PublishAt: publishAt.Unix()})
+	if err != nil {
+		t.Fatalf("memdb.AddPost() = error %v", err)
+	}
+
+	p := NewPublisher(db, time.Minute, log.New(io.Discard, "", 0))
+
+	n, err := p.Once()
+	if err != nil {
+		t.Fatalf("Publisher.Once() = error %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("Publisher.Once() before publish time = %d posts, want 0", n)
+	}
+
+	p.now = func() time.Time { return publishAt }
+	n, err = p.Once()
+	if err != nil {
+		t.Fatalf("Publisher.Once() = error %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Publisher.Once() at publish time = %d posts, want 1", n)
+	}
+
+	post, _ := db.Post(10)
+	if post.Status != storage.StatusPublished {
+		t.Fatalf("post status after publishing = %q, want %q", post.Status, storage.StatusPublished)
+	}
+
+	n, _ = p.Once()
+	if n != 0 {
+		t.Fatalf("Publisher.Once() repeated = %d posts, want 0", n)
+	}
+}
+
+func TestPublisher_notify(t *testing.T) {
+	events := make(chan storage.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e storage.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer receiver.Close()
+
+	d := webhooks.New(webhooks.Config{BaseBackoff: time.Millisecond, AllowPrivate: true})
+	defer d.Close()
+	if _, err := d.Subscribe(receiver.URL, nil, "secret"); err != nil {
+		t.Fatalf("webhooks.Subscribe() = error %v", err)
+	}
+	hub := stream.New(0)
+	sub, _, _ := hub.Subscribe(0)
+	defer hub.Unsubscribe(sub)
+
+	db := memDb.New()
+	logger := log.New(io.Discard, "", 0)
+	a := api.New(db, logger, api.WithWebhooks(d), api.WithStream(hub, time.Minute))
+
+	publishAt := time.Now().Add(time.Hour)
+	err := db.AddPost(storage.Post{Id: 10, Title: "soon", Status: storage.StatusScheduled, PublishAt: publishAt.Unix()})
+	if err != nil {
+		t.Fatalf("memdb.AddPost() = error %v", err)
+	}
+
+	p := NewPublisher(db, time.Minute, logger, WithNotify(a.NotifyPublished))
+	p.now = func() time.Time { return publishAt }
+	if n, err := p.Once(); err != nil || n != 1 {
+		t.Fatalf("Publisher.Once() = %d, %v, want 1 post", n, err)
+	}
+
+	for name, c := range map[string]<-chan storage.Event{"webhook": events, "stream": sub.C} {
+		select {
+		case e := <-c:
+			if e.Type != storage.EventCreated || e.PostId != 10 {
+				t.Fatalf("%s event = %s for post %d, want created for post 10", name, e.Type, e.PostId)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s event is not delivered", name)
+		}
+	}
+}
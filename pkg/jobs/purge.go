@@ -0,0 +1,61 @@
+// Пакет jobs содержит фоновые задачи сервера,
+// работающие с БД по расписанию
+package jobs
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"log"
+	"time"
+)
+
+// Purge периодически окончательно удаляет публикации,
+// которые находятся в корзине дольше срока хранения
+type Purge struct {
+	db        storage.Model
+	retention time.Duration
+	interval  time.Duration
+	logger    *log.Logger
+
+	now func() time.Time
+}
+
+// NewPurge возвращает задачу очистки корзины: каждые interval
+// удаляются публикации, помещенные в корзину раньше, чем retention назад
+func NewPurge(db storage.Model, retention, interval time.Duration, logger *log.Logger) *Purge {
+	return &Purge{
+		db:        db,
+		retention: retention,
+		interval:  interval,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Run выполняет очистку сразу и затем с заданным интервалом,
+// пока не будет отменен ctx
+func (p *Purge) Run(ctx context.Context) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for {
+		n, err := p.Once()
+		if err != nil {
+			p.logger.Printf("error purging trash: [%v]\n", err)
+		} else if n > 0 {
+			p.logger.Printf("purged %d posts from trash\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Once выполняет очистку корзины один раз
+// и возвращает число удаленных публикаций
+func (p *Purge) Once() (int, error) {
+	return p.db.PurgePosts(p.now().Add(-p.retention).Unix())
+}
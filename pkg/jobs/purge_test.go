@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestPurge_Once(t *testing.T) {
+	db := memDb.New()
+	if err := db.DeletePost(storage.Post{Id: 1}); err != nil {
+		t.Fatalf("memdb.DeletePost() = error %v", err)
+	}
+
+	p := NewPurge(db, 24*time.Hour, time.Hour, log.New(io.Discard, "", 0))
+
+	n, err := p.Once()
+	if err != nil {
+		t.Fatalf("Purge.Once() = error %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("Purge.Once() before retention = %d posts, want 0", n)
+	}
+
+	p.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	n, err = p.Once()
+	if err != nil {
+		t.Fatalf("Purge.Once() = error %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Purge.Once() after retention = %d posts, want 1", n)
+	}
+
+	trash, _ := db.Trash()
+	if len(trash) != 0 {
+		t.Fatalf("trash after purge = %v, want empty", trash)
+	}
+	if _, err := db.Post(2); err != nil {
+		t.Fatalf("memdb.Post() of live post after purge = error %v", err)
+	}
+}
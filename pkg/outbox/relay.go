@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultBatchSize число событий, читаемых из outbox за раз
+const DefaultBatchSize = 100
+
+// Sink получатель событий изменения публикаций. Доставка выполняется
+// не менее одного раза: после сбоя событие может прийти повторно,
+// получатель различает повторы по Event.Id
+type Sink interface {
+	Publish(ctx context.Context, e storage.Event) error
+}
+
+// Relay периодически доставляет события из outbox получателям
+// и подтверждает доставку. События передаются по возрастанию Id,
+// ошибка доставки останавливает проход: следующие события ждут
+// повторной попытки, поэтому события одной публикации приходят
+// в порядке изменений. Событие считается доставленным, только если
+// его приняли все получатели. На одну БД запускается один Relay
+type Relay struct {
+	db       storage.Outbox
+	sinks    []Sink
+	interval time.Duration
+	batch    int
+	logger   *log.Logger
+}
+
+// NewRelay возвращает задачу доставки событий получателям sinks,
+// outbox проверяется каждые interval
+func NewRelay(db storage.Outbox, interval time.Duration, logger *log.Logger, sinks ...Sink) *Relay {
+	return &Relay{
+		db:       db,
+		sinks:    sinks,
+		interval: interval,
+		batch:    DefaultBatchSize,
+		logger:   logger,
+	}
+}
+
+// Run выполняет доставку сразу и затем с заданным интервалом,
+// пока не будет отменен ctx
+func (r *Relay) Run(ctx context.Context) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		n, err := r.Once(ctx)
+		if err != nil {
+			r.logger.Printf("error relaying outbox events: [%v]\n", err)
+		} else if n > 0 {
+			r.logger.Printf("relayed %d outbox events\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Once доставляет накопленные события, пока outbox не опустеет
+// или доставка не прервется ошибкой, и возвращает число доставленных
+func (r *Relay) Once(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		events, err := r.db.Events(r.batch)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := r.deliver(ctx, events)
+		total += n
+		if err != nil || len(events) < r.batch {
+			return total, err
+		}
+	}
+}
+
+// deliver передает события получателям по порядку и подтверждает
+// доставленные, возвращает их число и ошибку первого недоставленного
+func (r *Relay) deliver(ctx context.Context, events []storage.Event) (int, error) {
+	ids := make([]int64, 0, len(events))
+
+	var err error
+	for _, e := range events {
+		if err = r.publish(ctx, e); err != nil {
+			break
+		}
+		ids = append(ids, e.Id)
+	}
+
+	if ackErr := r.db.AckEvents(ids...); ackErr != nil {
+		return 0, ackErr
+	}
+	return len(ids), err
+}
+
+// publish передает событие всем получателям
+func (r *Relay) publish(ctx context.Context, e storage.Event) error {
+	for _, s := range r.sinks {
+		if err := s.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
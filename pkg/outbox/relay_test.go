@@ -0,0 +1,209 @@
+package outbox
+
+import (
+	"GoNews/pkg/storage"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memOutbox outbox в памяти для тестов
+type memOutbox struct {
+	mu     sync.Mutex
+	next   int64
+	events []storage.Event
+}
+
+func (o *memOutbox) add(typ storage.EventType, postId int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.next++
+	o.events = append(o.events, storage.Event{Id: o.next, Type: typ, PostId: postId, Payload: json.RawMessage(`{}`)})
+}
+
+func (o *memOutbox) Events(limit int) ([]storage.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if limit > len(o.events) {
+		limit = len(o.events)
+	}
+	return append([]storage.Event(nil), o.events[:limit]...), nil
+}
+
+func (o *memOutbox) AckEvents(ids ...int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	acked := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+	kept := o.events[:0]
+	for _, e := range o.events {
+		if !acked[e.Id] {
+			kept = append(kept, e)
+		}
+	}
+	o.events = kept
+	return nil
+}
+
+// flakySink получатель, отказывающий в доставке событий из fail
+type flakySink struct {
+	fail map[int64]bool
+	got  []int64
+}
+
+func (s *flakySink) Publish(_ context.Context, e storage.Event) error {
+	if s.fail[e.Id] {
+		return errors.New("sink is unavailable")
+	}
+	s.got = append(s.got, e.Id)
+	return nil
+}
+
+func TestRelay_Once(t *testing.T) {
+	db := &memOutbox{}
+	for i := 1; i <= 5; i++ {
+		db.add(storage.EventUpdated, i%2)
+	}
+	sink := &flakySink{fail: map[int64]bool{3: true}}
+	r := NewRelay(db, time.Minute, log.New(io.Discard, "", 0), sink)
+	r.batch = 2
+
+	n, err := r.Once(context.Background())
+	if err == nil || n != 2 {
+		t.Fatalf("Relay.Once() = %d, %v, want 2 events and error", n, err)
+	}
+	// событие 3 и следующие за ним ждут повторной попытки
+	if events, _ := db.Events(10); len(events) != 3 || events[0].Id != 3 {
+		t.Fatalf("outbox after failure = %v, want events 3-5", events)
+	}
+
+	delete(sink.fail, 3)
+	n, err = r.Once(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("Relay.Once() after recovery = %d, %v, want 3 events", n, err)
+	}
+	want := []int64{1, 2, 3, 4, 5}
+	if len(sink.got) != len(want) {
+		t.Fatalf("delivered events = %v, want %v", sink.got, want)
+	}
+	for i := range want {
+		if sink.got[i] != want[i] {
+			t.Fatalf("delivered events = %v, want %v", sink.got, want)
+		}
+	}
+}
+
+func TestRelay_atLeastOnce(t *testing.T) {
+	db := &memOutbox{}
+	db.add(storage.EventCreated, 1)
+
+	// первый получатель принял событие, второй - нет:
+	// событие остается в outbox и доставляется обоим повторно
+	first := &flakySink{}
+	second := &flakySink{fail: map[int64]bool{1: true}}
+	r := NewRelay(db, time.Minute, log.New(io.Discard, "", 0), first, second)
+
+	if _, err := r.Once(context.Background()); err == nil {
+		t.Fatal("Relay.Once() with failing sink = nil error")
+	}
+	delete(second.fail, 1)
+	if _, err := r.Once(context.Background()); err != nil {
+		t.Fatalf("Relay.Once() = error %v", err)
+	}
+	if len(first.got) != 2 || len(second.got) != 1 {
+		t.Fatalf("deliveries = %v, %v, want 2 and 1", first.got, second.got)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	e := storage.Event{Id: 7, Type: storage.EventDeleted, PostId: 3, Payload: json.RawMessage(`{"Id":3}`)}
+	ctx := context.Background()
+
+	t.Run("webhook", func(t *testing.T) {
+		var got storage.Event
+		status := http.StatusNoContent
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Event-Id") != "7" {
+				t.Errorf("X-Event-Id = %q, want 7", r.Header.Get("X-Event-Id"))
+			}
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		wh := NewWebhook(srv.URL, nil)
+		if err := wh.Publish(ctx, e); err != nil {
+			t.Fatalf("Webhook.Publish() = error %v", err)
+		}
+		if got.Id != e.Id || got.PostId != e.PostId || got.Type != e.Type {
+			t.Fatalf("webhook received %+v, want %+v", got, e)
+		}
+
+		status = http.StatusBadGateway
+		if err := wh.Publish(ctx, e); err == nil {
+			t.Fatal("Webhook.Publish() with 502 response = nil error")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		f, err := OpenFile(path)
+		if err != nil {
+			t.Fatalf("OpenFile() = error %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = f.Publish(ctx, e); err != nil {
+				t.Fatalf("File.Publish() = error %v", err)
+			}
+		}
+		f.Close()
+
+		r, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("os.Open() = error %v", err)
+		}
+		defer r.Close()
+		lines := 0
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			var got storage.Event
+			if err = json.Unmarshal(sc.Bytes(), &got); err != nil || got.Id != e.Id {
+				t.Fatalf("file line = %s, error %v", sc.Text(), err)
+			}
+			lines++
+		}
+		if lines != 2 {
+			t.Fatalf("file contains %d events, want 2", lines)
+		}
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		c := make(Channel, 1)
+		if err := c.Publish(ctx, e); err != nil {
+			t.Fatalf("Channel.Publish() = error %v", err)
+		}
+		if got := <-c; got.Id != e.Id {
+			t.Fatalf("channel received %+v, want %+v", got, e)
+		}
+
+		// никто не читает канал: доставка прерывается отменой
+		c = make(Channel)
+		cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := c.Publish(cctx, e); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Channel.Publish() = error %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"GoNews/pkg/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultWebhookTimeout время ожидания ответа получателя по умолчанию
+const DefaultWebhookTimeout = 10 * time.Second
+
+// Webhook получатель, которому событие отправляется
+// POST-запросом в json. Доставка подтверждается ответом 2xx
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook возвращает получателя с адресом url. Если client
+// не задан, используется клиент с DefaultWebhookTimeout
+func NewWebhook(url string, client *http.Client) *Webhook {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	return &Webhook{url: url, client: client}
+}
+
+// Publish реализует интерфейс Sink
+func (w *Webhook) Publish(ctx context.Context, e storage.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatInt(e.Id, 10))
+	req.Header.Set("X-Event-Type", string(e.Type))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// File получатель, дописывающий события в файл по одному json
+// на строку. Событие подтверждается после сброса файла на диск
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile открывает файл событий path на дозапись
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+// Publish реализует интерфейс Sink
+func (f *File) Publish(_ context.Context, e storage.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err = f.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.f.Sync()
+}
+
+// Close закрывает файл событий
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// Channel получатель внутри процесса: событие доставлено,
+// когда его принял читатель канала
+type Channel chan storage.Event
+
+// Publish реализует интерфейс Sink
+func (c Channel) Publish(ctx context.Context, e storage.Event) error {
+	select {
+	case c <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
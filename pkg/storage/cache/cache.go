@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache хранилище кешированных значений по ключу, реализации должны
+// быть безопасны для конкурентного использования. Внешний кеш
+// подключается к Store реализацией этого интерфейса
+type Cache interface {
+	Get(key string) ([]byte, bool)                   // значение по ключу
+	Set(key string, value []byte, ttl time.Duration) // сохранение значения на время ttl
+	Delete(key string)                               // удаление значения
+	Clear()                                          // удаление всех значений
+}
+
+// Stats статистика кеширования. Misses - чтения, не найденные
+// в кеше, Loads - обращения к БД: одновременные промахи
+// по одному ключу объединяются в одно обращение
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Loads  int64 `json:"loads"`
+}
+
+// Ключи кешированных значений
+const (
+	postsKey   = "posts"
+	postPrefix = "post:"
+)
+
+// Store декоратор storage.Model, кеширующий списки публикаций
+// и публикации по id. Изменение публикаций сбрасывает их записи
+// в кеше, остальные методы передаются БД без изменений
+type Store struct {
+	storage.Model
+
+	cache Cache
+	ttl   time.Duration
+	group singleflight.Group
+
+	// gen меняется при каждом сбросе кеша, чтение из БД,
+	// начатое до сброса, не оставляет в кеше устаревших данных
+	gen atomic.Int64
+
+	hits, misses, loads atomic.Int64
+}
+
+// New оборачивает БД db кешем c со временем жизни записей ttl
+func New(db storage.Model, c Cache, ttl time.Duration) *Store {
+	return &Store{Model: db, cache: c, ttl: ttl}
+}
+
+// Stats возвращает статистику кеширования
+func (s *Store) Stats() Stats {
+	return Stats{Hits: s.hits.Load(), Misses: s.misses.Load(), Loads: s.loads.Load()}
+}
+
+// Posts возвращает публикации из кеша или БД
+func (s *Store) Posts() ([]storage.Post, error) {
+	var posts []storage.Post
+	err := s.load(postsKey, &posts, func() (any, error) {
+		return s.Model.Posts()
+	})
+	return posts, err
+}
+
+// Post возвращает публикацию из кеша или БД,
+// отсутствие публикации не кешируется
+func (s *Store) Post(id int) (storage.Post, error) {
+	var post storage.Post
+	err := s.load(postKey(id), &post, func() (any, error) {
+		return s.Model.Post(id)
+	})
+	if err != nil {
+		return storage.Post{}, err
+	}
+	return post, nil
+}
+
+// PostsContext возвращает публикации с учетом контекста:
+// чтение собственных записей (storage.WithPrimary) минует кеш
+func (s *Store) PostsContext(ctx context.Context) ([]storage.Post, error) {
+	if storage.Primary(ctx) {
+		return storage.PostsContext(ctx, s.Model)
+	}
+	var posts []storage.Post
+	err := s.load(postsKey, &posts, func() (any, error) {
+		return storage.PostsContext(ctx, s.Model)
+	})
+	return posts, err
+}
+
+// PostContext возвращает публикацию с учетом контекста:
+// чтение собственных записей (storage.WithPrimary) минует кеш
+func (s *Store) PostContext(ctx context.Context, id int) (storage.Post, error) {
+	if storage.Primary(ctx) {
+		return storage.PostContext(ctx, s.Model, id)
+	}
+	var post storage.Post
+	err := s.load(postKey(id), &post, func() (any, error) {
+		return storage.PostContext(ctx, s.Model, id)
+	})
+	if err != nil {
+		return storage.Post{}, err
+	}
+	return post, nil
+}
+
+// load читает значение по ключу из кеша в v, при промахе читает
+// его из БД функцией fetch и сохраняет в кеш. Одновременные промахи
+// по ключу ждут одного чтения из БД, но промах после сброса кеша
+// не присоединяется к чтению, начатому до сброса. Значения хранятся
+// в json, поэтому каждый вызов получает свою копию
+func (s *Store) load(key string, v any, fetch func() (any, error)) error {
+	if b, ok := s.cache.Get(key); ok {
+		if json.Unmarshal(b, v) == nil {
+			s.hits.Add(1)
+			return nil
+		}
+	}
+	s.misses.Add(1)
+
+	gen := s.gen.Load()
+	b, err, _ := s.group.Do(key+"#"+strconv.FormatInt(gen, 10), func() (any, error) {
+		s.loads.Add(1)
+
+		value, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		s.cache.Set(key, b, s.ttl)
+		// кеш сброшен во время чтения: значение могло устареть
+		if s.gen.Load() != gen {
+			s.cache.Delete(key)
+		}
+		return b, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b.([]byte), v)
+}
+
+// AddPost создает публикацию и сбрасывает ее записи в кеше
+func (s *Store) AddPost(post storage.Post) error {
+	defer s.invalidate(post)
+	return s.Model.AddPost(post)
+}
+
+// UpdatePost обновляет публикацию и сбрасывает ее записи в кеше
+func (s *Store) UpdatePost(post storage.Post) error {
+	defer s.invalidate(post)
+	return s.Model.UpdatePost(post)
+}
+
+// DeletePost удаляет публикацию и сбрасывает ее записи в кеше
+func (s *Store) DeletePost(post storage.Post) error {
+	defer s.invalidate(post)
+	return s.Model.DeletePost(post)
+}
+
+// AddPosts создает публикации и сбрасывает их записи в кеше
+func (s *Store) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	defer s.invalidate(posts...)
+	return s.Model.AddPosts(posts, atomic)
+}
+
+// UpdatePosts обновляет публикации и сбрасывает их записи в кеше
+func (s *Store) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	defer s.invalidate(posts...)
+	return s.Model.UpdatePosts(posts, atomic)
+}
+
+// DeletePosts удаляет публикации и сбрасывает их записи в кеше
+func (s *Store) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	defer s.invalidate(posts...)
+	return s.Model.DeletePosts(posts, atomic)
+}
+
+// RestorePost восстанавливает публикацию и сбрасывает ее записи в кеше
+func (s *Store) RestorePost(post storage.Post) error {
+	defer s.invalidate(post)
+	return s.Model.RestorePost(post)
+}
+
+// RenameTag переименовывает тег, затронутые публикации
+// заранее неизвестны, поэтому кеш сбрасывается полностью
+func (s *Store) RenameTag(from, to string) (int, error) {
+	n, err := s.Model.RenameTag(from, to)
+	if n > 0 || err != nil {
+		s.clear()
+	}
+	return n, err
+}
+
+// PublishScheduled публикует запланированные публикации,
+// если такие были, кеш сбрасывается полностью
+func (s *Store) PublishScheduled(now int64) ([]int, error) {
+	ids, err := s.Model.PublishScheduled(now)
+	if len(ids) > 0 || err != nil {
+		s.clear()
+	}
+	return ids, err
+}
+
+// Check проверяет готовность БД, если она реализует storage.Checker,
+// и дополняет сведения о ней статистикой кеширования
+func (s *Store) Check(ctx context.Context) (storage.Health, error) {
+	var health storage.Health
+	if c, ok := s.Model.(storage.Checker); ok {
+		var err error
+		if health, err = c.Check(ctx); err != nil {
+			return health, err
+		}
+	}
+	stats := s.Stats()
+	health.Cache = map[string]int64{"hits": stats.Hits, "misses": stats.Misses, "loads": stats.Loads}
+	return health, nil
+}
+
+// invalidate сбрасывает записи публикаций и списка публикаций.
+// Вызывается и после неудачного изменения: БД могла его применить
+func (s *Store) invalidate(posts ...storage.Post) {
+	s.gen.Add(1)
+	s.cache.Delete(postsKey)
+	for _, p := range posts {
+		s.cache.Delete(postKey(p.Id))
+	}
+}
+
+// clear сбрасывает кеш полностью
+func (s *Store) clear() {
+	s.gen.Add(1)
+	s.cache.Clear()
+}
+
+// postKey ключ публикации по id
+func postKey(id int) string {
+	return postPrefix + strconv.Itoa(id)
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"GoNews/pkg/storage"
+	memDb "GoNews/pkg/storage/memdb"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapCache внешний кеш для тестов
+type mapCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *mapCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *mapCache) Set(key string, value []byte, _ time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+}
+
+func (c *mapCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+}
+
+func (c *mapCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = make(map[string][]byte)
+}
+
+// slowDb БД, чтение списка публикаций которой ждет release
+type slowDb struct {
+	storage.Model
+	started chan struct{}
+	release chan struct{}
+}
+
+func (db *slowDb) Posts() ([]storage.Post, error) {
+	db.started <- struct{}{}
+	<-db.release
+	return db.Model.Posts()
+}
+
+func TestStore(t *testing.T) {
+	c := &mapCache{values: make(map[string][]byte)}
+	s := New(memDb.New(), c, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Post(1); err != nil {
+			t.Fatalf("Store.Post() = error %v", err)
+		}
+	}
+	if got, want := s.Stats(), (Stats{Hits: 2, Misses: 1, Loads: 1}); got != want {
+		t.Fatalf("Store.Stats() = %+v, want %+v", got, want)
+	}
+	if _, ok := c.Get(postKey(1)); !ok {
+		t.Fatal("post 1 is not cached in external cache")
+	}
+	health, err := s.Check(context.Background())
+	if err != nil || health.Cache["hits"] != 2 || health.Cache["misses"] != 1 || health.Cache["loads"] != 1 {
+		t.Fatalf("Store.Check() = %+v, error %v, want cache stats", health, err)
+	}
+
+	posts, _ := s.Posts()
+	post := posts[0]
+	post.Title = "updated"
+	if err := s.UpdatePost(post); err != nil {
+		t.Fatalf("Store.UpdatePost() = error %v", err)
+	}
+	got, _ := s.Post(post.Id)
+	if got.Title != "updated" {
+		t.Fatalf("Store.Post() after update = %v, want updated title", got)
+	}
+	posts, _ = s.Posts()
+	if posts[0].Title != "updated" {
+		t.Fatalf("Store.Posts() after update = %v, want updated title", posts)
+	}
+
+	if _, err := s.Post(100); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Store.Post() = error %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, ok := c.Get(postKey(100)); ok {
+		t.Fatal("missing post is cached")
+	}
+}
+
+func TestStore_coalescing(t *testing.T) {
+	db := &slowDb{Model: memDb.New(), started: make(chan struct{}), release: make(chan struct{})}
+	s := New(db, NewLRU(10), time.Minute)
+
+	const readers = 5
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := s.Posts(); err != nil {
+				t.Errorf("Store.Posts() = error %v", err)
+			}
+		}()
+	}
+
+	<-db.started
+	// даем остальным читателям присоединиться к чтению из БД
+	for s.Stats().Misses < readers {
+		time.Sleep(time.Millisecond)
+	}
+	close(db.release)
+	wg.Wait()
+
+	if got := s.Stats(); got.Loads != 1 || got.Misses != readers {
+		t.Fatalf("Store.Stats() = %+v, want %d misses and 1 load", got, readers)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry b is not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("LRU.Get(a) = %q, %v, want 1", v, ok)
+	}
+	if c.Evicted() != 1 {
+		t.Fatalf("LRU.Evicted() = %d, want 1", c.Evicted())
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("expired entry c is returned")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("LRU.Len() = %d, want 1", c.Len())
+	}
+}
+
+func TestStore_primary(t *testing.T) {
+	c := &mapCache{values: make(map[string][]byte)}
+	db := memDb.New()
+	s := New(db, c, time.Minute)
+
+	if _, err := s.PostContext(context.Background(), 1); err != nil {
+		t.Fatalf("Store.PostContext() = error %v", err)
+	}
+	// изменение в обход кеша: кешированная публикация устарела
+	post, _ := db.Post(1)
+	post.Title = "updated"
+	if err := db.UpdatePost(post); err != nil {
+		t.Fatalf("UpdatePost() = error %v", err)
+	}
+
+	got, err := s.PostContext(storage.WithPrimary(context.Background()), 1)
+	if err != nil || got.Title != "updated" {
+		t.Fatalf("Store.PostContext(primary) = %v, %v, want updated title", got, err)
+	}
+	if got := s.Stats(); got.Hits != 0 || got.Loads != 1 {
+		t.Fatalf("Store.Stats() = %+v, want primary read to bypass cache", got)
+	}
+}
@@ -0,0 +1,989 @@
+package kv
+
+import (
+	"GoNews/pkg/storage"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Бакеты хранилища. Ключи-числа записываются в 8 байтах big-endian
+// со сдвигом знака, поэтому их порядок совпадает с порядком чисел.
+// Составные ключи индексов - конкатенация таких чисел, значения пустые
+var (
+	postsBucket     = []byte("posts")            // id -> публикация
+	authorsBucket   = []byte("authors")          // id -> имя автора
+	byAuthorBucket  = []byte("posts_by_author")  // author_id|id
+	byCreatedBucket = []byte("posts_by_created") // created_at|id
+	tagsBucket      = []byte("tags")             // тег -> бакет id публикаций с тегом
+	revisionsBucket = []byte("revisions")        // post_id|number -> версия
+	commentsBucket  = []byte("comments")         // id -> комментарий
+	byPostBucket    = []byte("comments_by_post") // post_id|id
+	keysBucket      = []byte("idempotency_keys") // ключ -> запись идемпотентности
+)
+
+// SyncPolicy определяет, когда изменения сбрасываются на диск (fsync)
+type SyncPolicy int
+
+const (
+	// SyncAlways сбрасывает изменения при фиксации каждой транзакции,
+	// подтвержденные изменения не теряются при сбое
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval сбрасывает изменения периодически, при сбое
+	// теряются изменения за последний интервал
+	SyncInterval
+
+	// SyncNever сбрасывает изменения только при закрытии БД
+	SyncNever
+)
+
+// KV реализация БД во встроенном хранилище ключ-значение
+// (B+-дереве bbolt) в одном файле для развертываний без сервера БД.
+//
+// Каждая транзакция записывает новые страницы дерева, а затем одну
+// из двух страниц метаданных с контрольной суммой. Если запись
+// метаданных прервана сбоем, при открытии используется вторая,
+// предыдущая, страница, и БД возвращается к последней целой транзакции.
+//
+// Публикации индексируются по автору и времени создания,
+// Posts возвращает публикации в порядке создания по индексу
+type KV struct {
+	db *bolt.DB
+
+	sync     SyncPolicy
+	interval time.Duration
+	stop     chan struct{} // остановка периодического сброса
+	done     chan struct{}
+}
+
+// Option необязательный параметр хранилища
+type Option func(*KV)
+
+// WithSync задает политику сброса изменений на диск,
+// interval используется для SyncInterval
+func WithSync(policy SyncPolicy, interval time.Duration) Option {
+	return func(kv *KV) {
+		kv.sync = policy
+		kv.interval = interval
+	}
+}
+
+// ParseSync разбирает политику сброса изменений на диск:
+// always, never или интервал периодического сброса, например 1s
+func ParseSync(s string) (Option, error) {
+	switch s {
+	case "always":
+		return WithSync(SyncAlways, 0), nil
+	case "never":
+		return WithSync(SyncNever, 0), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid sync policy %q: want always, never or positive interval", s)
+	}
+	return WithSync(SyncInterval, d), nil
+}
+
+// New открывает файл БД path, создавая его при необходимости,
+// и возвращает объект для взаимодействия с БД. Если файл занят
+// другим процессом, возвращается ошибка
+func New(path string, opts ...Option) (*KV, error) {
+	kv := &KV{sync: SyncAlways}
+	for _, opt := range opts {
+		opt(kv)
+	}
+	if kv.sync == SyncInterval && kv.interval <= 0 {
+		return nil, errors.New("sync interval must be positive")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: time.Second,
+		NoSync:  kv.sync != SyncAlways,
+	})
+	if err != nil {
+		return nil, err
+	}
+	kv.db = db
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{postsBucket, authorsBucket, byAuthorBucket, byCreatedBucket,
+			tagsBucket, revisionsBucket, commentsBucket, byPostBucket, keysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if kv.sync == SyncInterval {
+		kv.stop, kv.done = make(chan struct{}), make(chan struct{})
+		go kv.syncLoop()
+	}
+
+	return kv, nil
+}
+
+// syncLoop периодически сбрасывает изменения на диск
+func (kv *KV) syncLoop() {
+	defer close(kv.done)
+
+	t := time.NewTicker(kv.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			_ = kv.db.Sync()
+		case <-kv.stop:
+			return
+		}
+	}
+}
+
+// Close сбрасывает изменения на диск и закрывает БД
+func (kv *KV) Close() {
+	if kv.stop != nil {
+		close(kv.stop)
+		<-kv.done
+	}
+	if kv.sync != SyncAlways {
+		_ = kv.db.Sync()
+	}
+	kv.db.Close()
+}
+
+// Posts возвращает все публикации, кроме удаленных
+// в корзину, в порядке создания
+func (kv *KV) Posts() ([]storage.Post, error) {
+	return kv.index(byCreatedBucket, nil)
+}
+
+// PostsByAuthor возвращает публикации автора, кроме удаленных
+// в корзину, упорядоченные по id
+func (kv *KV) PostsByAuthor(authorId int) ([]storage.Post, error) {
+	return kv.index(byAuthorBucket, itob(authorId))
+}
+
+// index возвращает публикации, не удаленные в корзину, по ключам
+// индекса bucket с префиксом prefix. Id публикации - последние 8 байт ключа
+func (kv *KV) index(bucket, prefix []byte) ([]storage.Post, error) {
+	var posts []storage.Post
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			post, err := getPost(tx, btoi(k[len(k)-8:]))
+			if err != nil {
+				return err
+			}
+			if post.DeletedAt == 0 {
+				posts = append(posts, post)
+			}
+		}
+		return nil
+	})
+
+	return posts, err
+}
+
+// Post возвращает публикацию по id или
+// storage.ErrNotFound, если такой публикации нет
+func (kv *KV) Post(id int) (post storage.Post, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		post, err = getPost(tx, id)
+		if err == nil && post.DeletedAt != 0 {
+			err = storage.ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return storage.Post{}, err
+	}
+	return post, nil
+}
+
+// AddPost создает публикацию и, если передан автор без id, автора
+func (kv *KV) AddPost(post storage.Post) error {
+	_, err := kv.bulk([]storage.Post{post}, true, addPost)
+	return err
+}
+
+// UpdatePost обновляет публикацию, если она есть,
+// как и UPDATE в SQL, отсутствие публикации ошибкой не является
+func (kv *KV) UpdatePost(post storage.Post) error {
+	errs, err := kv.bulk([]storage.Post{post}, false, updatePost)
+	if err == nil && !errors.Is(errs[0], storage.ErrNotFound) {
+		err = errs[0]
+	}
+	return err
+}
+
+// DeletePost удаляет публикацию в корзину
+func (kv *KV) DeletePost(post storage.Post) error {
+	errs, err := kv.bulk([]storage.Post{post}, false, deletePost)
+	if err == nil && !errors.Is(errs[0], storage.ErrNotFound) {
+		err = errs[0]
+	}
+	return err
+}
+
+// AddPosts создает публикации пакетом
+func (kv *KV) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return kv.bulk(posts, atomic, addPost)
+}
+
+// UpdatePosts обновляет публикации пакетом
+func (kv *KV) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return kv.bulk(posts, atomic, updatePost)
+}
+
+// DeletePosts удаляет публикации пакетом в корзину
+func (kv *KV) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return kv.bulk(posts, atomic, deletePost)
+}
+
+// errAborted отменяет транзакцию пакетной операции в режиме atomic
+var errAborted = errors.New("aborted")
+
+// bulk выполняет f для каждой публикации в одной транзакции. Операции
+// f сначала проверяют публикацию и только потом изменяют БД, поэтому
+// ошибка публикации не оставляет частичных изменений. В режиме atomic
+// первая ошибка отменяет транзакцию, иначе остальные публикации
+// применяются
+func (kv *KV) bulk(posts []storage.Post, atomic bool, f func(*bolt.Tx, storage.Post) error) ([]error, error) {
+	errs := make([]error, len(posts))
+
+	err := kv.db.Update(func(tx *bolt.Tx) error {
+		for i, post := range posts {
+			errs[i] = f(tx, post)
+			if errs[i] != nil && atomic {
+				return errAborted
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errAborted) {
+		if len(posts) == 1 {
+			return errs, errs[0]
+		}
+		return errs, storage.ErrBulkAborted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// addPost, updatePost и deletePost операции над
+// одной публикацией в рамках транзакции
+func addPost(tx *bolt.Tx, post storage.Post) error {
+	if tx.Bucket(postsBucket).Get(itob(post.Id)) != nil {
+		return fmt.Errorf("%w: post %d already exists", storage.ErrConflict, post.Id)
+	}
+	if post.Author.Id != 0 && tx.Bucket(authorsBucket).Get(itob(post.Author.Id)) == nil {
+		return fmt.Errorf("%w: author %d not found", storage.ErrConflict, post.Author.Id)
+	}
+
+	// добавляем сначала автора, если передан без id
+	if post.Author.Id == 0 {
+		authors := tx.Bucket(authorsBucket)
+		seq, err := authors.NextSequence()
+		if err != nil {
+			return err
+		}
+		post.Author.Id = int(seq)
+		if err = authors.Put(itob(post.Author.Id), []byte(post.Author.Name)); err != nil {
+			return err
+		}
+	}
+
+	if err := putPost(tx, nil, post); err != nil {
+		return err
+	}
+	return addRevision(tx, post, 1)
+}
+
+func updatePost(tx *bolt.Tx, post storage.Post) error {
+	stored, err := getPost(tx, post.Id)
+	if err == nil && stored.DeletedAt != 0 {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if tx.Bucket(authorsBucket).Get(itob(post.Author.Id)) == nil {
+		return fmt.Errorf("%w: author %d not found", storage.ErrConflict, post.Author.Id)
+	}
+
+	post.DeletedAt = 0
+	if err = putPost(tx, &stored, post); err != nil {
+		return err
+	}
+
+	number := 1
+	c := tx.Bucket(revisionsBucket).Cursor()
+	prefix := itob(post.Id)
+	// последняя версия публикации предшествует первому ключу следующей
+	k, _ := c.Seek(itob(post.Id + 1))
+	if k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+	if k != nil && bytes.HasPrefix(k, prefix) {
+		number = btoi(k[8:]) + 1
+	}
+	return addRevision(tx, post, number)
+}
+
+func deletePost(tx *bolt.Tx, post storage.Post) error {
+	stored, err := getPost(tx, post.Id)
+	if err == nil && stored.DeletedAt != 0 {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	post = stored
+	post.DeletedAt = time.Now().Unix()
+	return putPost(tx, &stored, post)
+}
+
+// getPost возвращает публикацию по id, в том числе из корзины
+func getPost(tx *bolt.Tx, id int) (storage.Post, error) {
+	v := tx.Bucket(postsBucket).Get(itob(id))
+	if v == nil {
+		return storage.Post{}, storage.ErrNotFound
+	}
+
+	var post storage.Post
+	if err := json.Unmarshal(v, &post); err != nil {
+		return storage.Post{}, err
+	}
+	post.Author.Name = string(tx.Bucket(authorsBucket).Get(itob(post.Author.Id)))
+	return post, nil
+}
+
+// putPost сохраняет публикацию post и обновляет индексы. Если
+// публикация уже сохранена как stored, ее индексы заменяются
+func putPost(tx *bolt.Tx, stored *storage.Post, post storage.Post) error {
+	if stored != nil {
+		if err := unindex(tx, *stored); err != nil {
+			return err
+		}
+	}
+
+	id := itob(post.Id)
+	for bucket, k := range map[string][]byte{
+		string(byAuthorBucket):  append(itob(post.Author.Id), id...),
+		string(byCreatedBucket): append(itob64(post.CreatedAt), id...),
+	} {
+		if err := tx.Bucket([]byte(bucket)).Put(k, nil); err != nil {
+			return err
+		}
+	}
+	for _, t := range post.Tags {
+		b, err := tx.Bucket(tagsBucket).CreateBucketIfNotExists([]byte(t))
+		if err != nil {
+			return err
+		}
+		if err = b.Put(id, nil); err != nil {
+			return err
+		}
+	}
+
+	// имя автора хранится только в бакете авторов
+	post.Author.Name = ""
+	v, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(postsBucket).Put(id, v)
+}
+
+// unindex удаляет публикацию из индексов
+func unindex(tx *bolt.Tx, post storage.Post) error {
+	id := itob(post.Id)
+	if err := tx.Bucket(byAuthorBucket).Delete(append(itob(post.Author.Id), id...)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(byCreatedBucket).Delete(append(itob64(post.CreatedAt), id...)); err != nil {
+		return err
+	}
+	for _, t := range post.Tags {
+		if err := untag(tx, t, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untag удаляет публикацию id из бакета тега,
+// пустой бакет тега удаляется
+func untag(tx *bolt.Tx, tag string, id []byte) error {
+	tags := tx.Bucket(tagsBucket)
+	b := tags.Bucket([]byte(tag))
+	if b == nil {
+		return nil
+	}
+	if err := b.Delete(id); err != nil {
+		return err
+	}
+	if k, _ := b.Cursor().First(); k == nil {
+		return tags.DeleteBucket([]byte(tag))
+	}
+	return nil
+}
+
+// addRevision сохраняет версию публикации с номером number
+func addRevision(tx *bolt.Tx, post storage.Post, number int) error {
+	v, err := json.Marshal(storage.Revision{
+		PostId:    post.Id,
+		Number:    number,
+		Title:     post.Title,
+		Content:   post.Content,
+		Author:    storage.Author{Id: post.Author.Id},
+		Editor:    post.Editor,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(revisionsBucket).Put(append(itob(post.Id), itob(number)...), v)
+}
+
+// Tags возвращает теги публикаций, не удаленных в корзину
+func (kv *KV) Tags() ([]storage.Tag, error) {
+	var tags []storage.Tag
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tagsBucket).ForEach(func(name, _ []byte) error {
+			tag := storage.Tag{Name: string(name)}
+			err := tx.Bucket(tagsBucket).Bucket(name).ForEach(func(id, _ []byte) error {
+				post, err := getPost(tx, btoi(id))
+				if err == nil && post.DeletedAt == 0 && post.Published() {
+					tag.Posts++
+				}
+				return err
+			})
+			if err == nil && tag.Posts > 0 {
+				tags = append(tags, tag)
+			}
+			return err
+		})
+	})
+
+	return tags, err
+}
+
+// PostsByTags возвращает публикации, у которых есть
+// любой из тегов tags или, если all, все теги
+func (kv *KV) PostsByTags(tags []string, all bool) ([]storage.Post, error) {
+	unique := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		unique[t] = true
+	}
+	need := 1
+	if all {
+		need = len(unique)
+	}
+
+	var posts []storage.Post
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		counts := make(map[int]int)
+		for t := range unique {
+			b := tx.Bucket(tagsBucket).Bucket([]byte(t))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(id, _ []byte) error {
+				counts[btoi(id)]++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for id, n := range counts {
+			if n < need {
+				continue
+			}
+			post, err := getPost(tx, id)
+			if err != nil {
+				return err
+			}
+			if post.DeletedAt == 0 {
+				posts = append(posts, post)
+			}
+		}
+		return nil
+	})
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Id < posts[j].Id })
+
+	return posts, err
+}
+
+// RenameTag переименовывает тег from в to. Если тег to уже
+// есть, публикации с тегом from получают тег to, а тег from удаляется
+func (kv *KV) RenameTag(from, to string) (n int, err error) {
+	err = kv.db.Update(func(tx *bolt.Tx) error {
+		tags := tx.Bucket(tagsBucket)
+		src := tags.Bucket([]byte(from))
+		if src == nil {
+			return storage.ErrNotFound
+		}
+		if from == to {
+			// переименование в себя ничего не меняет,
+			// иначе индекс тега был бы удален
+			return src.ForEach(func(_, _ []byte) error {
+				n++
+				return nil
+			})
+		}
+		dst, err := tags.CreateBucketIfNotExists([]byte(to))
+		if err != nil {
+			return err
+		}
+
+		var ids []int
+		err = src.ForEach(func(id, _ []byte) error {
+			ids = append(ids, btoi(id))
+			return dst.Put(id, nil)
+		})
+		if err != nil {
+			return err
+		}
+		if err = tags.DeleteBucket([]byte(from)); err != nil {
+			return err
+		}
+
+		// теги в самих публикациях
+		posts := tx.Bucket(postsBucket)
+		for _, id := range ids {
+			post, err := getPost(tx, id)
+			if err != nil {
+				return err
+			}
+			post.Tags = renameTag(post.Tags, from, to)
+			post.Author.Name = ""
+			v, err := json.Marshal(post)
+			if err != nil {
+				return err
+			}
+			if err = posts.Put(itob(id), v); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+
+	return n, err
+}
+
+// renameTag заменяет тег from на to в упорядоченных тегах публикации
+func renameTag(tags []string, from, to string) []string {
+	renamed := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		if t == from {
+			t = to
+		}
+		if !seen[t] {
+			seen[t] = true
+			renamed = append(renamed, t)
+		}
+	}
+	sort.Strings(renamed)
+	return renamed
+}
+
+// Comments возвращает комментарии к публикации по возрастанию id
+func (kv *KV) Comments(postId int) ([]storage.Comment, error) {
+	var comments []storage.Comment
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		prefix := itob(postId)
+		c := tx.Bucket(byPostBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			comment, err := getComment(tx, btoi(k[8:]))
+			if err != nil {
+				return err
+			}
+			comments = append(comments, comment)
+		}
+		return nil
+	})
+
+	return comments, err
+}
+
+// Comment возвращает комментарий по id
+func (kv *KV) Comment(id int) (c storage.Comment, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		c, err = getComment(tx, id)
+		return err
+	})
+	return c, err
+}
+
+// getComment возвращает комментарий по id в рамках транзакции
+func getComment(tx *bolt.Tx, id int) (storage.Comment, error) {
+	v := tx.Bucket(commentsBucket).Get(itob(id))
+	if v == nil {
+		return storage.Comment{}, storage.ErrNotFound
+	}
+	var c storage.Comment
+	err := json.Unmarshal(v, &c)
+	return c, err
+}
+
+// AddComment создает комментарий к публикации и возвращает его id
+func (kv *KV) AddComment(c storage.Comment) (int, error) {
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().Unix()
+	}
+
+	err := kv.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(postsBucket).Get(itob(c.PostId)) == nil {
+			return storage.ErrNotFound
+		}
+
+		comments := tx.Bucket(commentsBucket)
+		seq, err := comments.NextSequence()
+		if err != nil {
+			return err
+		}
+		c.Id = int(seq)
+
+		if err = putComment(tx, c); err != nil {
+			return err
+		}
+		return tx.Bucket(byPostBucket).Put(append(itob(c.PostId), itob(c.Id)...), nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Id, nil
+}
+
+// SetCommentStatus изменяет состояние модерации комментария
+func (kv *KV) SetCommentStatus(id int, status storage.CommentStatus) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		c, err := getComment(tx, id)
+		if err != nil {
+			return err
+		}
+		c.Status = status
+		return putComment(tx, c)
+	})
+}
+
+// putComment сохраняет комментарий
+func putComment(tx *bolt.Tx, c storage.Comment) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(commentsBucket).Put(itob(c.Id), v)
+}
+
+// Revisions возвращает историю версий публикации
+func (kv *KV) Revisions(postId int) ([]storage.Revision, error) {
+	var revs []storage.Revision
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		prefix := itob(postId)
+		c := tx.Bucket(revisionsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			rev, err := decodeRevision(tx, v)
+			if err != nil {
+				return err
+			}
+			revs = append(revs, rev)
+		}
+		return nil
+	})
+
+	return revs, err
+}
+
+// Revision возвращает версию публикации по номеру
+func (kv *KV) Revision(postId, number int) (rev storage.Revision, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(revisionsBucket).Get(append(itob(postId), itob(number)...))
+		if v == nil {
+			return storage.ErrNotFound
+		}
+		rev, err = decodeRevision(tx, v)
+		return err
+	})
+	return rev, err
+}
+
+// decodeRevision читает версию и имя ее автора
+func decodeRevision(tx *bolt.Tx, v []byte) (storage.Revision, error) {
+	var rev storage.Revision
+	if err := json.Unmarshal(v, &rev); err != nil {
+		return storage.Revision{}, err
+	}
+	rev.Author.Name = string(tx.Bucket(authorsBucket).Get(itob(rev.Author.Id)))
+	return rev, nil
+}
+
+// Trash возвращает публикации в корзине, начиная с последних удаленных
+func (kv *KV) Trash() ([]storage.Post, error) {
+	var posts []storage.Post
+
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(postsBucket).ForEach(func(k, _ []byte) error {
+			post, err := getPost(tx, btoi(k))
+			if err == nil && post.DeletedAt > 0 {
+				posts = append(posts, post)
+			}
+			return err
+		})
+	})
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].DeletedAt > posts[j].DeletedAt })
+
+	return posts, err
+}
+
+// TrashedPost возвращает публикацию из корзины по id
+func (kv *KV) TrashedPost(id int) (post storage.Post, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		post, err = getPost(tx, id)
+		if err == nil && post.DeletedAt == 0 {
+			err = storage.ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return storage.Post{}, err
+	}
+	return post, nil
+}
+
+// RestorePost восстанавливает публикацию из корзины
+func (kv *KV) RestorePost(post storage.Post) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getPost(tx, post.Id)
+		if err == nil && stored.DeletedAt == 0 {
+			err = storage.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		post = stored
+		post.DeletedAt = 0
+		return putPost(tx, &stored, post)
+	})
+}
+
+// PurgePosts окончательно удаляет публикации, помещенные в корзину
+// раньше момента before, вместе с их версиями и комментариями
+func (kv *KV) PurgePosts(before int64) (n int, err error) {
+	err = kv.db.Update(func(tx *bolt.Tx) error {
+		var purged []storage.Post
+		err := tx.Bucket(postsBucket).ForEach(func(k, _ []byte) error {
+			post, err := getPost(tx, btoi(k))
+			if err == nil && post.DeletedAt > 0 && post.DeletedAt < before {
+				purged = append(purged, post)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, post := range purged {
+			if err = unindex(tx, post); err != nil {
+				return err
+			}
+			if err = tx.Bucket(postsBucket).Delete(itob(post.Id)); err != nil {
+				return err
+			}
+			if err = deletePrefix(tx.Bucket(revisionsBucket), itob(post.Id)); err != nil {
+				return err
+			}
+			comments := tx.Bucket(commentsBucket)
+			err = deletePrefix(tx.Bucket(byPostBucket), itob(post.Id), func(k []byte) error {
+				return comments.Delete(k[8:])
+			})
+			if err != nil {
+				return err
+			}
+		}
+		n = len(purged)
+		return nil
+	})
+
+	return n, err
+}
+
+// deletePrefix удаляет ключи бакета с префиксом prefix,
+// перед удалением ключа вызывается each
+func deletePrefix(b *bolt.Bucket, prefix []byte, each ...func(k []byte) error) error {
+	var keys [][]byte
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		for _, f := range each {
+			if err := f(k); err != nil {
+				return err
+			}
+		}
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishScheduled публикует запланированные публикации,
+// время публикации которых наступило
+func (kv *KV) PublishScheduled(now int64) (ids []int, err error) {
+	err = kv.db.Update(func(tx *bolt.Tx) error {
+		var due []storage.Post
+		err := tx.Bucket(postsBucket).ForEach(func(k, _ []byte) error {
+			post, err := getPost(tx, btoi(k))
+			if err == nil && post.Status == storage.StatusScheduled &&
+				post.PublishAt <= now && post.DeletedAt == 0 {
+				due = append(due, post)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, stored := range due {
+			post := stored
+			post.Status = storage.StatusPublished
+			if err = putPost(tx, &stored, post); err != nil {
+				return err
+			}
+			ids = append(ids, post.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// IdempotencyRecord возвращает действующую запись идемпотентности по ключу
+func (kv *KV) IdempotencyRecord(key string) (rec storage.IdempotencyRecord, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		rec, err = getRecord(tx, key)
+		return err
+	})
+	return rec, err
+}
+
+// getRecord возвращает действующую запись идемпотентности в рамках транзакции
+func getRecord(tx *bolt.Tx, key string) (storage.IdempotencyRecord, error) {
+	v := tx.Bucket(keysBucket).Get([]byte(key))
+	if v == nil {
+		return storage.IdempotencyRecord{}, storage.ErrNotFound
+	}
+	var rec storage.IdempotencyRecord
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return storage.IdempotencyRecord{}, err
+	}
+	if !rec.ExpiresAt.After(time.Now()) {
+		return storage.IdempotencyRecord{}, storage.ErrNotFound
+	}
+	return rec, nil
+}
+
+// AddIdempotencyRecord резервирует ключ идемпотентности. Запись
+// с истекшим сроком заменяется, остальные просроченные записи удаляются
+func (kv *KV) AddIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+
+		var expired [][]byte
+		err := keys.ForEach(func(k, _ []byte) error {
+			_, err := getRecord(tx, string(k))
+			if errors.Is(err, storage.ErrNotFound) {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err = keys.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		if keys.Get([]byte(rec.Key)) != nil {
+			return storage.ErrConflict
+		}
+		return putRecord(tx, rec)
+	})
+}
+
+// UpdateIdempotencyRecord сохраняет ответ на запрос по ключу
+func (kv *KV) UpdateIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(keysBucket).Get([]byte(rec.Key)) == nil {
+			return storage.ErrNotFound
+		}
+		return putRecord(tx, rec)
+	})
+}
+
+// putRecord сохраняет запись идемпотентности
+func putRecord(tx *bolt.Tx, rec storage.IdempotencyRecord) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(keysBucket).Put([]byte(rec.Key), v)
+}
+
+// DeleteIdempotencyRecord освобождает ключ идемпотентности
+func (kv *KV) DeleteIdempotencyRecord(key string) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).Delete([]byte(key))
+	})
+}
+
+// itob и itob64 кодируют число в ключ, btoi - обратно
+func itob(v int) []byte {
+	return itob64(int64(v))
+}
+
+func itob64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v)^1<<63)
+	return b
+}
+
+func btoi(b []byte) int {
+	return int(int64(binary.BigEndian.Uint64(b) ^ 1<<63))
+}
@@ -0,0 +1,564 @@
+package kv
+
+import (
+	"GoNews/pkg/storage"
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var db *KV
+
+const (
+	postsNum = 2
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gonews-kv")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err = New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+
+	// авторы получают id 1 и 2
+	seed := []storage.Post{
+		{Id: 1, Author: storage.Author{Name: "Иван Иванов"}, Title: "KV Публикация номер 1",
+			Content: "Lorem ipsum 1", CreatedAt: 1652355804},
+		{Id: 2, Author: storage.Author{Name: "Петр Петров"}, Title: "KV Публикация номер 2",
+			Content: "Lorem ipsum 2", CreatedAt: 1652355830},
+	}
+	for _, p := range seed {
+		if err = db.AddPost(p); err != nil {
+			db.Close()
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+	}
+
+	exitCode := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+
+	os.Exit(exitCode)
+}
+
+func TestKV_Posts(t *testing.T) {
+	posts, err := db.Posts()
+	if err != nil {
+		t.Fatalf("kv.Posts() = error %v\n", err)
+	}
+
+	if len(posts) != postsNum {
+		t.Fatalf("kv.Posts() = %d posts in total, want %d\n", len(posts), postsNum)
+	}
+}
+
+func TestKV_Post(t *testing.T) {
+	post, err := db.Post(2)
+	if err != nil {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+	if post.Id != 2 || post.Author.Id != 2 {
+		t.Fatalf("kv.Post() = %v, want post 2 of author 2\n", post)
+	}
+
+	_, err = db.Post(100)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_AddPost(t *testing.T) {
+	newpost := storage.Post{
+		Id:        3,
+		Author:    storage.Author{Id: 1, Name: "Иван Иванов"},
+		Title:     "Test title1",
+		Content:   "Test content1",
+		CreatedAt: 0,
+	}
+	err := db.AddPost(newpost)
+	if err != nil {
+		t.Fatalf("kv.AddPost() = error %v\n", err)
+	}
+
+	post, err := db.Post(newpost.Id)
+	if err != nil {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+
+	if !reflect.DeepEqual(post, newpost) {
+		t.Fatalf("kv.AddPost() = %v, want %v\n", post, newpost)
+	}
+}
+
+func TestKV_UpdatePost(t *testing.T) {
+	newpost := storage.Post{
+		Id:        1,
+		Author:    storage.Author{Id: 2, Name: "Петр Петров"},
+		Title:     "Updated title",
+		Content:   "Updated content",
+		CreatedAt: 0,
+	}
+	err := db.UpdatePost(newpost)
+	if err != nil {
+		t.Fatalf("kv.UpdatePost() = error %v\n", err)
+	}
+
+	post, err := db.Post(newpost.Id)
+	if err != nil {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+
+	if !reflect.DeepEqual(post, newpost) {
+		t.Fatalf("kv.UpdatePost() = %v, want %v\n", post, newpost)
+	}
+}
+
+func TestKV_DeletePost(t *testing.T) {
+	p := storage.Post{Id: 1}
+	err := db.DeletePost(p)
+	if err != nil {
+		t.Fatalf("kv.DeletePost() = error %v\n", err)
+	}
+
+	post, err := db.Post(p.Id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(post, storage.Post{}) {
+		t.Fatalf("kv.DeletePost() = %v, want nothing\n", post)
+	}
+}
+
+func TestKV_AddPosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Bulk title 10", Content: "Bulk content"},
+		{Id: 2, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err := db.AddPosts(posts, false)
+	if err != nil {
+		t.Fatalf("kv.AddPosts() = error %v\n", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("kv.AddPosts() = %v, want [nil %v]\n", errs, storage.ErrConflict)
+	}
+
+	posts = []storage.Post{
+		{Id: 11, Author: storage.Author{Name: "Bulk Author"}, Title: "Bulk title 11", Content: "Bulk content"},
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err = db.AddPosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("kv.AddPosts(atomic) = %v, error %v, want %v for post 10\n",
+			errs, err, storage.ErrConflict)
+	}
+	if _, err = db.Post(11); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_UpdatePosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 2}, Title: "Bulk updated", Content: "Bulk content"},
+		{Id: 404, Author: storage.Author{Id: 2}, Title: "Missing", Content: "Bulk content"},
+	}
+	errs, err := db.UpdatePosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("kv.UpdatePosts(atomic) = %v, error %v, want %v for post 404\n",
+			errs, err, storage.ErrNotFound)
+	}
+	post, err := db.Post(10)
+	if err != nil {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+	if post.Title != "Bulk title 10" {
+		t.Fatalf("kv.UpdatePosts(atomic) applied aborted update: %v\n", post)
+	}
+
+	errs, err = db.UpdatePosts(posts, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("kv.UpdatePosts() = %v, error %v\n", errs, err)
+	}
+}
+
+func TestKV_DeletePosts(t *testing.T) {
+	errs, err := db.DeletePosts([]storage.Post{{Id: 10}, {Id: 404}}, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("kv.DeletePosts() = %v, error %v\n", errs, err)
+	}
+	if _, err = db.Post(10); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_IdempotencyRecord(t *testing.T) {
+	rec := storage.IdempotencyRecord{
+		Key:         "test:key-1",
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour).Truncate(time.Microsecond),
+	}
+	err := db.AddIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("kv.AddIdempotencyRecord() = error %v\n", err)
+	}
+	err = db.AddIdempotencyRecord(rec)
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("kv.AddIdempotencyRecord() = error %v, want %v\n", err, storage.ErrConflict)
+	}
+
+	rec.Status = 201
+	rec.Header = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Body = []byte(`{"data": 1}`)
+	err = db.UpdateIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("kv.UpdateIdempotencyRecord() = error %v\n", err)
+	}
+
+	got, err := db.IdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("kv.IdempotencyRecord() = error %v\n", err)
+	}
+	if got.Status != rec.Status || string(got.Body) != string(rec.Body) ||
+		got.Header["Content-Type"][0] != "application/json" || !got.ExpiresAt.Equal(rec.ExpiresAt) {
+		t.Fatalf("kv.IdempotencyRecord() = %v, want %v\n", got, rec)
+	}
+
+	err = db.DeleteIdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("kv.DeleteIdempotencyRecord() = error %v\n", err)
+	}
+	_, err = db.IdempotencyRecord(rec.Key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.IdempotencyRecord() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_Trash(t *testing.T) {
+	// публикация 1 удалена в корзину в TestKV_DeletePost
+	post, err := db.TrashedPost(1)
+	if err != nil {
+		t.Fatalf("kv.TrashedPost() = error %v\n", err)
+	}
+	if post.DeletedAt == 0 {
+		t.Fatalf("kv.TrashedPost() = %v, want deletion time\n", post)
+	}
+
+	err = db.RestorePost(storage.Post{Id: 1})
+	if err != nil {
+		t.Fatalf("kv.RestorePost() = error %v\n", err)
+	}
+	if _, err = db.Post(1); err != nil {
+		t.Fatalf("kv.Post() of restored post = error %v\n", err)
+	}
+	err = db.RestorePost(storage.Post{Id: 1})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.RestorePost() of live post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	trash, err := db.Trash()
+	if err != nil {
+		t.Fatalf("kv.Trash() = error %v\n", err)
+	}
+	n, err := db.PurgePosts(time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("kv.PurgePosts() = error %v\n", err)
+	}
+	if n != len(trash) {
+		t.Fatalf("kv.PurgePosts() = %d posts, want %d\n", n, len(trash))
+	}
+}
+
+func TestKV_Revisions(t *testing.T) {
+	post := storage.Post{Id: 50, Title: "v1", Content: "Lorem ipsum", Author: storage.Author{Id: 1}, Editor: "ivan"}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("kv.AddPost() = error %v\n", err)
+	}
+	post.Title, post.Editor = "v2", "olga"
+	if err := db.UpdatePost(post); err != nil {
+		t.Fatalf("kv.UpdatePost() = error %v\n", err)
+	}
+
+	revs, err := db.Revisions(50)
+	if err != nil {
+		t.Fatalf("kv.Revisions() = error %v\n", err)
+	}
+	if len(revs) != 2 || revs[0].Title != "v1" || revs[1].Title != "v2" || revs[1].Editor != "olga" {
+		t.Fatalf("kv.Revisions() = %v, want v1 by ivan and v2 by olga\n", revs)
+	}
+
+	rev, err := db.Revision(50, 1)
+	if err != nil {
+		t.Fatalf("kv.Revision() = error %v\n", err)
+	}
+	if rev.Number != 1 || rev.Editor != "ivan" || rev.Author.Id != 1 {
+		t.Fatalf("kv.Revision() = %v, want first revision\n", rev)
+	}
+	_, err = db.Revision(50, 3)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Revision() of missing revision = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_PublishScheduled(t *testing.T) {
+	publishAt := time.Now().Add(time.Hour).Unix()
+	post := storage.Post{Id: 60, Title: "scheduled", Author: storage.Author{Id: 1},
+		Status: storage.StatusScheduled, PublishAt: publishAt}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("kv.AddPost() = error %v\n", err)
+	}
+
+	ids, err := db.PublishScheduled(publishAt)
+	if err != nil {
+		t.Fatalf("kv.PublishScheduled() = error %v\n", err)
+	}
+	if len(ids) != 1 || ids[0] != 60 {
+		t.Fatalf("kv.PublishScheduled() = %v, want [60]\n", ids)
+	}
+
+	got, err := db.Post(60)
+	if err != nil {
+		t.Fatalf("kv.Post() = error %v\n", err)
+	}
+	if got.Status != storage.StatusPublished {
+		t.Fatalf("kv.Post() status = %q, want %q\n", got.Status, storage.StatusPublished)
+	}
+}
+
+func TestKV_Tags(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 70, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go", "news"}},
+		{Id: 71, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go"}},
+		{Id: 72, Title: "draft", Author: storage.Author{Id: 1}, Tags: []string{"draft"}, Status: storage.StatusDraft},
+	}
+	if _, err := db.AddPosts(posts, true); err != nil {
+		t.Fatalf("kv.AddPosts() = error %v\n", err)
+	}
+
+	got, err := db.PostsByTags([]string{"go", "news"}, true)
+	if err != nil {
+		t.Fatalf("kv.PostsByTags() = error %v\n", err)
+	}
+	if len(got) != 1 || got[0].Id != 70 || !reflect.DeepEqual(got[0].Tags, []string{"go", "news"}) {
+		t.Fatalf("kv.PostsByTags(all) = %v, want post 70\n", got)
+	}
+
+	n, err := db.RenameTag("go", "go")
+	if err != nil {
+		t.Fatalf("kv.RenameTag() to itself = error %v\n", err)
+	}
+	if n != 2 {
+		t.Fatalf("kv.RenameTag() to itself = %d posts, want 2\n", n)
+	}
+	got, err = db.PostsByTags([]string{"go"}, false)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("kv.PostsByTags() after rename to itself = %v, error %v, want 2 posts\n", got, err)
+	}
+
+	n, err = db.RenameTag("news", "go")
+	if err != nil {
+		t.Fatalf("kv.RenameTag() = error %v\n", err)
+	}
+	if n != 1 {
+		t.Fatalf("kv.RenameTag() = %d posts, want 1\n", n)
+	}
+	_, err = db.RenameTag("news", "go")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.RenameTag() of missing tag = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	tags, err := db.Tags()
+	if err != nil {
+		t.Fatalf("kv.Tags() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(tags, []storage.Tag{{Name: "go", Posts: 2}}) {
+		t.Fatalf("kv.Tags() = %v, want go with 2 posts\n", tags)
+	}
+}
+
+func TestKV_Comments(t *testing.T) {
+	post := storage.Post{Id: 80, Title: "commented", Author: storage.Author{Id: 1}}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("kv.AddPost() = error %v\n", err)
+	}
+
+	_, err := db.AddComment(storage.Comment{PostId: 81, Text: "lost"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.AddComment() to missing post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	id, err := db.AddComment(storage.Comment{PostId: 80, Author: "reader", Text: "first", Status: storage.CommentPending})
+	if err != nil {
+		t.Fatalf("kv.AddComment() = error %v\n", err)
+	}
+	reply, err := db.AddComment(storage.Comment{PostId: 80, ParentId: id, Author: "editor", Text: "reply",
+		Status: storage.CommentApproved})
+	if err != nil {
+		t.Fatalf("kv.AddComment() = error %v\n", err)
+	}
+
+	if err = db.SetCommentStatus(id, storage.CommentApproved); err != nil {
+		t.Fatalf("kv.SetCommentStatus() = error %v\n", err)
+	}
+	err = db.SetCommentStatus(reply+100, storage.CommentApproved)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.SetCommentStatus() of missing comment = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	comments, err := db.Comments(80)
+	if err != nil {
+		t.Fatalf("kv.Comments() = error %v\n", err)
+	}
+	if len(comments) != 2 || comments[0].Id != id || comments[1].ParentId != id ||
+		comments[0].Status != storage.CommentApproved || comments[0].CreatedAt == 0 {
+		t.Fatalf("kv.Comments() = %v, want approved comment %d with reply\n", comments, id)
+	}
+
+	if err = db.DeletePost(post); err != nil {
+		t.Fatalf("kv.DeletePost() = error %v\n", err)
+	}
+	if _, err = db.PurgePosts(time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("kv.PurgePosts() = error %v\n", err)
+	}
+	_, err = db.Comment(reply)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Comment() of purged post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_indexes(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatalf("kv.New() = error %v\n", err)
+	}
+	defer s.Close()
+
+	posts := []storage.Post{
+		{Id: 1, Author: storage.Author{Name: "first"}, Title: "late", CreatedAt: 300},
+		{Id: 2, Author: storage.Author{Name: "second"}, Title: "early", CreatedAt: 100},
+		{Id: 3, Author: storage.Author{Id: 1}, Title: "middle", CreatedAt: 200},
+	}
+	if _, err = s.AddPosts(posts, true); err != nil {
+		t.Fatalf("kv.AddPosts() = error %v\n", err)
+	}
+	// смена автора и времени создания переносит публикацию в индексах
+	posts[2].Author.Id, posts[2].CreatedAt = 2, 50
+	if err = s.UpdatePost(posts[2]); err != nil {
+		t.Fatalf("kv.UpdatePost() = error %v\n", err)
+	}
+
+	ids := func(posts []storage.Post, err error) []int {
+		if err != nil {
+			t.Fatalf("kv = error %v\n", err)
+		}
+		var ids []int
+		for _, p := range posts {
+			ids = append(ids, p.Id)
+		}
+		return ids
+	}
+	if got := ids(s.Posts()); !reflect.DeepEqual(got, []int{3, 2, 1}) {
+		t.Errorf("kv.Posts() = %v, want [3 2 1] by creation time\n", got)
+	}
+	if got := ids(s.PostsByAuthor(1)); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("kv.PostsByAuthor(1) = %v, want [1]\n", got)
+	}
+	if got := ids(s.PostsByAuthor(2)); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("kv.PostsByAuthor(2) = %v, want [2 3]\n", got)
+	}
+}
+
+func TestKV_recovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recovery.db")
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("kv.New() = error %v\n", err)
+	}
+	for _, id := range []int{1, 2} {
+		if err = s.AddPost(storage.Post{Id: id, Author: storage.Author{Name: "author"}}); err != nil {
+			t.Fatalf("kv.AddPost() = error %v\n", err)
+		}
+	}
+	s.Close()
+
+	// имитируем прерванную запись метаданных последней транзакции:
+	// портим страницу метаданных с большим номером транзакции
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := int64(os.Getpagesize())
+	txid := func(page int64) uint64 {
+		b := make([]byte, 8)
+		// заголовок страницы 16 байт, номер транзакции - 48 байт от начала метаданных
+		if _, err := f.ReadAt(b, page*pageSize+64); err != nil {
+			t.Fatal(err)
+		}
+		return binary.LittleEndian.Uint64(b)
+	}
+	latest := int64(0)
+	if txid(1) > txid(0) {
+		latest = 1
+	}
+	if _, err = f.WriteAt([]byte("torn write"), latest*pageSize+20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err = New(path)
+	if err != nil {
+		t.Fatalf("kv.New() after torn write = error %v\n", err)
+	}
+	defer s.Close()
+
+	if _, err = s.Post(1); err != nil {
+		t.Fatalf("kv.Post() of committed post = error %v\n", err)
+	}
+	if _, err = s.Post(2); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("kv.Post() of torn post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestKV_sync(t *testing.T) {
+	for _, policy := range []string{"always", "never", "10ms"} {
+		opt, err := ParseSync(policy)
+		if err != nil {
+			t.Fatalf("kv.ParseSync(%q) = error %v\n", policy, err)
+		}
+
+		path := filepath.Join(t.TempDir(), "sync.db")
+		s, err := New(path, opt)
+		if err != nil {
+			t.Fatalf("kv.New() = error %v\n", err)
+		}
+		if err = s.AddPost(storage.Post{Id: 1, Author: storage.Author{Name: "author"}}); err != nil {
+			t.Fatalf("kv.AddPost() = error %v\n", err)
+		}
+		s.Close()
+
+		s, err = New(path)
+		if err != nil {
+			t.Fatalf("kv.New() = error %v\n", err)
+		}
+		_, err = s.Post(1)
+		s.Close()
+		if err != nil {
+			t.Fatalf("kv.Post() after reopen with sync %s = error %v\n", policy, err)
+		}
+	}
+
+	if _, err := ParseSync("sometimes"); err == nil {
+		t.Fatal("kv.ParseSync(sometimes) = nil error, want error")
+	}
+}
@@ -1,6 +1,11 @@
 package memDb
 
-import "GoNews/pkg/storage"
+import (
+	"GoNews/pkg/storage"
+	"sort"
+	"sync"
+	"time"
+)
 
 var FakeData = []storage.Post{
 	{Id: 1, Title: "mem db post 1", Content: "Lorem ipsum"},
@@ -9,27 +14,435 @@ var FakeData = []storage.Post{
 
 var FakePost = storage.Post{Id: 99, Title: "mem db post 99", Content: "Lorem ipsum"}
 
-// MemDb заглушка для реализации БД в памяти
-type MemDb struct{}
+// MemDb реализация БД в памяти, при создании
+// заполняется тестовыми данными FakeData
+type MemDb struct {
+	mu        sync.RWMutex
+	posts     map[int]storage.Post
+	revisions map[int][]storage.Revision
+	comments  map[int]storage.Comment
+	commentId int // последний выданный id комментария
+	keys      map[string]storage.IdempotencyRecord
+}
 
 func New() *MemDb {
-	return &MemDb{}
+	db := MemDb{
+		posts:     make(map[int]storage.Post, len(FakeData)),
+		revisions: make(map[int][]storage.Revision, len(FakeData)),
+		comments:  make(map[int]storage.Comment),
+		keys:      make(map[string]storage.IdempotencyRecord),
+	}
+	for _, p := range FakeData {
+		db.posts[p.Id] = p
+		db.addRevision(p)
+	}
+	return &db
 }
 
+// Posts возвращает все публикации, кроме удаленных
+// в корзину, упорядоченные по id
 func (db *MemDb) Posts() ([]storage.Post, error) {
-	return FakeData, nil
+	return db.filter(func(p storage.Post) bool { return p.DeletedAt == 0 }), nil
+}
+
+// filter возвращает упорядоченные по id публикации, для которых ok истинно
+func (db *MemDb) filter(ok func(storage.Post) bool) []storage.Post {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	posts := make([]storage.Post, 0, len(db.posts))
+	for _, p := range db.posts {
+		if ok(p) {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Id < posts[j].Id })
+
+	return posts
+}
+
+func (db *MemDb) Post(id int) (storage.Post, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	p, ok := db.posts[id]
+	if !ok || p.DeletedAt != 0 {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return p, nil
+}
+
+// AddPost создает публикацию, как и первичный ключ в SQL,
+// не допускает повторного использования id
+func (db *MemDb) AddPost(p storage.Post) error {
+	_, err := db.bulk([]storage.Post{p}, true, db.add)
+	return err
+}
+
+// UpdatePost обновляет публикацию, если она есть,
+// как и UPDATE в SQL, отсутствие публикации ошибкой не является
+func (db *MemDb) UpdatePost(p storage.Post) error {
+	_, err := db.bulk([]storage.Post{p}, false, db.update)
+	return err
+}
+
+func (db *MemDb) DeletePost(p storage.Post) error {
+	_, err := db.bulk([]storage.Post{p}, false, db.delete)
+	return err
+}
+
+func (db *MemDb) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return db.bulk(posts, atomic, db.add)
+}
+
+func (db *MemDb) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return db.bulk(posts, atomic, db.update)
+}
+
+func (db *MemDb) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return db.bulk(posts, atomic, db.delete)
+}
+
+// add, update и delete операции над одной публикацией,
+// выполняются под блокировкой
+func (db *MemDb) add(p storage.Post) error {
+	if _, ok := db.posts[p.Id]; ok {
+		return storage.ErrConflict
+	}
+	db.posts[p.Id] = p
+	db.addRevision(p)
+	return nil
+}
+
+func (db *MemDb) update(p storage.Post) error {
+	stored, ok := db.posts[p.Id]
+	if !ok || stored.DeletedAt != 0 {
+		return storage.ErrNotFound
+	}
+	db.posts[p.Id] = p
+	db.addRevision(p)
+	return nil
+}
+
+func (db *MemDb) delete(p storage.Post) error {
+	stored, ok := db.posts[p.Id]
+	if !ok || stored.DeletedAt != 0 {
+		return storage.ErrNotFound
+	}
+	stored.DeletedAt = time.Now().Unix()
+	db.posts[p.Id] = stored
+	return nil
+}
+
+// addRevision сохраняет публикацию как ее новую версию
+func (db *MemDb) addRevision(p storage.Post) {
+	revs := db.revisions[p.Id]
+	db.revisions[p.Id] = append(revs[:len(revs):len(revs)], storage.Revision{
+		PostId:    p.Id,
+		Number:    len(revs) + 1,
+		Title:     p.Title,
+		Content:   p.Content,
+		Author:    p.Author,
+		Editor:    p.Editor,
+		CreatedAt: time.Now().Unix(),
+	})
+}
+
+// bulk применяет op к каждой публикации пакета. В режиме atomic
+// изменения применяются к копии данных, которая заменяет
+// исходные только если все операции прошли успешно
+func (db *MemDb) bulk(posts []storage.Post, atomic bool, op func(storage.Post) error) ([]error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	orig, origRevs := db.posts, db.revisions
+	if atomic {
+		db.posts = make(map[int]storage.Post, len(orig))
+		for id, p := range orig {
+			db.posts[id] = p
+		}
+		db.revisions = make(map[int][]storage.Revision, len(origRevs))
+		for id, revs := range origRevs {
+			db.revisions[id] = revs
+		}
+	}
+
+	errs := make([]error, len(posts))
+	for i, p := range posts {
+		errs[i] = op(p)
+		if errs[i] != nil && atomic {
+			db.posts, db.revisions = orig, origRevs
+			if len(posts) == 1 {
+				return errs, errs[0]
+			}
+			return errs, storage.ErrBulkAborted
+		}
+	}
+	return errs, nil
+}
+
+// Tags возвращает теги публикаций, не удаленных в корзину
+func (db *MemDb) Tags() ([]storage.Tag, error) {
+	counts := make(map[string]int)
+	for _, p := range db.filter(func(p storage.Post) bool { return p.DeletedAt == 0 && p.Published() }) {
+		for _, t := range p.Tags {
+			counts[t]++
+		}
+	}
+
+	tags := make([]storage.Tag, 0, len(counts))
+	for name, n := range counts {
+		tags = append(tags, storage.Tag{Name: name, Posts: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// PostsByTags возвращает публикации, у которых есть
+// любой из тегов tags или, если all, все теги
+func (db *MemDb) PostsByTags(tags []string, all bool) ([]storage.Post, error) {
+	return db.filter(func(p storage.Post) bool {
+		if p.DeletedAt != 0 {
+			return false
+		}
+		n := 0
+		for _, t := range tags {
+			if hasTag(p, t) {
+				n++
+			}
+		}
+		return n > 0 && (!all || n == len(tags))
+	}), nil
+}
+
+// RenameTag переименовывает тег from в to во всех публикациях
+func (db *MemDb) RenameTag(from, to string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := 0
+	for id, p := range db.posts {
+		if !hasTag(p, from) {
+			continue
+		}
+		tags := make([]string, 0, len(p.Tags))
+		for _, t := range p.Tags {
+			if t != from && t != to {
+				tags = append(tags, t)
+			}
+		}
+		p.Tags = append(tags, to)
+		sort.Strings(p.Tags)
+		db.posts[id] = p
+		n++
+	}
+	if n == 0 {
+		return 0, storage.ErrNotFound
+	}
+	return n, nil
 }
 
-func (db *MemDb) AddPost(_ storage.Post) error {
+func hasTag(p storage.Post, tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *MemDb) Comments(postId int) ([]storage.Comment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	comments := make([]storage.Comment, 0)
+	for _, c := range db.comments {
+		if c.PostId == postId {
+			comments = append(comments, c)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Id < comments[j].Id })
+	return comments, nil
+}
+
+func (db *MemDb) Comment(id int) (storage.Comment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	c, ok := db.comments[id]
+	if !ok {
+		return storage.Comment{}, storage.ErrNotFound
+	}
+	return c, nil
+}
+
+// AddComment создает комментарий к существующей публикации
+func (db *MemDb) AddComment(c storage.Comment) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// публикация в корзине не комментируется
+	if p, ok := db.posts[c.PostId]; !ok || p.DeletedAt != 0 {
+		return 0, storage.ErrNotFound
+	}
+	db.commentId++
+	c.Id = db.commentId
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().Unix()
+	}
+	db.comments[c.Id] = c
+	return c.Id, nil
+}
+
+func (db *MemDb) SetCommentStatus(id int, status storage.CommentStatus) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c, ok := db.comments[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	c.Status = status
+	db.comments[id] = c
 	return nil
 }
 
-func (db *MemDb) UpdatePost(_ storage.Post) error {
+func (db *MemDb) Revisions(postId int) ([]storage.Revision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	revs := db.revisions[postId]
+	return append(make([]storage.Revision, 0, len(revs)), revs...), nil
+}
+
+func (db *MemDb) Revision(postId, number int) (storage.Revision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	revs := db.revisions[postId]
+	if number < 1 || number > len(revs) {
+		return storage.Revision{}, storage.ErrNotFound
+	}
+	return revs[number-1], nil
+}
+
+// Trash возвращает публикации в корзине, начиная с последних удаленных
+func (db *MemDb) Trash() ([]storage.Post, error) {
+	posts := db.filter(func(p storage.Post) bool { return p.DeletedAt != 0 })
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].DeletedAt > posts[j].DeletedAt })
+	return posts, nil
+}
+
+func (db *MemDb) TrashedPost(id int) (storage.Post, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	p, ok := db.posts[id]
+	if !ok || p.DeletedAt == 0 {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return p, nil
+}
+
+func (db *MemDb) RestorePost(p storage.Post) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.posts[p.Id]
+	if !ok || stored.DeletedAt == 0 {
+		return storage.ErrNotFound
+	}
+	stored.DeletedAt = 0
+	db.posts[p.Id] = stored
 	return nil
 }
 
-func (db *MemDb) DeletePost(_ storage.Post) error {
+func (db *MemDb) PurgePosts(before int64) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := 0
+	for id, p := range db.posts {
+		if p.DeletedAt != 0 && p.DeletedAt < before {
+			delete(db.posts, id)
+			delete(db.revisions, id)
+			for cid, c := range db.comments {
+				if c.PostId == id {
+					delete(db.comments, cid)
+				}
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// PublishScheduled публикует запланированные публикации, время
+// публикации которых наступило. Публикации в корзине не затрагиваются
+func (db *MemDb) PublishScheduled(now int64) ([]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var ids []int
+	for id, p := range db.posts {
+		if p.Status == storage.StatusScheduled && p.PublishAt <= now && p.DeletedAt == 0 {
+			p.Status = storage.StatusPublished
+			db.posts[id] = p
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (db *MemDb) IdempotencyRecord(key string) (storage.IdempotencyRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rec, ok := db.keys[key]
+	if !ok || !rec.ExpiresAt.After(time.Now()) {
+		return storage.IdempotencyRecord{}, storage.ErrNotFound
+	}
+	return rec, nil
+}
+
+// AddIdempotencyRecord резервирует ключ, попутно
+// удаляя записи с истекшим сроком
+func (db *MemDb) AddIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	for k, r := range db.keys {
+		if !r.ExpiresAt.After(now) {
+			delete(db.keys, k)
+		}
+	}
+
+	if _, ok := db.keys[rec.Key]; ok {
+		return storage.ErrConflict
+	}
+	db.keys[rec.Key] = rec
+	return nil
+}
+
+func (db *MemDb) UpdateIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.keys[rec.Key]; !ok {
+		return storage.ErrNotFound
+	}
+	db.keys[rec.Key] = rec
+	return nil
+}
+
+func (db *MemDb) DeleteIdempotencyRecord(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.keys, key)
 	return nil
 }
 
-func (db *MemDb) Close() { return }
+func (db *MemDb) Close() {}
@@ -0,0 +1,28 @@
+package memDb
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"testing"
+)
+
+func TestMemDb_AddComment(t *testing.T) {
+	db := New()
+
+	if _, err := db.AddComment(storage.Comment{PostId: 1, Text: "first"}); err != nil {
+		t.Fatalf("memdb.AddComment() = error %v\n", err)
+	}
+
+	_, err := db.AddComment(storage.Comment{PostId: 404, Text: "lost"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("memdb.AddComment() to missing post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	if err = db.DeletePost(storage.Post{Id: 2}); err != nil {
+		t.Fatalf("memdb.DeletePost() = error %v\n", err)
+	}
+	_, err = db.AddComment(storage.Comment{PostId: 2, Text: "trashed"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("memdb.AddComment() to trashed post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
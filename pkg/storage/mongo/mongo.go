@@ -3,6 +3,10 @@ package mongo
 import (
 	"GoNews/pkg/storage"
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,6 +15,21 @@ import (
 
 var ErrNoDocuments = mongo.ErrNoDocuments
 
+const (
+	// idempotencyCollection коллекция ключей идемпотентности запросов
+	idempotencyCollection = "idempotency_keys"
+
+	// revisionsCollection коллекция версий публикаций
+	revisionsCollection = "revisions"
+
+	// commentsCollection коллекция комментариев
+	commentsCollection = "comments"
+
+	// countersCollection коллекция счетчиков для выдачи
+	// последовательных id, _id документа - имя счетчика
+	countersCollection = "counters"
+)
+
 // Mongo выполняет CRUD операции с БД
 type Mongo struct {
 	client *mongo.Client
@@ -20,13 +39,24 @@ type Mongo struct {
 	// соответствующими коллекциями
 	databaseName   string
 	collectionName string
+
+	opts *options.ClientOptions // действующие параметры клиента
+	pool *poolStats
 }
 
 // New выполняет подключение
 // и возвращает объект для взаимодействия с БД
-func New(connString string, dbName, collectionName string) (*Mongo, error) {
+func New(connString string, dbName, collectionName string, opts ...Option) (*Mongo, error) {
+	var cfg Config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-	mongoOpts := options.Client().ApplyURI(connString)
+	pool := &poolStats{}
+	mongoOpts := options.Client().ApplyURI(connString).SetPoolMonitor(pool.monitor())
+	if err := cfg.apply(mongoOpts); err != nil {
+		return nil, err
+	}
 
 	client, err := mongo.Connect(context.Background(), mongoOpts)
 	if err != nil {
@@ -38,11 +68,57 @@ func New(connString string, dbName, collectionName string) (*Mongo, error) {
 		return nil, err
 	}
 
-	return &Mongo{
+	m := &Mongo{
 		client:         client,
 		databaseName:   dbName,
 		collectionName: collectionName,
-	}, nil
+		opts:           mongoOpts,
+		pool:           pool,
+	}
+
+	err = m.createIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// createIndexes создает индексы коллекций. Записи идемпотентности
+// удаляются самой БД по TTL-индексу после истечения expires_at,
+// номер версии уникален в пределах публикации
+func (m *Mongo) createIndexes() error {
+	_, err := m.client.Database(m.databaseName).Collection(m.collectionName).Indexes().
+		CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{bson.E{Key: "tags", Value: 1}},
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.client.Database(m.databaseName).Collection(idempotencyCollection).Indexes().
+		CreateOne(context.Background(), mongo.IndexModel{
+			Keys:    bson.D{bson.E{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.client.Database(m.databaseName).Collection(revisionsCollection).Indexes().
+		CreateOne(context.Background(), mongo.IndexModel{
+			Keys:    bson.D{bson.E{Key: "post_id", Value: 1}, bson.E{Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.client.Database(m.databaseName).Collection(commentsCollection).Indexes().
+		CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{bson.E{Key: "post_id", Value: 1}},
+		})
+	return err
 }
 
 // Close выполняет закрытие подключения к БД
@@ -50,47 +126,688 @@ func (m *Mongo) Close() {
 	m.client.Disconnect(context.Background())
 }
 
-// AddPost создает пост в БД
+// AddPost создает пост в БД. Публикация с тем же id, в том числе
+// в корзине, не заменяется: возвращается storage.ErrConflict
 func (m *Mongo) AddPost(post storage.Post) error {
 
 	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
 
-	// если в коллекции отсутствует публикация по
-	// заданному id, то выполняем INSERT, иначе UPDATE
+	_, err := collection.InsertOne(context.Background(), post)
+	if err != nil {
+		return mapErr(err)
+	}
+
+	return m.addRevisions([]storage.Post{post})
+}
+
+// notDeleted условие фильтра: публикация не удалена в корзину.
+// У документов, созданных до появления корзины, поле отсутствует
+var notDeleted = bson.E{Key: "deleted_at", Value: bson.D{bson.E{Key: "$in", Value: bson.A{0, nil}}}}
+
+// published условие фильтра: публикация опубликована. У документов,
+// созданных до появления состояний, поле пустое или отсутствует
+var published = bson.E{Key: "status", Value: bson.D{bson.E{Key: "$in", Value: bson.A{storage.StatusPublished, "", nil}}}}
+
+// deleted условие фильтра: публикация удалена в корзину
+var deleted = bson.E{Key: "deleted_at", Value: bson.D{bson.E{Key: "$gt", Value: 0}}}
+
+// UpdatePost обновялет публикацию. Как и AddPost, создает
+// отсутствующую публикацию, но не трогает удаленные в корзину
+func (m *Mongo) UpdatePost(post storage.Post) error {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
 	opts := options.Replace().SetUpsert(true)
 
-	filter := bson.D{bson.E{Key: "_id", Value: post.Id}}
+	filter := bson.D{bson.E{Key: "_id", Value: post.Id}, notDeleted}
 
 	_, err := collection.ReplaceOne(context.Background(), filter, post, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		// публикация с этим id находится в корзине
+		return storage.ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
 
+	return m.addRevisions([]storage.Post{post})
+}
+
+// addRevisions сохраняет публикации как их новые версии. Версии
+// сохраняются отдельно от изменения публикаций, вне транзакции
+func (m *Mongo) addRevisions(posts []storage.Post) error {
+	collection := m.client.Database(m.databaseName).Collection(revisionsCollection)
+
+	now := time.Now().Unix()
+	for _, post := range posts {
+		// номер следует за номером последней версии
+		var last storage.Revision
+		err := collection.FindOne(context.Background(),
+			bson.D{bson.E{Key: "post_id", Value: post.Id}},
+			options.FindOne().SetSort(bson.D{bson.E{Key: "number", Value: -1}})).Decode(&last)
+		if err != nil && !errors.Is(err, ErrNoDocuments) {
+			return err
+		}
+
+		rev := storage.Revision{
+			PostId:    post.Id,
+			Number:    last.Number + 1,
+			Title:     post.Title,
+			Content:   post.Content,
+			Author:    post.Author,
+			Editor:    post.Editor,
+			CreatedAt: now,
+		}
+
+		_, err = collection.InsertOne(context.Background(), rev)
+		if err != nil {
+			return mapErr(err)
+		}
+	}
 	return nil
 }
 
-// UpdatePost обновялет публикацию
-func (m *Mongo) UpdatePost(post storage.Post) error {
-	return m.AddPost(post)
+// Tags возвращает теги публикаций, не удаленных в корзину
+func (m *Mongo) Tags() ([]storage.Tag, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	pipeline := mongo.Pipeline{
+		bson.D{bson.E{Key: "$match", Value: bson.D{notDeleted, published}}},
+		bson.D{bson.E{Key: "$unwind", Value: "$tags"}},
+		bson.D{bson.E{Key: "$group", Value: bson.D{
+			bson.E{Key: "_id", Value: "$tags"},
+			bson.E{Key: "posts", Value: bson.D{bson.E{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{bson.E{Key: "$sort", Value: bson.D{bson.E{Key: "_id", Value: 1}}}},
+	}
+
+	cur, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(context.Background())
+
+	var tags []storage.Tag
+
+	err = cur.All(context.Background(), &tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// PostsByTags возвращает публикации, у которых есть
+// любой из тегов tags или, если all, все теги
+func (m *Mongo) PostsByTags(tags []string, all bool) ([]storage.Post, error) {
+	op := "$in"
+	if all {
+		op = "$all"
+	}
+	return m.findPosts(bson.D{
+		bson.E{Key: "tags", Value: bson.D{bson.E{Key: op, Value: tags}}},
+		notDeleted,
+	})
+}
+
+// RenameTag переименовывает тег from в to во всех публикациях
+// в одной транзакции (требуется replica set). Если у публикации
+// уже есть тег to, теги объединяются
+func (m *Mongo) RenameTag(from, to string) (int, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	// tags = (tags \ {from}) ∪ {to}
+	update := mongo.Pipeline{
+		bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "tags", Value: bson.D{
+			bson.E{Key: "$setUnion", Value: bson.A{
+				bson.D{bson.E{Key: "$setDifference", Value: bson.A{"$tags", bson.A{from}}}},
+				bson.A{to},
+			}},
+		}}}}},
+	}
+
+	sess, err := m.client.StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer sess.EndSession(context.Background())
+
+	res, err := sess.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (any, error) {
+		return collection.UpdateMany(ctx, bson.D{bson.E{Key: "tags", Value: from}}, update)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := int(res.(*mongo.UpdateResult).MatchedCount)
+	if n == 0 {
+		return 0, storage.ErrNotFound
+	}
+	return n, nil
+}
+
+// Comments возвращает комментарии к публикации
+func (m *Mongo) Comments(postId int) ([]storage.Comment, error) {
+	collection := m.client.Database(m.databaseName).Collection(commentsCollection)
+
+	opts := options.Find().SetSort(bson.D{bson.E{Key: "_id", Value: 1}})
+	cur, err := collection.Find(context.Background(), bson.D{bson.E{Key: "post_id", Value: postId}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(context.Background())
+
+	var comments []storage.Comment
+
+	err = cur.All(context.Background(), &comments)
+	if err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Comment возвращает комментарий по id
+func (m *Mongo) Comment(id int) (storage.Comment, error) {
+	collection := m.client.Database(m.databaseName).Collection(commentsCollection)
+
+	var c storage.Comment
+	err := collection.FindOne(context.Background(), bson.D{bson.E{Key: "_id", Value: id}}).Decode(&c)
+	if errors.Is(err, ErrNoDocuments) {
+		return storage.Comment{}, storage.ErrNotFound
+	}
+	return c, err
+}
+
+// AddComment создает комментарий к публикации и возвращает его id
+func (m *Mongo) AddComment(c storage.Comment) (int, error) {
+	posts := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	n, err := posts.CountDocuments(context.Background(), bson.D{bson.E{Key: "_id", Value: c.PostId}})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	c.Id, err = m.nextId(commentsCollection)
+	if err != nil {
+		return 0, err
+	}
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().Unix()
+	}
+
+	_, err = m.client.Database(m.databaseName).Collection(commentsCollection).
+		InsertOne(context.Background(), c)
+	if err != nil {
+		return 0, mapErr(err)
+	}
+	return c.Id, nil
+}
+
+// SetCommentStatus изменяет состояние модерации комментария
+func (m *Mongo) SetCommentStatus(id int, status storage.CommentStatus) error {
+	collection := m.client.Database(m.databaseName).Collection(commentsCollection)
+
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "status", Value: status}}}}
+
+	res, err := collection.UpdateOne(context.Background(), bson.D{bson.E{Key: "_id", Value: id}}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// nextId возвращает следующее значение счетчика name
+func (m *Mongo) nextId(name string) (int, error) {
+	collection := m.client.Database(m.databaseName).Collection(countersCollection)
+
+	update := bson.D{bson.E{Key: "$inc", Value: bson.D{bson.E{Key: "seq", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := collection.FindOneAndUpdate(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: name}}, update, opts).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// Revisions возвращает историю версий публикации
+func (m *Mongo) Revisions(postId int) ([]storage.Revision, error) {
+	collection := m.client.Database(m.databaseName).Collection(revisionsCollection)
+
+	opts := options.Find().SetSort(bson.D{bson.E{Key: "number", Value: 1}})
+	cur, err := collection.Find(context.Background(), bson.D{bson.E{Key: "post_id", Value: postId}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(context.Background())
+
+	var revs []storage.Revision
+
+	err = cur.All(context.Background(), &revs)
+	if err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+// Revision возвращает версию публикации по номеру
+func (m *Mongo) Revision(postId, number int) (storage.Revision, error) {
+	collection := m.client.Database(m.databaseName).Collection(revisionsCollection)
+
+	filter := bson.D{bson.E{Key: "post_id", Value: postId}, bson.E{Key: "number", Value: number}}
+
+	var rev storage.Revision
+	err := collection.FindOne(context.Background(), filter).Decode(&rev)
+	if errors.Is(err, ErrNoDocuments) {
+		return storage.Revision{}, storage.ErrNotFound
+	}
+	return rev, err
 }
 
-// DeletePost удаляет публикацию
+// DeletePost удаляет публикацию в корзину
 func (m *Mongo) DeletePost(post storage.Post) error {
 	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
 
-	_, err := collection.DeleteOne(context.Background(), bson.D{bson.E{Key: "_id", Value: post.Id}})
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "deleted_at", Value: time.Now().Unix()}}}}
+
+	_, err := collection.UpdateOne(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: post.Id}, notDeleted}, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Trash возвращает публикации в корзине, начиная с последних удаленных
+func (m *Mongo) Trash() ([]storage.Post, error) {
+	opts := options.Find().SetSort(bson.D{
+		bson.E{Key: "deleted_at", Value: -1},
+		bson.E{Key: "_id", Value: 1},
+	})
+	return m.findPosts(bson.D{deleted}, opts)
+}
+
+// TrashedPost возвращает публикацию из корзины по id
+func (m *Mongo) TrashedPost(id int) (storage.Post, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	var p storage.Post
+	err := collection.FindOne(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: id}, deleted}).Decode(&p)
+	if errors.Is(err, ErrNoDocuments) {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return p, err
+}
+
+// RestorePost восстанавливает публикацию из корзины
+func (m *Mongo) RestorePost(post storage.Post) error {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "deleted_at", Value: int64(0)}}}}
+
+	res, err := collection.UpdateOne(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: post.Id}, deleted}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// PurgePosts окончательно удаляет публикации,
+// помещенные в корзину раньше момента before
+func (m *Mongo) PurgePosts(before int64) (int, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	filter := bson.D{bson.E{Key: "deleted_at", Value: bson.D{
+		bson.E{Key: "$gt", Value: 0},
+		bson.E{Key: "$lt", Value: before},
+	}}}
+
+	posts, err := m.findPosts(filter, options.Find().SetProjection(bson.D{bson.E{Key: "_id", Value: 1}}))
+	if err != nil || len(posts) == 0 {
+		return 0, err
+	}
+	ids := make(bson.A, len(posts))
+	for i, p := range posts {
+		ids[i] = p.Id
+	}
+
+	res, err := collection.DeleteMany(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: bson.D{bson.E{Key: "$in", Value: ids}}}, deleted})
+	if err != nil {
+		return 0, err
+	}
+
+	// вместе с публикациями удаляем их историю версий и комментарии
+	for _, name := range []string{revisionsCollection, commentsCollection} {
+		_, err = m.client.Database(m.databaseName).Collection(name).
+			DeleteMany(context.Background(),
+				bson.D{bson.E{Key: "post_id", Value: bson.D{bson.E{Key: "$in", Value: ids}}}})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+// PublishScheduled публикует запланированные публикации,
+// время публикации которых наступило. Публикации обновляются
+// по одной, чтобы вернуть только те, что опубликованы этим вызовом
+func (m *Mongo) PublishScheduled(now int64) ([]int, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+	ctx := context.Background()
+
+	filter := bson.D{
+		bson.E{Key: "status", Value: storage.StatusScheduled},
+		bson.E{Key: "publish_at", Value: bson.D{bson.E{Key: "$lte", Value: now}}},
+		notDeleted,
+	}
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.D{bson.E{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var due []struct {
+		Id int `bson:"_id"`
+	}
+	if err = cur.All(ctx, &due); err != nil {
+		return nil, err
+	}
+
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "status", Value: storage.StatusPublished}}}}
+	var ids []int
+	for _, d := range due {
+		res, err := collection.UpdateOne(ctx, append(bson.D{bson.E{Key: "_id", Value: d.Id}}, filter...), update)
+		if err != nil {
+			return ids, err
+		}
+		if res.ModifiedCount > 0 {
+			ids = append(ids, d.Id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// AddPosts создает публикации пакетом через BulkWrite.
+// Как и AddPost, существующие публикации не заменяются:
+// такие элементы пакета получают storage.ErrConflict
+func (m *Mongo) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	models := make([]mongo.WriteModel, len(posts))
+	for i, post := range posts {
+		models[i] = mongo.NewInsertOneModel().SetDocument(post)
+	}
+	return m.bulkWriteRevisions(posts, models, atomic)
+}
+
+// UpdatePosts обновляет публикации пакетом, публикации
+// в корзине не обновляются и дают ошибку
+func (m *Mongo) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	errs, err := m.writeEach(posts, atomic,
+		func(ctx context.Context, c *mongo.Collection, post storage.Post) (*mongo.UpdateResult, error) {
+			return c.ReplaceOne(ctx, bson.D{bson.E{Key: "_id", Value: post.Id}, notDeleted}, post)
+		})
+	if err != nil {
+		return errs, err
+	}
+	return errs, m.addRevisions(written(posts, errs))
+}
+
+// DeletePosts удаляет публикации пакетом в корзину
+func (m *Mongo) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "deleted_at", Value: time.Now().Unix()}}}}
+
+	return m.writeEach(posts, atomic,
+		func(ctx context.Context, c *mongo.Collection, post storage.Post) (*mongo.UpdateResult, error) {
+			return c.UpdateOne(ctx, bson.D{bson.E{Key: "_id", Value: post.Id}, notDeleted}, update)
+		})
+}
+
+// bulkWriteRevisions выполняет пакет операций bulkWrite и
+// сохраняет новые версии успешно записанных публикаций
+func (m *Mongo) bulkWriteRevisions(posts []storage.Post, models []mongo.WriteModel,
+	atomic bool) ([]error, error) {
+
+	errs, err := m.bulkWrite(models, atomic)
+	if err != nil {
+		return errs, err
+	}
+	return errs, m.addRevisions(written(posts, errs))
+}
+
+// written возвращает публикации пакета, записанные без ошибок
+func written(posts []storage.Post, errs []error) []storage.Post {
+	list := make([]storage.Post, 0, len(posts))
+	for i, post := range posts {
+		if errs[i] == nil {
+			list = append(list, post)
+		}
+	}
+	return list
+}
+
+// writeEach выполняет операцию write над каждой публикацией пакета
+// по отдельности, чтобы проверить результат каждой: публикация, не
+// найденная фильтром, получает ошибку storage.ErrNotFound. В режиме
+// atomic операции выполняются в транзакции, которая откатывается
+// при первой ошибке
+func (m *Mongo) writeEach(posts []storage.Post, atomic bool,
+	write func(ctx context.Context, c *mongo.Collection, post storage.Post) (*mongo.UpdateResult, error)) ([]error, error) {
+
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	errs := make([]error, len(posts))
+
+	// apply выполняет операцию над публикацией i. Ошибка самой
+	// операции сохраняется в errs, остальные возвращаются
+	apply := func(ctx context.Context, i int) error {
+		res, err := write(ctx, collection, posts[i])
+		var we mongo.WriteException
+		switch {
+		case errors.As(err, &we):
+			errs[i] = mapErr(err)
+		case err != nil:
+			return err
+		case res.MatchedCount == 0:
+			errs[i] = storage.ErrNotFound
+		}
+		return nil
+	}
+
+	if !atomic {
+		for i := range posts {
+			if err := apply(context.Background(), i); err != nil {
+				return nil, err
+			}
+		}
+		return errs, nil
+	}
+
+	sess, err := m.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.EndSession(context.Background())
+
+	_, err = sess.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (any, error) {
+		// транзакция может быть повторена
+		for i := range errs {
+			errs[i] = nil
+		}
+		for i := range posts {
+			if err := apply(ctx, i); err != nil {
+				return nil, err
+			}
+			if errs[i] != nil {
+				return nil, storage.ErrBulkAborted
+			}
+		}
+		return nil, nil
+	})
+	if errors.Is(err, storage.ErrBulkAborted) {
+		return errs, storage.ErrBulkAborted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// bulkWrite выполняет пакет операций. В режиме atomic операции
+// выполняются по порядку в транзакции (требуется replica set),
+// иначе - без упорядочивания, и ошибки отдельных операций
+// возвращаются в срезе по их индексам
+func (m *Mongo) bulkWrite(models []mongo.WriteModel, atomic bool) ([]error, error) {
+	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
+
+	errs := make([]error, len(models))
+
+	// writeErrors раскладывает ошибки отдельных операций по индексам
+	writeErrors := func(err error) bool {
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || len(bwe.WriteErrors) == 0 {
+			return false
+		}
+		for _, we := range bwe.WriteErrors {
+			if we.Index >= 0 && we.Index < len(errs) {
+				errs[we.Index] = mapErr(we)
+			}
+		}
+		return true
+	}
+
+	if !atomic {
+		opts := options.BulkWrite().SetOrdered(false)
+		_, err := collection.BulkWrite(context.Background(), models, opts)
+		if err != nil && !writeErrors(err) {
+			return nil, err
+		}
+		return errs, nil
+	}
+
+	sess, err := m.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.EndSession(context.Background())
+
+	_, err = sess.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (any, error) {
+		return collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+	})
+	if err != nil {
+		if writeErrors(err) {
+			return errs, storage.ErrBulkAborted
+		}
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// IdempotencyRecord возвращает действующую запись идемпотентности по ключу
+func (m *Mongo) IdempotencyRecord(key string) (storage.IdempotencyRecord, error) {
+	collection := m.client.Database(m.databaseName).Collection(idempotencyCollection)
+
+	// TTL-индекс удаляет документы с задержкой,
+	// поэтому срок проверяем в запросе
+	filter := bson.D{
+		bson.E{Key: "_id", Value: key},
+		bson.E{Key: "expires_at", Value: bson.D{bson.E{Key: "$gt", Value: time.Now()}}},
+	}
+
+	var rec storage.IdempotencyRecord
+	err := collection.FindOne(context.Background(), filter).Decode(&rec)
+	if errors.Is(err, ErrNoDocuments) {
+		return rec, storage.ErrNotFound
+	}
+	return rec, err
+}
+
+// AddIdempotencyRecord резервирует ключ идемпотентности,
+// запись с истекшим сроком заменяется
+func (m *Mongo) AddIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	collection := m.client.Database(m.databaseName).Collection(idempotencyCollection)
+
+	_, err := collection.InsertOne(context.Background(), rec)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	filter := bson.D{
+		bson.E{Key: "_id", Value: rec.Key},
+		bson.E{Key: "expires_at", Value: bson.D{bson.E{Key: "$lte", Value: time.Now()}}},
+	}
+	res, err := collection.ReplaceOne(context.Background(), filter, rec)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+// UpdateIdempotencyRecord сохраняет ответ на запрос по ключу
+func (m *Mongo) UpdateIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	collection := m.client.Database(m.databaseName).Collection(idempotencyCollection)
 
+	res, err := collection.ReplaceOne(context.Background(),
+		bson.D{bson.E{Key: "_id", Value: rec.Key}}, rec)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
 	return nil
 }
 
-// Posts возвращает список всех публикаций
+// DeleteIdempotencyRecord освобождает ключ идемпотентности
+func (m *Mongo) DeleteIdempotencyRecord(key string) error {
+	collection := m.client.Database(m.databaseName).Collection(idempotencyCollection)
+
+	_, err := collection.DeleteOne(context.Background(), bson.D{bson.E{Key: "_id", Value: key}})
+	return err
+}
+
+// mapErr приводит ошибки дублирования ключа к storage.ErrConflict
+func mapErr(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", storage.ErrConflict, err)
+	}
+	return err
+}
+
+// Posts возвращает список всех публикаций, кроме удаленных в корзину
 func (m *Mongo) Posts() ([]storage.Post, error) {
+	return m.findPosts(bson.D{notDeleted})
+}
+
+// findPosts возвращает публикации, удовлетворяющие фильтру
+func (m *Mongo) findPosts(filter any, opts ...*options.FindOptions) ([]storage.Post, error) {
 	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
 
-	cur, err := collection.Find(context.Background(), bson.D{})
+	cur, err := collection.Find(context.Background(), filter, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,13 +824,23 @@ func (m *Mongo) Posts() ([]storage.Post, error) {
 	return posts, nil
 }
 
+// Post возвращает публикацию по id или
+// storage.ErrNotFound, если такой публикации нет
+func (m *Mongo) Post(id int) (storage.Post, error) {
+	post, err := m.getPostById(id)
+	if errors.Is(err, ErrNoDocuments) {
+		return post, storage.ErrNotFound
+	}
+	return post, err
+}
+
 func (m *Mongo) getPostById(id any) (storage.Post, error) {
 	collection := m.client.Database(m.databaseName).Collection(m.collectionName)
 
 	var p storage.Post
 
 	err := collection.FindOne(context.Background(),
-		bson.D{bson.E{Key: "_id", Value: id}}).Decode(&p)
+		bson.D{bson.E{Key: "_id", Value: id}, notDeleted}).Decode(&p)
 	if err != nil {
 		return p, err
 	}
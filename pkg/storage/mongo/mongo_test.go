@@ -2,9 +2,13 @@ package mongo
 
 import (
 	"GoNews/pkg/storage"
+	"context"
+	"errors"
 	"log"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 var testMongoDB *Mongo
@@ -61,6 +65,21 @@ func TestMongo_Posts(t *testing.T) {
 	}
 }
 
+func TestMongo_Post(t *testing.T) {
+	post, err := testMongoDB.Post(2)
+	if err != nil {
+		t.Fatalf("mongo.Post() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(post, testData[1]) {
+		t.Fatalf("mongo.Post() = %v, want %v\n", post, testData[1])
+	}
+
+	_, err = testMongoDB.Post(100)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
 func TestMongo_AddPost(t *testing.T) {
 	newpost := storage.Post{
 		Id:        3,
@@ -79,9 +98,19 @@ func TestMongo_AddPost(t *testing.T) {
 		t.Fatalf("mongo.getPostById() = error %v\n", err)
 	}
 
-	if post != newpost {
+	if !reflect.DeepEqual(post, newpost) {
 		t.Fatalf("mongo.AddPost() = %v, want %v\n", post, newpost)
 	}
+
+	// существующая публикация не заменяется
+	err = testMongoDB.AddPost(storage.Post{Id: newpost.Id, Title: "replaced", Author: storage.Author{Id: 4}})
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("mongo.AddPost() of existing post = error %v, want %v\n", err, storage.ErrConflict)
+	}
+	post, _ = testMongoDB.getPostById(newpost.Id)
+	if post.Title != newpost.Title {
+		t.Fatalf("mongo.AddPost() replaced existing post: %v\n", post)
+	}
 }
 
 func TestMongo_UpdatePost(t *testing.T) {
@@ -102,7 +131,7 @@ func TestMongo_UpdatePost(t *testing.T) {
 		t.Fatalf("mongo.getPostById() = error %v\n", err)
 	}
 
-	if post != newpost {
+	if !reflect.DeepEqual(post, newpost) {
 		t.Fatalf("mongo.UpdatePost() = %v, want %v\n", post, newpost)
 	}
 }
@@ -118,7 +147,353 @@ func TestMongo_DeletePost(t *testing.T) {
 	if err != nil && err != ErrNoDocuments {
 		t.Fatalf("mongo.getPostById() = error %v\n", err)
 	}
-	if post != (storage.Post{}) {
+	if !reflect.DeepEqual(post, storage.Post{}) {
 		t.Fatalf("mongo.DeletePost() = %v, want nothing\n", post)
 	}
 }
+
+func TestMongo_AddPosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Title: "Mongo bulk post 10", Author: storage.Author{Id: 3, Name: "Test Author"}},
+		{Id: 11, Title: "Mongo bulk post 11", Author: storage.Author{Id: 3, Name: "Test Author"}},
+	}
+	errs, err := testMongoDB.AddPosts(posts, false)
+	if err != nil {
+		t.Fatalf("mongo.AddPosts() = error %v\n", err)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("mongo.AddPosts() post %d = error %v\n", posts[i].Id, err)
+		}
+	}
+
+	post, err := testMongoDB.getPostById(11)
+	if err != nil {
+		t.Fatalf("mongo.getPostById() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(post, posts[1]) {
+		t.Fatalf("mongo.AddPosts() = %v, want %v\n", post, posts[1])
+	}
+
+	// существующая публикация не заменяется
+	dup := []storage.Post{
+		{Id: 12, Title: "Mongo bulk post 12", Author: storage.Author{Id: 3, Name: "Test Author"}},
+		{Id: 11, Title: "replaced", Author: storage.Author{Id: 4}},
+	}
+	errs, err = testMongoDB.AddPosts(dup, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("mongo.AddPosts() = %v, error %v, want %v for post 11\n", errs, err, storage.ErrConflict)
+	}
+	post, _ = testMongoDB.getPostById(11)
+	if post.Title != posts[1].Title {
+		t.Fatalf("mongo.AddPosts() replaced existing post: %v\n", post)
+	}
+}
+
+func TestMongo_UpdatePosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Title: "Mongo bulk updated", Author: storage.Author{Id: 3, Name: "Test Author"}},
+		{Id: 404, Title: "Missing", Author: storage.Author{Id: 3, Name: "Test Author"}},
+	}
+	errs, err := testMongoDB.UpdatePosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("mongo.UpdatePosts(atomic) = %v, error %v, want %v for post 404\n",
+			errs, err, storage.ErrNotFound)
+	}
+	post, err := testMongoDB.getPostById(10)
+	if err != nil {
+		t.Fatalf("mongo.getPostById() = error %v\n", err)
+	}
+	if post.Title != "Mongo bulk post 10" {
+		t.Fatalf("mongo.UpdatePosts(atomic) applied aborted update: %v\n", post)
+	}
+
+	errs, err = testMongoDB.UpdatePosts(posts, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("mongo.UpdatePosts() = %v, error %v\n", errs, err)
+	}
+	// отсутствующая публикация не создается
+	if _, err = testMongoDB.Post(404); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestMongo_DeletePosts(t *testing.T) {
+	errs, err := testMongoDB.DeletePosts([]storage.Post{{Id: 10}, {Id: 11}, {Id: 404}}, false)
+	if err != nil || errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], storage.ErrNotFound) {
+		t.Fatalf("mongo.DeletePosts() = %v, error %v\n", errs, err)
+	}
+
+	_, err = testMongoDB.Post(10)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestMongo_IdempotencyRecord(t *testing.T) {
+	rec := storage.IdempotencyRecord{
+		Key:         "test:key-1",
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour).Truncate(time.Millisecond),
+	}
+	err := testMongoDB.AddIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("mongo.AddIdempotencyRecord() = error %v\n", err)
+	}
+	err = testMongoDB.AddIdempotencyRecord(rec)
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("mongo.AddIdempotencyRecord() = error %v, want %v\n", err, storage.ErrConflict)
+	}
+
+	rec.Status = 201
+	rec.Header = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Body = []byte(`{"data": 1}`)
+	err = testMongoDB.UpdateIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("mongo.UpdateIdempotencyRecord() = error %v\n", err)
+	}
+
+	got, err := testMongoDB.IdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("mongo.IdempotencyRecord() = error %v\n", err)
+	}
+	if got.Status != rec.Status || string(got.Body) != string(rec.Body) ||
+		got.Header["Content-Type"][0] != "application/json" || !got.ExpiresAt.Equal(rec.ExpiresAt) {
+		t.Fatalf("mongo.IdempotencyRecord() = %v, want %v\n", got, rec)
+	}
+
+	err = testMongoDB.DeleteIdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("mongo.DeleteIdempotencyRecord() = error %v\n", err)
+	}
+	_, err = testMongoDB.IdempotencyRecord(rec.Key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.IdempotencyRecord() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	// запись с истекшим сроком заменяется
+	rec.ExpiresAt = time.Now().Add(-time.Second)
+	if err = testMongoDB.AddIdempotencyRecord(rec); err != nil {
+		t.Fatalf("mongo.AddIdempotencyRecord() = error %v\n", err)
+	}
+	rec.ExpiresAt = time.Now().Add(time.Hour)
+	if err = testMongoDB.AddIdempotencyRecord(rec); err != nil {
+		t.Fatalf("mongo.AddIdempotencyRecord() of expired key = error %v\n", err)
+	}
+}
+
+func TestMongo_Trash(t *testing.T) {
+	// публикация 1 удалена в корзину в TestMongo_DeletePost
+	post, err := testMongoDB.TrashedPost(1)
+	if err != nil {
+		t.Fatalf("mongo.TrashedPost() = error %v\n", err)
+	}
+	if post.DeletedAt == 0 {
+		t.Fatalf("mongo.TrashedPost() = %v, want deletion time\n", post)
+	}
+
+	err = testMongoDB.RestorePost(storage.Post{Id: 1})
+	if err != nil {
+		t.Fatalf("mongo.RestorePost() = error %v\n", err)
+	}
+	if _, err = testMongoDB.Post(1); err != nil {
+		t.Fatalf("mongo.Post() of restored post = error %v\n", err)
+	}
+
+	trash, err := testMongoDB.Trash()
+	if err != nil {
+		t.Fatalf("mongo.Trash() = error %v\n", err)
+	}
+	n, err := testMongoDB.PurgePosts(time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("mongo.PurgePosts() = error %v\n", err)
+	}
+	if n != len(trash) {
+		t.Fatalf("mongo.PurgePosts() = %d posts, want %d\n", n, len(trash))
+	}
+}
+
+func TestMongo_Revisions(t *testing.T) {
+	post := storage.Post{Id: 50, Title: "v1", Content: "Lorem ipsum", Author: storage.Author{Id: 1}, Editor: "ivan"}
+	if err := testMongoDB.AddPost(post); err != nil {
+		t.Fatalf("mongo.AddPost() = error %v\n", err)
+	}
+	post.Title, post.Editor = "v2", "olga"
+	if err := testMongoDB.UpdatePost(post); err != nil {
+		t.Fatalf("mongo.UpdatePost() = error %v\n", err)
+	}
+
+	revs, err := testMongoDB.Revisions(50)
+	if err != nil {
+		t.Fatalf("mongo.Revisions() = error %v\n", err)
+	}
+	if len(revs) != 2 || revs[0].Title != "v1" || revs[1].Title != "v2" || revs[1].Editor != "olga" {
+		t.Fatalf("mongo.Revisions() = %v, want v1 by ivan and v2 by olga\n", revs)
+	}
+
+	rev, err := testMongoDB.Revision(50, 1)
+	if err != nil {
+		t.Fatalf("mongo.Revision() = error %v\n", err)
+	}
+	if rev.Number != 1 || rev.Editor != "ivan" {
+		t.Fatalf("mongo.Revision() = %v, want first revision\n", rev)
+	}
+	_, err = testMongoDB.Revision(50, 3)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.Revision() of missing revision = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestMongo_PublishScheduled(t *testing.T) {
+	publishAt := time.Now().Add(time.Hour).Unix()
+	post := storage.Post{Id: 60, Title: "scheduled", Status: storage.StatusScheduled, PublishAt: publishAt}
+	if err := testMongoDB.AddPost(post); err != nil {
+		t.Fatalf("mongo.AddPost() = error %v\n", err)
+	}
+
+	ids, err := testMongoDB.PublishScheduled(publishAt)
+	if err != nil {
+		t.Fatalf("mongo.PublishScheduled() = error %v\n", err)
+	}
+	if len(ids) != 1 || ids[0] != 60 {
+		t.Fatalf("mongo.PublishScheduled() = %v, want [60]\n", ids)
+	}
+
+	got, err := testMongoDB.Post(60)
+	if err != nil {
+		t.Fatalf("mongo.Post() = error %v\n", err)
+	}
+	if got.Status != storage.StatusPublished {
+		t.Fatalf("mongo.Post() status = %q, want %q\n", got.Status, storage.StatusPublished)
+	}
+}
+
+func TestMongo_Tags(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 70, Title: "tagged", Tags: []string{"go", "news"}},
+		{Id: 71, Title: "tagged", Tags: []string{"go"}},
+		{Id: 72, Title: "draft", Tags: []string{"draft"}, Status: storage.StatusDraft},
+	}
+	if _, err := testMongoDB.AddPosts(posts, true); err != nil {
+		t.Fatalf("mongo.AddPosts() = error %v\n", err)
+	}
+
+	got, err := testMongoDB.PostsByTags([]string{"go", "news"}, true)
+	if err != nil {
+		t.Fatalf("mongo.PostsByTags() = error %v\n", err)
+	}
+	if len(got) != 1 || got[0].Id != 70 || !reflect.DeepEqual(got[0].Tags, []string{"go", "news"}) {
+		t.Fatalf("mongo.PostsByTags(all) = %v, want post 70\n", got)
+	}
+
+	n, err := testMongoDB.RenameTag("news", "go")
+	if err != nil {
+		t.Fatalf("mongo.RenameTag() = error %v\n", err)
+	}
+	if n != 1 {
+		t.Fatalf("mongo.RenameTag() = %d posts, want 1\n", n)
+	}
+	_, err = testMongoDB.RenameTag("news", "go")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.RenameTag() of missing tag = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	tags, err := testMongoDB.Tags()
+	if err != nil {
+		t.Fatalf("mongo.Tags() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(tags, []storage.Tag{{Name: "go", Posts: 2}}) {
+		t.Fatalf("mongo.Tags() = %v, want go with 2 posts\n", tags)
+	}
+}
+
+func TestMongo_Comments(t *testing.T) {
+	post := storage.Post{Id: 80, Title: "commented", Author: storage.Author{Id: 1}}
+	if err := testMongoDB.AddPost(post); err != nil {
+		t.Fatalf("mongo.AddPost() = error %v\n", err)
+	}
+
+	_, err := testMongoDB.AddComment(storage.Comment{PostId: 81, Text: "lost"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.AddComment() to missing post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	id, err := testMongoDB.AddComment(storage.Comment{PostId: 80, Author: "reader", Text: "first", Status: storage.CommentPending})
+	if err != nil {
+		t.Fatalf("mongo.AddComment() = error %v\n", err)
+	}
+	reply, err := testMongoDB.AddComment(storage.Comment{PostId: 80, ParentId: id, Author: "editor", Text: "reply",
+		Status: storage.CommentApproved})
+	if err != nil {
+		t.Fatalf("mongo.AddComment() = error %v\n", err)
+	}
+
+	if err = testMongoDB.SetCommentStatus(id, storage.CommentApproved); err != nil {
+		t.Fatalf("mongo.SetCommentStatus() = error %v\n", err)
+	}
+	err = testMongoDB.SetCommentStatus(reply+100, storage.CommentApproved)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.SetCommentStatus() of missing comment = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	comments, err := testMongoDB.Comments(80)
+	if err != nil {
+		t.Fatalf("mongo.Comments() = error %v\n", err)
+	}
+	if len(comments) != 2 || comments[0].Id != id || comments[1].ParentId != id ||
+		comments[0].Status != storage.CommentApproved || comments[0].CreatedAt == 0 {
+		t.Fatalf("mongo.Comments() = %v, want approved comment %d with reply\n", comments, id)
+	}
+
+	if err = testMongoDB.DeletePost(post); err != nil {
+		t.Fatalf("mongo.DeletePost() = error %v\n", err)
+	}
+	if _, err = testMongoDB.PurgePosts(time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("mongo.PurgePosts() = error %v\n", err)
+	}
+	_, err = testMongoDB.Comment(reply)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("mongo.Comment() of purged post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestMongo_Config(t *testing.T) {
+	dbUrl := os.Getenv("MONGO_DB_TEST_URL")
+
+	_, err := New(dbUrl, testDbName, testDbCollection, WithConfig(Config{ReadConcern: "eventual"}))
+	if err == nil {
+		t.Fatal("mongo.New() with unknown read concern = nil error\n")
+	}
+
+	m, err := New(dbUrl, testDbName, testDbCollection, WithConfig(Config{
+		MaxPoolSize:  5,
+		ReadConcern:  "local",
+		WriteConcern: "1",
+		WriteTimeout: time.Second,
+		AppName:      "gonews-test",
+	}))
+	if err != nil {
+		t.Fatalf("mongo.New() = error %v\n", err)
+	}
+	defer m.Close()
+
+	health, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatalf("mongo.Check() = error %v\n", err)
+	}
+	want := map[string]string{
+		"max_pool_size": "5",
+		"read_concern":  "local",
+		"write_concern": "1",
+		"write_timeout": "1s",
+		"app_name":      "gonews-test",
+	}
+	for k, v := range want {
+		if health.Settings[k] != v {
+			t.Fatalf("mongo.Check() settings %s = %q, want %q\n", k, health.Settings[k], v)
+		}
+	}
+	if health.Pool["total"] < 1 {
+		t.Fatalf("mongo.Check() pool = %v, want open connections\n", health.Pool)
+	}
+}
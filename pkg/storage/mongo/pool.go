@@ -0,0 +1,200 @@
+package mongo
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config параметры пула соединений, таймаутов и гарантий чтения
+// и записи. Нулевые значения оставляют параметры строки подключения
+// или значения драйвера по умолчанию
+type Config struct {
+	// MaxPoolSize и MinPoolSize наибольшее и наименьшее число соединений
+	MaxPoolSize uint64
+	MinPoolSize uint64
+
+	// MaxConnIdleTime время простоя, после которого соединение закрывается
+	MaxConnIdleTime time.Duration
+
+	// ConnectTimeout время на установку соединения
+	ConnectTimeout time.Duration
+
+	// ServerSelectionTimeout время ожидания подходящего сервера
+	ServerSelectionTimeout time.Duration
+
+	// SocketTimeout время ожидания ответа на операцию
+	SocketTimeout time.Duration
+
+	// ReadConcern уровень гарантий чтения: local, available,
+	// majority, linearizable или snapshot
+	ReadConcern string
+
+	// WriteConcern подтверждение записи: majority или число узлов,
+	// WriteTimeout - время ожидания подтверждения
+	WriteConcern string
+	WriteTimeout time.Duration
+
+	// AppName имя приложения в журналах сервера
+	AppName string
+}
+
+// Option необязательный параметр подключения
+type Option func(*Config)
+
+// WithConfig задает параметры подключения
+func WithConfig(c Config) Option {
+	return func(cfg *Config) {
+		*cfg = c
+	}
+}
+
+// readConcerns допустимые уровни гарантий чтения
+var readConcerns = map[string]bool{
+	"local": true, "available": true, "majority": true, "linearizable": true, "snapshot": true,
+}
+
+// apply проверяет параметры и переносит их в параметры клиента
+func (c Config) apply(opts *options.ClientOptions) error {
+	if c.MaxPoolSize > 0 && c.MinPoolSize > c.MaxPoolSize {
+		return errors.New("min pool size exceeds max pool size")
+	}
+	if c.MaxConnIdleTime < 0 || c.ConnectTimeout < 0 || c.ServerSelectionTimeout < 0 ||
+		c.SocketTimeout < 0 || c.WriteTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+
+	if c.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(c.MaxPoolSize)
+	}
+	if c.MinPoolSize > 0 {
+		opts.SetMinPoolSize(c.MinPoolSize)
+	}
+	if c.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(c.MaxConnIdleTime)
+	}
+	if c.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.SocketTimeout > 0 {
+		opts.SetSocketTimeout(c.SocketTimeout)
+	}
+	if c.AppName != "" {
+		opts.SetAppName(c.AppName)
+	}
+
+	if c.ReadConcern != "" {
+		if !readConcerns[c.ReadConcern] {
+			return errors.New("unknown read concern " + strconv.Quote(c.ReadConcern))
+		}
+		opts.SetReadConcern(readconcern.New(readconcern.Level(c.ReadConcern)))
+	}
+
+	if c.WriteConcern != "" || c.WriteTimeout > 0 {
+		var wc []writeconcern.Option
+		switch c.WriteConcern {
+		case "":
+		case "majority":
+			wc = append(wc, writeconcern.WMajority())
+		default:
+			n, err := strconv.Atoi(c.WriteConcern)
+			if err != nil || n < 0 {
+				return errors.New("write concern must be majority or number of nodes")
+			}
+			wc = append(wc, writeconcern.W(n))
+		}
+		if c.WriteTimeout > 0 {
+			wc = append(wc, writeconcern.WTimeout(c.WriteTimeout))
+		}
+		opts.SetWriteConcern(writeconcern.New(wc...))
+	}
+
+	return nil
+}
+
+// poolStats счетчики соединений по событиям пула
+type poolStats struct {
+	total, inUse atomic.Int64
+}
+
+// monitor возвращает обработчик событий пула, ведущий счетчики
+func (s *poolStats) monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				s.total.Add(1)
+			case event.ConnectionClosed:
+				s.total.Add(-1)
+			case event.GetSucceeded:
+				s.inUse.Add(1)
+			case event.ConnectionReturned:
+				s.inUse.Add(-1)
+			}
+		},
+	}
+}
+
+// Check проверяет доступность основного сервера и возвращает
+// действующие параметры подключения и состояние пула
+func (m *Mongo) Check(ctx context.Context) (storage.Health, error) {
+	settings := map[string]string{
+		"database":   m.databaseName,
+		"collection": m.collectionName,
+	}
+	if v := m.opts.MaxPoolSize; v != nil {
+		settings["max_pool_size"] = strconv.FormatUint(*v, 10)
+	}
+	if v := m.opts.MinPoolSize; v != nil {
+		settings["min_pool_size"] = strconv.FormatUint(*v, 10)
+	}
+	for name, d := range map[string]*time.Duration{
+		"max_conn_idle_time":       m.opts.MaxConnIdleTime,
+		"connect_timeout":          m.opts.ConnectTimeout,
+		"server_selection_timeout": m.opts.ServerSelectionTimeout,
+		"socket_timeout":           m.opts.SocketTimeout,
+	} {
+		if d != nil {
+			settings[name] = d.String()
+		}
+	}
+	if rc := m.opts.ReadConcern; rc != nil && rc.GetLevel() != "" {
+		settings["read_concern"] = rc.GetLevel()
+	}
+	if wc := m.opts.WriteConcern; wc != nil {
+		switch w := wc.GetW().(type) {
+		case int:
+			settings["write_concern"] = strconv.Itoa(w)
+		case string:
+			settings["write_concern"] = w
+		}
+		if wc.GetWTimeout() > 0 {
+			settings["write_timeout"] = wc.GetWTimeout().String()
+		}
+	}
+	if m.opts.AppName != nil {
+		settings["app_name"] = *m.opts.AppName
+	}
+
+	health := storage.Health{
+		Backend:  "mongo",
+		Settings: settings,
+		Pool: map[string]int64{
+			"total":  m.pool.total.Load(),
+			"in_use": m.pool.inUse.Load(),
+		},
+	}
+	return health, m.client.Ping(ctx, readpref.Primary())
+}
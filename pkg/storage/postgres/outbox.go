@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// addEvents сохраняет в outbox события typ публикаций ids в рамках
+// транзакции tx. Событие записывается после изменения публикации:
+// изменение держит блокировку строки до конца транзакции, поэтому
+// события одной публикации получают id в порядке изменений
+func addEvents(ctx context.Context, tx pgx.Tx, typ storage.EventType, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(ctx, selectPosts+`WHERE p.id = ANY($1) ORDER BY array_position($1, p.id);`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var postIds []int
+	var payloads []string
+	for rows.Next() {
+		var post storage.Post
+		if err = scanPost(rows, &post); err != nil {
+			return err
+		}
+		b, err := json.Marshal(post)
+		if err != nil {
+			return err
+		}
+		postIds = append(postIds, post.Id)
+		payloads = append(payloads, string(b))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt := `
+		INSERT INTO outbox(post_id, type, payload)
+		SELECT x.post_id, $1, x.payload
+		FROM unnest($2::integer[], $3::jsonb[]) WITH ORDINALITY AS x(post_id, payload, n)
+		ORDER BY x.n;
+	`
+	_, err = tx.Exec(ctx, stmt, typ, postIds, payloads)
+	return err
+}
+
+// Events возвращает до limit недоставленных событий по возрастанию id
+func (p *Postgres) Events(limit int) ([]storage.Event, error) {
+	stmt := `
+		SELECT id, type, post_id, payload, created_at
+		FROM outbox
+		ORDER BY id
+		LIMIT $1;
+	`
+	rows, err := p.db.Query(context.Background(), stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []storage.Event
+
+	for rows.Next() {
+		var e storage.Event
+
+		err = rows.Scan(&e.Id, &e.Type, &e.PostId, &e.Payload, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// AckEvents удаляет доставленные события
+func (p *Postgres) AckEvents(ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := p.db.Exec(context.Background(), `DELETE FROM outbox WHERE id = ANY($1);`, ids)
+	return err
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PoolConfig параметры пула соединений и выполнения запросов.
+// Нулевые значения оставляют параметры строки подключения
+// или значения pgx по умолчанию
+type PoolConfig struct {
+	// MaxConns и MinConns наибольшее и наименьшее число соединений
+	MaxConns int32
+	MinConns int32
+
+	// MaxConnLifetime время, после которого соединение закрывается
+	MaxConnLifetime time.Duration
+
+	// MaxConnIdleTime время простоя, после которого соединение закрывается
+	MaxConnIdleTime time.Duration
+
+	// HealthCheckPeriod периодичность проверки простаивающих соединений
+	HealthCheckPeriod time.Duration
+
+	// StatementTimeout наибольшее время выполнения запроса на сервере
+	StatementTimeout time.Duration
+
+	// ApplicationName имя приложения в pg_stat_activity
+	ApplicationName string
+}
+
+// WithPool задает параметры пула соединений основного сервера и реплик
+func WithPool(c PoolConfig) Option {
+	return func(cfg *config) {
+		cfg.pool = c
+	}
+}
+
+// validate проверяет параметры пула
+func (c PoolConfig) validate() error {
+	switch {
+	case c.MaxConns < 0 || c.MinConns < 0:
+		return errors.New("pool size must not be negative")
+	case c.MaxConns > 0 && c.MinConns > c.MaxConns:
+		return errors.New("min pool size exceeds max pool size")
+	case c.MaxConnLifetime < 0 || c.MaxConnIdleTime < 0 || c.HealthCheckPeriod < 0:
+		return errors.New("pool durations must not be negative")
+	case c.StatementTimeout < 0 || c.StatementTimeout > 0 && c.StatementTimeout < time.Millisecond:
+		return errors.New("statement timeout must be at least 1ms")
+	}
+	return nil
+}
+
+// apply переносит заданные параметры в конфигурацию пула pgx
+func (c PoolConfig) apply(pc *pgxpool.Config) {
+	if c.MaxConns > 0 {
+		pc.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		pc.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		pc.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		pc.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.HealthCheckPeriod > 0 {
+		pc.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+	if c.StatementTimeout > 0 {
+		pc.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	if c.ApplicationName != "" {
+		pc.ConnConfig.RuntimeParams["application_name"] = c.ApplicationName
+	}
+}
+
+// Check проверяет доступность основного сервера и возвращает
+// действующие параметры пула и его состояние
+func (p *Postgres) Check(ctx context.Context) (storage.Health, error) {
+	pc := p.db.Config()
+	settings := map[string]string{
+		"max_conns":           strconv.Itoa(int(pc.MaxConns)),
+		"min_conns":           strconv.Itoa(int(pc.MinConns)),
+		"max_conn_lifetime":   pc.MaxConnLifetime.String(),
+		"max_conn_idle_time":  pc.MaxConnIdleTime.String(),
+		"health_check_period": pc.HealthCheckPeriod.String(),
+	}
+	if v, ok := pc.ConnConfig.RuntimeParams["statement_timeout"]; ok {
+		// значение без единиц измерения задано в миллисекундах
+		if _, err := strconv.Atoi(v); err == nil {
+			v += "ms"
+		}
+		settings["statement_timeout"] = v
+	}
+	if v, ok := pc.ConnConfig.RuntimeParams["application_name"]; ok {
+		settings["application_name"] = v
+	}
+
+	stat := p.db.Stat()
+	pool := map[string]int64{
+		"total":    int64(stat.TotalConns()),
+		"idle":     int64(stat.IdleConns()),
+		"acquired": int64(stat.AcquiredConns()),
+	}
+	if len(p.replicas) > 0 {
+		settings["max_replica_lag"] = p.maxLag.String()
+		pool["replicas"] = int64(len(p.replicas))
+		for _, r := range p.replicas {
+			if r.healthy.Load() {
+				pool["healthy_replicas"]++
+			}
+		}
+	}
+
+	health := storage.Health{Backend: "postgres", Settings: settings, Pool: pool}
+	return health, p.db.Ping(ctx)
+}
@@ -3,66 +3,242 @@ package postgres
 import (
 	"GoNews/pkg/storage"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 var ErrNoRows = pgx.ErrNoRows
 
-// Postgres выполняет CRUD операции с БД
+const (
+	// DefaultMaxReplicaLag допустимое по умолчанию отставание реплики
+	DefaultMaxReplicaLag = 10 * time.Second
+
+	// DefaultHealthCheckInterval периодичность проверки реплик по умолчанию
+	DefaultHealthCheckInterval = 5 * time.Second
+)
+
+// Postgres выполняет CRUD операции с БД. Если заданы реплики,
+// чтение публикаций и тегов распределяется между исправными
+// репликами по кругу, запись и чтение собственных записей
+// (контекст storage.WithPrimary) выполняются на основном сервере
 type Postgres struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	replicas []*replica
+	next     atomic.Uint64 // счетчик перебора реплик
+
+	maxLag   time.Duration
+	interval time.Duration
+	stop     chan struct{} // остановка проверки реплик
+	done     chan struct{}
+}
+
+// replica реплика БД и результат последней проверки:
+// реплика исправна, если доступна и отстает не больше maxLag
+type replica struct {
+	db      *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// config параметры подключения
+type config struct {
+	replicas []string
+	maxLag   time.Duration
+	interval time.Duration
+	pool     PoolConfig
+}
+
+// Option необязательный параметр подключения
+type Option func(*config)
+
+// WithReplicas задает строки подключения к репликам
+func WithReplicas(connStrings ...string) Option {
+	return func(c *config) {
+		c.replicas = append(c.replicas, connStrings...)
+	}
+}
+
+// WithMaxReplicaLag задает допустимое отставание реплики,
+// отстающая сильнее реплика не используется для чтения
+func WithMaxReplicaLag(d time.Duration) Option {
+	return func(c *config) {
+		c.maxLag = d
+	}
+}
+
+// WithHealthCheckInterval задает периодичность проверки реплик
+func WithHealthCheckInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.interval = d
+	}
 }
 
 // New выполняет подключение
-// и возвращает объект для взаимодействия с БД
-func New(connString string) (*Postgres, error) {
+// и возвращает объект для взаимодействия с БД.
+// К репликам подключение выполняется при первом обращении,
+// недоступная реплика не мешает запуску
+func New(connString string, opts ...Option) (*Postgres, error) {
+	cfg := config{maxLag: DefaultMaxReplicaLag, interval: DefaultHealthCheckInterval}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.interval <= 0 {
+		return nil, errors.New("health check interval must be positive")
+	}
+	if err := cfg.pool.validate(); err != nil {
+		return nil, err
+	}
 
-	pool, err := pgxpool.Connect(context.Background(), connString)
+	ctx := context.Background()
+
+	pc, err := pgxpool.ParseConfig(connString)
 	if err != nil {
 		return nil, err
 	}
+	cfg.pool.apply(pc)
+
+	pool, err := pgxpool.ConnectConfig(ctx, pc)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Postgres{db: pool, maxLag: cfg.maxLag, interval: cfg.interval}
+
+	for _, cs := range cfg.replicas {
+		rc, err := pgxpool.ParseConfig(cs)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		cfg.pool.apply(rc)
+		rc.LazyConnect = true
+
+		rp, err := pgxpool.ConnectConfig(ctx, rc)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.replicas = append(p.replicas, &replica{db: rp})
+	}
 
-	return &Postgres{db: pool}, nil
+	if len(p.replicas) > 0 {
+		p.checkReplicas()
+		p.stop, p.done = make(chan struct{}), make(chan struct{})
+		go p.monitor()
+	}
+
+	return p, nil
 }
 
 // Close выполняет закрытие подключения к БД
 func (p *Postgres) Close() {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+	}
+	for _, r := range p.replicas {
+		r.db.Close()
+	}
 	p.db.Close()
 }
 
-// AddPost создает пост в БД
-func (p *Postgres) AddPost(post storage.Post) error {
-	tx, err := p.db.Begin(context.Background())
-	if err != nil {
-		return err
+// monitor периодически проверяет реплики
+func (p *Postgres) monitor() {
+	defer close(p.done)
+
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			p.checkReplicas()
+		case <-p.stop:
+			return
+		}
 	}
+}
 
-	defer tx.Rollback(context.Background())
+// replicaLag возвращает отставание реплики в секундах. Реплика,
+// применившая все полученные изменения, не отстает, даже если
+// на основном сервере давно не было транзакций
+const replicaLag = `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE coalesce(extract(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END;
+`
 
-	// добавляем в БД сначала автора, если
-	// передан без id
-	if post.Author.Id == 0 {
-		post.Author.Id, err = p.addAuthor(tx, post.Author)
-		if err != nil {
-			return err
+// checkReplicas проверяет доступность и отставание реплик
+func (p *Postgres) checkReplicas() {
+	for _, r := range p.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+		var lag float64
+		err := r.db.QueryRow(ctx, replicaLag).Scan(&lag)
+		cancel()
+
+		r.healthy.Store(err == nil && time.Duration(lag*float64(time.Second)) <= p.maxLag)
+	}
+}
+
+// replica возвращает следующую по кругу исправную реплику
+// или nil, если чтение должно выполняться на основном сервере
+func (p *Postgres) replica(ctx context.Context) *replica {
+	if len(p.replicas) == 0 || storage.Primary(ctx) {
+		return nil
+	}
+
+	n := uint64(len(p.replicas))
+	start := p.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := p.replicas[(start+i)%n]; r.healthy.Load() {
+			return r
 		}
 	}
+	return nil
+}
 
-	stmt := `
-		INSERT INTO posts(id, title, content, author_id, created_at)
-		VALUES ($1, $2, $3, $4, $5);
-	`
+// read выполняет чтение f на реплике или на основном сервере.
+// Если чтение с реплики не удалось, она исключается до следующей
+// проверки, а чтение повторяется на основном сервере
+func (p *Postgres) read(ctx context.Context, f func(db *pgxpool.Pool) error) error {
+	r := p.replica(ctx)
+	if r == nil {
+		return f(p.db)
+	}
 
-	_, err = tx.Exec(context.Background(), stmt,
-		post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt)
+	err := f(r.db)
+	if err == nil || errors.Is(err, ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+	r.healthy.Store(false)
+	return f(p.db)
+}
+
+// AddPost создает пост в БД
+func (p *Postgres) AddPost(post storage.Post) error {
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit(context.Background())
+	defer tx.Rollback(ctx)
+
+	if err = p.addPost(ctx, tx, post); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 // addAuthor добавляет автора публикации и возвращает его новый id
@@ -80,81 +256,699 @@ func (p *Postgres) addAuthor(tx pgx.Tx, a storage.Author) (int, error) {
 	return id, nil
 }
 
-// Posts возвращает список всех публикаций
-func (p *Postgres) Posts() ([]storage.Post, error) {
-
-	stmt := `
+// selectPosts общая часть запросов публикаций с их авторами
+const selectPosts = `
 		SELECT 
 			p.id, 
 			p.title, 
 			p.content,
 			p.created_at,  
+			p.deleted_at,
+			p.status,
+			p.publish_at,
 			a.name,
-			a.id  
+			a.id,
+			(SELECT array_agg(t.name ORDER BY t.name)
+			FROM post_tags AS pt INNER JOIN tags AS t ON pt.tag_id = t.id
+			WHERE pt.post_id = p.id)
 		FROM
-			posts AS p INNER JOIN authors AS a ON p.author_id = a.id;
+			posts AS p INNER JOIN authors AS a ON p.author_id = a.id
+`
+
+// scanPost читает публикацию из строки результата запроса selectPosts
+func scanPost(row pgx.Row, post *storage.Post) error {
+	return row.Scan(
+		&post.Id, &post.Title, &post.Content, &post.CreatedAt, &post.DeletedAt,
+		&post.Status, &post.PublishAt, &post.Author.Name, &post.Author.Id, &post.Tags)
+}
+
+// Posts возвращает список всех публикаций, кроме удаленных в корзину
+func (p *Postgres) Posts() ([]storage.Post, error) {
+	return p.PostsContext(context.Background())
+}
+
+// PostsContext возвращает список всех публикаций,
+// кроме удаленных в корзину, с учетом контекста
+func (p *Postgres) PostsContext(ctx context.Context) ([]storage.Post, error) {
+	return p.queryPosts(ctx, selectPosts+`WHERE p.deleted_at = 0;`)
+}
+
+// queryPosts выполняет запрос публикаций и возвращает результат
+func (p *Postgres) queryPosts(ctx context.Context, stmt string, args ...any) ([]storage.Post, error) {
+	var posts []storage.Post
+
+	err := p.read(ctx, func(db *pgxpool.Pool) error {
+		rows, err := db.Query(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		posts = nil
+		for rows.Next() {
+			var post storage.Post
+
+			err = scanPost(rows, &post)
+			if err != nil {
+				return err
+			}
+
+			posts = append(posts, post)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// Post возвращает публикацию по id или
+// storage.ErrNotFound, если такой публикации нет
+func (p *Postgres) Post(id int) (storage.Post, error) {
+	return p.PostContext(context.Background(), id)
+}
+
+// PostContext возвращает публикацию по id с учетом контекста
+func (p *Postgres) PostContext(ctx context.Context, id int) (storage.Post, error) {
+	post, err := p.queryPost(ctx, id)
+	if errors.Is(err, ErrNoRows) {
+		return post, storage.ErrNotFound
+	}
+	return post, err
+}
+
+// getPost возвращает публикацию по id с основного сервера
+func (p *Postgres) getPost(id int) (storage.Post, error) {
+	return p.queryPost(storage.WithPrimary(context.Background()), id)
+}
+
+// queryPost выполняет запрос публикации по id
+func (p *Postgres) queryPost(ctx context.Context, id int) (storage.Post, error) {
+	var post storage.Post
+	err := p.read(ctx, func(db *pgxpool.Pool) error {
+		return scanPost(db.QueryRow(ctx, selectPosts+`WHERE p.id = $1 AND p.deleted_at = 0;`, id), &post)
+	})
+	if err != nil {
+		return storage.Post{}, err
+	}
+
+	return post, nil
+}
+
+// updatePost обновляет публикацию и сохраняет ее новую версию.
+// Номер версии следует за последним номером версий публикации
+const updatePost = `
+		WITH p AS (
+			UPDATE posts
+			SET title = $2,
+				content = $3,
+				author_id = $4,
+				created_at = $5,
+				status = $8,
+				publish_at = $9
+			WHERE id = $1 AND deleted_at = 0
+			RETURNING id, title, content, author_id
+		)
+		INSERT INTO revisions(post_id, number, title, content, author_id, editor, created_at)
+		SELECT
+			p.id,
+			coalesce((SELECT max(r.number) FROM revisions AS r WHERE r.post_id = p.id), 0) + 1,
+			p.title,
+			p.content,
+			p.author_id,
+			$6::text,
+			$7::bigint
+		FROM p;
+`
+
+// updateArgs возвращает аргументы запроса updatePost
+func updateArgs(post storage.Post) []any {
+	return []any{post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt,
+		post.Editor, time.Now().Unix(), post.Status, post.PublishAt}
+}
+
+// UpdatePost обновялет публикацию
+func (p *Postgres) UpdatePost(post storage.Post) error {
+
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, updatePost, updateArgs(post)...)
+	if err != nil {
+		return mapErr(err)
+	}
+	if tag.RowsAffected() > 0 {
+		if err = setPostTags(ctx, tx, post); err != nil {
+			return err
+		}
+		if err = addEvents(ctx, tx, storage.EventUpdated, post.Id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeletePost удаляет публикацию в корзину
+func (p *Postgres) DeletePost(post storage.Post) error {
+
+	stmt := `
+		UPDATE posts
+		SET deleted_at = $2
+		WHERE posts.id = $1 AND deleted_at = 0;
+	`
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, stmt, post.Id, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		if err = addEvents(ctx, tx, storage.EventDeleted, post.Id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AddPosts создает публикации пакетом в одной транзакции. В режиме
+// atomic публикации загружаются через COPY, иначе каждая вставляется
+// в отдельной точке сохранения, чтобы ошибка одной не отменяла остальные
+func (p *Postgres) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	errs := make([]error, len(posts))
+
+	if !atomic {
+		for i, post := range posts {
+			errs[i] = savepoint(ctx, tx, func(tx pgx.Tx) error {
+				return p.addPost(ctx, tx, post)
+			})
+		}
+		return errs, tx.Commit(ctx)
+	}
+
+	now := time.Now().Unix()
+	rows := make([][]any, len(posts))
+	revs := make([][]any, len(posts))
+	for i, post := range posts {
+		// авторов без id создаем заранее
+		if post.Author.Id == 0 {
+			post.Author.Id, err = p.addAuthor(tx, post.Author)
+			if err != nil {
+				errs[i] = mapErr(err)
+				return errs, storage.ErrBulkAborted
+			}
+		}
+		rows[i] = []any{post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt,
+			post.Status, post.PublishAt}
+		revs[i] = []any{post.Id, 1, post.Title, post.Content, post.Author.Id, post.Editor, now}
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"posts"},
+		[]string{"id", "title", "content", "author_id", "created_at", "status", "publish_at"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		// COPY не сообщает, какая строка нарушила ограничения
+		tx.Rollback(ctx)
+		return p.failedPost(posts, err)
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"revisions"},
+		[]string{"post_id", "number", "title", "content", "author_id", "editor", "created_at"},
+		pgx.CopyFromRows(revs))
+	if err != nil {
+		return nil, mapErr(err)
+	}
+
+	// теги всех публикаций добавляются двумя запросами
+	var ids []int
+	var tags []string
+	for _, post := range posts {
+		for _, t := range post.Tags {
+			ids = append(ids, post.Id)
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > 0 {
+		if _, err = tx.Exec(ctx, ensureTags, tags); err != nil {
+			return nil, mapErr(err)
+		}
+		stmt := `
+			INSERT INTO post_tags(post_id, tag_id)
+			SELECT x.post_id, t.id
+			FROM unnest($1::integer[], $2::text[]) AS x(post_id, name)
+				INNER JOIN tags AS t ON t.name = x.name
+			ON CONFLICT DO NOTHING;
+		`
+		if _, err = tx.Exec(ctx, stmt, ids, tags); err != nil {
+			return nil, mapErr(err)
+		}
+	}
+
+	created := make([]int, len(posts))
+	for i, post := range posts {
+		created[i] = post.Id
+	}
+	if err = addEvents(ctx, tx, storage.EventCreated, created...); err != nil {
+		return nil, err
+	}
+
+	return errs, tx.Commit(ctx)
+}
+
+// failedPost находит публикацию пакета, из-за которой не удался COPY:
+// публикации вставляются по одной в транзакции, которая затем
+// откатывается. Возвращает storage.ErrBulkAborted с ошибкой
+// найденной публикации или исходную ошибку copyErr
+func (p *Postgres) failedPost(posts []storage.Post, copyErr error) ([]error, error) {
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	errs := make([]error, len(posts))
+	for i, post := range posts {
+		if err = p.addPost(ctx, tx, post); err != nil {
+			errs[i] = err
+			return errs, storage.ErrBulkAborted
+		}
+	}
+	return nil, mapErr(copyErr)
+}
+
+// addPost добавляет публикацию и, если нужно, ее автора в рамках транзакции
+func (p *Postgres) addPost(ctx context.Context, tx pgx.Tx, post storage.Post) error {
+	var err error
+
+	// добавляем в БД сначала автора, если
+	// передан без id
+	if post.Author.Id == 0 {
+		post.Author.Id, err = p.addAuthor(tx, post.Author)
+		if err != nil {
+			return mapErr(err)
+		}
+	}
+
+	// публикация и ее первая версия
+	stmt := `
+		WITH p AS (
+			INSERT INTO posts(id, title, content, author_id, created_at, status, publish_at)
+			VALUES ($1, $2, $3, $4, $5, $8, $9)
+			RETURNING id, title, content, author_id
+		)
+		INSERT INTO revisions(post_id, number, title, content, author_id, editor, created_at)
+		SELECT id, 1, title, content, author_id, $6::text, $7::bigint FROM p;
+	`
+	_, err = tx.Exec(ctx, stmt,
+		post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt,
+		post.Editor, time.Now().Unix(), post.Status, post.PublishAt)
+	if err != nil {
+		return mapErr(err)
+	}
+
+	if err = setPostTags(ctx, tx, post); err != nil {
+		return err
+	}
+	return addEvents(ctx, tx, storage.EventCreated, post.Id)
+}
+
+// ensureTags создает отсутствующие теги
+const ensureTags = `
+		INSERT INTO tags(name)
+		SELECT DISTINCT unnest($1::text[])
+		ON CONFLICT (name) DO NOTHING;
+`
+
+// setTags заменяет теги публикации $1 тегами $2,
+// которые должны быть созданы заранее
+const setTags = `
+		WITH removed AS (
+			DELETE FROM post_tags AS pt
+			USING tags AS t
+			WHERE pt.post_id = $1 AND pt.tag_id = t.id AND t.name <> ALL($2::text[])
+		)
+		INSERT INTO post_tags(post_id, tag_id)
+		SELECT $1, t.id FROM tags AS t WHERE t.name = ANY($2::text[])
+		ON CONFLICT DO NOTHING;
+`
+
+// setPostTags заменяет теги публикации в рамках транзакции
+func setPostTags(ctx context.Context, tx pgx.Tx, post storage.Post) error {
+	tags := tagNames(post.Tags)
+	if _, err := tx.Exec(ctx, ensureTags, tags); err != nil {
+		return mapErr(err)
+	}
+	_, err := tx.Exec(ctx, setTags, post.Id, tags)
+	return mapErr(err)
+}
+
+// tagNames возвращает теги для передачи в запрос,
+// пустой массив вместо NULL для публикации без тегов
+func tagNames(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// UpdatePosts обновляет публикации пакетом
+func (p *Postgres) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return p.bulkExec(posts, atomic, true, storage.EventUpdated, updatePost, updateArgs)
+}
+
+// DeletePosts удаляет публикации пакетом в корзину
+func (p *Postgres) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	stmt := `
+		UPDATE posts
+		SET deleted_at = $2
+		WHERE posts.id = $1 AND deleted_at = 0;
+	`
+	now := time.Now().Unix()
+	return p.bulkExec(posts, atomic, false, storage.EventDeleted, stmt, func(post storage.Post) []any {
+		return []any{post.Id, now}
+	})
+}
+
+// Tags возвращает теги публикаций, не удаленных в корзину
+func (p *Postgres) Tags() ([]storage.Tag, error) {
+	stmt := `
+		SELECT t.name, count(*)
+		FROM tags AS t
+			INNER JOIN post_tags AS pt ON pt.tag_id = t.id
+			INNER JOIN posts AS p ON p.id = pt.post_id
+		WHERE p.deleted_at = 0 AND p.status IN ('published', '')
+		GROUP BY t.name
+		ORDER BY t.name;
 	`
+	ctx := context.Background()
 
-	rows, err := p.db.Query(context.Background(), stmt)
+	var tags []storage.Tag
+	err := p.read(ctx, func(db *pgxpool.Pool) error {
+		rows, err := db.Query(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		tags = nil
+		for rows.Next() {
+			var tag storage.Tag
+
+			err = rows.Scan(&tag.Name, &tag.Posts)
+			if err != nil {
+				return err
+			}
+
+			tags = append(tags, tag)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// PostsByTags возвращает публикации, у которых есть
+// любой из тегов tags или, если all, все теги
+func (p *Postgres) PostsByTags(tags []string, all bool) ([]storage.Post, error) {
+	unique := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		unique[t] = true
+	}
+	need := 1
+	if all {
+		need = len(unique)
+	}
+
+	return p.queryPosts(context.Background(), selectPosts+`
+		WHERE p.deleted_at = 0 AND (
+			SELECT count(*)
+			FROM post_tags AS pt INNER JOIN tags AS t ON pt.tag_id = t.id
+			WHERE pt.post_id = p.id AND t.name = ANY($1::text[])
+		) >= $2;`, tagNames(tags), need)
+}
+
+// RenameTag переименовывает тег from в to. Если тег to уже
+// есть, публикации с тегом from получают тег to, а тег from удаляется
+func (p *Postgres) RenameTag(from, to string) (int, error) {
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var fromId int
+	err = tx.QueryRow(ctx, `SELECT id FROM tags WHERE name = $1 FOR UPDATE;`, from).Scan(&fromId)
+	if errors.Is(err, ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	err = tx.QueryRow(ctx, `SELECT array_agg(post_id ORDER BY post_id) FROM post_tags WHERE tag_id = $1;`,
+		fromId).Scan(&ids)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	var toId int
+	err = tx.QueryRow(ctx, `SELECT id FROM tags WHERE name = $1 FOR UPDATE;`, to).Scan(&toId)
+	switch {
+	case errors.Is(err, ErrNoRows):
+		_, err = tx.Exec(ctx, `UPDATE tags SET name = $2 WHERE id = $1;`, fromId, to)
+		if err != nil {
+			return 0, mapErr(err)
+		}
+
+	case err != nil:
+		return 0, err
+
+	default:
+		// объединение тегов
+		stmt := `
+			INSERT INTO post_tags(post_id, tag_id)
+			SELECT post_id, $2 FROM post_tags WHERE tag_id = $1
+			ON CONFLICT DO NOTHING;
+		`
+		if _, err = tx.Exec(ctx, stmt, fromId, toId); err != nil {
+			return 0, mapErr(err)
+		}
+		if _, err = tx.Exec(ctx, `DELETE FROM tags WHERE id = $1;`, fromId); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = addEvents(ctx, tx, storage.EventUpdated, ids...); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit(ctx)
+}
+
+// Comments возвращает комментарии к публикации
+func (p *Postgres) Comments(postId int) ([]storage.Comment, error) {
+	rows, err := p.db.Query(context.Background(),
+		selectComments+`WHERE post_id = $1 ORDER BY id;`, postId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []storage.Post
+	var comments []storage.Comment
 
 	for rows.Next() {
-		var post storage.Post
+		var c storage.Comment
 
-		err = rows.Scan(
-			&post.Id, &post.Title, &post.Content, &post.CreatedAt,
-			&post.Author.Name, &post.Author.Id)
+		err = scanComment(rows, &c)
 		if err != nil {
 			return nil, err
 		}
 
-		posts = append(posts, post)
+		comments = append(comments, c)
 	}
 
-	return posts, rows.Err()
+	return comments, rows.Err()
 }
 
-// getPost возвращает публикацию по id
-func (p *Postgres) getPost(id int) (storage.Post, error) {
+// Comment возвращает комментарий по id
+func (p *Postgres) Comment(id int) (storage.Comment, error) {
+	var c storage.Comment
+	err := scanComment(p.db.QueryRow(context.Background(),
+		selectComments+`WHERE id = $1;`, id), &c)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Comment{}, storage.ErrNotFound
+	}
+	return c, err
+}
 
+// AddComment создает комментарий к публикации и возвращает его id
+func (p *Postgres) AddComment(c storage.Comment) (int, error) {
 	stmt := `
-		SELECT 
-			p.id, 
-			p.title, 
-			p.content,
-			p.created_at,  
-			a.name,
-			a.id  
-		FROM
-			posts AS p INNER JOIN authors AS a ON p.author_id = a.id
-		WHERE p.id = $1;
+		INSERT INTO comments(post_id, parent_id, author, text, created_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id;
 	`
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().Unix()
+	}
 
-	var post storage.Post
-	err := p.db.QueryRow(context.Background(), stmt, id).Scan(
-		&post.Id, &post.Title, &post.Content, &post.CreatedAt, &post.Author.Name, &post.Author.Id)
+	var id int
+	err := p.db.QueryRow(context.Background(), stmt,
+		c.PostId, c.ParentId, c.Author, c.Text, c.CreatedAt, c.Status).Scan(&id)
+	var pgErr *pgconn.PgError
+	// 23503 - foreign_key_violation: публикации нет
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return 0, storage.ErrNotFound
+	}
+	return id, mapErr(err)
+}
+
+// SetCommentStatus изменяет состояние модерации комментария
+func (p *Postgres) SetCommentStatus(id int, status storage.CommentStatus) error {
+	tag, err := p.db.Exec(context.Background(),
+		`UPDATE comments SET status = $2 WHERE id = $1;`, id, status)
 	if err != nil {
-		return post, err
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
+	return nil
+}
 
-	return post, nil
+// selectComments общая часть запросов комментариев
+const selectComments = `
+		SELECT id, post_id, parent_id, author, text, created_at, status
+		FROM comments
+`
+
+// scanComment читает комментарий из строки результата запроса selectComments
+func scanComment(row pgx.Row, c *storage.Comment) error {
+	return row.Scan(&c.Id, &c.PostId, &c.ParentId, &c.Author, &c.Text, &c.CreatedAt, &c.Status)
 }
 
-// UpdatePost обновялет публикацию
-func (p *Postgres) UpdatePost(post storage.Post) error {
+// Revisions возвращает историю версий публикации
+func (p *Postgres) Revisions(postId int) ([]storage.Revision, error) {
+	rows, err := p.db.Query(context.Background(),
+		selectRevisions+`WHERE r.post_id = $1 ORDER BY r.number;`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revs []storage.Revision
 
+	for rows.Next() {
+		var rev storage.Revision
+
+		err = scanRevision(rows, &rev)
+		if err != nil {
+			return nil, err
+		}
+
+		revs = append(revs, rev)
+	}
+
+	return revs, rows.Err()
+}
+
+// Revision возвращает версию публикации по номеру
+func (p *Postgres) Revision(postId, number int) (storage.Revision, error) {
+	var rev storage.Revision
+	err := scanRevision(p.db.QueryRow(context.Background(),
+		selectRevisions+`WHERE r.post_id = $1 AND r.number = $2;`, postId, number), &rev)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Revision{}, storage.ErrNotFound
+	}
+	return rev, err
+}
+
+// selectRevisions общая часть запросов версий публикаций с их авторами
+const selectRevisions = `
+		SELECT
+			r.post_id,
+			r.number,
+			r.title,
+			r.content,
+			r.editor,
+			r.created_at,
+			a.name,
+			a.id
+		FROM
+			revisions AS r INNER JOIN authors AS a ON r.author_id = a.id
+`
+
+// scanRevision читает версию из строки результата запроса selectRevisions
+func scanRevision(row pgx.Row, rev *storage.Revision) error {
+	return row.Scan(
+		&rev.PostId, &rev.Number, &rev.Title, &rev.Content, &rev.Editor, &rev.CreatedAt,
+		&rev.Author.Name, &rev.Author.Id)
+}
+
+// Trash возвращает публикации в корзине, начиная с последних удаленных.
+// Корзина читается с основного сервера: ее просматривают сразу после удаления
+func (p *Postgres) Trash() ([]storage.Post, error) {
+	return p.queryPosts(storage.WithPrimary(context.Background()), selectPosts+`WHERE p.deleted_at > 0 ORDER BY p.deleted_at DESC, p.id;`)
+}
+
+// TrashedPost возвращает публикацию из корзины по id
+func (p *Postgres) TrashedPost(id int) (storage.Post, error) {
+	var post storage.Post
+	err := scanPost(p.db.QueryRow(context.Background(),
+		selectPosts+`WHERE p.id = $1 AND p.deleted_at > 0;`, id), &post)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return post, err
+}
+
+// RestorePost восстанавливает публикацию из корзины
+func (p *Postgres) RestorePost(post storage.Post) error {
 	stmt := `
 		UPDATE posts
-		SET title = $2,
-			content = $3,
-			author_id = $4,
-			created_at = $5
-		WHERE id = $1;
+		SET deleted_at = 0
+		WHERE id = $1 AND deleted_at > 0;
 	`
 	ctx := context.Background()
 
@@ -165,22 +959,210 @@ func (p *Postgres) UpdatePost(post storage.Post) error {
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, stmt,
-		post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt)
+	tag, err := tx.Exec(ctx, stmt, post.Id)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	if err = addEvents(ctx, tx, storage.EventUpdated, post.Id); err != nil {
+		return err
+	}
 
 	return tx.Commit(ctx)
 }
 
-// DeletePost удаляет публикацию
-func (p *Postgres) DeletePost(post storage.Post) error {
-
+// PurgePosts окончательно удаляет публикации,
+// помещенные в корзину раньше момента before
+func (p *Postgres) PurgePosts(before int64) (int, error) {
 	stmt := `
 		DELETE FROM posts
-		WHERE posts.id = $1;
+		WHERE deleted_at > 0 AND deleted_at < $1;
+	`
+	tag, err := p.db.Exec(context.Background(), stmt, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// PublishScheduled публикует запланированные публикации,
+// время публикации которых наступило
+func (p *Postgres) PublishScheduled(now int64) ([]int, error) {
+	stmt := `
+		UPDATE posts
+		SET status = 'published'
+		WHERE status = 'scheduled' AND publish_at <= $1 AND deleted_at = 0
+		RETURNING id;
+	`
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, stmt, now)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Ints(ids)
+	if err = addEvents(ctx, tx, storage.EventUpdated, ids...); err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit(ctx)
+}
+
+// bulkExec выполняет запрос stmt для каждой публикации в одной
+// транзакции. В режиме atomic запросы отправляются одним пакетом
+// (pgx.Batch) и первая ошибка отменяет транзакцию, иначе каждый
+// запрос выполняется в своей точке сохранения. Публикация,
+// которую запрос не затронул, считается отсутствующей. Если tagged,
+// теги публикаций заменяются тегами из пакета. Для каждой измененной
+// публикации сохраняется событие event
+func (p *Postgres) bulkExec(posts []storage.Post, atomic, tagged bool, event storage.EventType, stmt string,
+	args func(storage.Post) []any) ([]error, error) {
+
+	ctx := context.Background()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	errs := make([]error, len(posts))
+
+	exec := func(tag pgconn.CommandTag, err error) error {
+		if err != nil {
+			return mapErr(err)
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+		return nil
+	}
+
+	if !atomic {
+		for i, post := range posts {
+			errs[i] = savepoint(ctx, tx, func(tx pgx.Tx) error {
+				if err := exec(tx.Exec(ctx, stmt, args(post)...)); err != nil {
+					return err
+				}
+				if tagged {
+					if err := setPostTags(ctx, tx, post); err != nil {
+						return err
+					}
+				}
+				return addEvents(ctx, tx, event, post.Id)
+			})
+		}
+		return errs, tx.Commit(ctx)
+	}
+
+	b := &pgx.Batch{}
+	if tagged {
+		var tags []string
+		for _, post := range posts {
+			tags = append(tags, post.Tags...)
+		}
+		b.Queue(ensureTags, tagNames(tags))
+	}
+	for _, post := range posts {
+		b.Queue(stmt, args(post)...)
+		if tagged {
+			b.Queue(setTags, post.Id, tagNames(post.Tags))
+		}
+	}
+
+	br := tx.SendBatch(ctx, b)
+	if tagged {
+		if _, err = br.Exec(); err != nil {
+			br.Close()
+			return nil, mapErr(err)
+		}
+	}
+	for i := range posts {
+		errs[i] = exec(br.Exec())
+		if errs[i] == nil && tagged {
+			_, err = br.Exec()
+			errs[i] = mapErr(err)
+		}
+		if errs[i] != nil {
+			br.Close()
+			return errs, storage.ErrBulkAborted
+		}
+	}
+	if err = br.Close(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	if err = addEvents(ctx, tx, event, ids...); err != nil {
+		return nil, err
+	}
+
+	return errs, tx.Commit(ctx)
+}
+
+// savepoint выполняет f во вложенной транзакции (точке сохранения).
+// При ошибке изменения f отменяются, но внешняя транзакция продолжается
+func savepoint(ctx context.Context, tx pgx.Tx, f func(pgx.Tx) error) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer sp.Rollback(ctx)
+
+	if err = f(sp); err != nil {
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+// IdempotencyRecord возвращает действующую запись идемпотентности по ключу
+func (p *Postgres) IdempotencyRecord(key string) (storage.IdempotencyRecord, error) {
+	stmt := `
+		SELECT key, request_hash, status, header, body, expires_at
+		FROM idempotency_keys
+		WHERE key = $1 AND expires_at > now();
 	`
+
+	var rec storage.IdempotencyRecord
+	err := p.db.QueryRow(context.Background(), stmt, key).Scan(
+		&rec.Key, &rec.RequestHash, &rec.Status, &rec.Header, &rec.Body, &rec.ExpiresAt)
+	if errors.Is(err, ErrNoRows) {
+		return rec, storage.ErrNotFound
+	}
+	return rec, err
+}
+
+// AddIdempotencyRecord резервирует ключ идемпотентности. Запись
+// с истекшим сроком заменяется, остальные просроченные записи удаляются
+func (p *Postgres) AddIdempotencyRecord(rec storage.IdempotencyRecord) error {
 	ctx := context.Background()
 
 	tx, err := p.db.Begin(ctx)
@@ -190,14 +1172,68 @@ func (p *Postgres) DeletePost(post storage.Post) error {
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, stmt, post.Id)
+	_, err = tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now();`)
 	if err != nil {
 		return err
 	}
 
+	stmt := `
+		INSERT INTO idempotency_keys(key, request_hash, status, header, body, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (key) DO NOTHING;
+	`
+	tag, err := tx.Exec(ctx, stmt,
+		rec.Key, rec.RequestHash, rec.Status, rec.Header, rec.Body, rec.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrConflict
+	}
+
 	return tx.Commit(ctx)
 }
 
+// UpdateIdempotencyRecord сохраняет ответ на запрос по ключу
+func (p *Postgres) UpdateIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	stmt := `
+		UPDATE idempotency_keys
+		SET request_hash = $2,
+			status = $3,
+			header = $4,
+			body = $5,
+			expires_at = $6
+		WHERE key = $1;
+	`
+	tag, err := p.db.Exec(context.Background(), stmt,
+		rec.Key, rec.RequestHash, rec.Status, rec.Header, rec.Body, rec.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord освобождает ключ идемпотентности
+func (p *Postgres) DeleteIdempotencyRecord(key string) error {
+	_, err := p.db.Exec(context.Background(),
+		`DELETE FROM idempotency_keys WHERE key = $1;`, key)
+	return err
+}
+
+// mapErr приводит ошибки нарушения ограничений
+// целостности к storage.ErrConflict
+func mapErr(err error) error {
+	var pgErr *pgconn.PgError
+	// класс 23 - integrity constraint violation
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23") {
+		return fmt.Errorf("%w: %s", storage.ErrConflict, pgErr.Message)
+	}
+	return err
+}
+
 func (p *Postgres) testCleanUp() error {
 
 	b, err := os.ReadFile("testdata/testCleanUp.sql")
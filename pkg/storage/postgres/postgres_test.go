@@ -2,9 +2,14 @@ package postgres
 
 import (
 	"GoNews/pkg/storage"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 var db *Postgres
@@ -50,6 +55,21 @@ func TestPostgres_Posts(t *testing.T) {
 	}
 }
 
+func TestPostgres_Post(t *testing.T) {
+	post, err := db.Post(2)
+	if err != nil {
+		t.Fatalf("postgres.Post() = error %v\n", err)
+	}
+	if post.Id != 2 || post.Author.Id != 2 {
+		t.Fatalf("postgres.Post() = %v, want post 2 of author 2\n", post)
+	}
+
+	_, err = db.Post(100)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
 func TestPostgres_AddPost(t *testing.T) {
 	newpost := storage.Post{
 		Id:        3,
@@ -68,7 +88,7 @@ func TestPostgres_AddPost(t *testing.T) {
 		t.Fatalf("postgres.getPost() = error %v\n", err)
 	}
 
-	if post != newpost {
+	if !reflect.DeepEqual(post, newpost) {
 		t.Fatalf("postgres.AddPost() = %v, want %v\n", post, newpost)
 	}
 }
@@ -91,7 +111,7 @@ func TestPostgres_UpdatePost(t *testing.T) {
 		t.Fatalf("postgres.getPost() = error %v\n", err)
 	}
 
-	if post != newpost {
+	if !reflect.DeepEqual(post, newpost) {
 		t.Fatalf("postgres.UpdatePost() = %v, want %v\n", post, newpost)
 	}
 }
@@ -107,7 +127,418 @@ func TestPostgres_DeletePost(t *testing.T) {
 	if err != nil && err != ErrNoRows {
 		t.Fatalf("postgres.getPost() = error %v\n", err)
 	}
-	if post != (storage.Post{}) {
+	if !reflect.DeepEqual(post, storage.Post{}) {
 		t.Fatalf("postgres.DeletePost() = %v, want nothing\n", post)
 	}
 }
+
+func TestPostgres_AddPosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Bulk title 10", Content: "Bulk content"},
+		{Id: 2, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err := db.AddPosts(posts, false)
+	if err != nil {
+		t.Fatalf("postgres.AddPosts() = error %v\n", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("postgres.AddPosts() = %v, want [nil %v]\n", errs, storage.ErrConflict)
+	}
+
+	posts = []storage.Post{
+		{Id: 11, Author: storage.Author{Name: "Bulk Author"}, Title: "Bulk title 11", Content: "Bulk content"},
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err = db.AddPosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || errs[0] != nil || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("postgres.AddPosts(atomic) = %v, error %v, want %v for post 10\n",
+			errs, err, storage.ErrConflict)
+	}
+	if _, err = db.Post(11); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestPostgres_UpdatePosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 2}, Title: "Bulk updated", Content: "Bulk content"},
+		{Id: 404, Author: storage.Author{Id: 2}, Title: "Missing", Content: "Bulk content"},
+	}
+	errs, err := db.UpdatePosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("postgres.UpdatePosts(atomic) = %v, error %v, want %v for post 404\n",
+			errs, err, storage.ErrNotFound)
+	}
+	post, err := db.Post(10)
+	if err != nil {
+		t.Fatalf("postgres.Post() = error %v\n", err)
+	}
+	if post.Title != "Bulk title 10" {
+		t.Fatalf("postgres.UpdatePosts(atomic) applied aborted update: %v\n", post)
+	}
+
+	errs, err = db.UpdatePosts(posts, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("postgres.UpdatePosts() = %v, error %v\n", errs, err)
+	}
+}
+
+func TestPostgres_DeletePosts(t *testing.T) {
+	errs, err := db.DeletePosts([]storage.Post{{Id: 10}, {Id: 404}}, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("postgres.DeletePosts() = %v, error %v\n", errs, err)
+	}
+	if _, err = db.Post(10); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestPostgres_IdempotencyRecord(t *testing.T) {
+	rec := storage.IdempotencyRecord{
+		Key:         "test:key-1",
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour).Truncate(time.Microsecond),
+	}
+	err := db.AddIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("postgres.AddIdempotencyRecord() = error %v\n", err)
+	}
+	err = db.AddIdempotencyRecord(rec)
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("postgres.AddIdempotencyRecord() = error %v, want %v\n", err, storage.ErrConflict)
+	}
+
+	rec.Status = 201
+	rec.Header = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Body = []byte(`{"data": 1}`)
+	err = db.UpdateIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("postgres.UpdateIdempotencyRecord() = error %v\n", err)
+	}
+
+	got, err := db.IdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("postgres.IdempotencyRecord() = error %v\n", err)
+	}
+	if got.Status != rec.Status || string(got.Body) != string(rec.Body) ||
+		got.Header["Content-Type"][0] != "application/json" || !got.ExpiresAt.Equal(rec.ExpiresAt) {
+		t.Fatalf("postgres.IdempotencyRecord() = %v, want %v\n", got, rec)
+	}
+
+	err = db.DeleteIdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("postgres.DeleteIdempotencyRecord() = error %v\n", err)
+	}
+	_, err = db.IdempotencyRecord(rec.Key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.IdempotencyRecord() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestPostgres_Trash(t *testing.T) {
+	// публикация 1 удалена в корзину в TestPostgres_DeletePost
+	post, err := db.TrashedPost(1)
+	if err != nil {
+		t.Fatalf("postgres.TrashedPost() = error %v\n", err)
+	}
+	if post.DeletedAt == 0 {
+		t.Fatalf("postgres.TrashedPost() = %v, want deletion time\n", post)
+	}
+
+	err = db.RestorePost(storage.Post{Id: 1})
+	if err != nil {
+		t.Fatalf("postgres.RestorePost() = error %v\n", err)
+	}
+	if _, err = db.Post(1); err != nil {
+		t.Fatalf("postgres.Post() of restored post = error %v\n", err)
+	}
+	err = db.RestorePost(storage.Post{Id: 1})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.RestorePost() of live post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	trash, err := db.Trash()
+	if err != nil {
+		t.Fatalf("postgres.Trash() = error %v\n", err)
+	}
+	n, err := db.PurgePosts(time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("postgres.PurgePosts() = error %v\n", err)
+	}
+	if n != len(trash) {
+		t.Fatalf("postgres.PurgePosts() = %d posts, want %d\n", n, len(trash))
+	}
+}
+
+func TestPostgres_Revisions(t *testing.T) {
+	post := storage.Post{Id: 50, Title: "v1", Content: "Lorem ipsum", Author: storage.Author{Id: 1}, Editor: "ivan"}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("postgres.AddPost() = error %v\n", err)
+	}
+	post.Title, post.Editor = "v2", "olga"
+	if err := db.UpdatePost(post); err != nil {
+		t.Fatalf("postgres.UpdatePost() = error %v\n", err)
+	}
+
+	revs, err := db.Revisions(50)
+	if err != nil {
+		t.Fatalf("postgres.Revisions() = error %v\n", err)
+	}
+	if len(revs) != 2 || revs[0].Title != "v1" || revs[1].Title != "v2" || revs[1].Editor != "olga" {
+		t.Fatalf("postgres.Revisions() = %v, want v1 by ivan and v2 by olga\n", revs)
+	}
+
+	rev, err := db.Revision(50, 1)
+	if err != nil {
+		t.Fatalf("postgres.Revision() = error %v\n", err)
+	}
+	if rev.Number != 1 || rev.Editor != "ivan" || rev.Author.Id != 1 {
+		t.Fatalf("postgres.Revision() = %v, want first revision\n", rev)
+	}
+	_, err = db.Revision(50, 3)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.Revision() of missing revision = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestPostgres_PublishScheduled(t *testing.T) {
+	publishAt := time.Now().Add(time.Hour).Unix()
+	post := storage.Post{Id: 60, Title: "scheduled", Author: storage.Author{Id: 1},
+		Status: storage.StatusScheduled, PublishAt: publishAt}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("postgres.AddPost() = error %v\n", err)
+	}
+
+	ids, err := db.PublishScheduled(publishAt)
+	if err != nil {
+		t.Fatalf("postgres.PublishScheduled() = error %v\n", err)
+	}
+	if len(ids) != 1 || ids[0] != 60 {
+		t.Fatalf("postgres.PublishScheduled() = %v, want [60]\n", ids)
+	}
+
+	got, err := db.Post(60)
+	if err != nil {
+		t.Fatalf("postgres.Post() = error %v\n", err)
+	}
+	if got.Status != storage.StatusPublished {
+		t.Fatalf("postgres.Post() status = %q, want %q\n", got.Status, storage.StatusPublished)
+	}
+}
+
+func TestPostgres_Tags(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 70, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go", "news"}},
+		{Id: 71, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go"}},
+		{Id: 72, Title: "draft", Author: storage.Author{Id: 1}, Tags: []string{"draft"}, Status: storage.StatusDraft},
+	}
+	if _, err := db.AddPosts(posts, true); err != nil {
+		t.Fatalf("postgres.AddPosts() = error %v\n", err)
+	}
+
+	got, err := db.PostsByTags([]string{"go", "news"}, true)
+	if err != nil {
+		t.Fatalf("postgres.PostsByTags() = error %v\n", err)
+	}
+	if len(got) != 1 || got[0].Id != 70 || !reflect.DeepEqual(got[0].Tags, []string{"go", "news"}) {
+		t.Fatalf("postgres.PostsByTags(all) = %v, want post 70\n", got)
+	}
+
+	n, err := db.RenameTag("news", "go")
+	if err != nil {
+		t.Fatalf("postgres.RenameTag() = error %v\n", err)
+	}
+	if n != 1 {
+		t.Fatalf("postgres.RenameTag() = %d posts, want 1\n", n)
+	}
+	_, err = db.RenameTag("news", "go")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.RenameTag() of missing tag = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	tags, err := db.Tags()
+	if err != nil {
+		t.Fatalf("postgres.Tags() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(tags, []storage.Tag{{Name: "go", Posts: 2}}) {
+		t.Fatalf("postgres.Tags() = %v, want go with 2 posts\n", tags)
+	}
+}
+
+func TestPostgres_Comments(t *testing.T) {
+	post := storage.Post{Id: 80, Title: "commented", Author: storage.Author{Id: 1}}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("postgres.AddPost() = error %v\n", err)
+	}
+
+	_, err := db.AddComment(storage.Comment{PostId: 81, Text: "lost"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.AddComment() to missing post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	id, err := db.AddComment(storage.Comment{PostId: 80, Author: "reader", Text: "first", Status: storage.CommentPending})
+	if err != nil {
+		t.Fatalf("postgres.AddComment() = error %v\n", err)
+	}
+	reply, err := db.AddComment(storage.Comment{PostId: 80, ParentId: id, Author: "editor", Text: "reply",
+		Status: storage.CommentApproved})
+	if err != nil {
+		t.Fatalf("postgres.AddComment() = error %v\n", err)
+	}
+
+	if err = db.SetCommentStatus(id, storage.CommentApproved); err != nil {
+		t.Fatalf("postgres.SetCommentStatus() = error %v\n", err)
+	}
+	err = db.SetCommentStatus(reply+100, storage.CommentApproved)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.SetCommentStatus() of missing comment = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	comments, err := db.Comments(80)
+	if err != nil {
+		t.Fatalf("postgres.Comments() = error %v\n", err)
+	}
+	if len(comments) != 2 || comments[0].Id != id || comments[1].ParentId != id ||
+		comments[0].Status != storage.CommentApproved || comments[0].CreatedAt == 0 {
+		t.Fatalf("postgres.Comments() = %v, want approved comment %d with reply\n", comments, id)
+	}
+
+	if err = db.DeletePost(post); err != nil {
+		t.Fatalf("postgres.DeletePost() = error %v\n", err)
+	}
+	if _, err = db.PurgePosts(time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("postgres.PurgePosts() = error %v\n", err)
+	}
+	_, err = db.Comment(reply)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("postgres.Comment() of purged post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestPostgres_Replicas(t *testing.T) {
+	dbUrl := os.Getenv("POSTGRES_DB_TEST_URL")
+
+	// тестовая БД служит своей же репликой, вторая реплика недоступна
+	p, err := New(dbUrl, WithReplicas(dbUrl, "postgres://nobody@127.0.0.1:1/none"))
+	if err != nil {
+		t.Fatalf("postgres.New() = error %v\n", err)
+	}
+	defer p.Close()
+
+	if !p.replicas[0].healthy.Load() || p.replicas[1].healthy.Load() {
+		t.Fatalf("postgres.checkReplicas() = %v, %v, want healthy and unhealthy replica\n",
+			p.replicas[0].healthy.Load(), p.replicas[1].healthy.Load())
+	}
+	for i := 0; i < 2; i++ {
+		if r := p.replica(context.Background()); r != p.replicas[0] {
+			t.Fatal("postgres.replica() returned not the healthy replica")
+		}
+	}
+	if r := p.replica(storage.WithPrimary(context.Background())); r != nil {
+		t.Fatal("postgres.replica() for primary read returned replica")
+	}
+
+	want, _ := db.Posts()
+	posts, err := p.Posts()
+	if err != nil || len(posts) != len(want) {
+		t.Fatalf("postgres.Posts() = %d posts, error %v, want %d\n", len(posts), err, len(want))
+	}
+
+	// отставание сверх допустимого исключает реплику
+	p.maxLag = -time.Second
+	p.checkReplicas()
+	if r := p.replica(context.Background()); r != nil {
+		t.Fatal("postgres.replica() returned lagging replica")
+	}
+}
+
+func TestPostgres_PoolConfig(t *testing.T) {
+	_, err := New(os.Getenv("POSTGRES_DB_TEST_URL"), WithPool(PoolConfig{MaxConns: 1, MinConns: 2}))
+	if err == nil {
+		t.Fatal("postgres.New() with min pool size above max = nil error\n")
+	}
+
+	p, err := New(os.Getenv("POSTGRES_DB_TEST_URL"), WithPool(PoolConfig{
+		MaxConns:         3,
+		StatementTimeout: 50 * time.Millisecond,
+		ApplicationName:  "gonews-test",
+	}))
+	if err != nil {
+		t.Fatalf("postgres.New() = error %v\n", err)
+	}
+	defer p.Close()
+
+	ctx := context.Background()
+	var timeout, name string
+	err = p.db.QueryRow(ctx, `SELECT current_setting('statement_timeout'), current_setting('application_name');`).
+		Scan(&timeout, &name)
+	if err != nil {
+		t.Fatalf("postgres settings = error %v\n", err)
+	}
+	if timeout != "50ms" || name != "gonews-test" {
+		t.Fatalf("postgres settings = %s, %s, want 50ms, gonews-test\n", timeout, name)
+	}
+	if _, err = p.db.Exec(ctx, `SELECT pg_sleep(1);`); err == nil {
+		t.Fatal("postgres statement timeout is not applied\n")
+	}
+
+	health, err := p.Check(ctx)
+	if err != nil {
+		t.Fatalf("postgres.Check() = error %v\n", err)
+	}
+	want := map[string]string{"max_conns": "3", "statement_timeout": "50ms", "application_name": "gonews-test"}
+	for k, v := range want {
+		if health.Settings[k] != v {
+			t.Fatalf("postgres.Check() settings %s = %q, want %q\n", k, health.Settings[k], v)
+		}
+	}
+}
+
+func TestPostgres_Outbox(t *testing.T) {
+	if _, err := db.db.Exec(context.Background(), `DELETE FROM outbox;`); err != nil {
+		t.Fatalf("outbox cleanup = error %v\n", err)
+	}
+
+	post := storage.Post{Id: 60, Title: "outbox", Content: "outbox", Author: storage.Author{Id: 1}}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("postgres.AddPost() = error %v\n", err)
+	}
+	// неудачная запись не оставляет событий
+	if err := db.AddPost(post); err == nil {
+		t.Fatal("postgres.AddPost() duplicate = nil error\n")
+	}
+	post.Title = "outbox updated"
+	if err := db.UpdatePost(post); err != nil {
+		t.Fatalf("postgres.UpdatePost() = error %v\n", err)
+	}
+	if err := db.DeletePost(post); err != nil {
+		t.Fatalf("postgres.DeletePost() = error %v\n", err)
+	}
+
+	events, err := db.Events(10)
+	if err != nil {
+		t.Fatalf("postgres.Events() = error %v\n", err)
+	}
+	want := []storage.EventType{storage.EventCreated, storage.EventUpdated, storage.EventDeleted}
+	if len(events) != len(want) {
+		t.Fatalf("postgres.Events() = %v, want %v\n", events, want)
+	}
+	for i, e := range events {
+		if e.Type != want[i] || e.PostId != post.Id || i > 0 && e.Id <= events[i-1].Id {
+			t.Fatalf("postgres.Events() = %v, want %v in order\n", events, want)
+		}
+	}
+
+	var payload storage.Post
+	if err = json.Unmarshal(events[1].Payload, &payload); err != nil || payload.Title != "outbox updated" {
+		t.Fatalf("updated event payload = %s, error %v\n", events[1].Payload, err)
+	}
+
+	if err = db.AckEvents(events[0].Id, events[1].Id); err != nil {
+		t.Fatalf("postgres.AckEvents() = error %v\n", err)
+	}
+	events, _ = db.Events(10)
+	if len(events) != 1 || events[0].Type != storage.EventDeleted {
+		t.Fatalf("postgres.Events() after ack = %v, want deleted event\n", events)
+	}
+}
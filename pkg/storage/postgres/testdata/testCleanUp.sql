@@ -1,4 +1,4 @@
-DROP TABLE IF EXISTS authors, posts;
+DROP TABLE IF EXISTS authors, posts, revisions, tags, post_tags, comments, idempotency_keys, outbox;
 
 -- таблица авторы
 CREATE TABLE IF NOT EXISTS authors (
@@ -13,10 +13,79 @@ CREATE TABLE IF NOT EXISTS posts (
 	content TEXT NOT NULL,
 	author_id INTEGER DEFAULT 0,
 	created_at BIGINT NOT NULL DEFAULT extract(epoch from now()),
+	deleted_at BIGINT NOT NULL DEFAULT 0, -- время удаления в корзину, 0 - не удалена
+	status TEXT NOT NULL DEFAULT 'published', -- draft, scheduled, published, archived
+	publish_at BIGINT NOT NULL DEFAULT 0, -- время публикации
 	FOREIGN KEY(author_id) REFERENCES authors(id)
 );
+CREATE INDEX IF NOT EXISTS posts_deleted_at_idx ON posts(deleted_at) WHERE deleted_at > 0;
+CREATE INDEX IF NOT EXISTS posts_publish_at_idx ON posts(publish_at) WHERE status = 'scheduled';
+
+-- таблица тегов
+CREATE TABLE IF NOT EXISTS tags (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+-- теги публикаций
+CREATE TABLE IF NOT EXISTS post_tags (
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (post_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS post_tags_tag_id_idx ON post_tags(tag_id);
+
+-- таблица версий публикаций, editor - пользователь,
+-- создавший или изменивший публикацию
+CREATE TABLE IF NOT EXISTS revisions (
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	number INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	author_id INTEGER REFERENCES authors(id),
+	editor TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL DEFAULT extract(epoch from now()),
+	PRIMARY KEY (post_id, number)
+);
+
+-- таблица комментариев, parent_id - комментарий, на который
+-- дан ответ, 0 - комментарий к публикации
+CREATE TABLE IF NOT EXISTS comments (
+	id SERIAL PRIMARY KEY,
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	parent_id INTEGER NOT NULL DEFAULT 0,
+	author TEXT NOT NULL,
+	text TEXT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT extract(epoch from now()),
+	status TEXT NOT NULL DEFAULT 'pending' -- pending, approved, rejected
+);
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments(post_id);
+
+-- таблица ключей идемпотентности запросов,
+-- status = 0 - запрос еще выполняется
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	header JSONB,
+	body BYTEA,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
+
+-- таблица исходящих событий изменения публикаций, событие
+-- удаляется после подтверждения доставки
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	post_id INTEGER NOT NULL,
+	type TEXT NOT NULL, -- created, updated, deleted
+	payload JSONB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT extract(epoch from now())
+);
 
 INSERT INTO authors(id, name) VALUES(1, 'Иван Иванов'), (2, 'Петр Петров');
 INSERT INTO posts(id, title, content, author_id, created_at) 
 VALUES (1, 'Постгрес Публикация номер 1', 'Lorem ipsum 1', 1, 1652355804),
-(2, 'Постгрес Публикация номер 2', 'Lorem ipsum 2', 2, 1652355830);
\ No newline at end of file
+(2, 'Постгрес Публикация номер 2', 'Lorem ipsum 2', 2, 1652355830);
+INSERT INTO revisions(post_id, number, title, content, author_id, created_at)
+SELECT id, 1, title, content, author_id, created_at FROM posts;
@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations скрипты миграций схемы БД. Номер миграции задается
+// префиксом имени файла до символа '_', например 0001_init.sql
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrate применяет миграции, номер которых больше версии схемы БД.
+// Версия хранится в PRAGMA user_version, каждая миграция
+// выполняется в отдельной транзакции вместе с изменением версии
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		n, err := migrationNumber(name)
+		if err != nil {
+			return err
+		}
+		if n <= version {
+			continue
+		}
+
+		b, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err = applyMigration(ctx, db, n, string(b)); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		version = n
+	}
+	return nil
+}
+
+// applyMigration выполняет скрипт миграции number
+func applyMigration(ctx context.Context, db *sql.DB, number int, script string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	// PRAGMA не поддерживает параметры запроса
+	if _, err = tx.ExecContext(ctx, `PRAGMA user_version = `+strconv.Itoa(number)+`;`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrationNumber возвращает номер миграции по имени файла
+func migrationNumber(name string) (int, error) {
+	base := strings.TrimPrefix(name, "migrations/")
+	prefix, _, ok := strings.Cut(base, "_")
+	if !ok {
+		return 0, fmt.Errorf("migration %s: name must start with number and '_'", name)
+	}
+	n, err := strconv.Atoi(prefix)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("migration %s: invalid number %q", name, prefix)
+	}
+	return n, nil
+}
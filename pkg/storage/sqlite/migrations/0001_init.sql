@@ -0,0 +1,72 @@
+-- таблица авторы
+CREATE TABLE authors (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+-- таблица публикации
+CREATE TABLE posts (
+	id INTEGER PRIMARY KEY,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	author_id INTEGER DEFAULT 0 REFERENCES authors(id),
+	created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+	deleted_at INTEGER NOT NULL DEFAULT 0, -- время удаления в корзину, 0 - не удалена
+	status TEXT NOT NULL DEFAULT 'published', -- draft, scheduled, published, archived
+	publish_at INTEGER NOT NULL DEFAULT 0 -- время публикации
+);
+CREATE INDEX posts_deleted_at_idx ON posts(deleted_at) WHERE deleted_at > 0;
+CREATE INDEX posts_publish_at_idx ON posts(publish_at) WHERE status = 'scheduled';
+
+-- таблица тегов
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+-- теги публикаций
+CREATE TABLE post_tags (
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (post_id, tag_id)
+);
+CREATE INDEX post_tags_tag_id_idx ON post_tags(tag_id);
+
+-- таблица версий публикаций, editor - пользователь,
+-- создавший или изменивший публикацию
+CREATE TABLE revisions (
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	number INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	author_id INTEGER REFERENCES authors(id),
+	editor TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+	PRIMARY KEY (post_id, number)
+);
+
+-- таблица комментариев, parent_id - комментарий, на который
+-- дан ответ, 0 - комментарий к публикации
+CREATE TABLE comments (
+	id INTEGER PRIMARY KEY,
+	post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	parent_id INTEGER NOT NULL DEFAULT 0,
+	author TEXT NOT NULL,
+	text TEXT NOT NULL,
+	created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+	status TEXT NOT NULL DEFAULT 'pending' -- pending, approved, rejected
+);
+CREATE INDEX comments_post_id_idx ON comments(post_id);
+
+-- таблица ключей идемпотентности запросов,
+-- status = 0 - запрос еще выполняется,
+-- header - заголовки ответа в json, expires_at - в наносекундах
+CREATE TABLE idempotency_keys (
+	key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	header TEXT,
+	body BLOB,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
@@ -0,0 +1,829 @@
+package sqlite
+
+import (
+	"GoNews/pkg/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrNoRows = sql.ErrNoRows
+
+// SQLite выполняет CRUD операции со встроенной БД SQLite,
+// предназначена для развертывания на одном узле и разработки
+type SQLite struct {
+	db *sql.DB
+}
+
+// New открывает файл БД path, создавая его при необходимости,
+// применяет миграции схемы и возвращает объект для взаимодействия
+// с БД. Включаются внешние ключи и журнал WAL. Транзакции сразу
+// захватывают блокировку записи, конкурирующие запросы ожидают ее
+func New(path string) (*SQLite, error) {
+	dsn := "file:" + path +
+		"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Close выполняет закрытие подключения к БД
+func (s *SQLite) Close() {
+	s.db.Close()
+}
+
+// AddPost создает пост в БД
+func (s *SQLite) AddPost(post storage.Post) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err = addPost(ctx, tx, post); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addAuthor добавляет автора публикации и возвращает его новый id
+func addAuthor(ctx context.Context, tx *sql.Tx, a storage.Author) (int, error) {
+	stmt := `
+		INSERT INTO authors(name)
+		VALUES (?) RETURNING id;
+	`
+	var id int
+	err := tx.QueryRowContext(ctx, stmt, a.Name).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// addPost добавляет публикацию, ее первую версию и,
+// если нужно, ее автора в рамках транзакции
+func addPost(ctx context.Context, tx *sql.Tx, post storage.Post) error {
+	var err error
+
+	// добавляем в БД сначала автора, если
+	// передан без id
+	if post.Author.Id == 0 {
+		post.Author.Id, err = addAuthor(ctx, tx, post.Author)
+		if err != nil {
+			return mapErr(err)
+		}
+	}
+
+	stmt := `
+		INSERT INTO posts(id, title, content, author_id, created_at, status, publish_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`
+	_, err = tx.ExecContext(ctx, stmt,
+		post.Id, post.Title, post.Content, post.Author.Id, post.CreatedAt, post.Status, post.PublishAt)
+	if err != nil {
+		return mapErr(err)
+	}
+
+	stmt = `
+		INSERT INTO revisions(post_id, number, title, content, author_id, editor, created_at)
+		VALUES (?, 1, ?, ?, ?, ?, ?);
+	`
+	_, err = tx.ExecContext(ctx, stmt,
+		post.Id, post.Title, post.Content, post.Author.Id, post.Editor, time.Now().Unix())
+	if err != nil {
+		return mapErr(err)
+	}
+
+	return setPostTags(ctx, tx, post)
+}
+
+// selectPosts общая часть запросов публикаций с их авторами,
+// теги публикации возвращаются json-массивом
+const selectPosts = `
+		SELECT
+			p.id,
+			p.title,
+			p.content,
+			p.created_at,
+			p.deleted_at,
+			p.status,
+			p.publish_at,
+			a.name,
+			a.id,
+			(SELECT json_group_array(name) FROM (
+				SELECT t.name
+				FROM post_tags AS pt INNER JOIN tags AS t ON pt.tag_id = t.id
+				WHERE pt.post_id = p.id
+				ORDER BY t.name))
+		FROM
+			posts AS p INNER JOIN authors AS a ON p.author_id = a.id
+`
+
+// scanPost читает публикацию из строки результата запроса selectPosts
+func scanPost(row interface{ Scan(...any) error }, post *storage.Post) error {
+	var tags string
+	err := row.Scan(
+		&post.Id, &post.Title, &post.Content, &post.CreatedAt, &post.DeletedAt,
+		&post.Status, &post.PublishAt, &post.Author.Name, &post.Author.Id, &tags)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(tags), &post.Tags); err != nil {
+		return err
+	}
+	// публикация без тегов, как и в других БД
+	if len(post.Tags) == 0 {
+		post.Tags = nil
+	}
+	return nil
+}
+
+// Posts возвращает список всех публикаций, кроме удаленных в корзину
+func (s *SQLite) Posts() ([]storage.Post, error) {
+	return s.queryPosts(selectPosts + `WHERE p.deleted_at = 0;`)
+}
+
+// queryPosts выполняет запрос публикаций и возвращает результат
+func (s *SQLite) queryPosts(stmt string, args ...any) ([]storage.Post, error) {
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []storage.Post
+
+	for rows.Next() {
+		var post storage.Post
+
+		err = scanPost(rows, &post)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// Post возвращает публикацию по id или
+// storage.ErrNotFound, если такой публикации нет
+func (s *SQLite) Post(id int) (storage.Post, error) {
+	post, err := s.getPost(id)
+	if errors.Is(err, ErrNoRows) {
+		return post, storage.ErrNotFound
+	}
+	return post, err
+}
+
+// getPost возвращает публикацию по id
+func (s *SQLite) getPost(id int) (storage.Post, error) {
+	var post storage.Post
+	err := scanPost(s.db.QueryRow(selectPosts+`WHERE p.id = ? AND p.deleted_at = 0;`, id), &post)
+	if err != nil {
+		return storage.Post{}, err
+	}
+
+	return post, nil
+}
+
+// UpdatePost обновялет публикацию
+func (s *SQLite) UpdatePost(post storage.Post) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = updatePost(ctx, tx, post)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updatePost обновляет публикацию, сохраняет ее новую версию
+// и заменяет теги. Номер версии следует за последним номером
+// версий публикации. Если публикации нет, возвращает storage.ErrNotFound
+func updatePost(ctx context.Context, tx *sql.Tx, post storage.Post) error {
+	stmt := `
+		UPDATE posts
+		SET title = ?,
+			content = ?,
+			author_id = ?,
+			created_at = ?,
+			status = ?,
+			publish_at = ?
+		WHERE id = ? AND deleted_at = 0;
+	`
+	res, err := tx.ExecContext(ctx, stmt, post.Title, post.Content, post.Author.Id,
+		post.CreatedAt, post.Status, post.PublishAt, post.Id)
+	if err = affected(res, err); err != nil {
+		return err
+	}
+
+	stmt = `
+		INSERT INTO revisions(post_id, number, title, content, author_id, editor, created_at)
+		SELECT
+			?1,
+			coalesce((SELECT max(number) FROM revisions WHERE post_id = ?1), 0) + 1,
+			?2, ?3, ?4, ?5, ?6;
+	`
+	_, err = tx.ExecContext(ctx, stmt,
+		post.Id, post.Title, post.Content, post.Author.Id, post.Editor, time.Now().Unix())
+	if err != nil {
+		return mapErr(err)
+	}
+
+	return setPostTags(ctx, tx, post)
+}
+
+// DeletePost удаляет публикацию в корзину
+func (s *SQLite) DeletePost(post storage.Post) error {
+	_, err := s.db.Exec(`UPDATE posts SET deleted_at = ? WHERE id = ? AND deleted_at = 0;`,
+		time.Now().Unix(), post.Id)
+	return err
+}
+
+// deletePost удаляет публикацию в корзину в рамках транзакции
+func deletePost(ctx context.Context, tx *sql.Tx, post storage.Post) error {
+	res, err := tx.ExecContext(ctx, `UPDATE posts SET deleted_at = ? WHERE id = ? AND deleted_at = 0;`,
+		time.Now().Unix(), post.Id)
+	return affected(res, err)
+}
+
+// AddPosts создает публикации пакетом в одной транзакции
+func (s *SQLite) AddPosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return s.bulk(posts, atomic, addPost)
+}
+
+// UpdatePosts обновляет публикации пакетом
+func (s *SQLite) UpdatePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return s.bulk(posts, atomic, updatePost)
+}
+
+// DeletePosts удаляет публикации пакетом в корзину
+func (s *SQLite) DeletePosts(posts []storage.Post, atomic bool) ([]error, error) {
+	return s.bulk(posts, atomic, deletePost)
+}
+
+// bulk выполняет f для каждой публикации в одной транзакции.
+// В режиме atomic первая ошибка отменяет транзакцию, иначе
+// каждая публикация обрабатывается в своей точке сохранения,
+// чтобы ошибка одной не отменяла остальные
+func (s *SQLite) bulk(posts []storage.Post, atomic bool,
+	f func(context.Context, *sql.Tx, storage.Post) error) ([]error, error) {
+
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	errs := make([]error, len(posts))
+
+	for i, post := range posts {
+		if atomic {
+			if errs[i] = f(ctx, tx, post); errs[i] != nil {
+				return errs, storage.ErrBulkAborted
+			}
+			continue
+		}
+		errs[i] = savepoint(ctx, tx, func() error {
+			return f(ctx, tx, post)
+		})
+	}
+
+	return errs, tx.Commit()
+}
+
+// savepoint выполняет f в точке сохранения. При ошибке
+// изменения f отменяются, но транзакция продолжается
+func savepoint(ctx context.Context, tx *sql.Tx, f func() error) error {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT bulk;`); err != nil {
+		return err
+	}
+
+	if err := f(); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO bulk;`); rbErr != nil {
+			return rbErr
+		}
+		_, _ = tx.ExecContext(ctx, `RELEASE bulk;`)
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `RELEASE bulk;`)
+	return err
+}
+
+// setPostTags заменяет теги публикации в рамках транзакции,
+// отсутствующие теги создаются. Теги передаются json-массивом
+func setPostTags(ctx context.Context, tx *sql.Tx, post storage.Post) error {
+	tags, err := tagNames(post.Tags)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		INSERT INTO tags(name)
+		SELECT DISTINCT value FROM json_each(?) WHERE true
+		ON CONFLICT (name) DO NOTHING;
+	`
+	if _, err = tx.ExecContext(ctx, stmt, tags); err != nil {
+		return mapErr(err)
+	}
+
+	stmt = `
+		DELETE FROM post_tags
+		WHERE post_id = ?1 AND tag_id IN (
+			SELECT id FROM tags WHERE name NOT IN (SELECT value FROM json_each(?2)));
+	`
+	if _, err = tx.ExecContext(ctx, stmt, post.Id, tags); err != nil {
+		return err
+	}
+
+	stmt = `
+		INSERT OR IGNORE INTO post_tags(post_id, tag_id)
+		SELECT ?1, id FROM tags WHERE name IN (SELECT value FROM json_each(?2));
+	`
+	_, err = tx.ExecContext(ctx, stmt, post.Id, tags)
+	return mapErr(err)
+}
+
+// tagNames возвращает теги json-массивом для передачи в запрос
+func tagNames(tags []string) (string, error) {
+	if tags == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(tags)
+	return string(b), err
+}
+
+// Tags возвращает теги публикаций, не удаленных в корзину
+func (s *SQLite) Tags() ([]storage.Tag, error) {
+	stmt := `
+		SELECT t.name, count(*)
+		FROM tags AS t
+			INNER JOIN post_tags AS pt ON pt.tag_id = t.id
+			INNER JOIN posts AS p ON p.id = pt.post_id
+		WHERE p.deleted_at = 0 AND p.status IN ('published', '')
+		GROUP BY t.name
+		ORDER BY t.name;
+	`
+	rows, err := s.db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []storage.Tag
+
+	for rows.Next() {
+		var tag storage.Tag
+
+		err = rows.Scan(&tag.Name, &tag.Posts)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// PostsByTags возвращает публикации, у которых есть
+// любой из тегов tags или, если all, все теги
+func (s *SQLite) PostsByTags(tags []string, all bool) ([]storage.Post, error) {
+	unique := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		unique[t] = true
+	}
+	need := 1
+	if all {
+		need = len(unique)
+	}
+
+	names, err := tagNames(tags)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryPosts(selectPosts+`
+		WHERE p.deleted_at = 0 AND (
+			SELECT count(*)
+			FROM post_tags AS pt INNER JOIN tags AS t ON pt.tag_id = t.id
+			WHERE pt.post_id = p.id AND t.name IN (SELECT value FROM json_each(?))
+		) >= ?;`, names, need)
+}
+
+// RenameTag переименовывает тег from в to. Если тег to уже
+// есть, публикации с тегом from получают тег to, а тег from удаляется
+func (s *SQLite) RenameTag(from, to string) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var fromId, n int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM tags WHERE name = ?;`, from).Scan(&fromId)
+	if errors.Is(err, ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM post_tags WHERE tag_id = ?;`, fromId).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	var toId int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM tags WHERE name = ?;`, to).Scan(&toId)
+	switch {
+	case errors.Is(err, ErrNoRows):
+		_, err = tx.ExecContext(ctx, `UPDATE tags SET name = ? WHERE id = ?;`, to, fromId)
+		if err != nil {
+			return 0, mapErr(err)
+		}
+
+	case err != nil:
+		return 0, err
+
+	default:
+		// объединение тегов
+		stmt := `
+			INSERT OR IGNORE INTO post_tags(post_id, tag_id)
+			SELECT post_id, ? FROM post_tags WHERE tag_id = ?;
+		`
+		if _, err = tx.ExecContext(ctx, stmt, toId, fromId); err != nil {
+			return 0, mapErr(err)
+		}
+		if _, err = tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ?;`, fromId); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, tx.Commit()
+}
+
+// Comments возвращает комментарии к публикации
+func (s *SQLite) Comments(postId int) ([]storage.Comment, error) {
+	rows, err := s.db.Query(selectComments+`WHERE post_id = ? ORDER BY id;`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []storage.Comment
+
+	for rows.Next() {
+		var c storage.Comment
+
+		err = scanComment(rows, &c)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
+// Comment возвращает комментарий по id
+func (s *SQLite) Comment(id int) (storage.Comment, error) {
+	var c storage.Comment
+	err := scanComment(s.db.QueryRow(selectComments+`WHERE id = ?;`, id), &c)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Comment{}, storage.ErrNotFound
+	}
+	return c, err
+}
+
+// AddComment создает комментарий к публикации и возвращает его id
+func (s *SQLite) AddComment(c storage.Comment) (int, error) {
+	stmt := `
+		INSERT INTO comments(post_id, parent_id, author, text, created_at, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id;
+	`
+	if c.CreatedAt == 0 {
+		c.CreatedAt = time.Now().Unix()
+	}
+
+	var id int
+	err := s.db.QueryRow(stmt, c.PostId, c.ParentId, c.Author, c.Text, c.CreatedAt, c.Status).Scan(&id)
+	var sqlErr sqlite3.Error
+	// публикации нет
+	if errors.As(err, &sqlErr) && sqlErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return 0, storage.ErrNotFound
+	}
+	return id, mapErr(err)
+}
+
+// SetCommentStatus изменяет состояние модерации комментария
+func (s *SQLite) SetCommentStatus(id int, status storage.CommentStatus) error {
+	res, err := s.db.Exec(`UPDATE comments SET status = ? WHERE id = ?;`, status, id)
+	return affected(res, err)
+}
+
+// selectComments общая часть запросов комментариев
+const selectComments = `
+		SELECT id, post_id, parent_id, author, text, created_at, status
+		FROM comments
+`
+
+// scanComment читает комментарий из строки результата запроса selectComments
+func scanComment(row interface{ Scan(...any) error }, c *storage.Comment) error {
+	return row.Scan(&c.Id, &c.PostId, &c.ParentId, &c.Author, &c.Text, &c.CreatedAt, &c.Status)
+}
+
+// Revisions возвращает историю версий публикации
+func (s *SQLite) Revisions(postId int) ([]storage.Revision, error) {
+	rows, err := s.db.Query(selectRevisions+`WHERE r.post_id = ? ORDER BY r.number;`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revs []storage.Revision
+
+	for rows.Next() {
+		var rev storage.Revision
+
+		err = scanRevision(rows, &rev)
+		if err != nil {
+			return nil, err
+		}
+
+		revs = append(revs, rev)
+	}
+
+	return revs, rows.Err()
+}
+
+// Revision возвращает версию публикации по номеру
+func (s *SQLite) Revision(postId, number int) (storage.Revision, error) {
+	var rev storage.Revision
+	err := scanRevision(s.db.QueryRow(
+		selectRevisions+`WHERE r.post_id = ? AND r.number = ?;`, postId, number), &rev)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Revision{}, storage.ErrNotFound
+	}
+	return rev, err
+}
+
+// selectRevisions общая часть запросов версий публикаций с их авторами
+const selectRevisions = `
+		SELECT
+			r.post_id,
+			r.number,
+			r.title,
+			r.content,
+			r.editor,
+			r.created_at,
+			a.name,
+			a.id
+		FROM
+			revisions AS r INNER JOIN authors AS a ON r.author_id = a.id
+`
+
+// scanRevision читает версию из строки результата запроса selectRevisions
+func scanRevision(row interface{ Scan(...any) error }, rev *storage.Revision) error {
+	return row.Scan(
+		&rev.PostId, &rev.Number, &rev.Title, &rev.Content, &rev.Editor, &rev.CreatedAt,
+		&rev.Author.Name, &rev.Author.Id)
+}
+
+// Trash возвращает публикации в корзине, начиная с последних удаленных
+func (s *SQLite) Trash() ([]storage.Post, error) {
+	return s.queryPosts(selectPosts + `WHERE p.deleted_at > 0 ORDER BY p.deleted_at DESC, p.id;`)
+}
+
+// TrashedPost возвращает публикацию из корзины по id
+func (s *SQLite) TrashedPost(id int) (storage.Post, error) {
+	var post storage.Post
+	err := scanPost(s.db.QueryRow(selectPosts+`WHERE p.id = ? AND p.deleted_at > 0;`, id), &post)
+	if errors.Is(err, ErrNoRows) {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	return post, err
+}
+
+// RestorePost восстанавливает публикацию из корзины
+func (s *SQLite) RestorePost(post storage.Post) error {
+	res, err := s.db.Exec(`UPDATE posts SET deleted_at = 0 WHERE id = ? AND deleted_at > 0;`, post.Id)
+	return affected(res, err)
+}
+
+// PurgePosts окончательно удаляет публикации, помещенные в корзину
+// раньше момента before. Версии, теги и комментарии публикаций
+// удаляются каскадно
+func (s *SQLite) PurgePosts(before int64) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM posts WHERE deleted_at > 0 AND deleted_at < ?;`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// PublishScheduled публикует запланированные публикации,
+// время публикации которых наступило
+func (s *SQLite) PublishScheduled(now int64) ([]int, error) {
+	stmt := `
+		UPDATE posts
+		SET status = 'published'
+		WHERE status = 'scheduled' AND publish_at <= ? AND deleted_at = 0
+		RETURNING id;
+	`
+	rows, err := s.db.Query(stmt, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// IdempotencyRecord возвращает действующую запись идемпотентности по ключу
+func (s *SQLite) IdempotencyRecord(key string) (storage.IdempotencyRecord, error) {
+	stmt := `
+		SELECT key, request_hash, status, header, body, expires_at
+		FROM idempotency_keys
+		WHERE key = ? AND expires_at > ?;
+	`
+
+	var rec storage.IdempotencyRecord
+	var header sql.NullString
+	var expiresAt int64
+	err := s.db.QueryRow(stmt, key, time.Now().UnixNano()).Scan(
+		&rec.Key, &rec.RequestHash, &rec.Status, &header, &rec.Body, &expiresAt)
+	if errors.Is(err, ErrNoRows) {
+		return rec, storage.ErrNotFound
+	}
+	if err != nil {
+		return rec, err
+	}
+	if header.Valid {
+		if err = json.Unmarshal([]byte(header.String), &rec.Header); err != nil {
+			return rec, err
+		}
+	}
+	rec.ExpiresAt = time.Unix(0, expiresAt)
+	return rec, nil
+}
+
+// AddIdempotencyRecord резервирует ключ идемпотентности. Запись
+// с истекшим сроком заменяется, остальные просроченные записи удаляются
+func (s *SQLite) AddIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	ctx := context.Background()
+
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?;`, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		INSERT INTO idempotency_keys(key, request_hash, status, header, body, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO NOTHING;
+	`
+	res, err := tx.ExecContext(ctx, stmt,
+		rec.Key, rec.RequestHash, rec.Status, string(header), rec.Body, rec.ExpiresAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrConflict
+	}
+
+	return tx.Commit()
+}
+
+// UpdateIdempotencyRecord сохраняет ответ на запрос по ключу
+func (s *SQLite) UpdateIdempotencyRecord(rec storage.IdempotencyRecord) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		UPDATE idempotency_keys
+		SET request_hash = ?,
+			status = ?,
+			header = ?,
+			body = ?,
+			expires_at = ?
+		WHERE key = ?;
+	`
+	res, err := s.db.Exec(stmt,
+		rec.RequestHash, rec.Status, string(header), rec.Body, rec.ExpiresAt.UnixNano(), rec.Key)
+	return affected(res, err)
+}
+
+// DeleteIdempotencyRecord освобождает ключ идемпотентности
+func (s *SQLite) DeleteIdempotencyRecord(key string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?;`, key)
+	return err
+}
+
+// affected возвращает storage.ErrNotFound, если запрос
+// не затронул ни одной строки
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return mapErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// mapErr приводит ошибки нарушения ограничений
+// целостности к storage.ErrConflict
+func mapErr(err error) error {
+	var sqlErr sqlite3.Error
+	if errors.As(err, &sqlErr) && sqlErr.Code == sqlite3.ErrConstraint {
+		return fmt.Errorf("%w: %s", storage.ErrConflict, sqlErr.Error())
+	}
+	return err
+}
+
+// testSeed заполняет БД тестовыми данными
+func (s *SQLite) testSeed() error {
+	b, err := os.ReadFile("testdata/seed.sql")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(string(b))
+	return err
+}
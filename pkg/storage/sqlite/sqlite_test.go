@@ -0,0 +1,472 @@
+package sqlite
+
+import (
+	"GoNews/pkg/storage"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var db *SQLite
+
+const (
+	postsNum = 2
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gonews-sqlite")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err = New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+
+	err = db.testSeed()
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+
+	exitCode := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+
+	os.Exit(exitCode)
+}
+
+func TestSQLite_Posts(t *testing.T) {
+	posts, err := db.Posts()
+	if err != nil {
+		t.Fatalf("sqlite.Posts() = error %v\n", err)
+	}
+
+	if len(posts) != postsNum {
+		t.Fatalf("sqlite.Posts() = %d posts in total, want %d\n", len(posts), postsNum)
+	}
+}
+
+func TestSQLite_Post(t *testing.T) {
+	post, err := db.Post(2)
+	if err != nil {
+		t.Fatalf("sqlite.Post() = error %v\n", err)
+	}
+	if post.Id != 2 || post.Author.Id != 2 {
+		t.Fatalf("sqlite.Post() = %v, want post 2 of author 2\n", post)
+	}
+
+	_, err = db.Post(100)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_AddPost(t *testing.T) {
+	newpost := storage.Post{
+		Id:        3,
+		Author:    storage.Author{Id: 1, Name: "Иван Иванов"},
+		Title:     "Test title1",
+		Content:   "Test content1",
+		CreatedAt: 0,
+	}
+	err := db.AddPost(newpost)
+	if err != nil {
+		t.Fatalf("sqlite.AddPost() = error %v\n", err)
+	}
+
+	post, err := db.getPost(newpost.Id)
+	if err != nil {
+		t.Fatalf("sqlite.getPost() = error %v\n", err)
+	}
+
+	if !reflect.DeepEqual(post, newpost) {
+		t.Fatalf("sqlite.AddPost() = %v, want %v\n", post, newpost)
+	}
+}
+
+func TestSQLite_UpdatePost(t *testing.T) {
+	newpost := storage.Post{
+		Id:        1,
+		Author:    storage.Author{Id: 2, Name: "Петр Петров"},
+		Title:     "Updated title",
+		Content:   "Updated content",
+		CreatedAt: 0,
+	}
+	err := db.UpdatePost(newpost)
+	if err != nil {
+		t.Fatalf("sqlite.UpdatePost() = error %v\n", err)
+	}
+
+	post, err := db.getPost(newpost.Id)
+	if err != nil {
+		t.Fatalf("sqlite.getPost() = error %v\n", err)
+	}
+
+	if !reflect.DeepEqual(post, newpost) {
+		t.Fatalf("sqlite.UpdatePost() = %v, want %v\n", post, newpost)
+	}
+}
+
+func TestSQLite_DeletePost(t *testing.T) {
+	p := storage.Post{Id: 1}
+	err := db.DeletePost(p)
+	if err != nil {
+		t.Fatalf("sqlite.DeletePost() = error %v\n", err)
+	}
+
+	post, err := db.getPost(p.Id)
+	if err != nil && err != ErrNoRows {
+		t.Fatalf("sqlite.getPost() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(post, storage.Post{}) {
+		t.Fatalf("sqlite.DeletePost() = %v, want nothing\n", post)
+	}
+}
+
+func TestSQLite_AddPosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Bulk title 10", Content: "Bulk content"},
+		{Id: 2, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err := db.AddPosts(posts, false)
+	if err != nil {
+		t.Fatalf("sqlite.AddPosts() = error %v\n", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("sqlite.AddPosts() = %v, want [nil %v]\n", errs, storage.ErrConflict)
+	}
+
+	posts = []storage.Post{
+		{Id: 11, Author: storage.Author{Name: "Bulk Author"}, Title: "Bulk title 11", Content: "Bulk content"},
+		{Id: 10, Author: storage.Author{Id: 1}, Title: "Duplicate", Content: "Bulk content"},
+	}
+	errs, err = db.AddPosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrConflict) {
+		t.Fatalf("sqlite.AddPosts(atomic) = %v, error %v, want %v for post 10\n",
+			errs, err, storage.ErrConflict)
+	}
+	if _, err = db.Post(11); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_UpdatePosts(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 10, Author: storage.Author{Id: 2}, Title: "Bulk updated", Content: "Bulk content"},
+		{Id: 404, Author: storage.Author{Id: 2}, Title: "Missing", Content: "Bulk content"},
+	}
+	errs, err := db.UpdatePosts(posts, true)
+	if !errors.Is(err, storage.ErrBulkAborted) || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("sqlite.UpdatePosts(atomic) = %v, error %v, want %v for post 404\n",
+			errs, err, storage.ErrNotFound)
+	}
+	post, err := db.Post(10)
+	if err != nil {
+		t.Fatalf("sqlite.Post() = error %v\n", err)
+	}
+	if post.Title != "Bulk title 10" {
+		t.Fatalf("sqlite.UpdatePosts(atomic) applied aborted update: %v\n", post)
+	}
+
+	errs, err = db.UpdatePosts(posts, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("sqlite.UpdatePosts() = %v, error %v\n", errs, err)
+	}
+}
+
+func TestSQLite_DeletePosts(t *testing.T) {
+	errs, err := db.DeletePosts([]storage.Post{{Id: 10}, {Id: 404}}, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], storage.ErrNotFound) {
+		t.Fatalf("sqlite.DeletePosts() = %v, error %v\n", errs, err)
+	}
+	if _, err = db.Post(10); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.Post() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_IdempotencyRecord(t *testing.T) {
+	rec := storage.IdempotencyRecord{
+		Key:         "test:key-1",
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour).Truncate(time.Microsecond),
+	}
+	err := db.AddIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("sqlite.AddIdempotencyRecord() = error %v\n", err)
+	}
+	err = db.AddIdempotencyRecord(rec)
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("sqlite.AddIdempotencyRecord() = error %v, want %v\n", err, storage.ErrConflict)
+	}
+
+	rec.Status = 201
+	rec.Header = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Body = []byte(`{"data": 1}`)
+	err = db.UpdateIdempotencyRecord(rec)
+	if err != nil {
+		t.Fatalf("sqlite.UpdateIdempotencyRecord() = error %v\n", err)
+	}
+
+	got, err := db.IdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("sqlite.IdempotencyRecord() = error %v\n", err)
+	}
+	if got.Status != rec.Status || string(got.Body) != string(rec.Body) ||
+		got.Header["Content-Type"][0] != "application/json" || !got.ExpiresAt.Equal(rec.ExpiresAt) {
+		t.Fatalf("sqlite.IdempotencyRecord() = %v, want %v\n", got, rec)
+	}
+
+	err = db.DeleteIdempotencyRecord(rec.Key)
+	if err != nil {
+		t.Fatalf("sqlite.DeleteIdempotencyRecord() = error %v\n", err)
+	}
+	_, err = db.IdempotencyRecord(rec.Key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.IdempotencyRecord() = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_Trash(t *testing.T) {
+	// публикация 1 удалена в корзину в TestSQLite_DeletePost
+	post, err := db.TrashedPost(1)
+	if err != nil {
+		t.Fatalf("sqlite.TrashedPost() = error %v\n", err)
+	}
+	if post.DeletedAt == 0 {
+		t.Fatalf("sqlite.TrashedPost() = %v, want deletion time\n", post)
+	}
+
+	err = db.RestorePost(storage.Post{Id: 1})
+	if err != nil {
+		t.Fatalf("sqlite.RestorePost() = error %v\n", err)
+	}
+	if _, err = db.Post(1); err != nil {
+		t.Fatalf("sqlite.Post() of restored post = error %v\n", err)
+	}
+	err = db.RestorePost(storage.Post{Id: 1})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.RestorePost() of live post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	trash, err := db.Trash()
+	if err != nil {
+		t.Fatalf("sqlite.Trash() = error %v\n", err)
+	}
+	n, err := db.PurgePosts(time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("sqlite.PurgePosts() = error %v\n", err)
+	}
+	if n != len(trash) {
+		t.Fatalf("sqlite.PurgePosts() = %d posts, want %d\n", n, len(trash))
+	}
+}
+
+func TestSQLite_Revisions(t *testing.T) {
+	post := storage.Post{Id: 50, Title: "v1", Content: "Lorem ipsum", Author: storage.Author{Id: 1}, Editor: "ivan"}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("sqlite.AddPost() = error %v\n", err)
+	}
+	post.Title, post.Editor = "v2", "olga"
+	if err := db.UpdatePost(post); err != nil {
+		t.Fatalf("sqlite.UpdatePost() = error %v\n", err)
+	}
+
+	revs, err := db.Revisions(50)
+	if err != nil {
+		t.Fatalf("sqlite.Revisions() = error %v\n", err)
+	}
+	if len(revs) != 2 || revs[0].Title != "v1" || revs[1].Title != "v2" || revs[1].Editor != "olga" {
+		t.Fatalf("sqlite.Revisions() = %v, want v1 by ivan and v2 by olga\n", revs)
+	}
+
+	rev, err := db.Revision(50, 1)
+	if err != nil {
+		t.Fatalf("sqlite.Revision() = error %v\n", err)
+	}
+	if rev.Number != 1 || rev.Editor != "ivan" || rev.Author.Id != 1 {
+		t.Fatalf("sqlite.Revision() = %v, want first revision\n", rev)
+	}
+	_, err = db.Revision(50, 3)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.Revision() of missing revision = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_PublishScheduled(t *testing.T) {
+	publishAt := time.Now().Add(time.Hour).Unix()
+	post := storage.Post{Id: 60, Title: "scheduled", Author: storage.Author{Id: 1},
+		Status: storage.StatusScheduled, PublishAt: publishAt}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("sqlite.AddPost() = error %v\n", err)
+	}
+
+	ids, err := db.PublishScheduled(publishAt)
+	if err != nil {
+		t.Fatalf("sqlite.PublishScheduled() = error %v\n", err)
+	}
+	if len(ids) != 1 || ids[0] != 60 {
+		t.Fatalf("sqlite.PublishScheduled() = %v, want [60]\n", ids)
+	}
+
+	got, err := db.Post(60)
+	if err != nil {
+		t.Fatalf("sqlite.Post() = error %v\n", err)
+	}
+	if got.Status != storage.StatusPublished {
+		t.Fatalf("sqlite.Post() status = %q, want %q\n", got.Status, storage.StatusPublished)
+	}
+}
+
+func TestSQLite_Tags(t *testing.T) {
+	posts := []storage.Post{
+		{Id: 70, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go", "news"}},
+		{Id: 71, Title: "tagged", Author: storage.Author{Id: 1}, Tags: []string{"go"}},
+		{Id: 72, Title: "draft", Author: storage.Author{Id: 1}, Tags: []string{"draft"}, Status: storage.StatusDraft},
+	}
+	if _, err := db.AddPosts(posts, true); err != nil {
+		t.Fatalf("sqlite.AddPosts() = error %v\n", err)
+	}
+
+	got, err := db.PostsByTags([]string{"go", "news"}, true)
+	if err != nil {
+		t.Fatalf("sqlite.PostsByTags() = error %v\n", err)
+	}
+	if len(got) != 1 || got[0].Id != 70 || !reflect.DeepEqual(got[0].Tags, []string{"go", "news"}) {
+		t.Fatalf("sqlite.PostsByTags(all) = %v, want post 70\n", got)
+	}
+
+	n, err := db.RenameTag("news", "go")
+	if err != nil {
+		t.Fatalf("sqlite.RenameTag() = error %v\n", err)
+	}
+	if n != 1 {
+		t.Fatalf("sqlite.RenameTag() = %d posts, want 1\n", n)
+	}
+	_, err = db.RenameTag("news", "go")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.RenameTag() of missing tag = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	tags, err := db.Tags()
+	if err != nil {
+		t.Fatalf("sqlite.Tags() = error %v\n", err)
+	}
+	if !reflect.DeepEqual(tags, []storage.Tag{{Name: "go", Posts: 2}}) {
+		t.Fatalf("sqlite.Tags() = %v, want go with 2 posts\n", tags)
+	}
+}
+
+func TestSQLite_Comments(t *testing.T) {
+	post := storage.Post{Id: 80, Title: "commented", Author: storage.Author{Id: 1}}
+	if err := db.AddPost(post); err != nil {
+		t.Fatalf("sqlite.AddPost() = error %v\n", err)
+	}
+
+	_, err := db.AddComment(storage.Comment{PostId: 81, Text: "lost"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.AddComment() to missing post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	id, err := db.AddComment(storage.Comment{PostId: 80, Author: "reader", Text: "first", Status: storage.CommentPending})
+	if err != nil {
+		t.Fatalf("sqlite.AddComment() = error %v\n", err)
+	}
+	reply, err := db.AddComment(storage.Comment{PostId: 80, ParentId: id, Author: "editor", Text: "reply",
+		Status: storage.CommentApproved})
+	if err != nil {
+		t.Fatalf("sqlite.AddComment() = error %v\n", err)
+	}
+
+	if err = db.SetCommentStatus(id, storage.CommentApproved); err != nil {
+		t.Fatalf("sqlite.SetCommentStatus() = error %v\n", err)
+	}
+	err = db.SetCommentStatus(reply+100, storage.CommentApproved)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.SetCommentStatus() of missing comment = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+
+	comments, err := db.Comments(80)
+	if err != nil {
+		t.Fatalf("sqlite.Comments() = error %v\n", err)
+	}
+	if len(comments) != 2 || comments[0].Id != id || comments[1].ParentId != id ||
+		comments[0].Status != storage.CommentApproved || comments[0].CreatedAt == 0 {
+		t.Fatalf("sqlite.Comments() = %v, want approved comment %d with reply\n", comments, id)
+	}
+
+	if err = db.DeletePost(post); err != nil {
+		t.Fatalf("sqlite.DeletePost() = error %v\n", err)
+	}
+	if _, err = db.PurgePosts(time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("sqlite.PurgePosts() = error %v\n", err)
+	}
+	_, err = db.Comment(reply)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sqlite.Comment() of purged post = error %v, want %v\n", err, storage.ErrNotFound)
+	}
+}
+
+func TestSQLite_AddPostAuthor(t *testing.T) {
+	newpost := storage.Post{Id: 90, Author: storage.Author{Name: "Новый автор"}, Title: "author", Content: "Lorem"}
+	if err := db.AddPost(newpost); err != nil {
+		t.Fatalf("sqlite.AddPost() = error %v\n", err)
+	}
+	post, err := db.Post(90)
+	if err != nil {
+		t.Fatalf("sqlite.Post() = error %v\n", err)
+	}
+	if post.Author.Id == 0 || post.Author.Name != newpost.Author.Name {
+		t.Fatalf("sqlite.AddPost() author = %v, want new author %q\n", post.Author, newpost.Author.Name)
+	}
+
+	var authors int
+	if err = db.db.QueryRow(`SELECT count(*) FROM authors;`).Scan(&authors); err != nil {
+		t.Fatal(err)
+	}
+	// автор публикации с занятым id не должен сохраниться
+	newpost.Author = storage.Author{Name: "Лишний автор"}
+	err = db.AddPost(newpost)
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("sqlite.AddPost() of duplicate = error %v, want %v\n", err, storage.ErrConflict)
+	}
+	var got int
+	if err = db.db.QueryRow(`SELECT count(*) FROM authors;`).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != authors {
+		t.Fatalf("sqlite.AddPost() left %d authors after rollback, want %d\n", got, authors)
+	}
+
+	err = db.AddPost(storage.Post{Id: 91, Author: storage.Author{Id: 404}, Title: "orphan"})
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("sqlite.AddPost() of unknown author = error %v, want %v\n", err, storage.ErrConflict)
+	}
+}
+
+func TestSQLite_migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.db")
+	for i := 0; i < 2; i++ {
+		s, err := New(path)
+		if err != nil {
+			t.Fatalf("sqlite.New() = error %v\n", err)
+		}
+		var version int
+		err = s.db.QueryRow(`PRAGMA user_version;`).Scan(&version)
+		s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 1 {
+			t.Fatalf("schema version = %d, want 1\n", version)
+		}
+	}
+}
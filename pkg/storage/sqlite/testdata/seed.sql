@@ -0,0 +1,6 @@
+INSERT INTO authors(id, name) VALUES(1, 'Иван Иванов'), (2, 'Петр Петров');
+INSERT INTO posts(id, title, content, author_id, created_at)
+VALUES (1, 'SQLite Публикация номер 1', 'Lorem ipsum 1', 1, 1652355804),
+(2, 'SQLite Публикация номер 2', 'Lorem ipsum 2', 2, 1652355830);
+INSERT INTO revisions(post_id, number, title, content, author_id, created_at)
+SELECT id, 1, title, content, author_id, created_at FROM posts;
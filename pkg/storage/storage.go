@@ -1,5 +1,35 @@
 package storage
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound возвращается, если запрошенная публикация отсутствует в БД
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict возвращается, если операция нарушает ограничения
+	// целостности БД, например, публикация с таким id уже существует
+	ErrConflict = errors.New("conflict")
+
+	// ErrBulkAborted возвращается пакетной операцией в режиме
+	// "все или ничего", если хотя бы одна публикация не прошла
+	ErrBulkAborted = errors.New("bulk operation aborted")
+)
+
+// Status состояние публикации
+type Status string
+
+const (
+	StatusDraft     Status = "draft"     // черновик
+	StatusScheduled Status = "scheduled" // будет опубликована в момент PublishAt
+	StatusPublished Status = "published"
+	StatusArchived  Status = "archived"
+)
+
 // Post содержит информацию о статье
 type Post struct {
 	Id        int    `bson:"_id"`
@@ -7,6 +37,59 @@ type Post struct {
 	Title     string `bson:"title"`
 	Content   string `bson:"content"`
 	CreatedAt int64  `bson:"created_at"`
+	DeletedAt int64  `bson:"deleted_at"` // время удаления в корзину, 0 - не удалена
+	Status    Status `bson:"status"`     // пустое состояние равнозначно опубликованной
+	PublishAt int64  `bson:"publish_at"` // время публикации
+
+	Tags []string `bson:"tags"`
+
+	// Editor пользователь, создающий или изменяющий публикацию,
+	// сохраняется только в истории версий
+	Editor string `bson:"-" json:"-"`
+}
+
+// Published сообщает, опубликована ли публикация
+func (p Post) Published() bool {
+	return p.Status == StatusPublished || p.Status == ""
+}
+
+// Revision версия публикации. Версия сохраняется при каждом создании
+// и изменении публикации, номера версий публикации начинаются с 1
+type Revision struct {
+	PostId    int    `bson:"post_id"`
+	Number    int    `bson:"number"`
+	Title     string `bson:"title"`
+	Content   string `bson:"content"`
+	Author    Author `bson:"author"`
+	Editor    string `bson:"editor"`
+	CreatedAt int64  `bson:"created_at"`
+}
+
+// Tag тег и число публикаций с ним
+type Tag struct {
+	Name  string `bson:"_id"`
+	Posts int    `bson:"posts"`
+}
+
+// CommentStatus состояние модерации комментария
+type CommentStatus string
+
+const (
+	CommentPending  CommentStatus = "pending" // ожидает модерации
+	CommentApproved CommentStatus = "approved"
+	CommentRejected CommentStatus = "rejected"
+)
+
+// Comment комментарий к публикации. ParentId - комментарий,
+// на который дан ответ, 0 - комментарий к самой публикации
+type Comment struct {
+	Id        int           `bson:"_id"`
+	PostId    int           `bson:"post_id"`
+	ParentId  int           `bson:"parent_id"`
+	Author    string        `bson:"author"`
+	Text      string        `bson:"text"`
+	CreatedAt int64         `bson:"created_at"`
+	Status    CommentStatus `bson:"status"`
 }
 
 // Author содержит информацию об авторе
@@ -15,11 +98,168 @@ type Author struct {
 	Name string `bson:"name"`
 }
 
+// IdempotencyRecord сохраненный ответ на запрос с ключом идемпотентности.
+// Нулевой Status означает, что запрос с этим ключом еще выполняется
+type IdempotencyRecord struct {
+	Key         string              `bson:"_id"`
+	RequestHash string              `bson:"request_hash"`
+	Status      int                 `bson:"status"`
+	Header      map[string][]string `bson:"header"`
+	Body        []byte              `bson:"body"`
+	ExpiresAt   time.Time           `bson:"expires_at"`
+}
+
 // Model задаёт контракт на работу с БД.
+//
+// Удаление публикации мягкое: она помечается временем удаления DeletedAt,
+// перестает возвращаться Posts и Post и попадает в корзину, откуда может
+// быть восстановлена или окончательно удалена PurgePosts.
+//
+// Создание и изменение публикации, в том числе пакетное, сохраняет
+// новую версию публикации в истории версий. История удаляется вместе
+// с публикацией при окончательном удалении.
+//
+// Пакетные операции AddPosts, UpdatePosts и DeletePosts в режиме atomic
+// применяют либо все публикации, либо ни одной, иначе каждая публикация
+// обрабатывается независимо. Срез ошибок содержит результат по каждой
+// публикации в том же порядке (nil - успех). Ненулевая ошибка означает,
+// что ни одна публикация не применена: для ErrBulkAborted срез содержит
+// ошибку публикации, из-за которой операция отменена, если она известна.
+//
+// Комментарии удаляются вместе с публикацией при окончательном
+// удалении, пока публикация в корзине, они сохраняются.
+//
+// Теги учитываются только у публикаций, не удаленных в корзину.
+// RenameTag атомарно переименовывает тег во всех публикациях, если
+// тег с новым именем уже есть, теги объединяются. Возвращает число
+// затронутых публикаций или ErrNotFound, если тега from нет.
+//
+// Записи идемпотентности с истекшим сроком ExpiresAt считаются
+// отсутствующими: IdempotencyRecord возвращает для них ErrNotFound,
+// а AddIdempotencyRecord может их заменить. Если действующая запись
+// с таким ключом уже есть, AddIdempotencyRecord возвращает ErrConflict
 type Model interface {
-	Posts() ([]Post, error) // получение всех публикаций
-	AddPost(Post) error     // создание новой публикации
-	UpdatePost(Post) error  // обновление публикации
-	DeletePost(Post) error  // удаление публикации по ID
-	Close()                 // закрытие подключения к БД
+	Posts() ([]Post, error)    // получение всех публикаций
+	Post(id int) (Post, error) // получение публикации по ID
+	AddPost(Post) error        // создание новой публикации
+	UpdatePost(Post) error     // обновление публикации
+	DeletePost(Post) error     // удаление публикации по ID в корзину
+
+	AddPosts(posts []Post, atomic bool) ([]error, error)    // пакетное создание публикаций
+	UpdatePosts(posts []Post, atomic bool) ([]error, error) // пакетное обновление публикаций
+	DeletePosts(posts []Post, atomic bool) ([]error, error) // пакетное удаление публикаций по ID в корзину
+
+	Tags() ([]Tag, error)                                // теги опубликованных публикаций с их числом по возрастанию имени
+	PostsByTags(tags []string, all bool) ([]Post, error) // публикации с любым (all - со всеми) из тегов
+	RenameTag(from, to string) (int, error)              // переименование тега во всех публикациях
+
+	Comments(postId int) ([]Comment, error)              // комментарии к публикации по возрастанию id
+	Comment(id int) (Comment, error)                     // комментарий по ID
+	AddComment(Comment) (int, error)                     // создание комментария, возвращает его ID
+	SetCommentStatus(id int, status CommentStatus) error // изменение состояния модерации комментария
+
+	Revisions(postId int) ([]Revision, error)      // история версий публикации по возрастанию номера
+	Revision(postId, number int) (Revision, error) // версия публикации по номеру
+
+	Trash() ([]Post, error)               // публикации в корзине
+	TrashedPost(id int) (Post, error)     // публикация из корзины по ID
+	RestorePost(Post) error               // восстановление публикации из корзины по ID
+	PurgePosts(before int64) (int, error) // окончательное удаление публикаций, удаленных в корзину до before
+
+	PublishScheduled(now int64) ([]int, error) // публикация запланированных публикаций с PublishAt не позже now, возвращает их id
+
+	IdempotencyRecord(key string) (IdempotencyRecord, error) // получение записи идемпотентности
+	AddIdempotencyRecord(IdempotencyRecord) error            // резервирование ключа идемпотентности
+	UpdateIdempotencyRecord(IdempotencyRecord) error         // сохранение ответа по ключу
+	DeleteIdempotencyRecord(key string) error                // освобождение ключа идемпотентности
+
+	Close() // закрытие подключения к БД
+}
+
+// ContextReader необязательный интерфейс БД, читающей публикации
+// с учетом контекста запроса, например, с реплик или из кеша.
+// Чтение с контекстом, помеченным WithPrimary, должно видеть
+// все ранее подтвержденные изменения
+type ContextReader interface {
+	PostsContext(ctx context.Context) ([]Post, error)
+	PostContext(ctx context.Context, id int) (Post, error)
+}
+
+// primaryKey ключ пометки контекста WithPrimary
+type primaryKey struct{}
+
+// WithPrimary помечает контекст чтения собственных записей:
+// такое чтение выполняется на основном сервере БД в обход
+// реплик и кеша
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Primary сообщает, помечен ли контекст WithPrimary
+func Primary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// PostsContext возвращает публикации db с учетом контекста,
+// если db реализует ContextReader
+func PostsContext(ctx context.Context, db Model) ([]Post, error) {
+	if r, ok := db.(ContextReader); ok {
+		return r.PostsContext(ctx)
+	}
+	return db.Posts()
+}
+
+// PostContext возвращает публикацию db по id с учетом
+// контекста, если db реализует ContextReader
+func PostContext(ctx context.Context, db Model, id int) (Post, error) {
+	if r, ok := db.(ContextReader); ok {
+		return r.PostContext(ctx, id)
+	}
+	return db.Post(id)
+}
+
+// Health сведения о подключении к БД для проверки готовности.
+// Settings - действующие параметры подключения без учетных
+// данных, Pool - состояние пула соединений, Cache - статистика
+// кеша чтений, если он подключен
+type Health struct {
+	Backend  string            `json:"backend"`
+	Settings map[string]string `json:"settings,omitempty"`
+	Pool     map[string]int64  `json:"pool,omitempty"`
+	Cache    map[string]int64  `json:"cache,omitempty"`
+}
+
+// Checker необязательный интерфейс БД, проверяющей свою готовность
+// к работе: Check возвращает ошибку, если БД недоступна
+type Checker interface {
+	Check(ctx context.Context) (Health, error)
+}
+
+// EventType вид изменения публикации
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated" // в том числе восстановление из корзины
+	EventDeleted EventType = "deleted" // удаление в корзину
+)
+
+// Event событие изменения публикации, Payload - публикация
+// после изменения в json
+type Event struct {
+	Id        int64           `json:"id"`
+	Type      EventType       `json:"type"`
+	PostId    int             `json:"post_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// Outbox необязательный интерфейс БД, сохраняющей события изменения
+// публикаций в одной транзакции с изменением. События одной публикации
+// получают возрастающие Id в порядке изменений, событие хранится,
+// пока его доставка не подтверждена AckEvents
+type Outbox interface {
+	Events(limit int) ([]Event, error) // недоставленные события по возрастанию Id
+	AckEvents(ids ...int64) error      // подтверждение доставки событий
 }
@@ -0,0 +1,156 @@
+// Package stream рассылает события изменения публикаций клиентам,
+// подключенным к ленте в реальном времени
+package stream
+
+import (
+	"GoNews/pkg/storage"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReplaySize = 1000 // событий в буфере повтора
+	DefaultQueueSize  = 64   // событий в очереди подписчика
+)
+
+// Subscriber подписчик ленты. Канал C закрывается при отписке,
+// остановке ленты или если подписчик не успевает читать события:
+// тогда клиент переподключается и получает пропущенные из буфера
+type Subscriber struct {
+	C <-chan storage.Event
+	c chan storage.Event
+}
+
+// Hub лента событий: рассылает события всем подписчикам
+// и хранит последние из них для возобновления после разрыва
+type Hub struct {
+	size int
+	now  func() time.Time
+
+	mu     sync.Mutex
+	buf    []storage.Event // последние события от старых к новым
+	next   int64
+	subs   map[*Subscriber]struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// New возвращает ленту, хранящую size последних событий
+func New(size int) *Hub {
+	if size <= 0 {
+		size = DefaultReplaySize
+	}
+	return &Hub{
+		size: size,
+		now:  time.Now,
+		subs: make(map[*Subscriber]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Publish рассылает подписчикам событие typ о публикации post
+func (h *Hub) Publish(typ storage.EventType, post storage.Post) {
+	payload, err := json.Marshal(post)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.next++
+	e := storage.Event{
+		Id:        h.next,
+		Type:      typ,
+		PostId:    post.Id,
+		Payload:   payload,
+		CreatedAt: h.now().Unix(),
+	}
+	if len(h.buf) == h.size {
+		h.buf = append(h.buf[:0], h.buf[1:]...)
+	}
+	h.buf = append(h.buf, e)
+
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			// медленный подписчик отключается, чтобы не задерживать
+			// остальных, и возобновляет чтение с последнего события
+			h.drop(s)
+		}
+	}
+}
+
+// Subscribe подписывает на события, следующие за событием lastId
+// (0 - только новые). Возвращает пропущенные события из буфера
+// и false, если часть из них уже вытеснена и клиенту нужно заново
+// загрузить публикации. Если лента остановлена, подписчик равен nil
+func (h *Hub) Subscribe(lastId int64) (*Subscriber, []storage.Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+
+	c := make(chan storage.Event, DefaultQueueSize)
+	s := &Subscriber{C: c, c: c}
+	h.subs[s] = struct{}{}
+
+	if lastId <= 0 {
+		return s, nil, true
+	}
+	// после перезапуска сервера номера событий начинаются заново
+	if lastId > h.next {
+		return s, nil, false
+	}
+	var replay []storage.Event
+	for _, e := range h.buf {
+		if e.Id > lastId {
+			replay = append(replay, e)
+		}
+	}
+	complete := len(replay) == 0 || replay[0].Id == lastId+1
+	return s, replay, complete
+}
+
+// Unsubscribe отписывает подписчика s
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(s)
+}
+
+// drop удаляет подписчика и закрывает его канал,
+// вызывается под блокировкой
+func (h *Hub) drop(s *Subscriber) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Done возвращает канал, закрываемый при остановке ленты
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Close останавливает ленту и отключает всех подписчиков
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	// done закрывается первым: отключенный подписчик отличает
+	// остановку ленты от отключения за медленное чтение
+	h.closed = true
+	close(h.done)
+	for s := range h.subs {
+		h.drop(s)
+	}
+}
@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"GoNews/pkg/storage"
+	"testing"
+)
+
+func TestHub_Publish(t *testing.T) {
+	h := New(10)
+	defer h.Close()
+
+	s, replay, complete := h.Subscribe(0)
+	if s == nil || len(replay) != 0 || !complete {
+		t.Fatalf("Hub.Subscribe(0) = %v, %v, %v, want new subscriber", s, replay, complete)
+	}
+	h.Publish(storage.EventCreated, storage.Post{Id: 1})
+	h.Publish(storage.EventDeleted, storage.Post{Id: 1})
+
+	for i, want := range []storage.EventType{storage.EventCreated, storage.EventDeleted} {
+		e := <-s.C
+		if e.Id != int64(i+1) || e.Type != want || e.PostId != 1 {
+			t.Fatalf("event = %+v, want %d %s", e, i+1, want)
+		}
+	}
+
+	h.Unsubscribe(s)
+	if _, ok := <-s.C; ok {
+		t.Fatal("subscriber channel is open after Hub.Unsubscribe()")
+	}
+}
+
+func TestHub_replay(t *testing.T) {
+	h := New(3)
+	defer h.Close()
+	for i := 1; i <= 5; i++ {
+		h.Publish(storage.EventUpdated, storage.Post{Id: i})
+	}
+
+	tests := []struct {
+		name     string
+		lastId   int64
+		want     []int64
+		complete bool
+	}{
+		{name: "recent", lastId: 3, want: []int64{4, 5}, complete: true},
+		{name: "up to date", lastId: 5, complete: true},
+		{name: "evicted", lastId: 1, want: []int64{3, 4, 5}, complete: false},
+		{name: "unknown", lastId: 10, complete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, replay, complete := h.Subscribe(tt.lastId)
+			defer h.Unsubscribe(s)
+			if complete != tt.complete || len(replay) != len(tt.want) {
+				t.Fatalf("Hub.Subscribe(%d) = %+v, %v, want %v, %v", tt.lastId, replay, complete, tt.want, tt.complete)
+			}
+			for i := range tt.want {
+				if replay[i].Id != tt.want[i] {
+					t.Fatalf("Hub.Subscribe(%d) = %+v, want %v", tt.lastId, replay, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHub_slowSubscriber(t *testing.T) {
+	h := New(0)
+	defer h.Close()
+	s, _, _ := h.Subscribe(0)
+
+	for i := 0; i <= DefaultQueueSize; i++ {
+		h.Publish(storage.EventCreated, storage.Post{Id: i})
+	}
+	n := 0
+	for range s.C {
+		n++
+	}
+	if n != DefaultQueueSize {
+		t.Fatalf("slow subscriber got %d events, want %d before disconnect", n, DefaultQueueSize)
+	}
+}
+
+func TestHub_Close(t *testing.T) {
+	h := New(0)
+	s, _, _ := h.Subscribe(0)
+	h.Close()
+	h.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Fatal("subscriber channel is open after Hub.Close()")
+	}
+	select {
+	case <-h.Done():
+	default:
+		t.Fatal("Hub.Done() is open after Hub.Close()")
+	}
+	if s, _, _ = h.Subscribe(0); s != nil {
+		t.Fatal("Hub.Subscribe() after Hub.Close() = subscriber, want nil")
+	}
+}
@@ -0,0 +1,594 @@
+// Package webhooks рассылает события изменения публикаций
+// подписчикам: партнерам, зарегистрировавшим адреса обратного вызова
+package webhooks
+
+import (
+	"GoNews/pkg/storage"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrNotFound подписка или доставка не найдена
+	ErrNotFound = errors.New("not found")
+
+	// ErrInProgress доставка еще выполняется
+	ErrInProgress = errors.New("delivery is in progress")
+
+	// ErrPrivateAddress адрес подписчика находится во внутренней сети
+	ErrPrivateAddress = errors.New("url must not point to a private, loopback or link-local address")
+)
+
+const (
+	DefaultMaxAttempts = 6
+	DefaultBaseBackoff = time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+	DefaultTimeout     = 10 * time.Second
+	DefaultLogSize     = 100  // доставок в журнале подписки
+	DefaultDeadLetters = 1000 // недоставленных событий
+)
+
+// Config параметры рассылки, нулевые значения заменяются
+// значениями по умолчанию
+type Config struct {
+	// MaxAttempts число попыток доставки, после которого
+	// событие попадает в список недоставленных
+	MaxAttempts int
+
+	// BaseBackoff пауза после первой неудачной попытки, каждая
+	// следующая пауза вдвое длиннее, но не длиннее MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Timeout время ожидания ответа подписчика
+	Timeout time.Duration
+
+	// LogSize число последних доставок в журнале каждой подписки,
+	// DeadLetters - число хранимых недоставленных событий
+	LogSize     int
+	DeadLetters int
+
+	// AllowPrivate разрешает адреса подписчиков во внутренней сети:
+	// loopback, частные и link-local, включая адрес метаданных облака.
+	// По умолчанию запрещены, иначе подписка позволяет обращаться
+	// к внутренним сервисам от имени сервера. Для тестов
+	AllowPrivate bool
+}
+
+// Subscription подписка на события. Пустой Events означает все
+// события. Secret - ключ подписи, возвращается только при создании
+type Subscription struct {
+	Id        int                 `json:"id"`
+	URL       string              `json:"url"`
+	Events    []storage.EventType `json:"events"`
+	Secret    string              `json:"secret,omitempty"`
+	CreatedAt int64               `json:"created_at"`
+}
+
+// DeliveryStatus состояние доставки
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // ожидает попытки
+	DeliverySucceeded DeliveryStatus = "succeeded" // подписчик ответил 2xx
+	DeliveryDead      DeliveryStatus = "dead"      // попытки исчерпаны
+)
+
+// Attempt попытка доставки: код ответа подписчика
+// или ошибка, если ответа 2xx не получено
+type Attempt struct {
+	At         int64  `json:"at"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Delivery доставка события подписчику
+type Delivery struct {
+	Id             int64          `json:"id"`
+	SubscriptionId int            `json:"subscription_id"`
+	Event          storage.Event  `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       []Attempt      `json:"attempts"`
+	NextAttemptAt  int64          `json:"next_attempt_at,omitempty"`
+
+	running bool // доставка выполняется
+	evicted bool // вытеснена из журнала подписки
+}
+
+// Dispatcher хранит подписки и доставляет им события. Каждая доставка
+// выполняется отдельно с повторами, поэтому порядок получения событий
+// не гарантирован: подписчик упорядочивает их по Event.Id и отбрасывает
+// повторы. Подписки и журналы хранятся в памяти процесса
+type Dispatcher struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu           sync.Mutex
+	subs         map[int]*Subscription
+	deliveries   map[int64]*Delivery
+	logs         map[int][]int64 // доставки подписок от старых к новым
+	dead         []int64
+	nextSub      int
+	nextEvent    int64
+	nextDelivery int64
+}
+
+// New возвращает рассылку с параметрами cfg
+func New(cfg Config) *Dispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = DefaultBaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.LogSize <= 0 {
+		cfg.LogSize = DefaultLogSize
+	}
+	if cfg.DeadLetters <= 0 {
+		cfg.DeadLetters = DefaultDeadLetters
+	}
+
+	// адрес проверяется и при соединении: имя подписчика
+	// могло начать указывать на другой адрес после подписки
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || private(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		cfg:        cfg,
+		client:     &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		now:        time.Now,
+		ctx:        ctx,
+		cancel:     cancel,
+		subs:       make(map[int]*Subscription),
+		deliveries: make(map[int64]*Delivery),
+		logs:       make(map[int][]int64),
+	}
+}
+
+// Close прерывает доставки и ждет их завершения
+func (d *Dispatcher) Close() {
+	// отмена под блокировкой: после нее start не запускает доставок
+	d.mu.Lock()
+	d.cancel()
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// Subscribe создает подписку на события events по адресу rawURL.
+// Если secret не задан, ключ подписи создается случайно. Адрес во
+// внутренней сети возвращает ErrPrivateAddress, если это не
+// разрешено в Config
+func (d *Dispatcher) Subscribe(rawURL string, events []storage.EventType, secret string) (Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return Subscription{}, errors.New("url must be an absolute http or https url")
+	}
+	if !d.cfg.AllowPrivate {
+		if err = d.checkHost(u.Hostname()); err != nil {
+			return Subscription{}, err
+		}
+	}
+	for _, e := range events {
+		if e != storage.EventCreated && e != storage.EventUpdated && e != storage.EventDeleted {
+			return Subscription{}, errors.New("unknown event " + strconv.Quote(string(e)))
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err = rand.Read(b); err != nil {
+			return Subscription{}, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextSub++
+	sub := &Subscription{
+		Id:        d.nextSub,
+		URL:       u.String(),
+		Events:    append([]storage.EventType{}, events...),
+		Secret:    secret,
+		CreatedAt: d.now().Unix(),
+	}
+	d.subs[sub.Id] = sub
+	return *sub, nil
+}
+
+// checkHost проверяет, что ни один из адресов хоста
+// подписчика не находится во внутренней сети
+func (d *Dispatcher) checkHost(host string) error {
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.Timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.New("url host cannot be resolved")
+	}
+	for _, a := range addrs {
+		if private(a.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// cgnat разделяемое адресное пространство операторов (RFC 6598)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// private сообщает, находится ли адрес во внутренней сети: loopback,
+// частные сети (RFC 1918, RFC 4193), link-local, включая адрес
+// метаданных облака 169.254.169.254, а также неуказанный и групповой
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// Subscriptions возвращает подписки по возрастанию id без ключей подписи
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(d.subs))
+	for _, s := range d.subs {
+		subs = append(subs, s.public())
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Id < subs[j].Id })
+	return subs
+}
+
+// Subscription возвращает подписку по id без ключа подписи
+func (d *Dispatcher) Subscription(id int) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.subs[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return s.public(), nil
+}
+
+// Unsubscribe удаляет подписку, ее журнал и недоставленные события.
+// Выполняющиеся доставки прекращаются перед следующей попыткой
+func (d *Dispatcher) Unsubscribe(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(d.subs, id)
+	delete(d.logs, id)
+
+	dead := d.dead[:0]
+	for _, did := range d.dead {
+		if d.deliveries[did].SubscriptionId != id {
+			dead = append(dead, did)
+		}
+	}
+	d.dead = dead
+
+	for did, dl := range d.deliveries {
+		if dl.SubscriptionId == id && !dl.running {
+			delete(d.deliveries, did)
+		}
+	}
+	return nil
+}
+
+// Notify рассылает событие typ о публикации post подписчикам
+func (d *Dispatcher) Notify(typ storage.EventType, post storage.Post) {
+	payload, err := json.Marshal(post)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextEvent++
+	e := storage.Event{
+		Id:        d.nextEvent,
+		Type:      typ,
+		PostId:    post.Id,
+		Payload:   payload,
+		CreatedAt: d.now().Unix(),
+	}
+
+	for _, s := range d.subs {
+		if !s.wants(typ) {
+			continue
+		}
+		d.nextDelivery++
+		dl := &Delivery{
+			Id:             d.nextDelivery,
+			SubscriptionId: s.Id,
+			Event:          e,
+			Status:         DeliveryPending,
+			Attempts:       []Attempt{},
+		}
+		d.deliveries[dl.Id] = dl
+		d.log(dl)
+		d.start(dl)
+	}
+}
+
+// Deliveries возвращает журнал доставок подписки, начиная с последних
+func (d *Dispatcher) Deliveries(subId int) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subs[subId]; !ok {
+		return nil, ErrNotFound
+	}
+	ids := d.logs[subId]
+	list := make([]Delivery, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		list = append(list, d.deliveries[ids[i]].copy())
+	}
+	return list, nil
+}
+
+// DeadLetters возвращает недоставленные события, начиная с последних
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]Delivery, 0, len(d.dead))
+	for i := len(d.dead) - 1; i >= 0; i-- {
+		list = append(list, d.deliveries[d.dead[i]].copy())
+	}
+	return list
+}
+
+// Redeliver повторяет завершенную доставку подписки subId: успешную
+// или недоставленную, в последнем случае она убирается из списка
+// недоставленных. Выполняющаяся доставка возвращает ErrInProgress
+func (d *Dispatcher) Redeliver(subId int, id int64) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, ok := d.deliveries[id]
+	if _, subOk := d.subs[subId]; !ok || !subOk || dl.SubscriptionId != subId {
+		return Delivery{}, ErrNotFound
+	}
+	if dl.running {
+		return Delivery{}, ErrInProgress
+	}
+
+	if dl.Status == DeliveryDead {
+		for i, did := range d.dead {
+			if did == id {
+				d.dead = append(d.dead[:i], d.dead[i+1:]...)
+				break
+			}
+		}
+	}
+	dl.Status = DeliveryPending
+	d.start(dl)
+	return dl.copy(), nil
+}
+
+// Sign возвращает подпись тела запроса body, отправленного в момент
+// timestamp: hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// Подписчик сверяет ее с заголовком X-Webhook-Signature без префикса
+// "sha256=" и отклоняет запросы с устаревшим X-Webhook-Timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// start запускает доставку, выполняется под блокировкой.
+// После Close доставка остается в состоянии ожидания
+func (d *Dispatcher) start(dl *Delivery) {
+	if d.ctx.Err() != nil {
+		return
+	}
+	dl.running = true
+	d.wg.Add(1)
+	go d.run(dl)
+}
+
+// run выполняет попытки доставки с паузами, пока подписчик
+// не ответит 2xx или попытки не закончатся
+func (d *Dispatcher) run(dl *Delivery) {
+	defer d.wg.Done()
+
+	for n := 1; ; n++ {
+		d.mu.Lock()
+		sub, ok := d.subs[dl.SubscriptionId]
+		if !ok {
+			// подписка удалена
+			delete(d.deliveries, dl.Id)
+			d.mu.Unlock()
+			return
+		}
+		s, e := *sub, dl.Event
+		d.mu.Unlock()
+
+		a := d.attempt(s, dl.Id, e)
+
+		d.mu.Lock()
+		dl.Attempts = append(dl.Attempts, a)
+		dl.NextAttemptAt = 0
+		switch {
+		case a.Error == "":
+			dl.Status, dl.running = DeliverySucceeded, false
+			if dl.evicted {
+				delete(d.deliveries, dl.Id)
+			}
+			d.mu.Unlock()
+			return
+
+		case n >= d.cfg.MaxAttempts || d.ctx.Err() != nil:
+			dl.Status, dl.running = DeliveryDead, false
+			d.bury(dl)
+			d.mu.Unlock()
+			return
+		}
+
+		backoff := d.backoff(n)
+		dl.NextAttemptAt = d.now().Add(backoff).Unix()
+		d.mu.Unlock()
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-d.ctx.Done():
+			t.Stop()
+		}
+	}
+}
+
+// attempt отправляет событие подписчику
+func (d *Dispatcher) attempt(s Subscription, deliveryId int64, e storage.Event) Attempt {
+	ts := d.now().Unix()
+	a := Attempt{At: ts}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(deliveryId, 10))
+	req.Header.Set("X-Webhook-Event", string(e.Type))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(s.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = "unexpected response status " + resp.Status
+	}
+	return a
+}
+
+// backoff возвращает паузу после n-й неудачной попытки
+func (d *Dispatcher) backoff(n int) time.Duration {
+	b := d.cfg.BaseBackoff
+	for i := 1; i < n && b < d.cfg.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > d.cfg.MaxBackoff {
+		b = d.cfg.MaxBackoff
+	}
+	return b
+}
+
+// log добавляет доставку в журнал подписки, вытесняя старые
+// записи. Выполняется под блокировкой
+func (d *Dispatcher) log(dl *Delivery) {
+	ids := append(d.logs[dl.SubscriptionId], dl.Id)
+	for len(ids) > d.cfg.LogSize {
+		old := d.deliveries[ids[0]]
+		old.evicted = true
+		if !old.running && old.Status != DeliveryDead {
+			delete(d.deliveries, old.Id)
+		}
+		ids = ids[1:]
+	}
+	d.logs[dl.SubscriptionId] = ids
+}
+
+// bury добавляет доставку в список недоставленных, вытесняя старые
+// записи. Выполняется под блокировкой
+func (d *Dispatcher) bury(dl *Delivery) {
+	d.dead = append(d.dead, dl.Id)
+	for len(d.dead) > d.cfg.DeadLetters {
+		// запись, оставшаяся в журнале подписки, сохраняется
+		if old := d.deliveries[d.dead[0]]; old.evicted {
+			delete(d.deliveries, old.Id)
+		}
+		d.dead = d.dead[1:]
+	}
+}
+
+// wants сообщает, подписана ли подписка на событие typ
+func (s *Subscription) wants(typ storage.EventType) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// public возвращает копию подписки без ключа подписи
+func (s *Subscription) public() Subscription {
+	c := *s
+	c.Secret = ""
+	c.Events = append([]storage.EventType{}, s.Events...)
+	return c
+}
+
+// copy возвращает копию доставки
+func (dl *Delivery) copy() Delivery {
+	c := *dl
+	c.Attempts = append([]Attempt{}, dl.Attempts...)
+	return c
+}
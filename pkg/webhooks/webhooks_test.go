@@ -0,0 +1,286 @@
+package webhooks
+
+import (
+	"GoNews/pkg/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver подписчик для тестов: отвечает кодами из codes
+// по очереди, затем 200, и запоминает полученные события
+type receiver struct {
+	mu     sync.Mutex
+	codes  []int
+	events []storage.Event
+	got    chan struct{}
+}
+
+func newReceiver(codes ...int) (*receiver, *httptest.Server) {
+	rc := &receiver{codes: codes, got: make(chan struct{}, 100)}
+	return rc, httptest.NewServer(rc)
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	code := http.StatusOK
+	if len(rc.codes) > 0 {
+		code, rc.codes = rc.codes[0], rc.codes[1:]
+	}
+	body, _ := io.ReadAll(r.Body)
+	var e storage.Event
+	_ = json.Unmarshal(body, &e)
+	if code == http.StatusOK {
+		rc.events = append(rc.events, e)
+	}
+	rc.mu.Unlock()
+
+	w.WriteHeader(code)
+	rc.got <- struct{}{}
+}
+
+// wait ждет n запросов к подписчику
+func (rc *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rc.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("receiver got %d of %d requests", i, n)
+		}
+	}
+}
+
+// settled ждет завершения доставки
+func settled(t *testing.T, d *Dispatcher, subId int, id int64) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		list, _ := d.Deliveries(subId)
+		for _, dl := range list {
+			if dl.Id == id && dl.Status != DeliveryPending {
+				return dl
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("delivery %d is not settled", id)
+	return Delivery{}
+}
+
+func testConfig() Config {
+	return Config{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, AllowPrivate: true}
+}
+
+func TestDispatcher_signature(t *testing.T) {
+	var sig, ts string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ts = r.Header.Get("X-Webhook-Signature"), r.Header.Get("X-Webhook-Timestamp")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	d := New(testConfig())
+	defer d.Close()
+	sub, err := d.Subscribe(srv.URL, nil, "secret")
+	if err != nil {
+		t.Fatalf("Dispatcher.Subscribe() = error %v", err)
+	}
+
+	d.Notify(storage.EventCreated, storage.Post{Id: 1, Title: "signed"})
+	settled(t, d, sub.Id, 1)
+
+	n, _ := strconv.ParseInt(ts, 10, 64)
+	if want := "sha256=" + Sign("secret", n, body); sig != want {
+		t.Fatalf("X-Webhook-Signature = %q, want %q", sig, want)
+	}
+	var e storage.Event
+	if err = json.Unmarshal(body, &e); err != nil || e.Type != storage.EventCreated || e.PostId != 1 {
+		t.Fatalf("webhook body = %s, error %v", body, err)
+	}
+}
+
+func TestDispatcher_filter(t *testing.T) {
+	rc, srv := newReceiver()
+	defer srv.Close()
+
+	d := New(testConfig())
+	defer d.Close()
+	sub, _ := d.Subscribe(srv.URL, []storage.EventType{storage.EventDeleted}, "")
+	if sub.Secret == "" {
+		t.Fatal("Dispatcher.Subscribe() secret is not generated")
+	}
+	if _, err := d.Subscribe(srv.URL, []storage.EventType{"published"}, ""); err == nil {
+		t.Fatal("Dispatcher.Subscribe() with unknown event = nil error")
+	}
+	if _, err := d.Subscribe("ftp://example.com", nil, ""); err == nil {
+		t.Fatal("Dispatcher.Subscribe() with ftp url = nil error")
+	}
+
+	d.Notify(storage.EventCreated, storage.Post{Id: 1})
+	d.Notify(storage.EventDeleted, storage.Post{Id: 1})
+	rc.wait(t, 1)
+
+	list, _ := d.Deliveries(sub.Id)
+	if len(list) != 1 || list[0].Event.Type != storage.EventDeleted {
+		t.Fatalf("Dispatcher.Deliveries() = %+v, want only deleted event", list)
+	}
+	if subs := d.Subscriptions(); len(subs) != 1 || subs[0].Secret != "" {
+		t.Fatalf("Dispatcher.Subscriptions() = %+v, want one subscription without secret", subs)
+	}
+}
+
+func TestDispatcher_retries(t *testing.T) {
+	// две неудачи, затем успех
+	rc, srv := newReceiver(http.StatusInternalServerError, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	d := New(testConfig())
+	defer d.Close()
+	sub, _ := d.Subscribe(srv.URL, nil, "")
+
+	d.Notify(storage.EventUpdated, storage.Post{Id: 2})
+	dl := settled(t, d, sub.Id, 1)
+	if dl.Status != DeliverySucceeded || len(dl.Attempts) != 3 {
+		t.Fatalf("delivery = %+v, want success on third attempt", dl)
+	}
+	if dl.Attempts[0].StatusCode != http.StatusInternalServerError || dl.Attempts[0].Error == "" {
+		t.Fatalf("first attempt = %+v, want 500 error", dl.Attempts[0])
+	}
+	if len(rc.events) != 1 {
+		t.Fatalf("receiver accepted %d events, want 1", len(rc.events))
+	}
+	if len(d.DeadLetters()) != 0 {
+		t.Fatal("succeeded delivery is in dead letters")
+	}
+}
+
+func TestDispatcher_deadLetters(t *testing.T) {
+	codes := []int{500, 500, 500}
+	rc, srv := newReceiver(codes...)
+	defer srv.Close()
+
+	d := New(testConfig())
+	defer d.Close()
+	sub, _ := d.Subscribe(srv.URL, nil, "")
+
+	d.Notify(storage.EventCreated, storage.Post{Id: 3})
+	dl := settled(t, d, sub.Id, 1)
+	if dl.Status != DeliveryDead || len(dl.Attempts) != 3 {
+		t.Fatalf("delivery = %+v, want dead after 3 attempts", dl)
+	}
+	if dead := d.DeadLetters(); len(dead) != 1 || dead[0].Id != dl.Id {
+		t.Fatalf("Dispatcher.DeadLetters() = %+v, want delivery %d", dead, dl.Id)
+	}
+
+	// повторная доставка вручную после восстановления подписчика
+	if _, err := d.Redeliver(sub.Id, dl.Id); err != nil {
+		t.Fatalf("Dispatcher.Redeliver() = error %v", err)
+	}
+	dl = settled(t, d, sub.Id, dl.Id)
+	if dl.Status != DeliverySucceeded || len(dl.Attempts) != 4 {
+		t.Fatalf("redelivery = %+v, want success on fourth attempt", dl)
+	}
+	if len(d.DeadLetters()) != 0 {
+		t.Fatal("redelivered event remains in dead letters")
+	}
+	if len(rc.events) != 1 || rc.events[0].PostId != 3 {
+		t.Fatalf("receiver events = %+v, want post 3", rc.events)
+	}
+
+	if _, err := d.Redeliver(sub.Id, 100); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Dispatcher.Redeliver() = error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDispatcher_privateAddress(t *testing.T) {
+	d := New(Config{MaxAttempts: 1})
+	defer d.Close()
+
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.0.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		if _, err := d.Subscribe(u, nil, ""); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("Dispatcher.Subscribe(%s) = error %v, want %v", u, err, ErrPrivateAddress)
+		}
+	}
+
+	// адрес проверяется и при доставке: имя подписчика
+	// может начать указывать на внутренний адрес после подписки
+	rc, srv := newReceiver()
+	defer srv.Close()
+	d.cfg.AllowPrivate = true
+	sub, err := d.Subscribe(srv.URL, nil, "")
+	d.cfg.AllowPrivate = false
+	if err != nil {
+		t.Fatalf("Dispatcher.Subscribe() = error %v", err)
+	}
+
+	d.Notify(storage.EventCreated, storage.Post{Id: 5})
+	dl := settled(t, d, sub.Id, 1)
+	if dl.Status != DeliveryDead || len(dl.Attempts) != 1 || dl.Attempts[0].StatusCode != 0 {
+		t.Fatalf("delivery = %+v, want dead without response", dl)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.events) != 0 {
+		t.Fatalf("receiver events = %+v, want none", rc.events)
+	}
+}
+
+func TestDispatcher_log(t *testing.T) {
+	_, srv := newReceiver()
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.LogSize = 2
+	d := New(cfg)
+	defer d.Close()
+	sub, _ := d.Subscribe(srv.URL, nil, "")
+
+	for i := 1; i <= 3; i++ {
+		d.Notify(storage.EventCreated, storage.Post{Id: i})
+		settled(t, d, sub.Id, int64(i))
+	}
+	list, _ := d.Deliveries(sub.Id)
+	if len(list) != 2 || list[0].Event.PostId != 3 || list[1].Event.PostId != 2 {
+		t.Fatalf("Dispatcher.Deliveries() = %+v, want posts 3 and 2", list)
+	}
+
+	if err := d.Unsubscribe(sub.Id); err != nil {
+		t.Fatalf("Dispatcher.Unsubscribe() = error %v", err)
+	}
+	if _, err := d.Deliveries(sub.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Dispatcher.Deliveries() after unsubscribe = error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDispatcher_backoff(t *testing.T) {
+	d := New(Config{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	defer d.Close()
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Fatalf("Dispatcher.backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}